@@ -0,0 +1,133 @@
+package apperrors
+
+//Kind of failure, it decides the status of the response
+
+type Kind int
+
+const (
+	//Unexpected failure, like a database error
+	Internal Kind = iota
+
+	//The request is malformed or one of its fields is invalid
+	Invalid
+
+	//The request isn't authenticated
+	Unauthorized
+
+	//The authenticated user can't access the resource
+	Forbidden
+
+	//The resource doesn't exist
+	NotFound
+
+	//The request conflicts with the state of the resource, like an already taken username
+	Conflict
+
+	//The request couldn't be served in time, it can be retried
+	Unavailable
+)
+
+//Code of the internal errors
+
+const InternalCode = "internal_error"
+
+//Invalid value of a field of the request
+//Field is the path of the field, like content.width, and Code tells why it's invalid, like required
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+//Codes of the invalid fields
+
+const (
+	//The field is missing or empty
+	FieldRequired = "required"
+
+	//The field doesn't have the expected type
+	FieldInvalidType = "invalid_type"
+
+	//The field is longer than allowed
+	FieldTooLong = "too_long"
+
+	//The number is out of the allowed range
+	FieldOutOfRange = "out_of_range"
+
+	//The field isn't one of the allowed values
+	FieldInvalidValue = "invalid_value"
+)
+
+//Failure of an operation
+//Code is the stable identifier of the failure, clients rely on it rather than on the message
+//Message describes the failure to the client, and Fields the invalid fields of an invalid request
+//Cause is the underlying error, it's logged but never returned to the client
+
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+	Fields  []FieldError
+	Cause   error
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return e.Message + ": " + e.Cause.Error()
+	}
+	return e.Message
+}
+
+//Returns the error of the given kind
+
+func New(kind Kind, code string, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+//Returns an invalid request error with the invalid fields
+
+func NewInvalid(code string, message string, fields ...FieldError) *Error {
+	return &Error{Kind: Invalid, Code: code, Message: message, Fields: fields}
+}
+
+//Returns the internal error caused by cause
+
+func NewInternal(message string, cause error) *Error {
+	return &Error{Kind: Internal, Code: InternalCode, Message: message, Cause: cause}
+}
+
+//Returns the error of the given kind caused by cause
+
+func Wrap(kind Kind, code string, message string, cause error) *Error {
+	return &Error{Kind: kind, Code: code, Message: message, Cause: cause}
+}
+
+//Returns the typed error as is, or an internal error with the message caused by err otherwise
+//Returns nil if err is nil
+
+func WrapInternal(err error, message string) error {
+	if err == nil {
+		return nil
+	}
+	if typed, ok := As(err); ok {
+		return typed
+	}
+	return NewInternal(message, err)
+}
+
+//Returns the typed error and true if err is one
+
+func As(err error) (*Error, bool) {
+	typed, ok := err.(*Error)
+	return typed, ok && typed != nil
+}
+
+//Returns the kind of the error, Internal if it isn't typed
+
+func KindOf(err error) Kind {
+	if typed, ok := As(err); ok {
+		return typed.Kind
+	}
+	return Internal
+}
@@ -0,0 +1,52 @@
+package apperrors
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrapInternal(t *testing.T) {
+	typed := New(NotFound, "not_found", "Not found")
+	cause := errors.New("database is locked")
+
+	cases := []struct {
+		name     string
+		err      error
+		expected error
+	}{
+		{"testWrapInternalNil", nil, nil},
+		{"testWrapInternalTyped", typed, typed},
+		{"testWrapInternalUntyped", cause, NewInternal("Error getting messages", cause)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			assert.Equal(tt, c.expected, WrapInternal(c.err, "Error getting messages"))
+		})
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		expected Kind
+	}{
+		{"testKindOfTyped", NewInvalid("invalid_body", "Invalid body"), Invalid},
+		{"testKindOfUntyped", errors.New("database is locked"), Internal},
+		{"testKindOfNilTyped", (*Error)(nil), Internal},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			assert.Equal(tt, c.expected, KindOf(c.err))
+		})
+	}
+}
+
+func TestErrorShouldDescribeTheCause(t *testing.T) {
+	assert.Equal(t, "Not found", New(NotFound, "not_found", "Not found").Error())
+	assert.Equal(t, "Error getting messages: database is locked", NewInternal("Error getting messages", errors.New("database is locked")).Error())
+}
@@ -0,0 +1,514 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/maidaneze/message-server/dao"
+	"github.com/maidaneze/message-server/logging"
+	"github.com/maidaneze/message-server/model"
+	"github.com/maidaneze/message-server/services/auth"
+	"github.com/maidaneze/message-server/services/groups"
+	"github.com/maidaneze/message-server/services/messages"
+	"github.com/maidaneze/message-server/services/passwords"
+	"github.com/maidaneze/message-server/tracing"
+
+	"gopkg.in/yaml.v2"
+)
+
+//Prefix of the environment variables of the options
+
+const EnvPrefix = "MESSAGE_SERVER_"
+
+//Configuration of the server
+//Every option has a default value, which is overridden by the configuration file, then by the environment
+//variables and finally by the command line flags
+
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	Database DatabaseConfig `yaml:"database"`
+	Auth     AuthConfig     `yaml:"auth"`
+	Messages MessagesConfig `yaml:"messages"`
+	Log      LogConfig      `yaml:"log"`
+	Tracing  TracingConfig  `yaml:"tracing"`
+}
+
+//ShutdownTimeout is the time the requests in progress have to finish once the server is stopped
+//RequestTimeout is the deadline of the database work of a request, 0 disables it
+//Dev allows running without a token hash key, using a random key that doesn't survive a restart
+
+type ServerConfig struct {
+	Addr            string        `yaml:"addr"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	RequestTimeout  time.Duration `yaml:"request_timeout"`
+	Dev             bool          `yaml:"dev"`
+}
+
+//Source is the file path for sqlite3 and the connection string for postgres
+
+type DatabaseConfig struct {
+	Driver           string        `yaml:"driver"`
+	Source           string        `yaml:"source"`
+	RetryAttempts    int           `yaml:"retry_attempts"`
+	RetryInterval    time.Duration `yaml:"retry_interval"`
+	RetryMaxInterval time.Duration `yaml:"retry_max_interval"`
+	RetryMaxElapsed  time.Duration `yaml:"retry_max_elapsed"`
+}
+
+//TokenHashKey and JwtKeys are file paths, the keys themselves are never part of the configuration
+
+type AuthConfig struct {
+	MaxSessions    int           `yaml:"max_sessions"`
+	TokenTTL       time.Duration `yaml:"token_ttl"`
+	TokenMode      string        `yaml:"token_mode"`
+	JwtKeys        string        `yaml:"jwt_keys"`
+	AccessTokenTTL time.Duration `yaml:"access_token_ttl"`
+	PasswordHash   string        `yaml:"password_hash"`
+	TokenHashKey   string        `yaml:"token_hash_key"`
+}
+
+type MessagesConfig struct {
+	DefaultLimit    int64 `yaml:"default_limit"`
+	MaxWaitSeconds  int64 `yaml:"max_wait_seconds"`
+	MaxTextSize     int   `yaml:"max_text_size"`
+	MaxGroupMembers int   `yaml:"max_group_members"`
+}
+
+//Level is the minimum level of the logged entries, either "debug", "info", "warn" or "error"
+
+type LogConfig struct {
+	Level string `yaml:"level"`
+}
+
+//Exporter is where the spans are sent, either "none" to disable the tracing, "stdout" or "otlp"
+//OtlpEndpoint is the url of the OTLP/HTTP traces endpoint of the collector
+//ServiceName is the service.name of the spans
+
+type TracingConfig struct {
+	Exporter     string `yaml:"exporter"`
+	OtlpEndpoint string `yaml:"otlp_endpoint"`
+	ServiceName  string `yaml:"service_name"`
+}
+
+//Span exporters
+
+const (
+	NoTracing     = "none"
+	StdoutTracing = "stdout"
+	OtlpTracing   = "otlp"
+)
+
+//Access token modes
+
+const (
+	OpaqueTokens = "opaque"
+	JwtTokens    = "jwt"
+)
+
+//Returns the default configuration
+
+func Default() Config {
+	return Config{
+		Server: ServerConfig{Addr: ":8080", ShutdownTimeout: time.Second * 30, RequestTimeout: time.Second * 10},
+		Database: DatabaseConfig{
+			Driver:           dao.SqliteDriver,
+			Source:           "db/challenge.db",
+			RetryAttempts:    dao.DefaultRetryOptions.Attempts,
+			RetryInterval:    dao.DefaultRetryOptions.Interval,
+			RetryMaxInterval: dao.DefaultRetryOptions.MaxInterval,
+			RetryMaxElapsed:  dao.DefaultRetryOptions.MaxElapsed,
+		},
+		Auth: AuthConfig{
+			MaxSessions:    auth.DefaultSessionPolicy.MaxSessions,
+			TokenTTL:       auth.DefaultSessionPolicy.TokenTTL,
+			TokenMode:      OpaqueTokens,
+			AccessTokenTTL: auth.DefaultAccessTokenTTL,
+			PasswordHash:   passwords.DefaultPolicy.Algorithm,
+		},
+		Messages: MessagesConfig{
+			DefaultLimit:    100,
+			MaxWaitSeconds:  60,
+			MaxTextSize:     1024,
+			MaxGroupMembers: 256,
+		},
+		Log: LogConfig{Level: logging.Info.String()},
+		Tracing: TracingConfig{
+			Exporter:     NoTracing,
+			OtlpEndpoint: "http://localhost:4318/v1/traces",
+			ServiceName:  "message-server",
+		},
+	}
+}
+
+//Command line flag and environment variable of an option of the configuration
+//The value binds the option to the field of the given configuration
+
+type option struct {
+	flag  string
+	env   string
+	usage string
+	value func(c *Config) flag.Value
+}
+
+var options = []option{
+	{"addr", "ADDR", "Address the server listens on", func(c *Config) flag.Value { return (*stringValue)(&c.Server.Addr) }},
+	{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "Time the requests in progress have to finish once the server is stopped", func(c *Config) flag.Value { return (*durationValue)(&c.Server.ShutdownTimeout) }},
+	{"request-timeout", "REQUEST_TIMEOUT", "Deadline of the database work of a request, 0 disables it", func(c *Config) flag.Value { return (*durationValue)(&c.Server.RequestTimeout) }},
+	{"dev", "DEV", "Development mode, a random token hash key is used if none is configured", func(c *Config) flag.Value { return (*boolValue)(&c.Server.Dev) }},
+	{"db-driver", "DB_DRIVER", "Database driver, either \"sqlite3\" or \"postgres\"", func(c *Config) flag.Value { return (*stringValue)(&c.Database.Driver) }},
+	{"db-source", "DB_SOURCE", "Database file path for sqlite3 or connection string for postgres", func(c *Config) flag.Value { return (*stringValue)(&c.Database.Source) }},
+	{"db-retry-attempts", "DB_RETRY_ATTEMPTS", "Number of attempts of the database operations", func(c *Config) flag.Value { return (*intValue)(&c.Database.RetryAttempts) }},
+	{"db-retry-interval", "DB_RETRY_INTERVAL", "Delay before the first retry of the database operations, doubled after every retry", func(c *Config) flag.Value { return (*durationValue)(&c.Database.RetryInterval) }},
+	{"db-retry-max-interval", "DB_RETRY_MAX_INTERVAL", "Maximum delay between the attempts of the database operations, 0 doesn't limit it", func(c *Config) flag.Value { return (*durationValue)(&c.Database.RetryMaxInterval) }},
+	{"db-retry-max-elapsed", "DB_RETRY_MAX_ELAPSED", "Time after which the database operations aren't retried anymore, 0 doesn't limit it", func(c *Config) flag.Value { return (*durationValue)(&c.Database.RetryMaxElapsed) }},
+	{"max-sessions", "MAX_SESSIONS", "Maximum number of concurrent sessions per user, 0 for no limit", func(c *Config) flag.Value { return (*intValue)(&c.Auth.MaxSessions) }},
+	{"token-ttl", "TOKEN_TTL", "Time a session is valid since the login", func(c *Config) flag.Value { return (*durationValue)(&c.Auth.TokenTTL) }},
+	{"token-mode", "TOKEN_MODE", "Access tokens mode, either \"opaque\" or \"jwt\" for signed access tokens renewed with refresh tokens", func(c *Config) flag.Value { return (*stringValue)(&c.Auth.TokenMode) }},
+	{"jwt-keys", "JWT_KEYS", "Path of the json file with the keys used to sign the access tokens in jwt mode", func(c *Config) flag.Value { return (*stringValue)(&c.Auth.JwtKeys) }},
+	{"access-token-ttl", "ACCESS_TOKEN_TTL", "Time a signed access token is valid in jwt mode", func(c *Config) flag.Value { return (*durationValue)(&c.Auth.AccessTokenTTL) }},
+	{"password-hash", "PASSWORD_HASH", "Algorithm of the password hashes, either \"argon2id\", \"bcrypt\" or \"scrypt\", older hashes are upgraded on login", func(c *Config) flag.Value { return (*stringValue)(&c.Auth.PasswordHash) }},
+	{"token-hash-key", "TOKEN_HASH_KEY", "Path of the file with the base64 key used to hash the stored tokens, required unless -dev is set", func(c *Config) flag.Value { return (*stringValue)(&c.Auth.TokenHashKey) }},
+	{"messages-default-limit", "MESSAGES_DEFAULT_LIMIT", "Number of messages returned when the request has no limit", func(c *Config) flag.Value { return (*int64Value)(&c.Messages.DefaultLimit) }},
+	{"messages-max-wait", "MESSAGES_MAX_WAIT", "Maximum number of seconds a request waits for new messages", func(c *Config) flag.Value { return (*int64Value)(&c.Messages.MaxWaitSeconds) }},
+	{"messages-max-text-size", "MESSAGES_MAX_TEXT_SIZE", "Maximum size of the text, url and source of the messages", func(c *Config) flag.Value { return (*intValue)(&c.Messages.MaxTextSize) }},
+	{"groups-max-members", "GROUPS_MAX_MEMBERS", "Maximum number of members of a group counting the owner", func(c *Config) flag.Value { return (*intValue)(&c.Messages.MaxGroupMembers) }},
+	{"log-level", "LOG_LEVEL", "Minimum level of the logged entries, either \"debug\", \"info\", \"warn\" or \"error\"", func(c *Config) flag.Value { return (*stringValue)(&c.Log.Level) }},
+	{"tracing-exporter", "TRACING_EXPORTER", "Exporter of the request traces, either \"none\", \"stdout\" or \"otlp\"", func(c *Config) flag.Value { return (*stringValue)(&c.Tracing.Exporter) }},
+	{"tracing-otlp-endpoint", "TRACING_OTLP_ENDPOINT", "Url of the OTLP/HTTP traces endpoint for the otlp exporter", func(c *Config) flag.Value { return (*stringValue)(&c.Tracing.OtlpEndpoint) }},
+	{"tracing-service-name", "TRACING_SERVICE_NAME", "Service name of the traces", func(c *Config) flag.Value { return (*stringValue)(&c.Tracing.ServiceName) }},
+}
+
+//Result of the command line parsing
+//Args are the arguments left after the flags, like the migrate command
+//PrintConfig is true if the configuration must be printed instead of starting the server
+
+type Options struct {
+	Config      Config
+	Args        []string
+	PrintConfig bool
+}
+
+//Loads the configuration from the command line arguments, the environment and the configuration file
+//The configuration file is the -config flag or the MESSAGE_SERVER_CONFIG environment variable, it's optional
+//Options set by the flags override the environment variables, which override the configuration file
+//lookupEnv returns the value of the environment variable and true if it's set, like os.LookupEnv
+//Returns flag.ErrHelp if the arguments request the usage, which is written to output
+//Returns error if an option is invalid or the configuration file can't be read
+
+func Load(name string, args []string, lookupEnv func(string) (string, bool), output io.Writer) (Options, error) {
+	defaults := Default()
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(output)
+
+	configFile, _ := lookupEnv(EnvPrefix + "CONFIG")
+	flags.StringVar(&configFile, "config", configFile, "Path of the YAML configuration file (env "+EnvPrefix+"CONFIG)")
+	printConfig := flags.Bool("print-config", false, "Print the configuration and exit")
+	for _, o := range options {
+		flags.Var(o.value(&defaults), o.flag, o.usage+" (env "+EnvPrefix+o.env+")")
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return Options{}, err
+	}
+
+	config := Default()
+	if configFile != "" {
+		if err := config.readFile(configFile); err != nil {
+			return Options{}, err
+		}
+	}
+
+	for _, o := range options {
+		if value, found := lookupEnv(EnvPrefix + o.env); found {
+			if err := o.value(&config).Set(value); err != nil {
+				return Options{}, fmt.Errorf("Invalid %v%v: %v", EnvPrefix, o.env, err)
+			}
+		}
+	}
+
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		for _, o := range options {
+			if o.flag == f.Name && err == nil {
+				err = o.value(&config).Set(f.Value.String())
+			}
+		}
+	})
+	if err != nil {
+		return Options{}, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return Options{}, err
+	}
+	return Options{Config: config, Args: flags.Args(), PrintConfig: *printConfig}, nil
+}
+
+//Overrides the configuration with the options of the YAML file
+//Returns error if the file can't be read or has unknown options
+
+func (c *Config) readFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Unable to read the configuration file: %v", err)
+	}
+
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return fmt.Errorf("Invalid configuration file %v: %v", path, err)
+	}
+	return nil
+}
+
+//Validates the configuration
+//Returns error describing the first invalid option
+
+func (c Config) Validate() error {
+	if c.Server.Addr == "" {
+		return errors.New("Invalid addr: it can't be empty")
+	}
+
+	if c.Server.ShutdownTimeout <= 0 {
+		return errors.New("Invalid shutdown-timeout: it must be positive")
+	}
+
+	if c.Server.RequestTimeout < 0 {
+		return errors.New("Invalid request-timeout: it can't be negative")
+	}
+
+	if c.Database.Driver != dao.SqliteDriver && c.Database.Driver != dao.PostgresDriver {
+		return fmt.Errorf("Invalid db-driver %q: it must be %q or %q", c.Database.Driver, dao.SqliteDriver, dao.PostgresDriver)
+	}
+
+	if c.Database.Source == "" {
+		return errors.New("Invalid db-source: it can't be empty")
+	}
+
+	if c.Database.RetryAttempts < 1 || c.Database.RetryInterval < 0 {
+		return errors.New("Invalid db retries: db-retry-attempts must be positive and db-retry-interval can't be negative")
+	}
+
+	if c.Database.RetryMaxInterval < 0 || c.Database.RetryMaxElapsed < 0 {
+		return errors.New("Invalid db retries: db-retry-max-interval and db-retry-max-elapsed can't be negative")
+	}
+
+	if c.Auth.MaxSessions < 0 || c.Auth.TokenTTL <= 0 {
+		return errors.New("Invalid session policy: max-sessions can't be negative and token-ttl must be positive")
+	}
+
+	if c.Auth.TokenMode != OpaqueTokens && c.Auth.TokenMode != JwtTokens {
+		return fmt.Errorf("Invalid token-mode %q: it must be %q or %q", c.Auth.TokenMode, OpaqueTokens, JwtTokens)
+	}
+
+	if c.Auth.TokenMode == JwtTokens && (c.Auth.JwtKeys == "" || c.Auth.AccessTokenTTL <= 0) {
+		return errors.New("Invalid jwt mode: jwt-keys is required and access-token-ttl must be positive")
+	}
+
+	if c.Auth.TokenHashKey == "" && !c.Server.Dev {
+		return errors.New("Invalid token-hash-key: it's required unless dev mode is on")
+	}
+
+	if err := c.PasswordPolicy().Validate(); err != nil {
+		return fmt.Errorf("Invalid password-hash: %v", err)
+	}
+
+	if c.Messages.DefaultLimit <= 0 || c.Messages.MaxWaitSeconds < 0 || c.Messages.MaxTextSize <= 0 || c.Messages.MaxGroupMembers < 2 {
+		return errors.New("Invalid messages limits: the default limit and max text size must be positive, " +
+			"the max wait can't be negative and groups must allow at least 2 members")
+	}
+
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		return fmt.Errorf("Invalid log-level: %v", err)
+	}
+
+	switch c.Tracing.Exporter {
+	case NoTracing, StdoutTracing:
+	case OtlpTracing:
+		if endpoint, err := url.Parse(c.Tracing.OtlpEndpoint); err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			return fmt.Errorf("Invalid tracing-otlp-endpoint %q: it must be an http or https url", c.Tracing.OtlpEndpoint)
+		}
+	default:
+		return fmt.Errorf("Invalid tracing-exporter %q: it must be %q, %q or %q", c.Tracing.Exporter, NoTracing, StdoutTracing, OtlpTracing)
+	}
+
+	if c.Tracing.ServiceName == "" {
+		return errors.New("Invalid tracing-service-name: it can't be empty")
+	}
+	return nil
+}
+
+//Returns the session policy of the configuration
+
+func (c Config) SessionPolicy() auth.SessionPolicy {
+	return auth.SessionPolicy{MaxSessions: c.Auth.MaxSessions, TokenTTL: c.Auth.TokenTTL}
+}
+
+//Returns the password policy of the configuration, the default policy using the configured algorithm
+
+func (c Config) PasswordPolicy() passwords.Policy {
+	policy := passwords.DefaultPolicy
+	policy.Algorithm = c.Auth.PasswordHash
+	return policy
+}
+
+//Returns the retry options of the database operations
+
+func (c Config) RetryOptions() dao.RetryOptions {
+	return dao.RetryOptions{
+		Attempts:    c.Database.RetryAttempts,
+		Interval:    c.Database.RetryInterval,
+		MaxInterval: c.Database.RetryMaxInterval,
+		MaxElapsed:  c.Database.RetryMaxElapsed,
+	}
+}
+
+//Returns the logger of the configuration, writing the entries of the configured level and above to out
+
+func (c Config) Logger(out io.Writer) *logging.Logger {
+	level, _ := logging.ParseLevel(c.Log.Level)
+	return logging.New(out, level)
+}
+
+//Returns the tracer of the configuration, nil if the tracing is disabled
+//The stdout exporter writes the spans to stdout, and the tracer logs its export failures to log
+
+func (c Config) Tracer(stdout io.Writer, log *logging.Logger) *tracing.Tracer {
+	switch c.Tracing.Exporter {
+	case StdoutTracing:
+		return tracing.New(tracing.NewWriterExporter(stdout), log)
+	case OtlpTracing:
+		return tracing.New(tracing.NewOTLPExporter(c.Tracing.OtlpEndpoint, c.Tracing.ServiceName), log)
+	default:
+		return nil
+	}
+}
+
+//Sets the limits of the messages and groups services
+
+func (c Config) ApplyLimits() {
+	messages.DEFAULT_LIMIT = c.Messages.DefaultLimit
+	messages.MAX_WAIT_SECONDS = c.Messages.MaxWaitSeconds
+	model.MAX_TEXT_FIELD_SIZE = c.Messages.MaxTextSize
+	groups.MAX_GROUP_MEMBERS = c.Messages.MaxGroupMembers
+}
+
+//Writes the configuration as YAML
+//The credentials of the urls and the paths of the keys are redacted, like in the logs
+
+func (c Config) Write(w io.Writer) error {
+	data, err := yaml.Marshal(c.redacted())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+//Returns a copy of the configuration without its secrets
+
+func (c Config) redacted() Config {
+	c.Database.Source = logging.RedactText(c.Database.Source)
+	c.Tracing.OtlpEndpoint = logging.RedactText(c.Tracing.OtlpEndpoint)
+	if c.Auth.JwtKeys != "" {
+		c.Auth.JwtKeys = logging.Redacted
+	}
+	if c.Auth.TokenHashKey != "" {
+		c.Auth.TokenHashKey = logging.Redacted
+	}
+	return c
+}
+
+type stringValue string
+
+func (v *stringValue) Set(s string) error {
+	*v = stringValue(s)
+	return nil
+}
+
+func (v *stringValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return string(*v)
+}
+
+type boolValue bool
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return errors.New("invalid boolean")
+	}
+	*v = boolValue(b)
+	return nil
+}
+
+func (v *boolValue) String() string {
+	if v == nil {
+		return "false"
+	}
+	return strconv.FormatBool(bool(*v))
+}
+
+func (v *boolValue) IsBoolFlag() bool {
+	return true
+}
+
+type intValue int
+
+func (v *intValue) Set(s string) error {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return errors.New("invalid number")
+	}
+	*v = intValue(i)
+	return nil
+}
+
+func (v *intValue) String() string {
+	if v == nil {
+		return "0"
+	}
+	return strconv.Itoa(int(*v))
+}
+
+type int64Value int64
+
+func (v *int64Value) Set(s string) error {
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return errors.New("invalid number")
+	}
+	*v = int64Value(i)
+	return nil
+}
+
+func (v *int64Value) String() string {
+	if v == nil {
+		return "0"
+	}
+	return strconv.FormatInt(int64(*v), 10)
+}
+
+type durationValue time.Duration
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return errors.New("invalid duration")
+	}
+	*v = durationValue(d)
+	return nil
+}
+
+func (v *durationValue) String() string {
+	if v == nil {
+		return "0s"
+	}
+	return time.Duration(*v).String()
+}
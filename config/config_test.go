@@ -0,0 +1,221 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/maidaneze/message-server/dao"
+	"github.com/maidaneze/message-server/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func envForTest(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, found := env[key]
+		return value, found
+	}
+}
+
+func writeConfigFileForTest(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "config*.yaml")
+	require.Nil(t, err)
+	defer file.Close()
+
+	_, err = file.WriteString(content)
+	require.Nil(t, err)
+	return file.Name()
+}
+
+func TestLoadDefaults(t *testing.T) {
+	options, err := Load("app", []string{"-dev"}, envForTest(nil), ioutil.Discard)
+	require.Nil(t, err)
+	expected := Default()
+	expected.Server.Dev = true
+	assert.Equal(t, expected, options.Config)
+	assert.Empty(t, options.Args)
+	assert.False(t, options.PrintConfig)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfigFileForTest(t, `
+server:
+  addr: ":9090"
+database:
+  source: file.db
+  retry_interval: 50ms
+auth:
+  max_sessions: 3
+  token_ttl: 2h
+  token_hash_key: token.key
+`)
+	defer os.Remove(path)
+
+	env := envForTest(map[string]string{
+		"MESSAGE_SERVER_CONFIG":            path,
+		"MESSAGE_SERVER_DB_SOURCE":         "env.db",
+		"MESSAGE_SERVER_MAX_SESSIONS":      "4",
+		"MESSAGE_SERVER_PASSWORD_HASH":     "bcrypt",
+		"MESSAGE_SERVER_MESSAGES_MAX_WAIT": "10",
+		"MESSAGE_SERVER_LOG_LEVEL":         "warn",
+	})
+
+	options, err := Load("app", []string{"-max-sessions", "5", "-print-config", "migrate", "up"}, env, ioutil.Discard)
+	require.Nil(t, err)
+
+	//File over defaults
+	assert.Equal(t, ":9090", options.Config.Server.Addr)
+	assert.Equal(t, time.Millisecond*50, options.Config.Database.RetryInterval)
+	assert.Equal(t, time.Hour*2, options.Config.Auth.TokenTTL)
+	assert.Equal(t, dao.SqliteDriver, options.Config.Database.Driver)
+	assert.Equal(t, "token.key", options.Config.Auth.TokenHashKey)
+
+	//Environment over file
+	assert.Equal(t, "env.db", options.Config.Database.Source)
+	assert.Equal(t, "bcrypt", options.Config.Auth.PasswordHash)
+	assert.Equal(t, int64(10), options.Config.Messages.MaxWaitSeconds)
+	assert.Equal(t, "warn", options.Config.Log.Level)
+
+	//Flags over environment
+	assert.Equal(t, 5, options.Config.Auth.MaxSessions)
+
+	assert.True(t, options.PrintConfig)
+	assert.Equal(t, []string{"migrate", "up"}, options.Args)
+}
+
+func TestLoadConfigFlagOverridesConfigEnv(t *testing.T) {
+	path := writeConfigFileForTest(t, "server:\n  addr: \":9090\"\n")
+	defer os.Remove(path)
+
+	env := envForTest(map[string]string{"MESSAGE_SERVER_CONFIG": "missing.yaml"})
+	options, err := Load("app", []string{"-config", path, "-dev"}, env, ioutil.Discard)
+	require.Nil(t, err)
+	assert.Equal(t, ":9090", options.Config.Server.Addr)
+}
+
+func TestFailToLoad(t *testing.T) {
+	unknownOption := writeConfigFileForTest(t, "server:\n  port: 8080\n")
+	defer os.Remove(unknownOption)
+
+	cases := []struct {
+		name string
+		args []string
+		env  map[string]string
+	}{
+		{"testFailToLoadUnknownFlag", []string{"-unknown"}, nil},
+		{"testFailToLoadInvalidFlag", []string{"-max-sessions", "a"}, nil},
+		{"testFailToLoadInvalidEnv", nil, map[string]string{"MESSAGE_SERVER_TOKEN_TTL": "a"}},
+		{"testFailToLoadMissingFile", []string{"-config", "missing.yaml"}, nil},
+		{"testFailToLoadUnknownFileOption", []string{"-config", unknownOption}, nil},
+		{"testFailToLoadInvalidConfig", []string{"-db-driver", "mysql"}, nil},
+		{"testFailToLoadWithoutTokenHashKey", []string{"-dev=false"}, nil},
+		{"testFailToLoadInvalidDev", nil, map[string]string{"MESSAGE_SERVER_DEV": "a"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			env := map[string]string{"MESSAGE_SERVER_DEV": "true"}
+			for key, value := range c.env {
+				env[key] = value
+			}
+			_, err := Load("app", c.args, envForTest(env), ioutil.Discard)
+			assert.NotNil(tt, err)
+		})
+	}
+}
+
+func TestLoadHelp(t *testing.T) {
+	output := new(bytes.Buffer)
+	_, err := Load("app", []string{"-h"}, envForTest(nil), output)
+	assert.Equal(t, flag.ErrHelp, err)
+	assert.Contains(t, output.String(), "MESSAGE_SERVER_DB_SOURCE")
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(c *Config)
+		valid  bool
+	}{
+		{"testValidateDefault", func(c *Config) {}, true},
+		{"testValidatePostgres", func(c *Config) { c.Database.Driver = dao.PostgresDriver }, true},
+		{"testValidateDevWithoutTokenHashKey", func(c *Config) { c.Auth.TokenHashKey = ""; c.Server.Dev = true }, true},
+		{"testValidateWithoutTokenHashKey", func(c *Config) { c.Auth.TokenHashKey = "" }, false},
+		{"testValidateJwt", func(c *Config) { c.Auth.TokenMode = JwtTokens; c.Auth.JwtKeys = "keys.json" }, true},
+		{"testValidateEmptyAddr", func(c *Config) { c.Server.Addr = "" }, false},
+		{"testValidateZeroShutdownTimeout", func(c *Config) { c.Server.ShutdownTimeout = 0 }, false},
+		{"testValidateDisabledRequestTimeout", func(c *Config) { c.Server.RequestTimeout = 0 }, true},
+		{"testValidateNegativeRequestTimeout", func(c *Config) { c.Server.RequestTimeout = -time.Second }, false},
+		{"testValidateUnknownDriver", func(c *Config) { c.Database.Driver = "mysql" }, false},
+		{"testValidateEmptySource", func(c *Config) { c.Database.Source = "" }, false},
+		{"testValidateNoRetryAttempts", func(c *Config) { c.Database.RetryAttempts = 0 }, false},
+		{"testValidateNegativeMaxSessions", func(c *Config) { c.Auth.MaxSessions = -1 }, false},
+		{"testValidateZeroTokenTTL", func(c *Config) { c.Auth.TokenTTL = 0 }, false},
+		{"testValidateUnknownTokenMode", func(c *Config) { c.Auth.TokenMode = "cookie" }, false},
+		{"testValidateJwtWithoutKeys", func(c *Config) { c.Auth.TokenMode = JwtTokens }, false},
+		{"testValidateUnknownPasswordHash", func(c *Config) { c.Auth.PasswordHash = "md5" }, false},
+		{"testValidateZeroDefaultLimit", func(c *Config) { c.Messages.DefaultLimit = 0 }, false},
+		{"testValidateZeroMaxTextSize", func(c *Config) { c.Messages.MaxTextSize = 0 }, false},
+		{"testValidateSingleMemberGroups", func(c *Config) { c.Messages.MaxGroupMembers = 1 }, false},
+		{"testValidateDebugLogLevel", func(c *Config) { c.Log.Level = "debug" }, true},
+		{"testValidateUnknownLogLevel", func(c *Config) { c.Log.Level = "trace" }, false},
+		{"testValidateStdoutTracing", func(c *Config) { c.Tracing.Exporter = StdoutTracing }, true},
+		{"testValidateOtlpTracing", func(c *Config) { c.Tracing.Exporter = OtlpTracing }, true},
+		{"testValidateUnknownTracingExporter", func(c *Config) { c.Tracing.Exporter = "jaeger" }, false},
+		{"testValidateInvalidOtlpEndpoint", func(c *Config) { c.Tracing.Exporter = OtlpTracing; c.Tracing.OtlpEndpoint = "localhost:4318" }, false},
+		{"testValidateEmptyServiceName", func(c *Config) { c.Tracing.ServiceName = "" }, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			config := Default()
+			config.Auth.TokenHashKey = "token.key"
+			c.modify(&config)
+			assert.Equal(tt, c.valid, config.Validate() == nil)
+		})
+	}
+}
+
+func TestWriteShouldBeLoadable(t *testing.T) {
+	config := Default()
+	config.Auth.TokenTTL = time.Minute * 90
+	config.Messages.MaxTextSize = 2048
+	config.Auth.TokenHashKey = "token.key"
+
+	output := new(bytes.Buffer)
+	require.Nil(t, config.Write(output))
+
+	path := writeConfigFileForTest(t, output.String())
+	defer os.Remove(path)
+
+	options, err := Load("app", []string{"-config", path}, envForTest(nil), ioutil.Discard)
+	require.Nil(t, err)
+	config.Auth.TokenHashKey = logging.Redacted
+	assert.Equal(t, config, options.Config)
+}
+
+func TestWriteShouldRedactTheSecrets(t *testing.T) {
+	cases := []struct {
+		name     string
+		modify   func(c *Config)
+		expected string
+	}{
+		{"testRedactConnectionUrl", func(c *Config) { c.Database.Source = "postgres://user1:pass1@db/messages" }, "source: postgres://user1:" + logging.Redacted + "@db/messages"},
+		{"testRedactConnectionString", func(c *Config) { c.Database.Source = "host=db user=user1 password=pass1" }, "source: host=db user=user1 password=" + logging.Redacted},
+		{"testRedactTokenHashKey", func(c *Config) { c.Auth.TokenHashKey = "pass1.key" }, "token_hash_key: '" + logging.Redacted + "'"},
+		{"testRedactJwtKeys", func(c *Config) { c.Auth.JwtKeys = "pass1.json" }, "jwt_keys: '" + logging.Redacted + "'"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			config := Default()
+			c.modify(&config)
+
+			output := new(bytes.Buffer)
+			require.Nil(tt, config.Write(output))
+			assert.Contains(tt, output.String(), c.expected)
+			assert.NotContains(tt, output.String(), "pass1")
+		})
+	}
+}
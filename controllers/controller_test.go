@@ -1,8 +1,11 @@
 package controllers
 
 import (
+	"bufio"
+	"context"
 	"github.com/maidaneze/message-server/dao"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"encoding/json"
@@ -10,16 +13,25 @@ import (
 
 	"bytes"
 
+	"github.com/maidaneze/message-server/logging"
 	"github.com/maidaneze/message-server/model"
 
 	"github.com/maidaneze/message-server/services/auth"
+	"github.com/maidaneze/message-server/services/groups"
+	"github.com/maidaneze/message-server/services/notifications"
+	"github.com/maidaneze/message-server/services/passwords"
+	"github.com/maidaneze/message-server/utils"
 
 	"math"
+	"net"
 
 	"fmt"
 
 	"time"
 
+	"strings"
+
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -28,16 +40,26 @@ var (
 	testHandler Handler
 	mockServer  *http.Server
 	testDB      dao.SqliteDB
+
+	//Cheap password policy, the tests don't need a secure hash
+	testPasswordPolicy = passwords.Policy{Algorithm: passwords.Argon2id, Argon2: passwords.Argon2Params{Time: 1, Memory: 64, Threads: 1}}
+
+	//The suite checks the responses, the access log is tested by itself
+	testLogger = logging.New(ioutil.Discard, logging.Error)
 )
 
 func TestSqlite3DatabaseSuiteWithOpenConnection(t *testing.T) {
 	//Setup
 
 	testDB = dao.SetupSqliteDatabaseTest(t, "foo.db")
-	testHandler = Handler{testDB}
+	tokens, err := auth.GenerateTokenHasher()
+	require.Nil(t, err)
+	testHandler = Handler{Db: testDB, Hub: notifications.NewHub(), Tokens: tokens, Passwords: testPasswordPolicy, Log: testLogger}
 
+	eventsHeartbeatInterval = 100 * time.Millisecond
 	mockServer = testHandler.Setup()
 	go mockServer.ListenAndServe()
+	waitForServerForTest(t)
 
 	//TestSuiteWithOpenDB
 
@@ -50,12 +72,26 @@ func TestSqlite3DatabaseSuiteWithOpenConnection(t *testing.T) {
 	t.Run("testFailToLoginUserInvalidBody", testFailToLoginUserInvalidBody)
 	t.Run("testFailToLoginUnregisteredUser", testFailToLoginUnregisteredUser)
 	t.Run("testFailToLoginWrongPassword", testFailToLoginWrongPassword)
+	t.Run("testLoginShouldUpgradePasswordHash", testLoginShouldUpgradePasswordHash)
 	t.Run("testFailToLoginUserInvalidFields", testFailToLoginUserInvalidFields)
+	t.Run("testLogoutUser", testLogoutUser)
+	t.Run("testOpaqueTokensShouldBeValidatedByHash", testOpaqueTokensShouldBeValidatedByHash)
+	t.Run("testRevokedTokensShouldFailValidation", testRevokedTokensShouldFailValidation)
+	t.Run("testLogoutAllUserSessions", testLogoutAllUserSessions)
+	t.Run("testFailToLogoutUser", testFailToLogoutUser)
+	t.Run("testSessions", testSessions)
+	t.Run("testFailToManageSessions", testFailToManageSessions)
+	t.Run("testJWTAccessTokens", testJWTAccessTokens)
+	t.Run("testRefreshTokenRotation", testRefreshTokenRotation)
+	t.Run("testFailToRefreshToken", testFailToRefreshToken)
 	t.Run("testFailToPostTextMessageInvalidFields", testFailToPostTextMessageInvalidFields)
 	t.Run("testFailToPostImageMessageInvalidFields", testFailToPostImageMessageInvalidFields)
 	t.Run("testFailToPostVideoMessageInvalidFields", testFailToPostVideoMessageInvalidFields)
 	t.Run("testFailToPostMessageInvalidBody", testFailToPostMessageInvalidBody)
 	t.Run("testFailToPostMessageUnauthorized", testFailToPostMessageUnauthorized)
+	t.Run("testMessagesShouldBeFromTheAuthenticatedUser", testMessagesShouldBeFromTheAuthenticatedUser)
+	t.Run("testRequestsShouldDefaultToTheAuthenticatedUser", testRequestsShouldDefaultToTheAuthenticatedUser)
+	t.Run("testProtectedRoutesShouldRequireToken", testProtectedRoutesShouldRequireToken)
 	t.Run("testPostTextMessage", testPostTextMessage)
 	t.Run("testPostImageMessage", testPostImageMessage)
 	t.Run("testPostVideoMessage", testPostVideoMessage)
@@ -63,6 +99,21 @@ func TestSqlite3DatabaseSuiteWithOpenConnection(t *testing.T) {
 	t.Run("testFailToGetMessageInvalidQueryParams", testFailToGetMessageInvalidQueryParams)
 	t.Run("testFailToGetMessageUnauthorized", testFailToGetMessageUnauthorized)
 	t.Run("testGetMessages", testGetMessages)
+	t.Run("testGetMessagesShouldWaitForNewMessage", testGetMessagesShouldWaitForNewMessage)
+	t.Run("testGetMessagesShouldReturnEmptyAfterWait", testGetMessagesShouldReturnEmptyAfterWait)
+	t.Run("testFailToStreamMessagesUnauthorized", testFailToStreamMessagesUnauthorized)
+	t.Run("testStreamMessages", testStreamMessages)
+	t.Run("testFailToStreamMessageEventsInvalidParams", testFailToStreamMessageEventsInvalidParams)
+	t.Run("testStreamMessageEvents", testStreamMessageEvents)
+	t.Run("testShutdownShouldCloseStreams", testShutdownShouldCloseStreams)
+	t.Run("testMessageReceipts", testMessageReceipts)
+	t.Run("testFailToReadMessagesInvalidFields", testFailToReadMessagesInvalidFields)
+	t.Run("testGetConversationMessages", testGetConversationMessages)
+	t.Run("testFailToGetConversationMessagesInvalidParams", testFailToGetConversationMessagesInvalidParams)
+	t.Run("testFailToCreateGroupInvalidFields", testFailToCreateGroupInvalidFields)
+	t.Run("testGroupMessages", testGroupMessages)
+	t.Run("testGroupMembers", testGroupMembers)
+	t.Run("testFullGroupShouldRejectNewMembers", testFullGroupShouldRejectNewMembers)
 	//Test de exito
 
 	//CloseDB
@@ -79,7 +130,7 @@ func TestSqlite3DatabaseSuiteWithOpenConnection(t *testing.T) {
 
 	//Teardown
 
-	mockServer.Shutdown(nil)
+	mockServer.Shutdown(context.Background())
 }
 
 func testHandler_CheckReturnsOk(t *testing.T) {
@@ -208,8 +259,62 @@ func testLoginUser(t *testing.T) {
 
 	for _, c := range cases {
 		t.Run(c.name, func(tt *testing.T) {
-			_ = createUserSuccessfullyForTest(tt, c.username, c.password)
-			_, _ = loginSuccessfullyForTest(t, c.username, c.password)
+			id := createUserSuccessfullyForTest(tt, c.username, c.password)
+			_, token := loginSuccessfullyForTest(t, c.username, c.password)
+
+			//Only the hash of the token is stored
+			tokens, err := testDB.GetTokens(context.Background(), id)
+			require.Nil(tt, err)
+			require.Equal(tt, 1, len(tokens))
+			assert.NotEqual(tt, token, tokens[0].Uuid)
+		})
+	}
+}
+
+func testLoginShouldUpgradePasswordHash(t *testing.T) {
+	dao.RefreshSchema(testDB)
+
+	legacyPassword, salt, err := passwords.GenerateSecurePassword("pass1")
+	require.Nil(t, err)
+	weakPassword, err := passwords.Policy{Algorithm: passwords.Bcrypt, BcryptCost: 4}.Hash("pass2")
+	require.Nil(t, err)
+
+	cases := []struct {
+		name     string
+		user     model.User
+		password string
+	}{
+		{"testLoginShouldUpgradeLegacyPassword", model.User{Username: "user1", Password: legacyPassword, Salt: salt}, "pass1"},
+		{"testLoginShouldUpgradeWeakerPassword", model.User{Username: "user2", Password: weakPassword}, "pass2"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			_, err := testDB.InsertUser(context.Background(), c.user)
+			require.Nil(tt, err)
+
+			//A wrong password doesn't upgrade the hash
+			resp, err := requestLogin(c.user.Username, "wrong")
+			require.Nil(tt, err)
+			assert.Equal(tt, http.StatusUnauthorized, resp.StatusCode)
+
+			user, _, err := testDB.GetUser(context.Background(), c.user.Username)
+			require.Nil(tt, err)
+			assert.Equal(tt, c.user.Password, user.Password)
+
+			//A successful login rehashes the password with the server policy
+			loginSuccessfullyForTest(tt, c.user.Username, c.password)
+
+			user, _, err = testDB.GetUser(context.Background(), c.user.Username)
+			require.Nil(tt, err)
+			assert.True(tt, strings.HasPrefix(user.Password, "$argon2id$"))
+			assert.Equal(tt, "", user.Salt)
+
+			valid, rehash := testPasswordPolicy.Verify(user, c.password)
+			assert.True(tt, valid)
+			assert.False(tt, rehash)
+
+			loginSuccessfullyForTest(tt, c.user.Username, c.password)
 		})
 	}
 }
@@ -292,6 +397,502 @@ func testFailToLoginUserWithClosedConnection(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
 }
 
+func testLogoutUser(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	id1 := createUserSuccessfullyForTest(t, "user1", "pass1")
+	_, token1 := loginSuccessfullyForTest(t, "user1", "pass1")
+	_, token2 := loginSuccessfullyForTest(t, "user1", "pass1")
+
+	assert.Equal(t, int64(1), logoutSuccessfullyForTest(t, "/logout", id1, token1))
+
+	//The revoked token is rejected and the other session is still valid
+	resp, err := requestGetMessage(id1, 1, 1, token1)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, err = requestGetMessage(id1, 1, 1, token2)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = requestAuthorized("POST", "/logout", model.LogoutRequestDTO{Id: id1}, token1)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func testLogoutAllUserSessions(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	id1 := createUserSuccessfullyForTest(t, "user1", "pass1")
+	_, token1 := loginSuccessfullyForTest(t, "user1", "pass1")
+	_, token2 := loginSuccessfullyForTest(t, "user1", "pass1")
+
+	assert.Equal(t, int64(2), logoutSuccessfullyForTest(t, "/logout/all", id1, token2))
+
+	for _, token := range []string{token1, token2} {
+		resp, err := requestGetMessage(id1, 1, 1, token)
+		require.Nil(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	//Logging in again opens a new session
+	_, token3 := loginSuccessfullyForTest(t, "user1", "pass1")
+	resp, err := requestGetMessage(id1, 1, 1, token3)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func testOpaqueTokensShouldBeValidatedByHash(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	h := jwtHandlerForTest(t)
+	h.Jwt = nil
+	id1 := createUserSuccessfullyForTest(t, "user1", "pass1")
+
+	valid, err := auth.GenerateToken()
+	require.Nil(t, err)
+	expired, err := auth.GenerateToken()
+	require.Nil(t, err)
+	expired.Expiration = utils.UTCTimeMilliseconds() - 1000
+	plain, err := auth.GenerateToken()
+	require.Nil(t, err)
+
+	for _, token := range []model.Token{h.Tokens.HashToken(valid), h.Tokens.HashToken(expired), plain} {
+		_, err := testDB.InsertToken(context.Background(), id1, token, 0)
+		require.Nil(t, err)
+	}
+
+	cases := []struct {
+		name           string
+		token          string
+		expectedStatus int
+	}{
+		{"testValidOpaqueToken", valid.Uuid, http.StatusOK},
+		{"testExpiredOpaqueToken", expired.Uuid, http.StatusUnauthorized},
+		{"testUnhashedOpaqueToken", plain.Uuid, http.StatusUnauthorized},
+		{"testHashAsOpaqueToken", h.Tokens.Hash(valid.Uuid), http.StatusUnauthorized},
+		{"testUnknownOpaqueToken", "invalid", http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			assert.Equal(tt, c.expectedStatus, serveAuthenticatedForTest(h, c.token).Code)
+		})
+	}
+}
+
+func testRevokedTokensShouldFailValidation(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	h := jwtHandlerForTest(t)
+	h.Jwt = nil
+	id1 := createUserSuccessfullyForTest(t, "user1", "pass1")
+
+	var tokens []model.Token
+	var sessions []model.Token
+	for i := 0; i < 3; i++ {
+		token, err := auth.GenerateToken()
+		require.Nil(t, err)
+		session, err := testDB.InsertToken(context.Background(), id1, h.Tokens.HashToken(token), 0)
+		require.Nil(t, err)
+		tokens = append(tokens, token)
+		sessions = append(sessions, session)
+	}
+	for _, token := range tokens {
+		require.Equal(t, http.StatusOK, serveAuthenticatedForTest(h, token.Uuid).Code)
+	}
+
+	//A deleted session is rejected, the others are still valid
+	deleted, err := testDB.DeleteSession(context.Background(), id1, sessions[0].SessionId)
+	require.Nil(t, err)
+	require.True(t, deleted)
+	assert.Equal(t, http.StatusUnauthorized, serveAuthenticatedForTest(h, tokens[0].Uuid).Code)
+	assert.Equal(t, http.StatusOK, serveAuthenticatedForTest(h, tokens[1].Uuid).Code)
+
+	//Deleting every session rejects the remaining tokens
+	count, err := testDB.DeleteTokens(context.Background(), id1)
+	require.Nil(t, err)
+	require.Equal(t, int64(2), count)
+	for _, token := range tokens {
+		assert.Equal(t, http.StatusUnauthorized, serveAuthenticatedForTest(h, token.Uuid).Code)
+	}
+}
+
+func testFailToLogoutUser(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	id1 := createUserSuccessfullyForTest(t, "user1", "pass1")
+	id2 := createUserSuccessfullyForTest(t, "user2", "pass2")
+	_, token1 := loginSuccessfullyForTest(t, "user1", "pass1")
+
+	cases := []struct {
+		name           string
+		method         string
+		path           string
+		body           interface{}
+		token          string
+		expectedStatus int
+	}{
+		{"logoutWithInvalidMethod", "GET", "/logout", model.LogoutRequestDTO{Id: id1}, token1, http.StatusMethodNotAllowed},
+		{"logoutWithInvalidBody", "POST", "/logout", "invalid", token1, http.StatusBadRequest},
+		{"logoutWithInvalidId", "POST", "/logout", model.LogoutRequestDTO{Id: -1}, token1, http.StatusBadRequest},
+		{"logoutAnotherUser", "POST", "/logout", model.LogoutRequestDTO{Id: id2}, token1, http.StatusUnauthorized},
+		{"logoutAllAnotherUser", "POST", "/logout/all", model.LogoutRequestDTO{Id: id2}, token1, http.StatusUnauthorized},
+		{"logoutWithInvalidToken", "POST", "/logout", model.LogoutRequestDTO{Id: id1}, "invalid", http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			resp, err := requestAuthorized(c.method, c.path, c.body, c.token)
+			require.Nil(tt, err)
+			defer resp.Body.Close()
+			assert.Equal(tt, c.expectedStatus, resp.StatusCode)
+		})
+	}
+
+	//The session is still valid
+	resp, err := requestGetMessage(id1, 1, 1, token1)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func testSessions(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	id1 := createUserSuccessfullyForTest(t, "user1", "pass1")
+	_, token1 := loginSuccessfullyForTest(t, "user1", "pass1")
+	_, token2 := loginSuccessfullyForTest(t, "user1", "pass1")
+	_, token3 := loginSuccessfullyForTest(t, "user1", "pass1")
+
+	//The oldest session is closed when the session limit is exceeded
+	resp, err := requestGetMessage(id1, 1, 1, token1)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	sessions := requestGetSessionsSuccessfullyForTest(t, id1, token3)
+	require.Equal(t, 2, len(sessions))
+	assert.False(t, sessions[0].Current)
+	assert.True(t, sessions[1].Current)
+	for _, session := range sessions {
+		assert.Equal(t, "Go-http-client/1.1", session.UserAgent)
+		assert.Equal(t, auth.DefaultSessionPolicy.TokenTTL, session.Expiration.Sub(session.Created))
+		assert.False(t, session.LastUsed.Before(session.Created))
+	}
+
+	//Terminating the other session revokes its token
+	resp, err = requestAuthorized("DELETE", fmt.Sprintf("/sessions?id=%v&session=%v", id1, sessions[0].Id), nil, token3)
+	require.Nil(t, err)
+	response := model.LogoutResponseDTO{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&response))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int64(1), response.Revoked)
+
+	resp, err = requestGetMessage(id1, 1, 1, token2)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	sessions = requestGetSessionsSuccessfullyForTest(t, id1, token3)
+	require.Equal(t, 1, len(sessions))
+	assert.True(t, sessions[0].Current)
+}
+
+func testFailToManageSessions(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	id1 := createUserSuccessfullyForTest(t, "user1", "pass1")
+	id2 := createUserSuccessfullyForTest(t, "user2", "pass2")
+	_, token1 := loginSuccessfullyForTest(t, "user1", "pass1")
+	_, token2 := loginSuccessfullyForTest(t, "user2", "pass2")
+	sessions := requestGetSessionsSuccessfullyForTest(t, id2, token2)
+	require.Equal(t, 1, len(sessions))
+
+	cases := []struct {
+		name           string
+		method         string
+		path           string
+		token          string
+		expectedStatus int
+	}{
+		{"sessionsWithInvalidMethod", "POST", fmt.Sprintf("/sessions?id=%v", id1), token1, http.StatusMethodNotAllowed},
+		{"getSessionsWithNegativeId", "GET", "/sessions?id=-1", token1, http.StatusBadRequest},
+		{"getSessionsWithInvalidId", "GET", "/sessions?id=a", token1, http.StatusBadRequest},
+		{"getSessionsOfAnotherUser", "GET", fmt.Sprintf("/sessions?id=%v", id2), token1, http.StatusUnauthorized},
+		{"getSessionsWithInvalidToken", "GET", fmt.Sprintf("/sessions?id=%v", id1), "invalid", http.StatusUnauthorized},
+		{"deleteSessionWithoutSession", "DELETE", fmt.Sprintf("/sessions?id=%v", id1), token1, http.StatusBadRequest},
+		{"deleteSessionWithInvalidSession", "DELETE", fmt.Sprintf("/sessions?id=%v&session=a", id1), token1, http.StatusBadRequest},
+		{"deleteSessionOfAnotherUser", "DELETE", fmt.Sprintf("/sessions?id=%v&session=%v", id2, sessions[0].Id), token1, http.StatusUnauthorized},
+		{"deleteSessionOwnedByAnotherUser", "DELETE", fmt.Sprintf("/sessions?id=%v&session=%v", id1, sessions[0].Id), token1, http.StatusNotFound},
+		{"deleteSessionNotFound", "DELETE", fmt.Sprintf("/sessions?id=%v&session=%v", id1, 100), token1, http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			resp, err := requestAuthorized(c.method, c.path, nil, c.token)
+			require.Nil(tt, err)
+			defer resp.Body.Close()
+			assert.Equal(tt, c.expectedStatus, resp.StatusCode)
+		})
+	}
+
+	//The sessions are still valid
+	assert.Equal(t, 1, len(requestGetSessionsSuccessfullyForTest(t, id1, token1)))
+	assert.Equal(t, 1, len(requestGetSessionsSuccessfullyForTest(t, id2, token2)))
+}
+
+func testJWTAccessTokens(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	h := jwtHandlerForTest(t)
+	id1 := createUserSuccessfullyForTest(t, "user1", "pass1")
+	id2 := createUserSuccessfullyForTest(t, "user2", "pass2")
+
+	login := jwtLoginSuccessfullyForTest(t, h, "user1", "pass1")
+	assert.Equal(t, id1, login.Id)
+	assert.Equal(t, 3, len(strings.Split(login.Token, ".")))
+	assert.NotEqual(t, "", login.RefreshToken)
+	assert.Equal(t, int64(60), login.ExpiresIn)
+
+	//The access token is signed, the refresh token isn't an access token
+	resp := serveForTest(h.Routes().ServeHTTP, "GET", fmt.Sprintf("/messages?id=%v&start=1", id1), nil, login.Token)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resp = serveForTest(h.Routes().ServeHTTP, "GET", fmt.Sprintf("/messages?id=%v&start=1", id1), nil, login.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	resp = serveForTest(h.Routes().ServeHTTP, "GET", fmt.Sprintf("/messages?id=%v&start=1", id2), nil, login.Token)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	//The server in opaque mode doesn't accept signed access tokens
+	httpResp, err := requestGetMessage(id1, 1, 1, login.Token)
+	require.Nil(t, err)
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, httpResp.StatusCode)
+
+	//The session of the access token is the current one
+	resp = serveForTest(h.Routes().ServeHTTP, "GET", fmt.Sprintf("/sessions?id=%v", id1), nil, login.Token)
+	require.Equal(t, http.StatusOK, resp.Code)
+	sessions := model.GetSessionsResponseDTO{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&sessions))
+	require.Equal(t, 1, len(sessions.Sessions))
+	assert.True(t, sessions.Sessions[0].Current)
+
+	//Logging out revokes the refresh token and the access token before it expires
+	resp = serveForTest(h.Routes().ServeHTTP, "POST", "/logout", model.LogoutRequestDTO{Id: id1}, login.Token)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resp = serveForTest(h.RefreshToken, "POST", "/refresh", model.RefreshRequestDTO{Id: id1, RefreshToken: login.RefreshToken}, "")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	resp = serveForTest(h.Routes().ServeHTTP, "GET", fmt.Sprintf("/messages?id=%v&start=1", id1), nil, login.Token)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	//Logging out of every session revokes the access tokens of the other sessions
+	login1 := jwtLoginSuccessfullyForTest(t, h, "user1", "pass1")
+	login2 := jwtLoginSuccessfullyForTest(t, h, "user1", "pass1")
+	resp = serveForTest(h.Routes().ServeHTTP, "POST", "/logout/all", model.LogoutRequestDTO{Id: id1}, login1.Token)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	for _, token := range []string{login1.Token, login2.Token} {
+		resp = serveForTest(h.Routes().ServeHTTP, "GET", fmt.Sprintf("/messages?id=%v&start=1", id1), nil, token)
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	}
+}
+
+func testRefreshTokenRotation(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	h := jwtHandlerForTest(t)
+	id1 := createUserSuccessfullyForTest(t, "user1", "pass1")
+	login := jwtLoginSuccessfullyForTest(t, h, "user1", "pass1")
+
+	//Refreshing rotates the refresh token and issues a new access token for the same session
+	resp := serveForTest(h.RefreshToken, "POST", "/refresh", model.RefreshRequestDTO{Id: id1, RefreshToken: login.RefreshToken}, "")
+	require.Equal(t, http.StatusOK, resp.Code)
+	refreshed := model.LoginResponseDTO{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&refreshed))
+	assert.Equal(t, id1, refreshed.Id)
+	assert.NotEqual(t, login.RefreshToken, refreshed.RefreshToken)
+
+	resp = serveForTest(h.Routes().ServeHTTP, "GET", fmt.Sprintf("/messages?id=%v&start=1", id1), nil, refreshed.Token)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	tokens, err := testDB.GetTokens(context.Background(), id1)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(tokens))
+	assert.Equal(t, h.Tokens.Hash(refreshed.RefreshToken), tokens[0].Uuid)
+
+	//Reusing the replaced refresh token revokes the session
+	resp = serveForTest(h.RefreshToken, "POST", "/refresh", model.RefreshRequestDTO{Id: id1, RefreshToken: login.RefreshToken}, "")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	resp = serveForTest(h.RefreshToken, "POST", "/refresh", model.RefreshRequestDTO{Id: id1, RefreshToken: refreshed.RefreshToken}, "")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	tokens, err = testDB.GetTokens(context.Background(), id1)
+	require.Nil(t, err)
+	assert.Equal(t, 0, len(tokens))
+}
+
+func testFailToRefreshToken(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	h := jwtHandlerForTest(t)
+	id1 := createUserSuccessfullyForTest(t, "user1", "pass1")
+	id2 := createUserSuccessfullyForTest(t, "user2", "pass2")
+	login := jwtLoginSuccessfullyForTest(t, h, "user1", "pass1")
+
+	cases := []struct {
+		name           string
+		handler        http.HandlerFunc
+		method         string
+		body           interface{}
+		expectedStatus int
+	}{
+		{"refreshWithInvalidMethod", h.Routes().ServeHTTP, "GET", model.RefreshRequestDTO{Id: id1, RefreshToken: login.RefreshToken}, http.StatusMethodNotAllowed},
+		{"refreshWithOpaqueTokens", testHandler.RefreshToken, "POST", model.RefreshRequestDTO{Id: id1, RefreshToken: login.RefreshToken}, http.StatusNotFound},
+		{"refreshWithInvalidBody", h.RefreshToken, "POST", "invalid", http.StatusBadRequest},
+		{"refreshWithoutId", h.RefreshToken, "POST", model.RefreshRequestDTO{RefreshToken: login.RefreshToken}, http.StatusBadRequest},
+		{"refreshWithoutToken", h.RefreshToken, "POST", model.RefreshRequestDTO{Id: id1}, http.StatusBadRequest},
+		{"refreshWithInvalidToken", h.RefreshToken, "POST", model.RefreshRequestDTO{Id: id1, RefreshToken: "invalid"}, http.StatusUnauthorized},
+		{"refreshAnotherUser", h.RefreshToken, "POST", model.RefreshRequestDTO{Id: id2, RefreshToken: login.RefreshToken}, http.StatusUnauthorized},
+		{"refreshWithAccessToken", h.RefreshToken, "POST", model.RefreshRequestDTO{Id: id1, RefreshToken: login.Token}, http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			resp := serveForTest(c.handler, c.method, "/refresh", c.body, "")
+			assert.Equal(tt, c.expectedStatus, resp.Code)
+		})
+	}
+
+	//The refresh token is still valid
+	resp := serveForTest(h.RefreshToken, "POST", "/refresh", model.RefreshRequestDTO{Id: id1, RefreshToken: login.RefreshToken}, "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
+
+func testShutdownShouldCloseStreams(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	h := jwtHandlerForTest(t)
+	createUserSuccessfullyForTest(t, "user1", "pass1")
+	login := jwtLoginSuccessfullyForTest(t, h, "user1", "pass1")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	srv := h.Setup()
+	go srv.Serve(listener)
+
+	header := http.Header{"Authorization": {"Bearer " + login.Token}}
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%v/messages/stream?start=1", listener.Addr()), header)
+	require.Nil(t, err)
+	defer conn.Close()
+
+	//A request waiting for new messages
+	polled := make(chan int, 1)
+	go func() {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("http://%v/messages?start=1&wait=30", listener.Addr()), nil)
+		req.Header = header
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			polled <- 0
+			return
+		}
+		resp.Body.Close()
+		polled <- resp.StatusCode
+	}()
+
+	for start := time.Now(); h.Hub.Subscribers() < 2 && time.Since(start) < time.Second; {
+		time.Sleep(time.Millisecond)
+	}
+	require.Equal(t, 2, h.Hub.Subscribers())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	require.Nil(t, h.Shutdown(ctx, srv))
+
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway))
+	assert.Equal(t, http.StatusOK, <-polled)
+
+	//The server doesn't accept new connections
+	_, err = http.Get(fmt.Sprintf("http://%v/check", listener.Addr()))
+	assert.NotNil(t, err)
+}
+
+func jwtHandlerForTest(t *testing.T) Handler {
+	keys := auth.KeySet{
+		Current: "test",
+		Keys:    map[string]auth.SigningKey{"test": {Id: "test", Algorithm: auth.HS256, Secret: []byte("0123456789abcdef0123456789abcdef")}},
+	}
+	issuer, err := auth.NewJWTIssuer(keys, time.Minute)
+	require.Nil(t, err)
+	tokens, err := auth.GenerateTokenHasher()
+	require.Nil(t, err)
+	return Handler{Db: testDB, Hub: notifications.NewHub(), Sessions: auth.DefaultSessionPolicy, Jwt: issuer, Tokens: tokens, Passwords: testPasswordPolicy, Log: testLogger}
+}
+
+//Database whose sessions are always open, so the tests can issue signed tokens without logging in
+
+type openSessionsDBForTest struct {
+	dao.DB
+}
+
+func (openSessionsDBForTest) CheckSession(ctx context.Context, userid int64, sessionId int64) (bool, error) {
+	return true, nil
+}
+
+func jwtLoginSuccessfullyForTest(t *testing.T, h Handler, username string, password string) model.LoginResponseDTO {
+	resp := serveForTest(h.LoginUser, "POST", "/login", model.UserRequestDTO{Username: username, Password: password}, "")
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	response := model.LoginResponseDTO{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&response))
+	return response
+}
+
+func serveForTest(handler http.HandlerFunc, method string, path string, body interface{}, token string) *httptest.ResponseRecorder {
+	requestByte, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(requestByte))
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp := httptest.NewRecorder()
+	handler(resp, req)
+	return resp
+}
+
+//Serves an authenticated request through the routes of the handler, which validate the token
+
+func serveAuthenticatedForTest(h Handler, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/sessions", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+	h.Routes().ServeHTTP(resp, req)
+	return resp
+}
+
+func requestGetSessionsSuccessfullyForTest(t *testing.T, id int64, token string) []model.SessionResponse {
+
+	resp, err := requestAuthorized("GET", fmt.Sprintf("/sessions?id=%v", id), nil, token)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	response := model.GetSessionsResponseDTO{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&response))
+	return response.Sessions
+}
+
+func logoutSuccessfullyForTest(t *testing.T, path string, id int64, token string) int64 {
+
+	resp, err := requestAuthorized("POST", path, model.LogoutRequestDTO{Id: id}, token)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	response := model.LogoutResponseDTO{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&response))
+	return response.Revoked
+}
+
 func testPostTextMessage(t *testing.T) {
 	dao.RefreshSchema(testDB)
 	username1 := "user1"
@@ -363,11 +964,9 @@ func testFailToPostTextMessageInvalidFields(t *testing.T) {
 		recipientid int64
 		content     model.MessageContent
 	}{
-		{"testFailToPostTextMessageSenderZero", 0, id1, content},
 		{"testFailToPostTextMessageRecipientZero", id2, 0, content},
-		{"testFailToPostTextMessageUnregisteredSender", math.MaxInt64, id1, content},
 		{"testFailToPostTextMessageUnregisteredRecipient", id2, math.MaxInt64, content},
-		{"testFailToPostTextMessageSenderEqualsRecipient", id1, id1, content},
+		{"testFailToPostTextMessageSenderEqualsRecipient", id2, id2, content},
 		{"testFailToPostTextMessageHugeTextField", id2, id1, bigContent},
 	}
 
@@ -406,11 +1005,9 @@ func testFailToPostImageMessageInvalidFields(t *testing.T) {
 		recipientid int64
 		content     model.MessageContent
 	}{
-		{"testFailToPostImageMessageSenderZero", 0, id1, content},
 		{"testFailToPostImageMessageRecipientZero", id2, 0, content},
-		{"testFailToPostImageMessageUnregisteredSender", math.MaxInt64, id1, content},
 		{"testFailToPostImageMessageUnregisteredRecipient", id2, math.MaxInt64, content},
-		{"testFailToPostImageMessageSenderEqualsRecipient", id1, id1, content},
+		{"testFailToPostImageMessageSenderEqualsRecipient", id2, id2, content},
 		{"testFailToPostImageMessageHugeUrlField", id2, id1, bigUrl},
 		{"testFailToPostImageMessageZeroHeight", id2, id1, zeroHeight},
 		{"testFailToPostImageMessageZeroWidth", id2, id1, zeroWidth},
@@ -452,11 +1049,9 @@ func testFailToPostVideoMessageInvalidFields(t *testing.T) {
 		recipientid int64
 		content     model.MessageContent
 	}{
-		{"testFailToPostVideoMessageSenderZero", 0, id1, content},
 		{"testFailToPostVideoMessageRecipientZero", id2, 0, content},
-		{"testFailToPostVideoMessageUnregisteredSender", math.MaxInt64, id1, content},
 		{"testFailToPostVideoMessageUnregisteredRecipient", id2, math.MaxInt64, content},
-		{"testFailToPostVideoMessageSenderEqualsRecipient", id1, id1, content},
+		{"testFailToPostVideoMessageSenderEqualsRecipient", id2, id2, content},
 		{"testFailToPostVideoMessageHugeUrlField", id2, id1, bigUrl},
 		{"testFailToPostVideoMessageHugeSourceField", id2, id1, bigSource},
 		{"testFailToPostVideoMessageInvalidSourceField1", id2, id1, invalid1},
@@ -474,9 +1069,12 @@ func testFailToPostVideoMessageInvalidFields(t *testing.T) {
 func testFailToPostMessageInvalidBody(t *testing.T) {
 	dao.RefreshSchema(testDB)
 
-	requestReader := bytes.NewReader([]byte("invalid"))
+	_ = createUserSuccessfullyForTest(t, "user1", "pass1")
+	_, token := loginSuccessfullyForTest(t, "user1", "pass1")
 
-	resp, _ := http.Post("http://localhost:8080/messages", "application/json", requestReader)
+	req, _ := http.NewRequest("POST", "http://localhost:8080/messages", bytes.NewReader([]byte("invalid")))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, _ := http.DefaultClient.Do(req)
 	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
 }
 
@@ -498,82 +1096,205 @@ func testFailToPostMessageUnauthorized(t *testing.T) {
 
 }
 
-func testFailToPostMessageWithClosedConnection(t *testing.T) {
+func testProtectedRoutesShouldRequireToken(t *testing.T) {
 	dao.RefreshSchema(testDB)
+	wrongToken, err := auth.GenerateToken()
+	require.Nil(t, err)
 
-	token, _ := auth.GenerateToken()
-	resp, err := requestPostMessage(int64(1), int64(2), model.TextContent{"text", "text"}, token.Uuid)
-	if err == nil {
-		defer resp.Body.Close()
-	}
-	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
-}
-
-func testFailToGetMessageInvalidQueryParams(t *testing.T) {
-	dao.RefreshSchema(testDB)
+	public := map[string]bool{"check": true, "users": true, "login": true, "refresh": true}
 
-	req, _ := http.NewRequest("GET", fmt.Sprintf("http://localhost:8080/messages?id=%v&start=%v&limit=%v", "invalid", 1, 1), nil)
-	resp, _ := http.DefaultClient.Do(req)
-	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	for _, route := range testHandler.router().routes {
+		for _, method := range route.methods() {
+			t.Run(method+" "+route.pattern, func(tt *testing.T) {
+				path := "/" + strings.Replace(route.pattern, "{id}", "1", -1)
 
-	req, _ = http.NewRequest("GET", fmt.Sprintf("http://localhost:8080/messages?id=%v&start=%v&limit=%v", 1, "invalid", 1), nil)
-	resp, _ = http.DefaultClient.Do(req)
-	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+				resp, err := requestAuthorized(method, path, nil, wrongToken.Uuid)
+				require.Nil(tt, err)
+				resp.Body.Close()
 
-	req, _ = http.NewRequest("GET", fmt.Sprintf("http://localhost:8080/messages?id=%v&start=%v&limit=%v", 1, 1, "invalid"), nil)
-	resp, _ = http.DefaultClient.Do(req)
-	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+				if public[route.pattern] {
+					assert.NotEqual(tt, http.StatusUnauthorized, resp.StatusCode)
+				} else {
+					assert.Equal(tt, http.StatusUnauthorized, resp.StatusCode)
+				}
+			})
+		}
+	}
 }
 
-func testFailToGetMessageUnauthorized(t *testing.T) {
+func testMessagesShouldBeFromTheAuthenticatedUser(t *testing.T) {
 	dao.RefreshSchema(testDB)
-	username1 := "user1"
-	password1 := "pass1"
-
-	username2 := "user2"
-	password2 := "pass2"
-	id1 := createUserSuccessfullyForTest(t, username1, password1)
-	_ = createUserSuccessfullyForTest(t, username2, password2)
-	id2, token := loginSuccessfullyForTest(t, username2, password2)
+	id1 := createUserSuccessfullyForTest(t, "user1", "pass1")
+	id2 := createUserSuccessfullyForTest(t, "user2", "pass2")
+	_, token1 := loginSuccessfullyForTest(t, "user1", "pass1")
+	_, token2 := loginSuccessfullyForTest(t, "user2", "pass2")
 	content := model.TextContent{"text", "text"}
-	messageID, _ := postMessageSuccessfullyForTest(t, id2, id1, content, token)
-	wrongToken, err := auth.GenerateToken()
-	assert.Nil(t, err)
-	req, err := requestGetMessage(id1, messageID, 1, wrongToken.Uuid)
-	assert.Equal(t, http.StatusUnauthorized, req.StatusCode)
-
-}
-
-func testFailToGetMessageInvalidFields(t *testing.T) {
-	dao.RefreshSchema(testDB)
-
-	username1 := "user1"
-	password1 := "pass1"
 
-	username2 := "user2"
-	password2 := "pass2"
-	id1 := createUserSuccessfullyForTest(t, username1, password1)
-	_ = createUserSuccessfullyForTest(t, username2, password2)
-	id2, token2 := loginSuccessfullyForTest(t, username2, password2)
-	content := model.TextContent{"text", "text"}
+	//Without sender and id the user is the one of the token
+	messageId, _ := postMessageSuccessfullyForTest(t, 0, id1, content, token2)
 
-	messageId, _ := postMessageSuccessfullyForTest(t, id2, id1, content, token2)
+	messages := requestGetMessageSuccessfullyForTest(t, 0, messageId, 1, token1)
+	require.Equal(t, 1, len(messages))
+	assert.Equal(t, id2, messages[0].Sender)
+	assert.Equal(t, id1, messages[0].Recipient)
 
+	//Claiming to be another user is rejected
 	cases := []struct {
-		name  string
-		id    int64
-		start int64
-		limit int64
+		name      string
+		sender    int64
+		recipient int64
 	}{
-		{"testFailToGetMessageRecipientZero", 0, messageId, 1},
-		{"testFailToGetMessageStartZero", id2, 0, 1},
-		{"testFailToGetMessageLimitZero", id2, messageId, 0},
+		{"testFailToPostMessageAsOtherUser", id1, id2},
+		{"testFailToPostMessageAsUnregisteredUser", math.MaxInt64, id1},
 	}
 
 	for _, c := range cases {
 		t.Run(c.name, func(tt *testing.T) {
-			resp, _ := requestGetMessage(c.id, c.start, c.limit, token2)
-			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			resp, err := requestPostMessage(c.sender, c.recipient, content, token2)
+			require.Nil(tt, err)
+			assert.Equal(tt, http.StatusUnauthorized, resp.StatusCode)
+		})
+	}
+
+	resp, err := requestGetMessage(id2, messageId, 1, token1)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func testRequestsShouldDefaultToTheAuthenticatedUser(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	createUserSuccessfullyForTest(t, "user1", "pass1")
+	id2 := createUserSuccessfullyForTest(t, "user2", "pass2")
+	_, token1 := loginSuccessfullyForTest(t, "user1", "pass1")
+	_, token2 := loginSuccessfullyForTest(t, "user2", "pass2")
+	content := model.TextContent{Type: "text", Text: "text"}
+
+	messageId, _ := postMessageSuccessfullyForTest(t, 0, id2, content, token1)
+	groupId := createGroupSuccessfullyForTest(t, 0, "group", []int64{id2}, token1)
+	postGroupMessageSuccessfullyForTest(t, 0, groupId, content, token1)
+
+	//Without the user id the user is the one of the token
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		token  string
+	}{
+		{"getSessionsWithoutId", "GET", "/sessions", nil, token1},
+		{"getConversationMessagesWithoutId", "GET", fmt.Sprintf("/conversations/%v/messages", id2), nil, token1},
+		{"getMessageStatusWithoutId", "GET", fmt.Sprintf("/messages/status?start=%v", messageId), nil, token1},
+		{"readMessagesWithoutRecipient", "POST", "/messages/read", model.ReadMessagesRequestDTO{Message: messageId}, token2},
+		{"getGroupMembersWithoutId", "GET", fmt.Sprintf("/groups/members?group=%v", groupId), nil, token2},
+		{"getGroupMessagesWithoutId", "GET", fmt.Sprintf("/groups/messages?group=%v&start=1", groupId), nil, token2},
+		{"removeGroupMemberWithoutId", "DELETE", fmt.Sprintf("/groups/members?group=%v&member=%v", groupId, id2), nil, token2},
+		{"addGroupMemberWithoutUser", "POST", "/groups/members", model.GroupMemberRequestDTO{Group: groupId, Member: id2}, token1},
+		{"logoutWithoutId", "POST", "/logout", model.LogoutRequestDTO{}, token2},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			resp, err := requestAuthorized(c.method, c.path, c.body, c.token)
+			require.Nil(tt, err)
+			defer resp.Body.Close()
+			assert.Equal(tt, http.StatusOK, resp.StatusCode)
+		})
+	}
+
+	//The message was read by the recipient of the token
+	resp, err := requestAuthorized("GET", fmt.Sprintf("/messages/status?start=%v", messageId), nil, token1)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	response := model.GetMessageStatusResponseDTO{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&response))
+	require.Equal(t, 1, len(response.Statuses))
+	assert.NotNil(t, response.Statuses[0].Read)
+}
+
+func testFailToPostMessageWithClosedConnection(t *testing.T) {
+	dao.RefreshSchema(testDB)
+
+	token, _ := auth.GenerateToken()
+	resp, err := requestPostMessage(int64(1), int64(2), model.TextContent{"text", "text"}, token.Uuid)
+	if err == nil {
+		defer resp.Body.Close()
+	}
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
+
+func testFailToGetMessageInvalidQueryParams(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	id := createUserSuccessfullyForTest(t, "user1", "pass1")
+	_, token := loginSuccessfullyForTest(t, "user1", "pass1")
+
+	cases := []struct {
+		name  string
+		query string
+	}{
+		{"testFailToGetMessageInvalidId", fmt.Sprintf("id=%v&start=%v&limit=%v", "invalid", 1, 1)},
+		{"testFailToGetMessageInvalidStart", fmt.Sprintf("id=%v&start=%v&limit=%v", id, "invalid", 1)},
+		{"testFailToGetMessageInvalidLimit", fmt.Sprintf("id=%v&start=%v&limit=%v", id, 1, "invalid")},
+		{"testFailToGetMessageNegativeId", fmt.Sprintf("id=%v&start=%v&limit=%v", -1, 1, 1)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			req, _ := http.NewRequest("GET", "http://localhost:8080/messages?"+c.query, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			resp, _ := http.DefaultClient.Do(req)
+			assert.Equal(tt, http.StatusBadRequest, resp.StatusCode)
+		})
+	}
+}
+
+func testFailToGetMessageUnauthorized(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	username1 := "user1"
+	password1 := "pass1"
+
+	username2 := "user2"
+	password2 := "pass2"
+	id1 := createUserSuccessfullyForTest(t, username1, password1)
+	_ = createUserSuccessfullyForTest(t, username2, password2)
+	id2, token := loginSuccessfullyForTest(t, username2, password2)
+	content := model.TextContent{"text", "text"}
+	messageID, _ := postMessageSuccessfullyForTest(t, id2, id1, content, token)
+	wrongToken, err := auth.GenerateToken()
+	assert.Nil(t, err)
+	req, err := requestGetMessage(id1, messageID, 1, wrongToken.Uuid)
+	assert.Equal(t, http.StatusUnauthorized, req.StatusCode)
+
+}
+
+func testFailToGetMessageInvalidFields(t *testing.T) {
+	dao.RefreshSchema(testDB)
+
+	username1 := "user1"
+	password1 := "pass1"
+
+	username2 := "user2"
+	password2 := "pass2"
+	id1 := createUserSuccessfullyForTest(t, username1, password1)
+	_ = createUserSuccessfullyForTest(t, username2, password2)
+	id2, token2 := loginSuccessfullyForTest(t, username2, password2)
+	content := model.TextContent{"text", "text"}
+
+	messageId, _ := postMessageSuccessfullyForTest(t, id2, id1, content, token2)
+
+	cases := []struct {
+		name  string
+		id    int64
+		start int64
+		limit int64
+	}{
+		{"testFailToGetMessageStartZero", id2, 0, 1},
+		{"testFailToGetMessageLimitZero", id2, messageId, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			resp, _ := requestGetMessage(c.id, c.start, c.limit, token2)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
 		})
 	}
 }
@@ -612,17 +1333,17 @@ func testGetMessages(t *testing.T) {
 	timestamp1, err := time.Parse("2006-01-02T15:04:05Z", stamp1)
 	assert.Nil(t, err)
 
-	textMessage := model.MessageResponse{messageId1, timestamp1, id2, id1, textContent}
+	textMessage := model.MessageResponse{messageId1, timestamp1, id2, id1, textContent, nil, nil}
 
 	timestamp2, err := time.Parse("2006-01-02T15:04:05Z", stamp2)
 	assert.Nil(t, err)
 
-	imageMessage := model.MessageResponse{messageId2, timestamp2, id2, id1, imageContent}
+	imageMessage := model.MessageResponse{messageId2, timestamp2, id2, id1, imageContent, nil, nil}
 
 	timestamp3, err := time.Parse("2006-01-02T15:04:05Z", stamp3)
 	assert.Nil(t, err)
 
-	videoMessage := model.MessageResponse{messageId3, timestamp3, id2, id1, videoContent}
+	videoMessage := model.MessageResponse{messageId3, timestamp3, id2, id1, videoContent, nil, nil}
 
 	emptyMessages := []model.MessageResponse{}
 	messages1 := []model.MessageResponse{textMessage, imageMessage, videoMessage}
@@ -693,6 +1414,273 @@ func testGetMessages(t *testing.T) {
 	}
 }
 
+func testGetMessagesShouldWaitForNewMessage(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	username1 := "user1"
+	password1 := "pass1"
+
+	username2 := "user2"
+	password2 := "pass2"
+	id1 := createUserSuccessfullyForTest(t, username1, password1)
+	_ = createUserSuccessfullyForTest(t, username2, password2)
+	_, token1 := loginSuccessfullyForTest(t, username1, password1)
+	id2, token2 := loginSuccessfullyForTest(t, username2, password2)
+	textContent := model.TextContent{"text", "text"}
+
+	subscribers := testHandler.Hub.Subscribers()
+	received := make(chan []model.MessageResponse)
+	go func() {
+		resp, err := requestGetMessageWithWait(id1, 1, 10, 5, token1)
+		if err != nil {
+			received <- nil
+			return
+		}
+		defer resp.Body.Close()
+
+		response := model.GetMessageResponseDTO{}
+		json.NewDecoder(resp.Body).Decode(&response)
+		received <- response.Messages
+	}()
+
+	//Wait until the request is subscribed to the new messages
+	for start := time.Now(); testHandler.Hub.Subscribers() == subscribers && time.Since(start) < time.Second; {
+		time.Sleep(time.Millisecond)
+	}
+	require.Equal(t, subscribers+1, testHandler.Hub.Subscribers())
+
+	start := time.Now()
+	messageId, _ := postMessageSuccessfullyForTest(t, id2, id1, textContent, token2)
+
+	actualMessages := <-received
+	require.Equal(t, 1, len(actualMessages))
+	assert.Equal(t, messageId, actualMessages[0].Id)
+	assert.True(t, time.Since(start) < 4*time.Second)
+}
+
+func testGetMessagesShouldReturnEmptyAfterWait(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	username1 := "user1"
+	password1 := "pass1"
+
+	id1 := createUserSuccessfullyForTest(t, username1, password1)
+	_, token1 := loginSuccessfullyForTest(t, username1, password1)
+
+	wait := int64(1)
+	start := time.Now()
+	resp, err := requestGetMessageWithWait(id1, 1, 10, wait, token1)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= time.Duration(wait)*time.Second, "returned after %v", elapsed)
+
+	response := map[string]json.RawMessage{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&response))
+	assert.JSONEq(t, "[]", string(response["messages"]))
+}
+
+func requestGetMessageWithWait(recipient int64, start int64, limit int64, wait int64, token string) (*http.Response, error) {
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:8080/messages?id=%v&start=%v&limit=%v&wait=%v", recipient, start, limit, wait), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return http.DefaultClient.Do(req)
+}
+
+func testFailToStreamMessagesUnauthorized(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	username1 := "user1"
+	password1 := "pass1"
+
+	id1 := createUserSuccessfullyForTest(t, username1, password1)
+	_, _ = loginSuccessfullyForTest(t, username1, password1)
+	wrongToken, err := auth.GenerateToken()
+	assert.Nil(t, err)
+
+	_, resp, err := dialStreamMessages(id1, 1, wrongToken.Uuid)
+	require.NotNil(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	_, resp, err = dialStreamMessages(0, 1, wrongToken.Uuid)
+	require.NotNil(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func testStreamMessages(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	username1 := "user1"
+	password1 := "pass1"
+
+	username2 := "user2"
+	password2 := "pass2"
+	id1 := createUserSuccessfullyForTest(t, username1, password1)
+	_ = createUserSuccessfullyForTest(t, username2, password2)
+	_, token1 := loginSuccessfullyForTest(t, username1, password1)
+	id2, token2 := loginSuccessfullyForTest(t, username2, password2)
+	textContent := model.TextContent{"text", "text"}
+
+	//Messages sent before connecting are recovered from the database
+	messageId1, _ := postMessageSuccessfullyForTest(t, id2, id1, textContent, token2)
+	messageId2, _ := postMessageSuccessfullyForTest(t, id2, id1, textContent, token2)
+
+	conn, _, err := dialStreamMessages(id1, messageId2, token1)
+	require.Nil(t, err)
+
+	message := readStreamMessageForTest(t, conn)
+	assert.Equal(t, messageId2, message.Id)
+
+	//Messages sent after connecting are pushed as they are inserted
+	messageId3, _ := postMessageSuccessfullyForTest(t, id2, id1, textContent, token2)
+	message = readStreamMessageForTest(t, conn)
+	assert.Equal(t, messageId3, message.Id)
+	assert.Equal(t, id2, message.Sender)
+	assert.Equal(t, id1, message.Recipient)
+	conn.Close()
+
+	//Reconnecting from the first message recovers every message in order
+	conn, _, err = dialStreamMessages(id1, messageId1, token1)
+	require.Nil(t, err)
+	defer conn.Close()
+
+	for _, expectedId := range []int64{messageId1, messageId2, messageId3} {
+		message = readStreamMessageForTest(t, conn)
+		assert.Equal(t, expectedId, message.Id)
+	}
+}
+
+func testFailToStreamMessageEventsInvalidParams(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	username1 := "user1"
+	password1 := "pass1"
+
+	id1 := createUserSuccessfullyForTest(t, username1, password1)
+	_, token1 := loginSuccessfullyForTest(t, username1, password1)
+
+	resp, err := requestStreamMessageEvents(id1, 1, "invalid", token1)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	wrongToken, err := auth.GenerateToken()
+	assert.Nil(t, err)
+	resp, err = requestStreamMessageEvents(id1, 1, "", wrongToken.Uuid)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func testStreamMessageEvents(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	username1 := "user1"
+	password1 := "pass1"
+
+	username2 := "user2"
+	password2 := "pass2"
+	id1 := createUserSuccessfullyForTest(t, username1, password1)
+	_ = createUserSuccessfullyForTest(t, username2, password2)
+	_, token1 := loginSuccessfullyForTest(t, username1, password1)
+	id2, token2 := loginSuccessfullyForTest(t, username2, password2)
+	textContent := model.TextContent{"text", "text"}
+
+	messageId1, _ := postMessageSuccessfullyForTest(t, id2, id1, textContent, token2)
+	messageId2, _ := postMessageSuccessfullyForTest(t, id2, id1, textContent, token2)
+
+	//Resuming from the first message skips it
+	resp, err := requestStreamMessageEvents(id1, messageId1, fmt.Sprint(messageId1), token1)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	id, data := readMessageEventForTest(t, reader)
+	assert.Equal(t, fmt.Sprint(messageId2), id)
+
+	message := model.MessageResponse{}
+	require.Nil(t, json.Unmarshal([]byte(data), &message))
+	assert.Equal(t, messageId2, message.Id)
+	assert.Equal(t, id2, message.Sender)
+
+	//New messages are pushed after the heartbeats
+	messageId3, _ := postMessageSuccessfullyForTest(t, id2, id1, textContent, token2)
+	id, _ = readMessageEventForTest(t, reader)
+	assert.Equal(t, fmt.Sprint(messageId3), id)
+
+	heartbeat := false
+	for !heartbeat {
+		line, err := reader.ReadString('\n')
+		require.Nil(t, err)
+		heartbeat = line == ": heartbeat\n"
+	}
+}
+
+func requestStreamMessageEvents(recipient int64, start int64, lastEventId string, token string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:8080/messages/events?id=%v&start=%v", recipient, start), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Authorization", "Bearer "+token)
+	if lastEventId != "" {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+
+	return http.DefaultClient.Do(req)
+}
+
+//Reads lines until a "message" event is complete and returns its id and data
+
+func readMessageEventForTest(t *testing.T, reader *bufio.Reader) (string, string) {
+	var id, event, data string
+	for {
+		line, err := reader.ReadString('\n')
+		require.Nil(t, err)
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && event == "message":
+			return id, data
+		}
+	}
+}
+
+func dialStreamMessages(recipient int64, start int64, token string) (*websocket.Conn, *http.Response, error) {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+	return websocket.DefaultDialer.Dial(fmt.Sprintf("ws://localhost:8080/messages/stream?id=%v&start=%v", recipient, start), header)
+}
+
+func readStreamMessageForTest(t *testing.T, conn *websocket.Conn) model.MessageResponse {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	message := model.MessageResponse{}
+	require.Nil(t, conn.ReadJSON(&message))
+	return message
+}
+
+func waitForServerForTest(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		resp, err := http.Post("http://localhost:8080/check", "application/json", nil)
+		if err == nil {
+			resp.Body.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.FailNow()
+}
+
 func createUserSuccessfullyForTest(t *testing.T, username string, password string) int64 {
 
 	resp, err := requestCreateUser(username, password)
@@ -808,3 +1796,436 @@ func requestGetMessage(recipient int64, start int64, limit int64, token string)
 
 	return http.DefaultClient.Do(req)
 }
+
+func testMessageReceipts(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	id1 := createUserSuccessfullyForTest(t, "user1", "pass1")
+	id2 := createUserSuccessfullyForTest(t, "user2", "pass2")
+	_, token1 := loginSuccessfullyForTest(t, "user1", "pass1")
+	_, token2 := loginSuccessfullyForTest(t, "user2", "pass2")
+	textContent := model.TextContent{Type: "text", Text: "text"}
+
+	messageId1, _ := postMessageSuccessfullyForTest(t, id2, id1, textContent, token2)
+	messageId2, _ := postMessageSuccessfullyForTest(t, id2, id1, textContent, token2)
+
+	statuses := requestGetMessageStatusSuccessfullyForTest(t, id2, messageId1, token2)
+	require.Equal(t, 2, len(statuses))
+	assert.Equal(t, messageId1, statuses[0].Id)
+	assert.Equal(t, id1, statuses[0].Recipient)
+	assert.Nil(t, statuses[0].Delivered)
+	assert.Nil(t, statuses[0].Read)
+
+	//Fetching the first message delivers it
+	messages := requestGetMessageSuccessfullyForTest(t, id1, messageId1, 1, token1)
+	require.Equal(t, 1, len(messages))
+	assert.NotNil(t, messages[0].Delivered)
+	assert.Nil(t, messages[0].Read)
+
+	statuses = requestGetMessageStatusSuccessfullyForTest(t, id2, messageId1, token2)
+	require.Equal(t, 2, len(statuses))
+	assert.NotNil(t, statuses[0].Delivered)
+	assert.Nil(t, statuses[0].Read)
+	assert.Nil(t, statuses[1].Delivered)
+
+	//Only the recipient can read its messages
+	resp, err := requestAuthorized("POST", "/messages/read", model.ReadMessagesRequestDTO{Recipient: id1, Message: messageId2}, token2)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	//Reading up to the second message reads both
+	resp, err = requestAuthorized("POST", "/messages/read", model.ReadMessagesRequestDTO{Recipient: id1, Message: messageId2}, token1)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	response := model.ReadMessagesResponseDTO{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&response))
+	resp.Body.Close()
+	assert.Equal(t, int64(2), response.Read)
+
+	statuses = requestGetMessageStatusSuccessfullyForTest(t, id2, messageId1, token2)
+	require.Equal(t, 2, len(statuses))
+	for _, status := range statuses {
+		assert.NotNil(t, status.Delivered)
+		assert.NotNil(t, status.Read)
+	}
+
+	//The recipient doesn't have sent messages
+	assert.Equal(t, 0, len(requestGetMessageStatusSuccessfullyForTest(t, id1, messageId1, token1)))
+
+	//Only the sender can get the status of its messages
+	resp, err = requestAuthorized("GET", fmt.Sprintf("/messages/status?id=%v&start=%v", id2, messageId1), nil, token1)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func testFailToReadMessagesInvalidFields(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	id1 := createUserSuccessfullyForTest(t, "user1", "pass1")
+	_, token1 := loginSuccessfullyForTest(t, "user1", "pass1")
+
+	cases := []struct {
+		name    string
+		request model.ReadMessagesRequestDTO
+	}{
+		{"readMessagesWithoutMessage", model.ReadMessagesRequestDTO{Recipient: id1}},
+		{"readMessagesWithInvalidMessage", model.ReadMessagesRequestDTO{Recipient: id1, Message: -1}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			resp, err := requestAuthorized("POST", "/messages/read", c.request, token1)
+			require.Nil(tt, err)
+			defer resp.Body.Close()
+			assert.Equal(tt, http.StatusBadRequest, resp.StatusCode)
+		})
+	}
+}
+
+func requestGetMessageStatusSuccessfullyForTest(t *testing.T, sender int64, start int64, token string) []model.MessageStatusResponse {
+
+	resp, err := requestAuthorized("GET", fmt.Sprintf("/messages/status?id=%v&start=%v", sender, start), nil, token)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	response := model.GetMessageStatusResponseDTO{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&response))
+	return response.Statuses
+}
+
+func testGetConversationMessages(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	id1 := createUserSuccessfullyForTest(t, "user1", "pass1")
+	id2 := createUserSuccessfullyForTest(t, "user2", "pass2")
+	id3 := createUserSuccessfullyForTest(t, "user3", "pass3")
+	_, token1 := loginSuccessfullyForTest(t, "user1", "pass1")
+	_, token2 := loginSuccessfullyForTest(t, "user2", "pass2")
+	_, token3 := loginSuccessfullyForTest(t, "user3", "pass3")
+	textContent := model.TextContent{Type: "text", Text: "text"}
+
+	messageId1, _ := postMessageSuccessfullyForTest(t, id1, id2, textContent, token1)
+	postMessageSuccessfullyForTest(t, id3, id1, textContent, token3)
+	messageId2, _ := postMessageSuccessfullyForTest(t, id2, id1, textContent, token2)
+	postMessageSuccessfullyForTest(t, id1, id3, textContent, token1)
+	messageId3, _ := postMessageSuccessfullyForTest(t, id1, id2, textContent, token1)
+
+	cases := []struct {
+		name        string
+		user        int64
+		other       int64
+		query       string
+		token       string
+		expectedIds []int64
+	}{
+		{"getLatestConversationMessages", id1, id2, "", token1, []int64{messageId1, messageId2, messageId3}},
+		{"getLatestConversationMessagesFromTheOtherSide", id2, id1, "", token2, []int64{messageId1, messageId2, messageId3}},
+		{"getLatestConversationMessagesWithLimit", id1, id2, "&limit=2", token1, []int64{messageId2, messageId3}},
+		{"getConversationMessagesBefore", id1, id2, fmt.Sprintf("&before=%v&limit=1", messageId2), token1, []int64{messageId1}},
+		{"getConversationMessagesAfter", id1, id2, fmt.Sprintf("&after=%v", messageId1), token1, []int64{messageId2, messageId3}},
+		{"getConversationMessagesAfterWithLimit", id1, id2, "&after=0&limit=1", token1, []int64{messageId1}},
+		{"getConversationMessagesAfterLastMessage", id1, id2, fmt.Sprintf("&after=%v", messageId3), token1, []int64{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			resp, err := requestGetConversationMessages(c.user, c.other, c.query, c.token)
+			require.Nil(tt, err)
+			defer resp.Body.Close()
+			require.Equal(tt, http.StatusOK, resp.StatusCode)
+
+			response := model.GetMessageResponseDTO{}
+			require.Nil(tt, json.NewDecoder(resp.Body).Decode(&response))
+			ids := make([]int64, 0)
+			for _, message := range response.Messages {
+				ids = append(ids, message.Id)
+			}
+			assert.Equal(tt, c.expectedIds, ids)
+		})
+	}
+}
+
+func testFailToGetConversationMessagesInvalidParams(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	id1 := createUserSuccessfullyForTest(t, "user1", "pass1")
+	id2 := createUserSuccessfullyForTest(t, "user2", "pass2")
+	_, token1 := loginSuccessfullyForTest(t, "user1", "pass1")
+	_, token2 := loginSuccessfullyForTest(t, "user2", "pass2")
+
+	cases := []struct {
+		name           string
+		path           string
+		token          string
+		expectedStatus int
+	}{
+		{"invalidOtherUser", fmt.Sprintf("/conversations/a/messages?id=%v", id1), token1, http.StatusNotFound},
+		{"invalidResource", fmt.Sprintf("/conversations/%v/users?id=%v", id2, id1), token1, http.StatusNotFound},
+		{"negativeUser", fmt.Sprintf("/conversations/%v/messages?id=-1", id2), token1, http.StatusBadRequest},
+		{"beforeAndAfter", fmt.Sprintf("/conversations/%v/messages?id=%v&before=2&after=1", id2, id1), token1, http.StatusBadRequest},
+		{"beforeZero", fmt.Sprintf("/conversations/%v/messages?id=%v&before=0", id2, id1), token1, http.StatusBadRequest},
+		{"invalidLimit", fmt.Sprintf("/conversations/%v/messages?id=%v&limit=0", id2, id1), token1, http.StatusBadRequest},
+		{"unregisteredOtherUser", fmt.Sprintf("/conversations/%v/messages?id=%v", id2+1, id1), token1, http.StatusBadRequest},
+		{"unauthorized", fmt.Sprintf("/conversations/%v/messages?id=%v", id2, id1), token2, http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			resp, err := requestAuthorized("GET", c.path, nil, c.token)
+			require.Nil(tt, err)
+			defer resp.Body.Close()
+			assert.Equal(tt, c.expectedStatus, resp.StatusCode)
+		})
+	}
+}
+
+func requestGetConversationMessages(user int64, other int64, query string, token string) (*http.Response, error) {
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:8080/conversations/%v/messages?id=%v%v", other, user, query), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return http.DefaultClient.Do(req)
+}
+
+func testFailToCreateGroupInvalidFields(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	username1 := "user1"
+	password1 := "pass1"
+
+	id1 := createUserSuccessfullyForTest(t, username1, password1)
+	_, token1 := loginSuccessfullyForTest(t, username1, password1)
+
+	cases := []struct {
+		name           string
+		request        model.CreateGroupRequestDTO
+		token          string
+		expectedStatus int
+	}{
+		{"createGroupWithoutName", model.CreateGroupRequestDTO{Owner: id1}, token1, http.StatusBadRequest},
+		{"createGroupWithHugeName", model.CreateGroupRequestDTO{Owner: id1, Name: strings.Repeat("a", model.MAX_GROUP_NAME_FIELD_SIZE+1)}, token1, http.StatusBadRequest},
+		{"createGroupWithInvalidOwner", model.CreateGroupRequestDTO{Owner: -1, Name: "group"}, token1, http.StatusUnauthorized},
+		{"createGroupWithInvalidMember", model.CreateGroupRequestDTO{Owner: id1, Name: "group", Members: []int64{-1}}, token1, http.StatusBadRequest},
+		{"createGroupWithUnregisteredMember", model.CreateGroupRequestDTO{Owner: id1, Name: "group", Members: []int64{id1 + 1}}, token1, http.StatusBadRequest},
+		{"createGroupUnauthorized", model.CreateGroupRequestDTO{Owner: id1, Name: "group"}, "invalid", http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			resp, err := requestAuthorized("POST", "/groups", c.request, c.token)
+			require.Nil(tt, err)
+			defer resp.Body.Close()
+			assert.Equal(tt, c.expectedStatus, resp.StatusCode)
+		})
+	}
+}
+
+func testGroupMessages(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	id1 := createUserSuccessfullyForTest(t, "user1", "pass1")
+	id2 := createUserSuccessfullyForTest(t, "user2", "pass2")
+	id3 := createUserSuccessfullyForTest(t, "user3", "pass3")
+	_, token1 := loginSuccessfullyForTest(t, "user1", "pass1")
+	_, token2 := loginSuccessfullyForTest(t, "user2", "pass2")
+	_, token3 := loginSuccessfullyForTest(t, "user3", "pass3")
+
+	groupId := createGroupSuccessfullyForTest(t, id1, "group", []int64{id2}, token1)
+
+	textContent := model.TextContent{Type: "text", Text: "text"}
+	messageId1 := postGroupMessageSuccessfullyForTest(t, id1, groupId, textContent, token1)
+	messageId2 := postGroupMessageSuccessfullyForTest(t, id2, groupId, textContent, token2)
+
+	//Every member reads the same messages
+	for _, member := range []struct {
+		id    int64
+		token string
+	}{{id1, token1}, {id2, token2}} {
+		messages := requestGetGroupMessagesSuccessfullyForTest(t, member.id, groupId, 1, 10, member.token)
+		require.Equal(t, 2, len(messages))
+		assert.Equal(t, messageId1, messages[0].Id)
+		assert.Equal(t, id1, messages[0].Sender)
+		assert.Equal(t, messageId2, messages[1].Id)
+		assert.Equal(t, id2, messages[1].Sender)
+		assert.Equal(t, groupId, messages[1].Group)
+	}
+
+	//Pagination works like GET /messages
+	messages := requestGetGroupMessagesSuccessfullyForTest(t, id1, groupId, messageId2, 10, token1)
+	require.Equal(t, 1, len(messages))
+	assert.Equal(t, messageId2, messages[0].Id)
+
+	messages = requestGetGroupMessagesSuccessfullyForTest(t, id1, groupId, 1, 1, token1)
+	require.Equal(t, 1, len(messages))
+	assert.Equal(t, messageId1, messages[0].Id)
+
+	//Non members can't read or post
+	resp, err := requestAuthorized("GET", fmt.Sprintf("/groups/messages?id=%v&group=%v&start=1", id3, groupId), nil, token3)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, err = requestAuthorized("POST", "/groups/messages", model.PostGroupMessageRequestDTO{Sender: id3, Group: groupId, Content: textContent}, token3)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	//Unknown groups
+	resp, err = requestAuthorized("GET", fmt.Sprintf("/groups/messages?id=%v&group=%v&start=1", id1, groupId+1), nil, token1)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	//Members must use their own token
+	resp, err = requestAuthorized("GET", fmt.Sprintf("/groups/messages?id=%v&group=%v&start=1", id1, groupId), nil, token2)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func testGroupMembers(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	id1 := createUserSuccessfullyForTest(t, "user1", "pass1")
+	id2 := createUserSuccessfullyForTest(t, "user2", "pass2")
+	id3 := createUserSuccessfullyForTest(t, "user3", "pass3")
+	_, token1 := loginSuccessfullyForTest(t, "user1", "pass1")
+	_, token2 := loginSuccessfullyForTest(t, "user2", "pass2")
+	_, token3 := loginSuccessfullyForTest(t, "user3", "pass3")
+
+	groupId := createGroupSuccessfullyForTest(t, id1, "group", []int64{id2, id2}, token1)
+	assert.Equal(t, []int64{id1, id2}, requestGetGroupMembersSuccessfullyForTest(t, id2, groupId, token2))
+
+	cases := []struct {
+		name            string
+		method          string
+		path            string
+		body            interface{}
+		token           string
+		expectedStatus  int
+		expectedMembers []int64
+	}{
+		{"memberCantAddMembers", "POST", "/groups/members", model.GroupMemberRequestDTO{User: id2, Group: groupId, Member: id3}, token2, http.StatusForbidden, nil},
+		{"ownerAddsMember", "POST", "/groups/members", model.GroupMemberRequestDTO{User: id1, Group: groupId, Member: id3}, token1, http.StatusOK, []int64{id1, id2, id3}},
+		{"ownerCantAddUnregisteredMember", "POST", "/groups/members", model.GroupMemberRequestDTO{User: id1, Group: groupId, Member: id3 + 1}, token1, http.StatusBadRequest, nil},
+		{"memberCantRemoveOtherMember", "DELETE", fmt.Sprintf("/groups/members?id=%v&group=%v&member=%v", id2, groupId, id3), nil, token2, http.StatusForbidden, nil},
+		{"memberCantRemoveOwner", "DELETE", fmt.Sprintf("/groups/members?id=%v&group=%v&member=%v", id2, groupId, id1), nil, token2, http.StatusForbidden, nil},
+		{"memberRemovesThemselves", "DELETE", fmt.Sprintf("/groups/members?id=%v&group=%v&member=%v", id2, groupId, id2), nil, token2, http.StatusOK, []int64{id1, id3}},
+		{"removedMemberCantReadMembers", "GET", fmt.Sprintf("/groups/members?id=%v&group=%v", id2, groupId), nil, token2, http.StatusForbidden, nil},
+		{"ownerRemovesMember", "DELETE", fmt.Sprintf("/groups/members?id=%v&group=%v&member=%v", id1, groupId, id3), nil, token1, http.StatusOK, []int64{id1}},
+		{"ownerCantRemoveMissingMember", "DELETE", fmt.Sprintf("/groups/members?id=%v&group=%v&member=%v", id1, groupId, id3), nil, token1, http.StatusNotFound, nil},
+		{"ownerCantRemoveThemselves", "DELETE", fmt.Sprintf("/groups/members?id=%v&group=%v&member=%v", id1, groupId, id1), nil, token1, http.StatusForbidden, nil},
+		{"invalidQueryParams", "DELETE", fmt.Sprintf("/groups/members?id=%v&group=a&member=%v", id1, id3), nil, token1, http.StatusBadRequest, nil},
+		{"unknownGroup", "GET", fmt.Sprintf("/groups/members?id=%v&group=%v", id1, groupId+1), nil, token1, http.StatusNotFound, nil},
+		{"unauthorized", "GET", fmt.Sprintf("/groups/members?id=%v&group=%v", id1, groupId), nil, token3, http.StatusUnauthorized, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			resp, err := requestAuthorized(c.method, c.path, c.body, c.token)
+			require.Nil(tt, err)
+			defer resp.Body.Close()
+
+			require.Equal(tt, c.expectedStatus, resp.StatusCode)
+			if c.expectedStatus == http.StatusOK {
+				response := model.GetGroupMembersResponseDTO{}
+				require.Nil(tt, json.NewDecoder(resp.Body).Decode(&response))
+				assert.Equal(tt, c.expectedMembers, response.Members)
+			}
+		})
+	}
+}
+
+func testFullGroupShouldRejectNewMembers(t *testing.T) {
+	dao.RefreshSchema(testDB)
+	defer func(max int) { groups.MAX_GROUP_MEMBERS = max }(groups.MAX_GROUP_MEMBERS)
+	groups.MAX_GROUP_MEMBERS = 2
+
+	id1 := createUserSuccessfullyForTest(t, "user1", "pass1")
+	id2 := createUserSuccessfullyForTest(t, "user2", "pass2")
+	id3 := createUserSuccessfullyForTest(t, "user3", "pass3")
+	_, token1 := loginSuccessfullyForTest(t, "user1", "pass1")
+	groupId := createGroupSuccessfullyForTest(t, id1, "group", []int64{id2}, token1)
+
+	resp, err := requestAuthorized("POST", "/groups/members", model.GroupMemberRequestDTO{User: id1, Group: groupId, Member: id3}, token1)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	p := problem{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&p))
+	assert.Equal(t, "group_full", p.Code)
+	assert.Equal(t, []int64{id1, id2}, requestGetGroupMembersSuccessfullyForTest(t, id1, groupId, token1))
+}
+
+func createGroupSuccessfullyForTest(t *testing.T, owner int64, name string, members []int64, token string) int64 {
+
+	resp, err := requestAuthorized("POST", "/groups", model.CreateGroupRequestDTO{Owner: owner, Name: name, Members: members}, token)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	response := model.CreateGroupResponseDTO{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&response))
+	return response.Id
+}
+
+func postGroupMessageSuccessfullyForTest(t *testing.T, sender int64, group int64, content model.MessageContent, token string) int64 {
+
+	resp, err := requestAuthorized("POST", "/groups/messages", model.PostGroupMessageRequestDTO{Sender: sender, Group: group, Content: content}, token)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	response := model.PostMessageResponseDTO{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&response))
+	return response.Id
+}
+
+func requestGetGroupMessagesSuccessfullyForTest(t *testing.T, user int64, group int64, start int64, limit int64, token string) []model.GroupMessageResponse {
+
+	resp, err := requestAuthorized("GET", fmt.Sprintf("/groups/messages?id=%v&group=%v&start=%v&limit=%v", user, group, start, limit), nil, token)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	response := model.GetGroupMessageResponseDTO{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&response))
+	return response.Messages
+}
+
+func requestGetGroupMembersSuccessfullyForTest(t *testing.T, user int64, group int64, token string) []int64 {
+
+	resp, err := requestAuthorized("GET", fmt.Sprintf("/groups/members?id=%v&group=%v", user, group), nil, token)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	response := model.GetGroupMembersResponseDTO{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&response))
+	return response.Members
+}
+
+func requestAuthorized(method string, path string, body interface{}, token string) (*http.Response, error) {
+	var requestReader *bytes.Reader
+	if body != nil {
+		requestByte, _ := json.Marshal(body)
+		requestReader = bytes.NewReader(requestByte)
+	} else {
+		requestReader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, "http://localhost:8080"+path, requestReader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return http.DefaultClient.Do(req)
+}
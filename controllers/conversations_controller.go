@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/maidaneze/message-server/apperrors"
+	"github.com/maidaneze/message-server/model"
+	"github.com/maidaneze/message-server/services/messages"
+)
+
+//Gets the messages exchanged between the user and the other user, sent by either of them
+//Returns the messages before or after the given cursor, or the latest ones if there is no cursor,
+//up to limit number of messages (Default 100) ordered from oldest to newest
+//The other user is the id path param
+//The id queryparam is optional, if present it must be the authenticated user
+//Returns 400 if the request queryparams are invalid
+//Returns 401 if the user isn't the authenticated user
+//Returns 404 if the other user id isn't a number
+//Returns 500 if the messages couldn't be recovered
+//Returns 200 otherwise
+func (h Handler) getConversationMessages(w http.ResponseWriter, r *http.Request) {
+
+	//Get Path params
+	otherUserId, err := messages.ParseConversationUserId(pathParam(r, "id"))
+
+	if err != nil {
+		writeError(w, r, errNotFound)
+		return
+	}
+
+	//Get Query params
+	userId, messageId, limit, before, err := messages.ParseGetConversationQueryParams(r)
+
+	if err != nil {
+		writeError(w, r, errInvalidParams)
+		return
+	}
+
+	//Validate query params
+	if !(userId >= 0 && otherUserId > 0 && messageId >= 0 && limit > 0) || (before && messageId == 0) {
+		writeError(w, r, errInvalidBody)
+		return
+	}
+
+	//Validate user
+	if !h.authorizeUser(w, r, &userId) {
+		return
+	}
+
+	//Validate other user Exists
+	found, err := h.Db.CheckUserExists(r.Context(), otherUserId)
+	if !found && err == nil {
+		writeError(w, r, apperrors.NewInvalid("unknown_user", "User doesn't exist"))
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error getting messages"))
+		return
+	}
+
+	//Get messages
+	getMessages, err := h.Db.GetConversationMessages(r.Context(), userId, otherUserId, messageId, limit, before)
+	if err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error getting messages"))
+		return
+	}
+
+	//Marshall response
+	if err := json.NewEncoder(w).Encode(model.GetMessageResponseDTO{Messages: messages.ParseMessages(getMessages)}); err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error getting messages"))
+	}
+}
@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/maidaneze/message-server/apperrors"
+	"github.com/maidaneze/message-server/logging"
+	"github.com/maidaneze/message-server/metrics"
+	"github.com/maidaneze/message-server/model"
+	"github.com/maidaneze/message-server/services/messages"
+)
+
+var (
+	eventsHeartbeatInterval = 15 * time.Second
+	eventsRetryMilliseconds = 3000
+)
+
+//Streams the messages for the recipient as Server-Sent Events starting from the given messageid
+//Fallback for the clients that can't use the WebSocket stream
+//Each message is sent as a "message" event with the message id as the event id, so a client reconnecting with
+//the "Last-Event-ID" header resumes from the message after it
+//A comment is sent periodically as a heartbeat to keep the connection alive through proxies
+//The limit param sets how many messages are recovered from the database at a time (Default 100)
+//The id is optional, if present it must be the authenticated user
+//Returns 400 if the request queryparams or the Last-Event-ID header are invalid
+//Returns 401 if the recipient isn't the authenticated user
+//Returns 500 if the connection doesn't support streaming
+//Returns 200 and keeps the connection open otherwise
+
+func (h Handler) StreamMessageEvents(w http.ResponseWriter, r *http.Request) {
+
+	//Get Query params
+	recipientid, messageid, limit, _, err := messages.ParseGetMessageQueryParams(r)
+
+	if err != nil {
+		writeError(w, r, errInvalidParams)
+		return
+	}
+
+	lastEventId, resume, err := messages.ParseLastEventId(r)
+
+	if err != nil {
+		writeError(w, r, errInvalidParams)
+		return
+	}
+
+	if resume {
+		messageid = lastEventId + 1
+	}
+
+	//Validate query params
+	if !(recipientid >= 0 && messageid > 0 && limit > 0) {
+		writeError(w, r, errInvalidBody)
+		return
+	}
+
+	//Validate user token, the recipient is the authenticated user
+	identity, valid := authorize(w, r, recipientid)
+	if !valid {
+		return
+	}
+	recipientid = identity.Userid
+
+	flusher, ok := streamFlusher(w)
+	if !ok {
+		writeError(w, r, apperrors.NewInternal("Streaming unsupported", nil))
+		return
+	}
+
+	//Subscribe before recovering the stored messages so no message is missed in between
+	subscription := h.Hub.Subscribe(recipientid)
+	defer subscription.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	defer h.Metrics.OpenStream(metrics.EventsStream)()
+
+	fmt.Fprintf(w, "retry: %d\n\n", eventsRetryMilliseconds)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	stream := messageStream{h.Db, recipientid, messageid, limit}
+	send := func(message model.MessageResponse) error {
+		return writeMessageEvent(w, message)
+	}
+
+	for {
+		if err := h.drainStream(r, &stream, send); err != nil {
+			//The client went away
+			if r.Context().Err() != nil {
+				return
+			}
+			logging.FromContext(r.Context()).Error("Error streaming messages", logging.Fields{"error": err, "user_id": recipientid})
+			fmt.Fprint(w, "event: error\ndata: Error getting messages\n\n")
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case _, open := <-subscription.C:
+			if !open {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+//Writes the message as a "message" event using the message id as the event id
+//Returns error in case of failiure
+
+func writeMessageEvent(w http.ResponseWriter, message model.MessageResponse) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", message.Id, data)
+	return err
+}
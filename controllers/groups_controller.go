@@ -0,0 +1,369 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/maidaneze/message-server/apperrors"
+	"github.com/maidaneze/message-server/metrics"
+	"github.com/maidaneze/message-server/model"
+	"github.com/maidaneze/message-server/services/groups"
+)
+
+//Creates a new group owned by the requesting user with the given members
+//The owner is optional, if present it must be the authenticated user
+//Returns 400 if the request dto is invalid or a member doesn't exist
+//Returns 401 if the owner isn't the authenticated user
+//Returns 500 if the group couldn't be created
+//Returns 200 otherwise
+func (h Handler) createGroup(w http.ResponseWriter, r *http.Request) {
+
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(r.Body)
+	defer r.Body.Close()
+
+	var dto model.CreateGroupRequestDTO
+
+	//Check body
+	if err := json.Unmarshal(buf.Bytes(), &dto); err != nil {
+		writeError(w, r, errInvalidBody)
+		return
+	}
+
+	//Validate owner
+	if !h.authorizeUser(w, r, &dto.Owner) {
+		return
+	}
+
+	//Validate dto
+	if !groups.ValidCreateGroupRequestDTO(dto) {
+		writeError(w, r, errInvalidBody)
+		return
+	}
+
+	//Validate members exist
+	members := groups.GroupMembers(dto)
+	for _, member := range members {
+		found, err := h.Db.CheckUserExists(r.Context(), member)
+		if err != nil {
+			writeError(w, r, apperrors.WrapInternal(err, "Error creating group"))
+			return
+		}
+		if !found {
+			writeError(w, r, unknownUser("members", "Member doesn't exist"))
+			return
+		}
+	}
+
+	//Insert group
+	group, err := h.Db.InsertGroup(r.Context(), groups.CreateGroup(dto), members)
+	if err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error creating group"))
+		return
+	}
+
+	//Marshall response
+	if err := json.NewEncoder(w).Encode(model.CreateGroupResponseDTO{Id: group.GroupId}); err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error creating group"))
+	}
+}
+
+//Gets the members of the group
+//The id is optional, if present it must be the authenticated user
+//Returns 400 if the request queryparams are invalid
+//Returns 401 if the user isn't the authenticated user
+//Returns 403 if the user isn't a member of the group
+//Returns 404 if the group doesn't exist
+//Returns 500 if the members couldn't be recovered
+//Returns 200 otherwise
+func (h Handler) getGroupMembers(w http.ResponseWriter, r *http.Request) {
+
+	//Get Query params
+	dto, err := groups.ParseGroupMemberQueryParams(r)
+	if err != nil || !(dto.User >= 0 && dto.Group > 0) {
+		writeError(w, r, errInvalidParams)
+		return
+	}
+
+	//Validate user
+	if !h.authorizeUser(w, r, &dto.User) {
+		return
+	}
+
+	//Validate membership
+	if _, ok := h.authorizeGroupMember(w, r, dto.Group, dto.User, "Error getting group members"); !ok {
+		return
+	}
+
+	h.writeGroupMembers(w, r, dto.Group, "Error getting group members")
+}
+
+//Adds a member to the group, only the owner can add members
+//The user is optional, if present it must be the authenticated user
+//Returns 400 if the request dto is invalid, the member doesn't exist or the group is full
+//Returns 401 if the user isn't the authenticated user
+//Returns 403 if the user isn't the owner of the group
+//Returns 404 if the group doesn't exist
+//Returns 500 if the member couldn't be added
+//Returns 200 with the group members otherwise
+func (h Handler) addGroupMember(w http.ResponseWriter, r *http.Request) {
+
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(r.Body)
+	defer r.Body.Close()
+
+	var dto model.GroupMemberRequestDTO
+
+	//Check body
+	if err := json.Unmarshal(buf.Bytes(), &dto); err != nil {
+		writeError(w, r, errInvalidBody)
+		return
+	}
+
+	//Validate user
+	if !h.authorizeUser(w, r, &dto.User) {
+		return
+	}
+
+	//Validate dto
+	if !groups.ValidGroupMemberRequestDTO(dto) {
+		writeError(w, r, errInvalidBody)
+		return
+	}
+
+	//Validate permissions
+	group, ok := h.authorizeGroupMember(w, r, dto.Group, dto.User, "Error adding group member")
+	if !ok {
+		return
+	}
+
+	if !groups.CanAddMember(group, dto.User) {
+		writeError(w, r, errForbidden)
+		return
+	}
+
+	//Validate member Exists
+	found, err := h.Db.CheckUserExists(r.Context(), dto.Member)
+	if !found && err == nil {
+		writeError(w, r, unknownUser("member", "Member doesn't exist"))
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error adding group member"))
+		return
+	}
+
+	//Insert member, unless the group is full
+	if err := h.Db.InsertGroupMember(r.Context(), dto.Group, dto.Member, groups.MAX_GROUP_MEMBERS); err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error adding group member"))
+		return
+	}
+
+	h.writeGroupMembers(w, r, dto.Group, "Error adding group member")
+}
+
+//Removes a member from the group
+//The owner can remove any other member and the members can remove themselves
+//The id is optional, if present it must be the authenticated user
+//Returns 400 if the request queryparams are invalid
+//Returns 401 if the user isn't the authenticated user
+//Returns 403 if the user can't remove the member
+//Returns 404 if the group doesn't exist or the member isn't in the group
+//Returns 500 if the member couldn't be removed
+//Returns 200 with the group members otherwise
+func (h Handler) removeGroupMember(w http.ResponseWriter, r *http.Request) {
+
+	//Get Query params
+	dto, err := groups.ParseGroupMemberQueryParams(r)
+	if err != nil {
+		writeError(w, r, errInvalidParams)
+		return
+	}
+
+	//Validate user
+	if !h.authorizeUser(w, r, &dto.User) {
+		return
+	}
+
+	//Validate query params
+	if !groups.ValidGroupMemberRequestDTO(dto) {
+		writeError(w, r, errInvalidParams)
+		return
+	}
+
+	//Validate permissions
+	group, ok := h.authorizeGroupMember(w, r, dto.Group, dto.User, "Error removing group member")
+	if !ok {
+		return
+	}
+
+	if !groups.CanRemoveMember(group, dto.User, dto.Member) {
+		writeError(w, r, errForbidden)
+		return
+	}
+
+	//Remove member
+	deleted, err := h.Db.DeleteGroupMember(r.Context(), dto.Group, dto.Member)
+	if err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error removing group member"))
+		return
+	}
+
+	if !deleted {
+		writeError(w, r, apperrors.New(apperrors.NotFound, "member_not_found", "Member not found"))
+		return
+	}
+
+	h.writeGroupMembers(w, r, dto.Group, "Error removing group member")
+}
+
+//Sends a new message from the sender to every member of the group
+//The message is stored once and shared by all the members
+//The sender is optional, if present it must be the authenticated user
+//Returns 400 if the request dto is invalid
+//Returns 401 if the sender isn't the authenticated user
+//Returns 403 if the sender isn't a member of the group
+//Returns 404 if the group doesn't exist
+//Returns 500 if the message couldn't be sent
+//Returns 200 otherwise
+func (h Handler) insertGroupMessage(w http.ResponseWriter, r *http.Request) {
+
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(r.Body)
+	defer r.Body.Close()
+
+	var postGroupMessageRequestDTO model.PostGroupMessageRequestDTO
+
+	//Check body
+	if err := json.Unmarshal(buf.Bytes(), &postGroupMessageRequestDTO); err != nil {
+		writeError(w, r, errInvalidBody)
+		return
+	}
+
+	dto, err := groups.UnmarshallGroupMessageContent(postGroupMessageRequestDTO)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	//Validate sender
+	if !h.authorizeUser(w, r, &dto.SenderId) {
+		return
+	}
+
+	//Validate dto
+	if fields := groups.ValidGroupMessageDto(dto); len(fields) > 0 {
+		writeError(w, r, invalidBody(fields))
+		return
+	}
+
+	//Validate membership
+	if _, ok := h.authorizeGroupMember(w, r, dto.GroupId, dto.SenderId, "Error sending message"); !ok {
+		return
+	}
+
+	//Insert into group messages
+	dto, err = h.Db.InsertGroupMessage(r.Context(), dto)
+	if err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error sending message"))
+		return
+	}
+	h.Metrics.CountMessage(metrics.GroupConversation, dto.Type)
+
+	//Marshall response
+	if err := json.NewEncoder(w).Encode(groups.GetPostGroupMessageResponseDTO(dto)); err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error sending message"))
+	}
+}
+
+//Gets the group messages starting from the given messageid and up to limit number of messages (Default 100)
+//The id is optional, if present it must be the authenticated user
+//Returns 400 if the request queryparams are invalid
+//Returns 401 if the user isn't the authenticated user
+//Returns 403 if the user isn't a member of the group
+//Returns 404 if the group doesn't exist
+//Returns 500 if the messages couldn't be recovered
+//Returns 200 otherwise
+func (h Handler) getGroupMessages(w http.ResponseWriter, r *http.Request) {
+
+	//Get Query params
+	userid, groupid, messageid, limit, err := groups.ParseGetGroupMessageQueryParams(r)
+
+	if err != nil {
+		writeError(w, r, errInvalidParams)
+		return
+	}
+
+	//Validate query params
+	if !(userid >= 0 && groupid > 0 && messageid > 0 && limit > 0) {
+		writeError(w, r, errInvalidBody)
+		return
+	}
+
+	//Validate user
+	if !h.authorizeUser(w, r, &userid) {
+		return
+	}
+
+	//Validate membership
+	if _, ok := h.authorizeGroupMember(w, r, groupid, userid, "Error getting messages"); !ok {
+		return
+	}
+
+	//Get messages
+	getMessages, err := h.Db.GetGroupMessages(r.Context(), groupid, messageid, limit)
+	if err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error getting messages"))
+		return
+	}
+
+	//Marshall response
+	if err := json.NewEncoder(w).Encode(model.GetGroupMessageResponseDTO{Messages: groups.ParseGroupMessages(getMessages)}); err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error getting messages"))
+	}
+}
+
+//Validates the group exists and the user is one of its members
+//Writes the error response and returns false if it isn't
+//Returns the group otherwise
+
+func (h Handler) authorizeGroupMember(w http.ResponseWriter, r *http.Request, groupid int64, userid int64, errorMessage string) (model.Group, bool) {
+	group, found, err := h.Db.GetGroup(r.Context(), groupid)
+	if err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, errorMessage))
+		return group, false
+	}
+
+	if !found {
+		writeError(w, r, apperrors.New(apperrors.NotFound, "group_not_found", "Group not found"))
+		return group, false
+	}
+
+	isMember, err := h.Db.CheckGroupMember(r.Context(), groupid, userid)
+	if err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, errorMessage))
+		return group, false
+	}
+
+	if !isMember {
+		writeError(w, r, errForbidden)
+		return group, false
+	}
+	return group, true
+}
+
+//Writes the group members as the response
+
+func (h Handler) writeGroupMembers(w http.ResponseWriter, r *http.Request, groupid int64, errorMessage string) {
+	members, err := h.Db.GetGroupMembers(r.Context(), groupid)
+	if err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, errorMessage))
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(model.GetGroupMembersResponseDTO{Members: members}); err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, errorMessage))
+	}
+}
@@ -1,7 +1,64 @@
 package controllers
 
-import "github.com/maidaneze/message-server/dao"
+import (
+	"net/http"
+	"time"
+
+	"github.com/maidaneze/message-server/dao"
+	"github.com/maidaneze/message-server/logging"
+	"github.com/maidaneze/message-server/metrics"
+	"github.com/maidaneze/message-server/services/auth"
+	"github.com/maidaneze/message-server/services/notifications"
+	"github.com/maidaneze/message-server/services/passwords"
+	"github.com/maidaneze/message-server/tracing"
+)
+
+//Addr is the address the server listens on, ":8080" if empty
+//Jwt is nil when the server issues opaque access tokens, otherwise the access tokens are signed JWTs
+//and the sessions are renewed with refresh tokens
+//Tokens hashes the session tokens, only their hashes are stored in the database, Setup requires it
+//Passwords is the algorithm and cost of the password hashes, older hashes are upgraded on login
+//Log is the logger of the requests and their errors, nil discards them
+//Metrics records the requests, streams and messages and is exposed at /metrics, nil disables them
+//Tracer traces the requests, nil disables the tracing
+//RequestTimeout is the deadline of the database work of a request, 0 disables it
 
 type Handler struct {
-	Db dao.DB
+	Addr           string
+	Db             dao.DB
+	Hub            *notifications.Hub
+	Sessions       auth.SessionPolicy
+	Jwt            *auth.JWTIssuer
+	Tokens         auth.TokenHasher
+	Passwords      passwords.Policy
+	Log            *logging.Logger
+	Metrics        *metrics.Metrics
+	Tracer         *tracing.Tracer
+	RequestTimeout time.Duration
+}
+
+//Validates the authenticated user of the request is the given user, unless userid is 0
+//Sets the userid to the authenticated user, so the requests can omit it
+//Writes the error response and returns false if it isn't
+
+func (h Handler) authorizeUser(w http.ResponseWriter, r *http.Request, userid *int64) bool {
+	identity, valid := authorize(w, r, *userid)
+	if valid {
+		*userid = identity.Userid
+	}
+	return valid
+}
+
+//Validates the authenticated user of the request is the given user, unless userid is 0
+//The request must have been authenticated by the middleware of the protected routes
+//Returns the authenticated user and true if it is
+//Writes the error response and returns false otherwise
+
+func authorize(w http.ResponseWriter, r *http.Request, userid int64) (auth.Identity, bool) {
+	identity, found := auth.IdentityFromContext(r.Context())
+	if !found || !identity.Matches(userid) {
+		writeError(w, r, errUnauthorized)
+		return auth.Identity{}, false
+	}
+	return identity, true
 }
@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/maidaneze/message-server/logging"
+	"github.com/maidaneze/message-server/tracing"
+)
+
+//Header with the id of the request, propagated from the client when valid, generated otherwise
+
+const requestIdHeader = "X-Request-Id"
+
+//Request ids accepted from the clients
+
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+//Details of the request found by the inner handlers for the access log
+
+type requestInfo struct {
+	route  string
+	userid int64
+}
+
+type requestInfoKey struct{}
+
+//Wraps the handler with the access log, every request gets an id and is logged once finished with its method, route,
+//status, latency and authenticated user
+//The request context has a logger with the request id, and the trace id if the request is traced, for the handlers
+//The finished requests are recorded in the metrics too
+
+func (h Handler) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestId := r.Header.Get(requestIdHeader)
+		if !validRequestId.MatchString(requestId) {
+			requestId = uuid.New().String()
+		}
+		w.Header().Set(requestIdHeader, requestId)
+
+		loggerFields := logging.Fields{"request_id": requestId}
+		if span := tracing.SpanFromContext(r.Context()); span != nil {
+			loggerFields["trace_id"] = span.Context().TraceID.String()
+		}
+		logger := h.Log.With(loggerFields)
+		info := &requestInfo{}
+		ctx := context.WithValue(logging.WithLogger(r.Context(), logger), requestInfoKey{}, info)
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		latency := time.Since(start)
+		h.Metrics.ObserveRequest(r.Method, info.route, recorder.statusCode(), latency)
+
+		fields := logging.Fields{
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"route":      info.route,
+			"status":     recorder.statusCode(),
+			"latency_ms": float64(latency) / float64(time.Millisecond),
+			"remote":     r.RemoteAddr,
+		}
+		if info.userid != 0 {
+			fields["user_id"] = info.userid
+		}
+		logger.Info("request", fields)
+	})
+}
+
+//Records the route pattern of the request for the access log and the trace, if the request is logged or traced
+
+func setRequestRoute(r *http.Request, route string) {
+	if info, found := r.Context().Value(requestInfoKey{}).(*requestInfo); found {
+		info.route = route
+	}
+	span := tracing.SpanFromContext(r.Context())
+	span.SetName(r.Method + " " + route)
+	span.SetAttribute("http.route", route)
+}
+
+//Records the authenticated user of the request for the access log and the trace, if the request is logged or traced
+
+func setRequestUser(r *http.Request, userid int64) {
+	if info, found := r.Context().Value(requestInfoKey{}).(*requestInfo); found {
+		info.userid = userid
+	}
+	tracing.SpanFromContext(r.Context()).SetAttribute("enduser.id", strconv.FormatInt(userid, 10))
+}
+
+//Response writer recording the status of the response
+//Streams and WebSockets need the flusher and hijacker of the underlying writer
+//Flush does nothing if the underlying writer can't flush, streamFlusher checks it first
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status   int
+	hijacked bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		if s.status == 0 {
+			s.status = http.StatusOK
+		}
+		flusher.Flush()
+	}
+}
+
+//Returns true if the underlying writer supports flushing
+
+func (s *statusRecorder) canFlush() bool {
+	_, ok := streamFlusher(s.ResponseWriter)
+	return ok
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("The response writer doesn't support hijacking")
+	}
+	s.hijacked = true
+	return hijacker.Hijack()
+}
+
+//Returns the flusher of the response writer
+//Returns false if the writer, or the writer wrapped by the status recorders, doesn't support flushing
+
+func streamFlusher(w http.ResponseWriter) (http.Flusher, bool) {
+	if recorder, ok := w.(*statusRecorder); ok && !recorder.canFlush() {
+		return nil, false
+	}
+	flusher, ok := w.(http.Flusher)
+	return flusher, ok
+}
+
+//Returns the status of the response, 101 for the hijacked connections and 200 if nothing was written
+
+func (s *statusRecorder) statusCode() int {
+	switch {
+	case s.status != 0:
+		return s.status
+	case s.hijacked:
+		return http.StatusSwitchingProtocols
+	default:
+		return http.StatusOK
+	}
+}
@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/maidaneze/message-server/apperrors"
+	"github.com/maidaneze/message-server/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loggedHandlerForTest(t *testing.T, output *bytes.Buffer) (Handler, http.Handler) {
+	h := jwtHandlerForTest(t)
+	h.Db = openSessionsDBForTest{h.Db}
+	h.Log = logging.New(output, logging.Debug)
+
+	rt := newRouter()
+	rt.group("").handle("GET", "/check", writeBodyForTest("check"))
+	protected := rt.group("", h.authenticated)
+	protected.handle("GET", "/users/{id}", writeBodyForTest("user "))
+	protected.handle("GET", "/failure", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, apperrors.WrapInternal(errors.New("database is locked"), "Error getting messages"))
+	})
+	return h, h.logRequests(rt)
+}
+
+func readLogEntriesForTest(t *testing.T, output *bytes.Buffer) []map[string]interface{} {
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		entry := map[string]interface{}{}
+		require.Nil(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestLogRequestsShouldLogTheAccess(t *testing.T) {
+	output := new(bytes.Buffer)
+	h, handler := loggedHandlerForTest(t, output)
+	token, err := h.Jwt.Issue(7, 1)
+	require.Nil(t, err)
+
+	req := httptest.NewRequest("GET", "/users/7", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	entries := readLogEntriesForTest(t, output)
+	require.Len(t, entries, 1)
+	entry := entries[0]
+	assert.Equal(t, "info", entry["level"])
+	assert.Equal(t, "request", entry["msg"])
+	assert.Equal(t, "GET", entry["method"])
+	assert.Equal(t, "/users/7", entry["path"])
+	assert.Equal(t, "/users/{id}", entry["route"])
+	assert.Equal(t, float64(http.StatusOK), entry["status"])
+	assert.Equal(t, float64(7), entry["user_id"])
+	assert.Contains(t, entry, "latency_ms")
+	assert.Contains(t, entry, "time")
+	assert.Equal(t, resp.Header().Get(requestIdHeader), entry["request_id"])
+	assert.NotContains(t, output.String(), token)
+}
+
+func TestLogRequestsShouldLogTheRejectedRequests(t *testing.T) {
+	cases := []struct {
+		name           string
+		method         string
+		path           string
+		expectedStatus int
+		expectedRoute  string
+	}{
+		{"testLogRequestsUnauthorized", "GET", "/users/7", http.StatusUnauthorized, "/users/{id}"},
+		{"testLogRequestsNotFound", "GET", "/unknown", http.StatusNotFound, ""},
+		{"testLogRequestsMethodNotAllowed", "POST", "/check", http.StatusMethodNotAllowed, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			output := new(bytes.Buffer)
+			_, handler := loggedHandlerForTest(tt, output)
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(c.method, c.path, nil))
+
+			entries := readLogEntriesForTest(tt, output)
+			require.Len(tt, entries, 1)
+			assert.Equal(tt, float64(c.expectedStatus), entries[0]["status"])
+			assert.Equal(tt, c.expectedRoute, entries[0]["route"])
+			assert.NotContains(tt, entries[0], "user_id")
+		})
+	}
+}
+
+func TestLogRequestsShouldPropagateValidRequestIds(t *testing.T) {
+	cases := []struct {
+		name      string
+		requestId string
+		propagate bool
+	}{
+		{"testLogRequestsValidId", "client-id.1234", true},
+		{"testLogRequestsNoId", "", false},
+		{"testLogRequestsInvalidId", "bad id", false},
+		{"testLogRequestsLongId", strings.Repeat("a", 129), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			output := new(bytes.Buffer)
+			_, handler := loggedHandlerForTest(tt, output)
+
+			req := httptest.NewRequest("GET", "/check", nil)
+			req.Header.Set(requestIdHeader, c.requestId)
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+
+			requestId := resp.Header().Get(requestIdHeader)
+			assert.NotEmpty(tt, requestId)
+			assert.Equal(tt, c.propagate, requestId == c.requestId)
+			assert.Equal(tt, requestId, readLogEntriesForTest(tt, output)[0]["request_id"])
+		})
+	}
+}
+
+func TestWriteErrorShouldLogTheCause(t *testing.T) {
+	output := new(bytes.Buffer)
+	h, handler := loggedHandlerForTest(t, output)
+	token, err := h.Jwt.Issue(7, 1)
+	require.Nil(t, err)
+
+	req := httptest.NewRequest("GET", "/failure", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	assert.Equal(t, problem{
+		Type:     "about:blank",
+		Title:    "Internal Server Error",
+		Status:   http.StatusInternalServerError,
+		Detail:   "Error getting messages",
+		Instance: "/failure",
+		Code:     apperrors.InternalCode,
+	}, readProblemForTest(t, resp))
+
+	entries := readLogEntriesForTest(t, output)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "error", entries[0]["level"])
+	assert.Equal(t, "Error getting messages", entries[0]["msg"])
+	assert.Equal(t, "database is locked", entries[0]["error"])
+	assert.Equal(t, entries[1]["request_id"], entries[0]["request_id"])
+	assert.Equal(t, float64(http.StatusInternalServerError), entries[1]["status"])
+}
+
+//Response writer hiding the flusher of the recorder
+
+type nonFlushingWriterForTest struct {
+	http.ResponseWriter
+}
+
+func TestStreamFlusherShouldReportTheUnderlyingWriter(t *testing.T) {
+	cases := []struct {
+		name     string
+		writer   http.ResponseWriter
+		expected bool
+	}{
+		{"testFlushingWriter", httptest.NewRecorder(), true},
+		{"testNonFlushingWriter", nonFlushingWriterForTest{httptest.NewRecorder()}, false},
+		{"testRecorderOfFlushingWriter", &statusRecorder{ResponseWriter: httptest.NewRecorder()}, true},
+		{"testRecorderOfNonFlushingWriter", &statusRecorder{ResponseWriter: nonFlushingWriterForTest{httptest.NewRecorder()}}, false},
+		{"testNestedRecorderOfNonFlushingWriter", &statusRecorder{ResponseWriter: &statusRecorder{ResponseWriter: nonFlushingWriterForTest{httptest.NewRecorder()}}}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			_, ok := streamFlusher(c.writer)
+			assert.Equal(tt, c.expected, ok)
+		})
+	}
+}
+
+func TestStreamMessageEventsShouldFailWithoutFlushing(t *testing.T) {
+	h := jwtHandlerForTest(t)
+	h.Db = openSessionsDBForTest{h.Db}
+	token, err := h.Jwt.Issue(7, 1)
+	require.Nil(t, err)
+
+	req := httptest.NewRequest("GET", "/messages/events?start=1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+	h.Routes().ServeHTTP(nonFlushingWriterForTest{resp}, req)
+
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	p := readProblemForTest(t, resp)
+	assert.Equal(t, "Streaming unsupported", p.Detail)
+	assert.Equal(t, 0, h.Hub.Subscribers())
+}
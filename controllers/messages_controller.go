@@ -1,36 +1,26 @@
 package controllers
 
 import (
+	"context"
 	"bytes"
+	"github.com/maidaneze/message-server/apperrors"
+	"github.com/maidaneze/message-server/dao"
+	"github.com/maidaneze/message-server/metrics"
 	"github.com/maidaneze/message-server/model"
-	"github.com/maidaneze/message-server/services/auth"
 	"github.com/maidaneze/message-server/services/messages"
+	"github.com/maidaneze/message-server/services/notifications"
 	"encoding/json"
 	"net/http"
+	"time"
 )
 
-//Wrapper for the insertMessage handler and getMessage handler
-//Executes the getMessage handler if the resource is GET
-//Executes the insertMessage handler if the resource is POST
-//Returns 404 otherwise
-func (h Handler) MessagesHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == "GET" {
-		h.getMessage(w, r)
-	} else if r.Method == "POST" {
-		h.insertMessage(w, r)
-	} else {
-		http.Error(w, "Not found", http.StatusNotFound)
-		return
-	}
-}
-
-//Sends a new message from the sender to the recipient
+//Sends a new message from the authenticated user to the recipient
+//The sender is optional, if present it must be the authenticated user
 //Returns 400 if the request dto is invalid
-//Returns 401 if the token header doesn't correspond to the sender or is invalid
+//Returns 401 if the sender isn't the authenticated user
 //Returns 500 if the message couldn't be sent
 //Returns 200 otherwise
 func (h Handler) insertMessage(w http.ResponseWriter, r *http.Request) {
-
 	buf := new(bytes.Buffer)
 	buf.ReadFrom(r.Body)
 	defer r.Body.Close()
@@ -40,147 +30,247 @@ func (h Handler) insertMessage(w http.ResponseWriter, r *http.Request) {
 	//Check body
 	var err error
 	if err := json.Unmarshal(buf.Bytes(), &postMessageRequestDTO); err != nil {
-		http.Error(w, "Invalid body", http.StatusBadRequest)
+		writeError(w, r, errInvalidBody)
 		return
 	}
 
-	var dto model.MessageDTO
-	if dto, err = messages.UnmarshallMessageContent(postMessageRequestDTO); err != nil {
-		http.Error(w, "Invalid body", http.StatusBadRequest)
+	//The sender is the authenticated user
+	identity, valid := authorize(w, r, postMessageRequestDTO.Sender)
+	if !valid {
 		return
 	}
+	postMessageRequestDTO.Sender = identity.Userid
 
-	//Validate dto
-	if !messages.ValidMessageDto(dto) {
-		http.Error(w, "Invalid body", http.StatusBadRequest)
+	var dto model.MessageDTO
+	if dto, err = messages.UnmarshallMessageContent(postMessageRequestDTO); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	//Validate authorization Header
-	token, valid := auth.ValidateTokenHeader(r)
-	if !valid {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	//Validate dto
+	if fields := messages.ValidMessageDto(dto); len(fields) > 0 {
+		writeError(w, r, invalidBody(fields))
 		return
 	}
 
 	//Validate recipient Exists
-	found, err := h.Db.CheckUserExists(dto.RecipientId)
+	found, err := h.Db.CheckUserExists(r.Context(), dto.RecipientId)
 	if !found && err == nil {
-		http.Error(w, "Recipient doen't exist", http.StatusBadRequest)
+		writeError(w, r, unknownUser("recipient", "Recipient doesn't exist"))
 		return
 	}
 
 	if err != nil {
-		http.Error(w, "Error sending message", http.StatusInternalServerError)
+		writeError(w, r, apperrors.WrapInternal(err, "Error sending message"))
 		return
 	}
 
-	//Validate sender Exists
-	found, err = h.Db.CheckUserExists(dto.SenderId)
-	if !found && err == nil {
-		http.Error(w, "Sender doen't exist", http.StatusBadRequest)
+	//Insert into messages
+	dto, err = h.Db.InsertMessage(r.Context(), dto)
+	if err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error sending message"))
 		return
 	}
 
-	if err != nil {
-		http.Error(w, "Error sending message", http.StatusInternalServerError)
-		return
+	h.Metrics.CountMessage(metrics.DirectConversation, dto.Type)
+
+	//Notify the recipient streams
+	h.Hub.Publish(dto.RecipientId)
+
+	//Marshall response
+	if err := json.NewEncoder(w).Encode(messages.GetPostMessageResponseDTO(dto)); err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error sending message"))
 	}
+}
 
-	//Get user tokens
-	tokens, err := h.Db.GetTokens(dto.SenderId)
+//Gets all messages for the authenticated user starting from the given messageid and up to limit number of messages
+//(Default 100)
+//The id is optional, if present it must be the authenticated user
+//If there are no messages and the wait param is set, waits up to wait seconds for a new message before returning
+//The returned messages are marked as delivered
+//Returns 400 if the request queryparams are invalid
+//Returns 401 if the id isn't the authenticated user
+//Returns 500 if the message couldn't be recovered
+//Returns 200 otherwise
+func (h Handler) getMessage(w http.ResponseWriter, r *http.Request) {
+	//Get Query params
+	recipientid, messageid, limit, wait, err := messages.ParseGetMessageQueryParams(r)
 
 	if err != nil {
-		http.Error(w, "Error sending message", http.StatusInternalServerError)
+		writeError(w, r, errInvalidParams)
+		return
+	}
+
+	//Validate query params
+	if !(recipientid >= 0 && messageid > 0 && limit > 0) {
+		writeError(w, r, errInvalidBody)
 		return
 	}
 
-	//Validate user token
-	valid = auth.ValidateAuthorizedUser(token, tokens)
+	//The recipient is the authenticated user
+	identity, valid := authorize(w, r, recipientid)
 	if !valid {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
+	recipientid = identity.Userid
 
-	//Insert into messages
-	dto, err = h.Db.InsertMessage(dto)
+	//The request can wait for new messages on top of the request timeout
+	ctx, cancel := h.deadline(r.Context(), wait)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	//Subscribe before getting the messages so no message is missed in between
+	var subscription *notifications.Subscription
+	if wait > 0 {
+		subscription = h.Hub.Subscribe(recipientid)
+		defer subscription.Close()
+	}
+
+	//Get messages
+	getMessages, err := h.Db.GetMessages(r.Context(), recipientid, messageid, limit)
 	if err != nil {
-		http.Error(w, "Error sending message", http.StatusInternalServerError)
+		writeError(w, r, apperrors.WrapInternal(err, "Error getting messages"))
 		return
 	}
 
+	//Wait for a new message
+	if len(getMessages) == 0 && wait > 0 && waitForNotification(r, subscription, wait) {
+		getMessages, err = h.Db.GetMessages(r.Context(), recipientid, messageid, limit)
+		if err != nil {
+			writeError(w, r, apperrors.WrapInternal(err, "Error getting messages"))
+			return
+		}
+	}
+
+	//Mark messages as delivered
+	if err := deliverMessages(r.Context(), h.Db, recipientid, getMessages); err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error getting messages"))
+		return
+	}
+
+	messagesResponse := messages.ParseMessages(getMessages)
+
 	//Marshall response
-	if err := json.NewEncoder(w).Encode(messages.GetPostMessageResponseDTO(dto)); err != nil {
-		http.Error(w, "Error sending message", http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(map[string][]model.MessageResponse{"messages": messagesResponse}); err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error getting messages"))
 	}
 }
 
-//Gets all messages for the recipient starting from the given messageid and up to limit number of messages (Default 100)
-//Returns 400 if the request queryparams are invalid
-//Returns 401 if the token header doesn't correspond to the sender or is invalid
-//Returns 500 if the message couldn't be recovered
-//Returns 200 otherwise
-func (h Handler) getMessage(w http.ResponseWriter, r *http.Request) {
+//Marks as read the messages of the recipient up to the given messageid
+//The recipient is optional, if present it must be the authenticated user
+//Returns 400 if the request dto is invalid
+//Returns 401 if the recipient isn't the authenticated user
+//Returns 500 if the messages couldn't be marked
+//Returns 200 with the number of messages marked as read otherwise
+func (h Handler) readMessages(w http.ResponseWriter, r *http.Request) {
 
-	//Get Query params
-	recipientid, messageid, limit, err := messages.ParseGetMessageQueryParams(r)
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(r.Body)
+	defer r.Body.Close()
 
-	if err != nil {
-		http.Error(w, "Invalid Params", http.StatusBadRequest)
-		return
-	}
+	var dto model.ReadMessagesRequestDTO
 
-	//Validate query params
-	if !(recipientid > 0 && messageid > 0 && limit > 0) {
-		http.Error(w, "Invalid body", http.StatusBadRequest)
+	//Check body
+	if err := json.Unmarshal(buf.Bytes(), &dto); err != nil {
+		writeError(w, r, errInvalidBody)
 		return
 	}
 
-	//Validate authorization Header
-	token, valid := auth.ValidateTokenHeader(r)
-	if !valid {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	//Validate recipient
+	if !h.authorizeUser(w, r, &dto.Recipient) {
 		return
 	}
 
-	//Validate recipient Exists
-	found, err := h.Db.CheckUserExists(recipientid)
-	if !found && err == nil {
-		http.Error(w, "Recipient doen't exist", http.StatusBadRequest)
+	//Validate dto
+	if fields := messages.ValidReadMessagesRequestDTO(dto); len(fields) > 0 {
+		writeError(w, r, invalidBody(fields))
 		return
 	}
 
+	//Mark messages as read
+	read, err := h.Db.MarkMessagesRead(r.Context(), dto.Recipient, dto.Message, time.Now())
 	if err != nil {
-		http.Error(w, "Error getting messages", http.StatusInternalServerError)
+		writeError(w, r, apperrors.WrapInternal(err, "Error reading messages"))
 		return
 	}
 
-	//Get user tokens
-	tokens, err := h.Db.GetTokens(recipientid)
+	//Marshall response
+	if err := json.NewEncoder(w).Encode(model.ReadMessagesResponseDTO{Read: read}); err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error reading messages"))
+	}
+}
+
+//Gets the delivery status of the messages sent by the sender starting from the given messageid and up to limit
+//number of messages (Default 100)
+//The id is optional, if present it must be the authenticated user
+//Returns 400 if the request queryparams are invalid
+//Returns 401 if the sender isn't the authenticated user
+//Returns 500 if the statuses couldn't be recovered
+//Returns 200 otherwise
+func (h Handler) getMessageStatus(w http.ResponseWriter, r *http.Request) {
+
+	//Get Query params
+	senderid, messageid, limit, _, err := messages.ParseGetMessageQueryParams(r)
 
 	if err != nil {
-		http.Error(w, "Error getting messages", http.StatusInternalServerError)
+		writeError(w, r, errInvalidParams)
 		return
 	}
 
-	//Validate user token
-	valid = auth.ValidateAuthorizedUser(token, tokens)
-	if !valid {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	//Validate query params
+	if !(senderid >= 0 && messageid > 0 && limit > 0) {
+		writeError(w, r, errInvalidBody)
 		return
 	}
 
-	//Get messages
-	getMessages, err := h.Db.GetMessages(recipientid, messageid, limit)
-	if err != nil {
-		http.Error(w, "Error getting messages", http.StatusInternalServerError)
+	//Validate sender
+	if !h.authorizeUser(w, r, &senderid) {
 		return
 	}
 
-	messagesResponse := messages.ParseMessages(getMessages)
+	//Get statuses
+	statuses, err := h.Db.GetSentMessageStatuses(r.Context(), senderid, messageid, limit)
+	if err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error getting message status"))
+		return
+	}
 
 	//Marshall response
-	if err := json.NewEncoder(w).Encode(map[string][]model.MessageResponse{"messages": messagesResponse}); err != nil {
-		http.Error(w, "Error getting messages", http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(model.GetMessageStatusResponseDTO{Statuses: messages.ParseMessageStatuses(statuses)}); err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error getting message status"))
+	}
+}
+
+//Marks as delivered the messages recovered for the recipient and sets their delivery time
+//The messages must be the consecutive messages of the recipient ordered by messageid
+//Returns error in case of failiure
+
+func deliverMessages(ctx context.Context, db dao.DB, recipientId int64, m []model.MessageDTO) error {
+	if len(m) == 0 {
+		return nil
+	}
+
+	delivered := time.Now()
+	if err := db.MarkMessagesDelivered(ctx, recipientId, m[0].MessageId, m[len(m)-1].MessageId, delivered); err != nil {
+		return err
+	}
+	messages.SetDelivered(m, delivered)
+	return nil
+}
+
+//Waits until the subscription is notified, the wait time expires or the client disconnects
+//Returns true if the subscription was notified and false otherwise
+
+func waitForNotification(r *http.Request, subscription *notifications.Subscription, wait time.Duration) bool {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case _, open := <-subscription.C:
+		return open
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
 	}
 }
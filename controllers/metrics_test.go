@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/maidaneze/message-server/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoutesShouldServeTheMetrics(t *testing.T) {
+	h := jwtHandlerForTest(t)
+	h.Log = testLogger
+	h.Metrics = metrics.New()
+	routes := h.Routes()
+
+	routes.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/messages", nil))
+	routes.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/unknown", nil))
+
+	resp := httptest.NewRecorder()
+	routes.ServeHTTP(resp, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), `message_server_http_requests_total{method="GET",route="/messages",status="401"} 1`)
+	assert.Contains(t, resp.Body.String(), `message_server_http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+}
+
+func TestRoutesShouldBoundTheMethodsOfTheMetrics(t *testing.T) {
+	h := jwtHandlerForTest(t)
+	h.Log = testLogger
+	h.Metrics = metrics.New()
+	routes := h.Routes()
+
+	routes.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/messages", nil))
+	routes.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("XYZZY", "/unknown", nil))
+
+	resp := httptest.NewRecorder()
+	routes.ServeHTTP(resp, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), `message_server_http_requests_total{method="other",`)
+	assert.NotContains(t, resp.Body.String(), "BREW")
+	assert.NotContains(t, resp.Body.String(), "XYZZY")
+}
+
+func TestRoutesShouldNotServeTheMetricsIfDisabled(t *testing.T) {
+	resp := httptest.NewRecorder()
+	jwtHandlerForTest(t).Routes().ServeHTTP(resp, httptest.NewRequest("GET", "/metrics", bytes.NewReader(nil)))
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
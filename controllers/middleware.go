@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"context"
+	"github.com/maidaneze/message-server/apperrors"
+	"net/http"
+	"time"
+
+	"github.com/maidaneze/message-server/logging"
+	"github.com/maidaneze/message-server/model"
+	"github.com/maidaneze/message-server/services/auth"
+	"github.com/maidaneze/message-server/utils"
+)
+
+//Middleware bounding the request by the request timeout, its database operations are canceled once the deadline
+//passes and the request fails with 503
+//The streams and long polls bound their database operations themselves, they last longer than the timeout
+
+func (h Handler) withDeadline(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := h.deadline(r.Context(), 0)
+		defer cancel()
+		next(w, r.WithContext(ctx))
+	}
+}
+
+//Returns a copy of the context with a deadline of the request timeout plus extra, and the function releasing it
+//The context is only canceled with its parent or by the function if the timeout is disabled
+
+func (h Handler) deadline(ctx context.Context, extra time.Duration) (context.Context, context.CancelFunc) {
+	if h.RequestTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, h.RequestTimeout+extra)
+}
+
+//Middleware of the protected routes, authenticates the request by its token header before calling the next handler
+//The token alone identifies the user, which is added to the request context for the next handler
+//Returns 401 if the token header is invalid, expired or revoked
+//Returns 500 if the token couldn't be validated
+
+func (h Handler) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		//Validate authorization Header
+		token, valid := auth.ValidateTokenHeader(r)
+		if !valid {
+			writeError(w, r, errUnauthorized)
+			return
+		}
+
+		//Resolve the user of the token
+		identity, valid, err := h.identify(r, token)
+
+		if err != nil {
+			writeError(w, r, apperrors.WrapInternal(err, "Error authenticating request"))
+			return
+		}
+
+		if !valid {
+			writeError(w, r, errUnauthorized)
+			return
+		}
+
+		setRequestUser(r, identity.Userid)
+		next(w, r.WithContext(auth.WithIdentity(r.Context(), identity)))
+	}
+}
+
+//Resolves the user and session of the access token
+//Signed access tokens have them in their claims, their session is checked so they are revoked by the logout
+//Opaque tokens are looked up by their hash, then validated by auth.ValidateAuthorizedUser in constant time
+//Returns the identity and true if the token is valid
+//Returns error in case of failiure
+
+func (h Handler) identify(r *http.Request, token string) (auth.Identity, bool, error) {
+	if h.Jwt != nil {
+		claims, err := h.Jwt.Validate(token)
+		if err != nil {
+			return auth.Identity{}, false, nil
+		}
+		userid, err := claims.Userid()
+		if err != nil {
+			return auth.Identity{}, false, nil
+		}
+		found, err := h.Db.CheckSession(r.Context(), userid, claims.SessionId)
+		if err != nil || !found {
+			return auth.Identity{}, false, err
+		}
+		return auth.Identity{Userid: userid, SessionId: claims.SessionId}, true, nil
+	}
+
+	hash := h.Tokens.Hash(token)
+	session, found, err := h.Db.GetToken(r.Context(), hash)
+	if err != nil {
+		return auth.Identity{}, false, err
+	}
+
+	if !found || !auth.ValidateAuthorizedUser(hash, []model.Token{session}) {
+		return auth.Identity{}, false, nil
+	}
+	h.touchSession(r, session.Userid, session)
+	return auth.Identity{Userid: session.Userid, SessionId: session.SessionId}, true, nil
+}
+
+//Updates the last used time of the session, at most once every auth.LastUsedResolution
+//The last used time is informative, so failing to update it is logged and doesn't fail the request
+
+func (h Handler) touchSession(r *http.Request, userid int64, session model.Token) {
+	now := utils.UTCTimeMilliseconds()
+	if auth.ShouldTouchToken(session, now) {
+		if err := h.Db.TouchToken(r.Context(), userid, session.Uuid, now); err != nil {
+			logging.FromContext(r.Context()).Warn("Unable to update the session last used time", logging.Fields{"error": err, "user_id": userid})
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/maidaneze/message-server/dao"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//Serves the request with the given request timeout, the database operations run the interceptor instead of
+//accessing a database
+
+func serveWithTimeoutForTest(t *testing.T, timeout time.Duration, interceptor dao.Interceptor, path string) *httptest.ResponseRecorder {
+	h := jwtHandlerForTest(t)
+	h.Db = openSessionsDBForTest{dao.Intercept(nil, interceptor)}
+	h.RequestTimeout = timeout
+	token, err := h.Jwt.Issue(7, 1)
+	require.Nil(t, err)
+
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+	h.Routes().ServeHTTP(resp, req)
+	return resp
+}
+
+func TestWithDeadlineShouldCancelTheTimedOutRequests(t *testing.T) {
+	resp := serveWithTimeoutForTest(t, time.Millisecond*20, func(ctx context.Context, operation string, next func(ctx context.Context) error) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, "/sessions?id=7")
+
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	assert.Equal(t, "request_timeout", readProblemForTest(t, resp).Code)
+}
+
+func TestWithDeadlineShouldSetTheRequestTimeout(t *testing.T) {
+	cases := []struct {
+		name             string
+		timeout          time.Duration
+		expectedDeadline bool
+	}{
+		{"testWithDeadlineTimeout", time.Second, true},
+		{"testWithDeadlineDisabled", 0, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			var hasDeadline bool
+			resp := serveWithTimeoutForTest(tt, c.timeout, func(ctx context.Context, operation string, next func(ctx context.Context) error) error {
+				_, hasDeadline = ctx.Deadline()
+				return nil
+			}, "/sessions?id=7")
+
+			assert.Equal(tt, http.StatusOK, resp.Code)
+			assert.Equal(tt, c.expectedDeadline, hasDeadline)
+		})
+	}
+}
+
+func TestLongPollsShouldWaitOnTopOfTheRequestTimeout(t *testing.T) {
+	var remaining time.Duration
+	resp := serveWithTimeoutForTest(t, time.Millisecond*20, func(ctx context.Context, operation string, next func(ctx context.Context) error) error {
+		deadline, _ := ctx.Deadline()
+		remaining = time.Until(deadline)
+		return nil
+	}, "/messages?id=7&start=1&wait=1")
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.True(t, remaining > time.Millisecond*500)
+}
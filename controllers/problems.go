@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/maidaneze/message-server/apperrors"
+	"github.com/maidaneze/message-server/logging"
+	"github.com/maidaneze/message-server/tracing"
+)
+
+//Content type of the error responses, see RFC 7807
+
+const problemContentType = "application/problem+json"
+
+//Problem details of an error response
+//Code is the stable identifier of the problem, and Errors the invalid fields of an invalid request
+
+type problem struct {
+	Type     string                 `json:"type"`
+	Title    string                 `json:"title"`
+	Status   int                    `json:"status"`
+	Detail   string                 `json:"detail,omitempty"`
+	Instance string                 `json:"instance,omitempty"`
+	Code     string                 `json:"code"`
+	Errors   []apperrors.FieldError `json:"errors,omitempty"`
+}
+
+//Errors shared by the handlers
+
+var (
+	errInvalidBody   = apperrors.NewInvalid("invalid_body", "Invalid body")
+	errInvalidParams = apperrors.NewInvalid("invalid_params", "Invalid params")
+	errUnauthorized  = apperrors.New(apperrors.Unauthorized, "unauthorized", "Unauthorized")
+	errForbidden     = apperrors.New(apperrors.Forbidden, "forbidden", "Forbidden")
+	errNotFound      = apperrors.New(apperrors.NotFound, "not_found", "Not found")
+)
+
+//Returns the invalid body error with the invalid fields of the body
+
+func invalidBody(fields []apperrors.FieldError) error {
+	return apperrors.NewInvalid("invalid_body", "Invalid body", fields...)
+}
+
+//Returns the invalid request error of a field referencing a user that doesn't exist
+
+func unknownUser(field string, message string) error {
+	return apperrors.NewInvalid("unknown_user", message, apperrors.FieldError{Field: field, Code: "unknown_user", Message: message})
+}
+
+//Code of the requests with an unsupported method
+
+const methodNotAllowedCode = "method_not_allowed"
+
+//Status of the responses of every kind of error
+
+var problemStatus = map[apperrors.Kind]int{
+	apperrors.Internal:     http.StatusInternalServerError,
+	apperrors.Invalid:      http.StatusBadRequest,
+	apperrors.Unauthorized: http.StatusUnauthorized,
+	apperrors.Forbidden:    http.StatusForbidden,
+	apperrors.NotFound:     http.StatusNotFound,
+	apperrors.Conflict:     http.StatusConflict,
+	apperrors.Unavailable:  http.StatusServiceUnavailable,
+}
+
+//Writes the error response of the error, with its status, code and message
+//Errors that aren't typed are internal errors
+//The internal and unavailable errors are logged with the request logger and recorded in the trace with their
+//cause, which the response never exposes
+//If the request timed out or the client went away, the failure is logged as a warning and the response is a 503
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	typed, ok := apperrors.As(err)
+	if !ok {
+		typed = apperrors.NewInternal("Internal server error", err)
+	}
+
+	if typed.Kind == apperrors.Internal || typed.Kind == apperrors.Unavailable {
+		cause := typed.Cause
+		if cause == nil {
+			cause = typed
+		}
+		tracing.SpanFromContext(r.Context()).SetError(cause)
+
+		log := logging.FromContext(r.Context())
+		switch r.Context().Err() {
+		case context.DeadlineExceeded:
+			log.Warn(typed.Message, logging.Fields{"error": cause, "reason": "request timed out"})
+			typed = apperrors.Wrap(apperrors.Unavailable, "request_timeout", "Request timed out", cause)
+		case context.Canceled:
+			log.Warn(typed.Message, logging.Fields{"error": cause, "reason": "request canceled"})
+			typed = apperrors.Wrap(apperrors.Unavailable, "request_canceled", "Request canceled", cause)
+		default:
+			if typed.Kind == apperrors.Internal {
+				log.Error(typed.Message, logging.Fields{"error": cause})
+			} else {
+				log.Warn(typed.Message, logging.Fields{"error": cause})
+			}
+		}
+	}
+
+	status, found := problemStatus[typed.Kind]
+	if !found {
+		status = http.StatusInternalServerError
+	}
+	writeProblem(w, r, status, typed.Code, typed.Message, typed.Fields)
+}
+
+//Writes the problem details response
+//The problems have no documentation of their own, so their type is about:blank and their title the status text
+
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code string, detail string, fields []apperrors.FieldError) {
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Code:     code,
+		Errors:   fields,
+	})
+}
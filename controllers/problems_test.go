@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/maidaneze/message-server/apperrors"
+	"github.com/maidaneze/message-server/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//Decodes the problem details of the error response
+
+func readProblemForTest(t *testing.T, resp *httptest.ResponseRecorder) problem {
+	require.Equal(t, problemContentType, resp.Header().Get("Content-Type"))
+	var p problem
+	require.Nil(t, json.Unmarshal(resp.Body.Bytes(), &p))
+	return p
+}
+
+func TestWriteErrorShouldWriteTheProblem(t *testing.T) {
+	field := apperrors.FieldError{Field: "recipient", Code: "unknown_user", Message: "Recipient doesn't exist"}
+	cases := []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedCode   string
+		expectedDetail string
+		expectedFields []apperrors.FieldError
+	}{
+		{"testWriteInvalidError", unknownUser("recipient", "Recipient doesn't exist"), http.StatusBadRequest, "unknown_user", "Recipient doesn't exist", []apperrors.FieldError{field}},
+		{"testWriteUnauthorizedError", errUnauthorized, http.StatusUnauthorized, "unauthorized", "Unauthorized", nil},
+		{"testWriteForbiddenError", errForbidden, http.StatusForbidden, "forbidden", "Forbidden", nil},
+		{"testWriteNotFoundError", errNotFound, http.StatusNotFound, "not_found", "Not found", nil},
+		{"testWriteConflictError", apperrors.New(apperrors.Conflict, "username_taken", "Username already exists"), http.StatusConflict, "username_taken", "Username already exists", nil},
+		{"testWriteInternalError", apperrors.WrapInternal(errors.New("database is locked"), "Error getting messages"), http.StatusInternalServerError, apperrors.InternalCode, "Error getting messages", nil},
+		{"testWriteUntypedError", errors.New("database is locked"), http.StatusInternalServerError, apperrors.InternalCode, "Internal server error", nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			resp := httptest.NewRecorder()
+			writeError(resp, httptest.NewRequest("GET", "/messages", nil), c.err)
+
+			assert.Equal(tt, c.expectedStatus, resp.Code)
+			assert.Equal(tt, "nosniff", resp.Header().Get("X-Content-Type-Options"))
+			assert.Equal(tt, problem{
+				Type:     "about:blank",
+				Title:    http.StatusText(c.expectedStatus),
+				Status:   c.expectedStatus,
+				Detail:   c.expectedDetail,
+				Instance: "/messages",
+				Code:     c.expectedCode,
+				Errors:   c.expectedFields,
+			}, readProblemForTest(tt, resp))
+			assert.NotContains(tt, resp.Body.String(), "database is locked")
+		})
+	}
+}
+
+func TestWriteErrorShouldOnlyLogTheServerErrors(t *testing.T) {
+	cases := []struct {
+		name          string
+		err           error
+		expectedLevel string
+	}{
+		{"testLogInternalError", apperrors.NewInternal("Error getting messages", errors.New("database is locked")), "error"},
+		{"testLogUnavailableError", apperrors.Wrap(apperrors.Unavailable, "unavailable", "Unavailable", errors.New("database is locked")), "warn"},
+		{"testNotLogInvalidError", errInvalidBody, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			output := new(bytes.Buffer)
+			req := httptest.NewRequest("GET", "/messages", nil)
+			req = req.WithContext(logging.WithLogger(req.Context(), logging.New(output, logging.Debug)))
+			writeError(httptest.NewRecorder(), req, c.err)
+
+			if c.expectedLevel == "" {
+				assert.Empty(tt, output.String())
+				return
+			}
+			entries := readLogEntriesForTest(tt, output)
+			require.Len(tt, entries, 1)
+			assert.Equal(tt, c.expectedLevel, entries[0]["level"])
+			assert.Equal(tt, "database is locked", entries[0]["error"])
+		})
+	}
+}
+
+func TestWriteErrorShouldRespondUnavailableOnceTheRequestIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	resp := httptest.NewRecorder()
+	writeError(resp, httptest.NewRequest("GET", "/messages", nil).WithContext(ctx), errors.New("context canceled"))
+
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	assert.Equal(t, "request_canceled", readProblemForTest(t, resp).Code)
+}
+
+func TestInvalidPayloadsShouldReturnTheInvalidFields(t *testing.T) {
+	h := jwtHandlerForTest(t)
+	h.Db = openSessionsDBForTest{h.Db}
+	token, err := h.Jwt.Issue(7, 1)
+	require.Nil(t, err)
+
+	cases := []struct {
+		name     string
+		path     string
+		body     string
+		expected []apperrors.FieldError
+	}{
+		{"testInvalidUserFields", "/users", `{"username":"user1"}`,
+			[]apperrors.FieldError{{Field: "password", Code: apperrors.FieldRequired, Message: "password is required"}}},
+		{"testInvalidImageFields", "/messages", `{"recipient":8,"content":{"type":"image","url":"url","height":0,"width":10}}`,
+			[]apperrors.FieldError{{Field: "content.height", Code: apperrors.FieldOutOfRange, Message: "content.height must be > 0"}}},
+		{"testInvalidVideoFields", "/messages", `{"recipient":8,"content":{"type":"video","url":"url","source":"dailymotion"}}`,
+			[]apperrors.FieldError{{Field: "content.source", Code: apperrors.FieldInvalidValue, Message: "content.source must be youtube or vimeo"}}},
+		{"testMissingContentFields", "/messages", `{"recipient":8,"content":{"type":"text"}}`,
+			[]apperrors.FieldError{{Field: "content.text", Code: apperrors.FieldRequired, Message: "content.text is required"}}},
+		{"testInvalidReadMessagesFields", "/messages/read", `{"recipient":7}`,
+			[]apperrors.FieldError{{Field: "message", Code: apperrors.FieldOutOfRange, Message: "message must be > 0"}}},
+		{"testInvalidGroupMessageFields", "/groups/messages", `{"sender":7,"content":{"type":"image","url":"url","height":10,"width":0}}`,
+			[]apperrors.FieldError{
+				{Field: "group", Code: apperrors.FieldOutOfRange, Message: "group must be > 0"},
+				{Field: "content.width", Code: apperrors.FieldOutOfRange, Message: "content.width must be > 0"},
+			}},
+		{"testMissingGroupMessageContentFields", "/groups/messages", `{"sender":7,"group":1,"content":{"type":"video","url":"url"}}`,
+			[]apperrors.FieldError{{Field: "content.source", Code: apperrors.FieldRequired, Message: "content.source is required"}}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			req := httptest.NewRequest("POST", c.path, bytes.NewReader([]byte(c.body)))
+			req.Header.Set("Authorization", "Bearer "+token)
+			resp := httptest.NewRecorder()
+			h.Routes().ServeHTTP(resp, req)
+
+			assert.Equal(tt, http.StatusBadRequest, resp.Code)
+			p := readProblemForTest(tt, resp)
+			assert.Equal(tt, "invalid_body", p.Code)
+			assert.Equal(tt, c.expected, p.Errors)
+		})
+	}
+}
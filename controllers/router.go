@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+//Wraps a handler, the middleware decides whether and how the next handler is called
+
+type middleware func(next http.HandlerFunc) http.HandlerFunc
+
+//Routes the requests by their method and path
+//Patterns are paths whose segments can be path params with the form "{name}", matching any non empty segment
+//When several patterns match a path, the one with static segments before the params wins
+//Returns 404 if no pattern matches the path
+//Returns 405 with the Allow header if the pattern doesn't have a handler for the method
+
+type router struct {
+	routes []*route
+}
+
+//Handlers of a pattern by method
+
+type route struct {
+	pattern  string
+	segments []string
+	handlers map[string]http.HandlerFunc
+}
+
+//Routes registered under a common prefix and with common middlewares
+//The middlewares of a group run in order, after the middlewares of its parent group
+
+type routeGroup struct {
+	router      *router
+	prefix      string
+	middlewares []middleware
+}
+
+type pathParamsKey struct{}
+
+func newRouter() *router {
+	return &router{}
+}
+
+//Returns a new group of routes with the given prefix and middlewares
+
+func (rt *router) group(prefix string, middlewares ...middleware) *routeGroup {
+	return &routeGroup{router: rt, prefix: prefix, middlewares: middlewares}
+}
+
+//Returns a new group of routes nested in the group, adding the prefix and middlewares to the ones of the group
+
+func (g *routeGroup) group(prefix string, middlewares ...middleware) *routeGroup {
+	all := make([]middleware, 0, len(g.middlewares)+len(middlewares))
+	all = append(all, g.middlewares...)
+	all = append(all, middlewares...)
+	return &routeGroup{router: g.router, prefix: g.prefix + prefix, middlewares: all}
+}
+
+//Registers the handler for the method and the pattern, prefixed by the group prefix
+//Panics if the pattern already has a handler for the method
+
+func (g *routeGroup) handle(method string, pattern string, handler http.HandlerFunc) {
+	for i := len(g.middlewares) - 1; i >= 0; i-- {
+		handler = g.middlewares[i](handler)
+	}
+	g.router.handle(method, g.prefix+pattern, handler)
+}
+
+func (rt *router) handle(method string, pattern string, handler http.HandlerFunc) {
+	segments := splitPath(pattern)
+	for _, route := range rt.routes {
+		if route.pattern != strings.Join(segments, "/") {
+			continue
+		}
+		if _, found := route.handlers[method]; found {
+			panic("router: duplicate route " + method + " " + pattern)
+		}
+		route.handlers[method] = handler
+		return
+	}
+
+	rt.routes = append(rt.routes, &route{
+		pattern:  strings.Join(segments, "/"),
+		segments: segments,
+		handlers: map[string]http.HandlerFunc{method: handler},
+	})
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var match *route
+	var params map[string]string
+
+	path := splitPath(r.URL.Path)
+	for _, route := range rt.routes {
+		if routeParams, found := route.match(path); found && (match == nil || route.moreSpecific(match)) {
+			match, params = route, routeParams
+		}
+	}
+
+	if match == nil {
+		writeError(w, r, errNotFound)
+		return
+	}
+
+	handler, found := match.handlers[r.Method]
+	if !found {
+		w.Header().Set("Allow", strings.Join(match.methods(), ", "))
+		writeProblem(w, r, http.StatusMethodNotAllowed, methodNotAllowedCode, "Method not allowed", nil)
+		return
+	}
+
+	setRequestRoute(r, "/"+match.pattern)
+	if len(params) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), pathParamsKey{}, params))
+	}
+	handler(w, r)
+}
+
+//Returns the path params of the route for the path and true if the route pattern matches it
+
+func (rt *route) match(path []string) (map[string]string, bool) {
+	if len(path) != len(rt.segments) {
+		return nil, false
+	}
+
+	var params map[string]string
+	for i, segment := range rt.segments {
+		if name, isParam := paramName(segment); isParam {
+			if path[i] == "" {
+				return nil, false
+			}
+			if params == nil {
+				params = map[string]string{}
+			}
+			params[name] = path[i]
+		} else if segment != path[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+//Returns true if the first segment that differs between both routes is static in this route
+
+func (rt *route) moreSpecific(other *route) bool {
+	for i, segment := range rt.segments {
+		_, isParam := paramName(segment)
+		_, otherIsParam := paramName(other.segments[i])
+		if isParam != otherIsParam {
+			return !isParam
+		}
+	}
+	return false
+}
+
+//Returns the methods of the route sorted alphabetically
+
+func (rt *route) methods() []string {
+	methods := make([]string, 0, len(rt.handlers))
+	for method := range rt.handlers {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+//Returns the value of the path param of the request, or an empty string if the route doesn't have the param
+
+func pathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(pathParamsKey{}).(map[string]string)
+	return params[name]
+}
+
+//Returns the name of the path param and true if the pattern segment is a path param
+
+func paramName(segment string) (string, bool) {
+	if len(segment) > 2 && strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+//Splits the path in its segments, ignoring the leading and trailing slashes
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
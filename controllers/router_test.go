@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeBodyForTest(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body + pathParam(r, "id")))
+	}
+}
+
+func headerMiddlewareForTest(value string) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Middleware", value)
+			next(w, r)
+		}
+	}
+}
+
+func TestRouter(t *testing.T) {
+	rt := newRouter()
+	root := rt.group("")
+	root.handle("GET", "/messages", writeBodyForTest("get messages"))
+	root.handle("POST", "/messages", writeBodyForTest("post messages"))
+	root.handle("GET", "/messages/{id}", writeBodyForTest("get message "))
+	root.handle("DELETE", "/messages/{id}", writeBodyForTest("delete message "))
+	root.handle("GET", "/messages/status", writeBodyForTest("get status"))
+
+	cases := []struct {
+		name           string
+		method         string
+		path           string
+		expectedStatus int
+		expectedBody   string //Code of the problem for errors
+		expectedAllow  string
+	}{
+		{"testRouterStaticRoute", "GET", "/messages", http.StatusOK, "get messages", ""},
+		{"testRouterMethod", "POST", "/messages", http.StatusOK, "post messages", ""},
+		{"testRouterTrailingSlash", "GET", "/messages/", http.StatusOK, "get messages", ""},
+		{"testRouterPathParam", "GET", "/messages/12", http.StatusOK, "get message 12", ""},
+		{"testRouterStaticBeforeParam", "GET", "/messages/status", http.StatusOK, "get status", ""},
+		{"testRouterNotFound", "GET", "/users", http.StatusNotFound, "not_found", ""},
+		{"testRouterExtraSegments", "GET", "/messages/12/status", http.StatusNotFound, "not_found", ""},
+		{"testRouterEmptyParam", "GET", "/messages//status", http.StatusNotFound, "not_found", ""},
+		{"testRouterMethodNotAllowed", "DELETE", "/messages", http.StatusMethodNotAllowed, "method_not_allowed", "GET, POST"},
+		{"testRouterParamMethodNotAllowed", "POST", "/messages/12", http.StatusMethodNotAllowed, "method_not_allowed", "DELETE, GET"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			resp := httptest.NewRecorder()
+			rt.ServeHTTP(resp, httptest.NewRequest(c.method, c.path, nil))
+			assert.Equal(tt, c.expectedStatus, resp.Code)
+			if c.expectedStatus == http.StatusOK {
+				assert.Equal(tt, c.expectedBody, resp.Body.String())
+			} else {
+				assert.Equal(tt, c.expectedBody, readProblemForTest(tt, resp).Code)
+			}
+			assert.Equal(tt, c.expectedAllow, resp.Header().Get("Allow"))
+		})
+	}
+}
+
+func TestRouterGroupsShouldShareMiddlewares(t *testing.T) {
+	rt := newRouter()
+	rt.group("").handle("GET", "/check", writeBodyForTest("check"))
+	protected := rt.group("", headerMiddlewareForTest("auth"))
+	protected.group("/groups", headerMiddlewareForTest("groups")).handle("GET", "/members", writeBodyForTest("members"))
+
+	resp := httptest.NewRecorder()
+	rt.ServeHTTP(resp, httptest.NewRequest("GET", "/groups/members", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "members", resp.Body.String())
+	assert.Equal(t, []string{"auth", "groups"}, resp.Header()["X-Middleware"])
+
+	resp = httptest.NewRecorder()
+	rt.ServeHTTP(resp, httptest.NewRequest("GET", "/check", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Empty(t, resp.Header()["X-Middleware"])
+}
+
+func TestRouterShouldRejectDuplicateRoutes(t *testing.T) {
+	rt := newRouter()
+	rt.group("").handle("GET", "/messages", writeBodyForTest("messages"))
+	assert.Panics(t, func() {
+		rt.group("/messages").handle("GET", "/", writeBodyForTest("messages"))
+	})
+}
+
+func TestSetupShouldRequireATokenHasher(t *testing.T) {
+	assert.Panics(t, func() {
+		Handler{}.Setup()
+	})
+}
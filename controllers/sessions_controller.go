@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/maidaneze/message-server/apperrors"
+	"github.com/maidaneze/message-server/model"
+	"github.com/maidaneze/message-server/services/auth"
+)
+
+//Gets the active sessions of the user with their creation, expiration and last used times and user agent
+//The session of the token used in the request is flagged as current
+//The id is optional, if present it must be the authenticated user
+//Returns 400 if the request queryparams are invalid
+//Returns 401 if the user isn't the authenticated user
+//Returns 500 if the sessions couldn't be recovered
+//Returns 200 otherwise
+func (h Handler) getSessions(w http.ResponseWriter, r *http.Request) {
+
+	//Get Query params
+	userid, _, err := auth.ParseSessionQueryParams(r)
+	if err != nil || userid < 0 {
+		writeError(w, r, errInvalidParams)
+		return
+	}
+
+	//The user is the authenticated user
+	identity, valid := authorize(w, r, userid)
+	if !valid {
+		return
+	}
+	userid = identity.Userid
+
+	//Get sessions
+	tokens, err := h.Db.GetTokens(r.Context(), userid)
+	if err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error getting sessions"))
+		return
+	}
+
+	//Marshall response
+	if err := json.NewEncoder(w).Encode(model.GetSessionsResponseDTO{Sessions: auth.ParseSessions(tokens, identity.SessionId)}); err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error getting sessions"))
+	}
+}
+
+//Terminates the given session of the user, revoking its token
+//The id is optional, if present it must be the authenticated user
+//Returns 400 if the request queryparams are invalid
+//Returns 401 if the user isn't the authenticated user
+//Returns 404 if the session doesn't exist
+//Returns 500 if the session couldn't be terminated
+//Returns 200 otherwise with the number of revoked tokens
+func (h Handler) deleteSession(w http.ResponseWriter, r *http.Request) {
+
+	//Get Query params
+	userid, sessionid, err := auth.ParseSessionQueryParams(r)
+	if err != nil || userid < 0 || sessionid <= 0 {
+		writeError(w, r, errInvalidParams)
+		return
+	}
+
+	//Validate user
+	if !h.authorizeUser(w, r, &userid) {
+		return
+	}
+
+	//Delete session
+	deleted, err := h.Db.DeleteSession(r.Context(), userid, sessionid)
+	if err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error terminating session"))
+		return
+	}
+
+	if !deleted {
+		writeError(w, r, apperrors.New(apperrors.NotFound, "session_not_found", "Session not found"))
+		return
+	}
+
+	//Marshall response
+	if err := json.NewEncoder(w).Encode(model.LogoutResponseDTO{Revoked: 1}); err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error terminating session"))
+	}
+}
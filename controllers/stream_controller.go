@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/maidaneze/message-server/dao"
+	"github.com/maidaneze/message-server/logging"
+	"github.com/maidaneze/message-server/metrics"
+	"github.com/maidaneze/message-server/model"
+	"github.com/maidaneze/message-server/services/messages"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	streamWriteWait    = 10 * time.Second
+	streamPongWait     = 60 * time.Second
+	streamPingInterval = streamPongWait * 9 / 10
+	streamReadLimit    = int64(512)
+
+	upgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+)
+
+//Recovers the messages of a recipient in order, starting from a message id
+//Keeps track of the next message id to deliver so the messages are never repeated or skipped
+
+type messageStream struct {
+	db          dao.DB
+	recipientId int64
+	next        int64
+	limit       int64
+}
+
+//Recovers all the pending messages, at most limit messages at a time, and calls send for each of them
+//The messages are marked as delivered before being sent
+//Advances the stream past every sent message
+//Returns error in case of failiure
+
+func (s *messageStream) drain(ctx context.Context, send func(model.MessageResponse) error) error {
+	for {
+		page, err := s.db.GetMessages(ctx, s.recipientId, s.next, s.limit)
+		if err != nil {
+			return err
+		}
+
+		if len(page) == 0 {
+			return nil
+		}
+
+		if err := deliverMessages(ctx, s.db, s.recipientId, page); err != nil {
+			return err
+		}
+
+		for _, message := range messages.ParseMessages(page) {
+			if err := send(message); err != nil {
+				return err
+			}
+		}
+		s.next = page[len(page)-1].MessageId + 1
+
+		if int64(len(page)) < s.limit {
+			return nil
+		}
+	}
+}
+
+//Drains the stream with the request timeout as deadline, a stream lasts as long as its client but each drain
+//is bounded
+//Returns error in case of failiure
+
+func (h Handler) drainStream(r *http.Request, stream *messageStream, send func(model.MessageResponse) error) error {
+	ctx, cancel := h.deadline(r.Context(), 0)
+	defer cancel()
+	return stream.drain(ctx, send)
+}
+
+//Streams the messages for the recipient over a WebSocket starting from the given messageid
+//The messages already stored are sent first, and then each new message is sent as soon as it is inserted
+//A client can resume without gaps by reconnecting with start set to the last received message id plus one
+//The limit param sets how many messages are recovered from the database at a time (Default 100)
+//The id is optional, if present it must be the authenticated user
+//Returns 400 if the request queryparams are invalid
+//Returns 401 if the recipient isn't the authenticated user
+//Upgrades the connection to a WebSocket otherwise
+
+func (h Handler) StreamMessages(w http.ResponseWriter, r *http.Request) {
+
+	//Get Query params
+	recipientid, messageid, limit, _, err := messages.ParseGetMessageQueryParams(r)
+
+	if err != nil {
+		writeError(w, r, errInvalidParams)
+		return
+	}
+
+	//Validate query params
+	if !(recipientid >= 0 && messageid > 0 && limit > 0) {
+		writeError(w, r, errInvalidBody)
+		return
+	}
+
+	//Validate user token, the recipient is the authenticated user
+	identity, valid := authorize(w, r, recipientid)
+	if !valid {
+		return
+	}
+	recipientid = identity.Userid
+
+	//Upgrade connection, the upgrader replies with an error on failiure
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	defer h.Metrics.OpenStream(metrics.WebSocketStream)()
+
+	//Subscribe before recovering the stored messages so no message is missed in between
+	subscription := h.Hub.Subscribe(recipientid)
+	defer subscription.Close()
+
+	closed := make(chan struct{})
+	go readUntilClosed(conn, closed)
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+
+	stream := messageStream{h.Db, recipientid, messageid, limit}
+	send := func(message model.MessageResponse) error {
+		conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+		return conn.WriteJSON(message)
+	}
+
+	for {
+		if err := h.drainStream(r, &stream, send); err != nil {
+			logging.FromContext(r.Context()).Error("Error streaming messages", logging.Fields{"error": err, "user_id": recipientid})
+			closeStream(conn, websocket.CloseInternalServerErr, "Error getting messages")
+			return
+		}
+
+		select {
+		case _, open := <-subscription.C:
+			if !open {
+				closeStream(conn, websocket.CloseGoingAway, "Server shutting down")
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteWait)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+//Reads from the connection until the client closes it or stops answering the pings, discarding any message
+//Closes the closed channel when it returns
+
+func readUntilClosed(conn *websocket.Conn, closed chan struct{}) {
+	defer close(closed)
+
+	conn.SetReadLimit(streamReadLimit)
+	conn.SetReadDeadline(time.Now().Add(streamPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(streamPongWait))
+	})
+
+	for {
+		if _, _, err := conn.NextReader(); err != nil {
+			return
+		}
+	}
+}
+
+//Sends a close message with the given code and reason
+
+func closeStream(conn *websocket.Conn, code int, reason string) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(streamWriteWait))
+}
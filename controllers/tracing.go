@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/maidaneze/message-server/tracing"
+)
+
+//Wraps the handler with a server span for every request, continuing the trace of the traceparent header if valid
+//The span is named after the method and route once the router matches the request, and has the status and the
+//authenticated user of the request
+//The handlers and the database operations trace their work as children of the span of the request context
+
+func (h Handler) traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if parent, valid := tracing.ParseTraceparent(r.Header.Get(tracing.TraceparentHeader)); valid {
+			ctx = tracing.ContextWithRemoteParent(ctx, parent)
+		}
+
+		ctx, span := h.Tracer.Start(ctx, r.Method, tracing.Server)
+		if span == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer span.End()
+
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.Path)
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		status := recorder.statusCode()
+		span.SetAttribute("http.status_code", status)
+		if status >= http.StatusInternalServerError {
+			span.SetError(errors.New(http.StatusText(status)))
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/maidaneze/message-server/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//Exporter keeping the exported spans
+
+type recordingExporter struct {
+	spans []tracing.SpanData
+}
+
+func (e *recordingExporter) Export(ctx context.Context, spans []tracing.SpanData) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+//Serves the request through the traced and logged test routes, and returns the exported spans and the log entries
+
+func traceForTest(t *testing.T, req *http.Request, authenticate bool) (*httptest.ResponseRecorder, []tracing.SpanData, []map[string]interface{}) {
+	output := new(bytes.Buffer)
+	h, handler := loggedHandlerForTest(t, output)
+	exporter := &recordingExporter{}
+	h.Tracer = tracing.New(exporter, nil)
+
+	if authenticate {
+		token, err := h.Jwt.Issue(7, 1)
+		require.Nil(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp := httptest.NewRecorder()
+	h.traceRequests(handler).ServeHTTP(resp, req)
+	require.Nil(t, h.Tracer.Shutdown(context.Background()))
+	return resp, exporter.spans, readLogEntriesForTest(t, output)
+}
+
+func attributesForTest(span tracing.SpanData) map[string]interface{} {
+	attributes := map[string]interface{}{}
+	for _, attribute := range span.Attributes {
+		attributes[attribute.Key] = attribute.Value
+	}
+	return attributes
+}
+
+func TestTraceRequestsShouldContinueTheIncomingTrace(t *testing.T) {
+	req := httptest.NewRequest("GET", "/users/7", nil)
+	req.Header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, spans, entries := traceForTest(t, req, true)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "GET /users/{id}", span.Name)
+	assert.Equal(t, tracing.Server, span.Kind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.Context.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", span.Parent.String())
+	assert.Empty(t, span.Error)
+
+	attributes := attributesForTest(span)
+	assert.Equal(t, "GET", attributes["http.method"])
+	assert.Equal(t, "/users/7", attributes["http.target"])
+	assert.Equal(t, "/users/{id}", attributes["http.route"])
+	assert.Equal(t, http.StatusOK, attributes["http.status_code"])
+	assert.Equal(t, "7", attributes["enduser.id"])
+
+	require.Len(t, entries, 1)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", entries[0]["trace_id"])
+}
+
+func TestTraceRequestsShouldStartANewTrace(t *testing.T) {
+	cases := []struct {
+		name        string
+		traceparent string
+	}{
+		{"testTraceRequestsWithoutTraceparent", ""},
+		{"testTraceRequestsWithInvalidTraceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			req := httptest.NewRequest("GET", "/check", nil)
+			if c.traceparent != "" {
+				req.Header.Set(tracing.TraceparentHeader, c.traceparent)
+			}
+			_, spans, _ := traceForTest(tt, req, false)
+
+			require.Len(tt, spans, 1)
+			assert.Equal(tt, "GET /check", spans[0].Name)
+			assert.True(tt, spans[0].Context.TraceID.IsValid())
+			assert.False(tt, spans[0].Parent.IsValid())
+		})
+	}
+}
+
+func TestTraceRequestsShouldMarkTheFailedRequests(t *testing.T) {
+	resp, spans, _ := traceForTest(t, httptest.NewRequest("GET", "/failure", nil), true)
+	require.Equal(t, http.StatusInternalServerError, resp.Code)
+
+	require.Len(t, spans, 1)
+	assert.Equal(t, "database is locked", spans[0].Error)
+	assert.Equal(t, http.StatusInternalServerError, attributesForTest(spans[0])["http.status_code"])
+}
+
+func TestTraceRequestsShouldNotTraceWithoutTracer(t *testing.T) {
+	output := new(bytes.Buffer)
+	h, handler := loggedHandlerForTest(t, output)
+
+	resp := httptest.NewRecorder()
+	h.traceRequests(handler).ServeHTTP(resp, httptest.NewRequest("GET", "/check", nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	entries := readLogEntriesForTest(t, output)
+	require.Len(t, entries, 1)
+	assert.NotContains(t, entries[0], "trace_id")
+}
@@ -1,12 +1,111 @@
 package controllers
 
-import "net/http"
+import (
+	"context"
+	"github.com/maidaneze/message-server/logging"
+	"github.com/maidaneze/message-server/services/auth"
+	"github.com/maidaneze/message-server/services/notifications"
+	"github.com/maidaneze/message-server/services/passwords"
+	"net/http"
+)
 
 func (h Handler) Setup() *http.Server {
-	srv := &http.Server{Addr: ":8080"}
-	http.HandleFunc("/check", h.Check)
-	http.HandleFunc("/users", h.CreateUser)
-	http.HandleFunc("/login", h.LoginUser)
-	http.HandleFunc("/messages", h.MessagesHandler)
+	if h.Hub == nil {
+		h.Hub = notifications.NewHub()
+	}
+
+	if h.Sessions == (auth.SessionPolicy{}) {
+		h.Sessions = auth.DefaultSessionPolicy
+	}
+
+	if h.Passwords == (passwords.Policy{}) {
+		h.Passwords = passwords.DefaultPolicy
+	}
+
+	//The stored sessions must survive a restart, so the key can't be generated here
+	if h.Tokens.IsZero() {
+		panic("The handler needs a token hasher")
+	}
+
+	if h.Log == nil {
+		h.Log = logging.Default
+	}
+
+	if h.Addr == "" {
+		h.Addr = ":8080"
+	}
+
+	srv := &http.Server{Addr: h.Addr, Handler: h.Routes()}
+	srv.RegisterOnShutdown(h.Hub.Close)
 	return srv
 }
+
+//Stops the server gracefully, it stops accepting connections and waits for the requests in progress
+//The notifications hub is closed, so the streams end, the WebSockets with a going away close message, and the
+//requests waiting for new messages return
+//Then the spans of the finished requests are exported
+//Returns the context error if the requests or streams didn't finish or the spans weren't exported before the
+//context is done
+
+func (h Handler) Shutdown(ctx context.Context, srv *http.Server) error {
+	err := srv.Shutdown(ctx)
+	if err == nil && h.Hub != nil {
+		err = h.Hub.Drain(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	return h.Tracer.Shutdown(ctx)
+}
+
+//Returns the handler of the API, the router with the access log and the tracing
+
+func (h Handler) Routes() http.Handler {
+	return h.traceRequests(h.logRequests(h.router()))
+}
+
+//Returns the router of the API
+//Public routes are served to anyone, the rest are protected and require a valid token header
+//The handlers of the protected routes get the authenticated user from the request context
+//The metrics are public too, they're only served if the handler has metrics
+//The requests are bounded by the request timeout, except the streams and the long polls of new messages
+
+func (h Handler) router() *router {
+	rt := newRouter()
+
+	public := rt.group("", h.withDeadline)
+	public.handle("GET", "/check", h.Check)
+	public.handle("POST", "/check", h.Check)
+	public.handle("POST", "/users", h.CreateUser)
+	public.handle("POST", "/login", h.LoginUser)
+	public.handle("POST", "/refresh", h.RefreshToken)
+	if h.Metrics != nil {
+		public.handle("GET", "/metrics", h.Metrics.Handler().ServeHTTP)
+	}
+
+	protected := rt.group("", h.withDeadline, h.authenticated)
+	protected.handle("POST", "/logout", h.LogoutUser)
+	protected.handle("POST", "/logout/all", h.LogoutAllUserSessions)
+	protected.handle("GET", "/sessions", h.getSessions)
+	protected.handle("DELETE", "/sessions", h.deleteSession)
+	protected.handle("GET", "/conversations/{id}/messages", h.getConversationMessages)
+
+	messages := protected.group("/messages")
+	messages.handle("POST", "", h.insertMessage)
+	messages.handle("POST", "/read", h.readMessages)
+	messages.handle("GET", "/status", h.getMessageStatus)
+
+	groups := protected.group("/groups")
+	groups.handle("POST", "", h.createGroup)
+	groups.handle("GET", "/members", h.getGroupMembers)
+	groups.handle("POST", "/members", h.addGroupMember)
+	groups.handle("DELETE", "/members", h.removeGroupMember)
+	groups.handle("GET", "/messages", h.getGroupMessages)
+	groups.handle("POST", "/messages", h.insertGroupMessage)
+
+	longLived := rt.group("/messages", h.authenticated)
+	longLived.handle("GET", "", h.getMessage)
+	longLived.handle("GET", "/stream", h.StreamMessages)
+	longLived.handle("GET", "/events", h.StreamMessageEvents)
+	return rt
+}
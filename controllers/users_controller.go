@@ -2,12 +2,16 @@ package controllers
 
 import (
 	"bytes"
+	"github.com/maidaneze/message-server/apperrors"
+	"github.com/maidaneze/message-server/dao"
+	"github.com/maidaneze/message-server/logging"
 	"github.com/maidaneze/message-server/model"
 	"github.com/maidaneze/message-server/services/auth"
-	"github.com/maidaneze/message-server/services/passwords"
 	"github.com/maidaneze/message-server/services/users"
+	"github.com/maidaneze/message-server/utils"
 	"encoding/json"
 	"net/http"
+	"time"
 )
 
 //Creates a new user for the given username and password
@@ -28,51 +32,47 @@ func (h Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
 
 	var err error
 	if err = json.Unmarshal(buf.Bytes(), &usersRequestDTO); err != nil {
-		http.Error(w, "Invalid body", http.StatusBadRequest)
+		writeError(w, r, errInvalidBody)
 		return
 	}
 
 	//Validate dto
 
-	if !users.ValidateUsersRequestDTO(usersRequestDTO) {
-		http.Error(w, "Invalid body", http.StatusBadRequest)
-		return
-	}
-
-	//Check If user exists
-
-	var found bool
-	if _, found, err = h.Db.GetUser(usersRequestDTO.Username); found && err == nil {
-		http.Error(w, "Username already exists", http.StatusConflict)
-		return
-	}
-
-	if !found && err != nil {
-		http.Error(w, "Error generating user", http.StatusInternalServerError)
+	if fields := users.ValidateUsersRequestDTO(usersRequestDTO); len(fields) > 0 {
+		writeError(w, r, invalidBody(fields))
 		return
 	}
 
 	//Create User
 
 	var user model.User
-	if user, err = users.CreateUser(usersRequestDTO.Username, usersRequestDTO.Password); err != nil {
-		http.Error(w, "Error generating user", http.StatusInternalServerError)
+	if user, err = users.CreateUser(usersRequestDTO.Username, usersRequestDTO.Password, h.Passwords); err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error generating user"))
 		return
 	}
 
 	//Post user
 
-	user, err = h.Db.InsertUser(user)
+	user, err = h.Db.InsertUser(r.Context(), user)
+	if apperrors.KindOf(err) == apperrors.Conflict {
+		writeError(w, r, apperrors.Wrap(apperrors.Conflict, "username_taken", "Username already exists", err))
+		return
+	}
+
 	if err != nil {
-		http.Error(w, "Error generating user", http.StatusInternalServerError)
+		writeError(w, r, apperrors.WrapInternal(err, "Error generating user"))
 		return
 	}
 
 	if err := json.NewEncoder(w).Encode(model.UserResponseDTO{user.Userid}); err != nil {
-		http.Error(w, "Write error", http.StatusInternalServerError)
+		writeError(w, r, apperrors.WrapInternal(err, "Write error"))
 	}
 }
 
+//Login failure, it doesn't tell whether the user exists
+
+var errInvalidCredentials = apperrors.New(apperrors.Unauthorized, "invalid_credentials", "Invalid username password combination")
+
 //Generates a new access token for the given username and password
 //Returns 400 if the request dto is invalid
 //Returns 401 if the user doesn't exist or the username/password combination is invalid
@@ -90,55 +90,232 @@ func (h Handler) LoginUser(w http.ResponseWriter, r *http.Request) {
 	//Check body
 
 	if err := json.Unmarshal(buf.Bytes(), &usersRequestDTO); err != nil {
-		http.Error(w, "Invalid body", http.StatusBadRequest)
+		writeError(w, r, errInvalidBody)
 		return
 	}
 
 	//Validate dto
 
-	if !users.ValidateUsersRequestDTO(usersRequestDTO) {
-		http.Error(w, "Invalid body", http.StatusBadRequest)
+	if fields := users.ValidateUsersRequestDTO(usersRequestDTO); len(fields) > 0 {
+		writeError(w, r, invalidBody(fields))
 		return
 	}
 
 	//Get user
 
-	user, found, err := h.Db.GetUser(usersRequestDTO.Username)
+	user, found, err := h.Db.GetUser(r.Context(), usersRequestDTO.Username)
 
 	if err == nil && !found {
-		http.Error(w, "Invalid username password combination", http.StatusUnauthorized)
+		writeError(w, r, errInvalidCredentials)
 		return
 	}
 
 	if err != nil && !found {
-		http.Error(w, "Error logging in", http.StatusInternalServerError)
+		writeError(w, r, apperrors.WrapInternal(err, "Error logging in"))
 		return
 	}
 
 	//Validate user
 
-	if !passwords.ValidUserPassword(user, usersRequestDTO.Password) {
-		http.Error(w, "Invalid username password combination", http.StatusUnauthorized)
+	valid, rehash := h.Passwords.Verify(user, usersRequestDTO.Password)
+	if !valid {
+		writeError(w, r, errInvalidCredentials)
 		return
 	}
 
+	if rehash {
+		h.upgradePassword(r, user, usersRequestDTO.Password)
+	}
+
 	//Generate token
 
-	token, err := auth.GenerateToken()
+	token, err := h.Sessions.GenerateSessionToken(r.UserAgent())
+
+	if err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error logging in"))
+		return
+	}
+
+	session, err := h.Db.InsertToken(r.Context(), user.Userid, h.Tokens.HashToken(token), h.Sessions.MaxSessions)
+
+	if err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error logging in"))
+		return
+	}
+
+	h.writeLoginResponse(w, r, user.Userid, session.SessionId, token.Uuid, "Error logging in")
+}
+
+//Rehashes the password of the user with the current password policy, replacing a legacy or weaker hash
+//The old hash is still valid, so failing to upgrade it doesn't fail the login and it's retried on the next one
+
+func (h Handler) upgradePassword(r *http.Request, user model.User, password string) {
+	upgraded, err := h.Passwords.HashUserPassword(user, password)
+	if err == nil {
+		err = h.Db.UpdateUserPassword(r.Context(), upgraded)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("Unable to upgrade the password hash", logging.Fields{"error": err, "user_id": user.Userid})
+	}
+}
+
+//Issues a new access token for the session of the refresh token, replacing the refresh token by a new one
+//Reusing a replaced refresh token revokes the whole session, since it may have been stolen
+//Only available when the server issues signed access tokens
+//Returns 400 if the request dto is invalid
+//Returns 401 if the refresh token is invalid, expired or was already used
+//Returns 404 if the server doesn't issue signed access tokens
+//Returns 500 if the token couldn't be refreshed
+//Returns 200 otherwise the userid, the access token and the new refresh token
+
+func (h Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	if h.Jwt == nil {
+		writeError(w, r, errNotFound)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(r.Body)
+	defer r.Body.Close()
+
+	var refreshRequestDTO model.RefreshRequestDTO
+
+	//Unmarshall body
+
+	if err := json.Unmarshal(buf.Bytes(), &refreshRequestDTO); err != nil {
+		writeError(w, r, errInvalidBody)
+		return
+	}
+
+	//Validate dto
+
+	if refreshRequestDTO.Id <= 0 || refreshRequestDTO.RefreshToken == "" {
+		writeError(w, r, errInvalidBody)
+		return
+	}
+
+	//Rotate refresh token
 
+	newToken, err := auth.GenerateRefreshToken()
 	if err != nil {
-		http.Error(w, "Error logging in", http.StatusInternalServerError)
+		writeError(w, r, apperrors.WrapInternal(err, "Error refreshing token"))
+		return
+	}
+
+	session, result, err := h.Db.RotateRefreshToken(r.Context(), refreshRequestDTO.Id, h.Tokens.Hash(refreshRequestDTO.RefreshToken), h.Tokens.Hash(newToken), utils.UTCTimeMilliseconds())
+	if err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Error refreshing token"))
+		return
+	}
+
+	if result == dao.RefreshReused {
+		writeError(w, r, apperrors.New(apperrors.Unauthorized, "refresh_token_reused", "Refresh token reused, session revoked"))
+		return
+	}
+
+	if result != dao.RefreshRotated {
+		writeError(w, r, errUnauthorized)
+		return
+	}
+
+	h.writeLoginResponse(w, r, refreshRequestDTO.Id, session.SessionId, newToken, "Error refreshing token")
+}
+
+//Writes the tokens of the session, the token is the plain session token since only its hash is stored
+//With signed access tokens, the response has a new access token and the session token is the refresh token
+//Otherwise the session token is the access token
+
+func (h Handler) writeLoginResponse(w http.ResponseWriter, r *http.Request, userid int64, sessionId int64, token string, errorMessage string) {
+	response := model.LoginResponseDTO{Id: userid, Token: token}
+
+	if h.Jwt != nil {
+		accessToken, err := h.Jwt.Issue(userid, sessionId)
+		if err != nil {
+			writeError(w, r, apperrors.WrapInternal(err, errorMessage))
+			return
+		}
+		response.Token = accessToken
+		response.RefreshToken = token
+		response.ExpiresIn = int64(h.Jwt.TTL() / time.Second)
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, errorMessage))
+	}
+}
+
+//Revokes the access token used in the request
+//Returns 400 if the request dto is invalid
+//Returns 401 if the user isn't the authenticated user
+//Returns 500 if the token couldn't be revoked
+//Returns 200 otherwise with the number of revoked tokens
+
+func (h Handler) LogoutUser(w http.ResponseWriter, r *http.Request) {
+	h.logout(w, r, false)
+}
+
+//Revokes every access token of the user, closing all the sessions
+//Returns 400 if the request dto is invalid
+//Returns 401 if the user isn't the authenticated user
+//Returns 500 if the tokens couldn't be revoked
+//Returns 200 otherwise with the number of revoked tokens
+
+func (h Handler) LogoutAllUserSessions(w http.ResponseWriter, r *http.Request) {
+	h.logout(w, r, true)
+}
+
+//Revokes the access token used in the request, or every access token of the user if all is true
+//The id is optional, if present it must be the authenticated user
+
+func (h Handler) logout(w http.ResponseWriter, r *http.Request, all bool) {
+
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(r.Body)
+	defer r.Body.Close()
+
+	var logoutRequestDTO model.LogoutRequestDTO
+
+	//Unmarshall body
+
+	if err := json.Unmarshal(buf.Bytes(), &logoutRequestDTO); err != nil {
+		writeError(w, r, errInvalidBody)
 		return
 	}
 
-	err = h.Db.InsertToken(user.Userid, token)
+	//Validate dto
+
+	if logoutRequestDTO.Id < 0 {
+		writeError(w, r, errInvalidBody)
+		return
+	}
+
+	//The user is the authenticated user
+
+	identity, valid := authorize(w, r, logoutRequestDTO.Id)
+	if !valid {
+		return
+	}
+	logoutRequestDTO.Id = identity.Userid
+
+	//Revoke tokens
+
+	var revoked int64
+	var err error
+	if all {
+		revoked, err = h.Db.DeleteTokens(r.Context(), logoutRequestDTO.Id)
+	} else {
+		var deleted bool
+		if deleted, err = h.Db.DeleteSession(r.Context(), logoutRequestDTO.Id, identity.SessionId); deleted {
+			revoked = 1
+		}
+	}
 
 	if err != nil {
-		http.Error(w, "Error logging in", http.StatusInternalServerError)
+		writeError(w, r, apperrors.WrapInternal(err, "Error logging out"))
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(model.LoginResponseDTO{user.Userid, token.Uuid}); err != nil {
-		http.Error(w, "Error logging in", http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(model.LogoutResponseDTO{Revoked: revoked}); err != nil {
+		writeError(w, r, apperrors.WrapInternal(err, "Write error"))
 	}
 }
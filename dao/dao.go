@@ -1,49 +1,344 @@
 package dao
 
-import "github.com/maidaneze/message-server/model"
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/maidaneze/message-server/apperrors"
+	"github.com/maidaneze/message-server/model"
+	"github.com/maidaneze/message-server/tracing"
+	"github.com/maidaneze/message-server/utils"
+)
+
+//Supported database drivers
+
+const (
+	SqliteDriver   = "sqlite3"
+	PostgresDriver = "postgres"
+)
+
+//Retry policy of the database operations
+//Attempts is the maximum number of attempts of an operation
+//Interval is the delay before the first retry, doubled after every retry up to MaxInterval, and every delay is
+//randomized by up to a fifth of it
+//MaxElapsed is the time after which an operation isn't retried anymore, 0 doesn't limit it
+//OnAttempt is called after every attempt, like to record it in the metrics
+//Only the transient errors of the driver are retried, like a busy sqlite database
+
+type RetryOptions struct {
+	Attempts    int
+	Interval    time.Duration
+	MaxInterval time.Duration
+	MaxElapsed  time.Duration
+	OnAttempt   func(attempt utils.RetryAttempt)
+
+	//Tells the transient errors of the driver, nil retries every error
+	retryable func(err error) bool
+}
+
+//Default retry options, every operation is attempted up to 5 times, 10ms apart at first and 200ms at most, for
+//at most a second
+
+var DefaultRetryOptions = RetryOptions{Attempts: 5, Interval: time.Millisecond * 10, MaxInterval: time.Millisecond * 200, MaxElapsed: time.Second}
+
+//Growth and randomization of the delays between the attempts
+
+const (
+	retryMultiplier = 2
+	retryJitter     = 0.2
+)
+
+//Returns the retry policy of the options
+
+func (r RetryOptions) policy() utils.RetryPolicy {
+	return utils.RetryPolicy{
+		Attempts:    r.Attempts,
+		Interval:    r.Interval,
+		Multiplier:  retryMultiplier,
+		MaxInterval: r.MaxInterval,
+		Jitter:      retryJitter,
+		MaxElapsed:  r.MaxElapsed,
+		Retryable:   r.retryable,
+		OnAttempt:   r.OnAttempt,
+	}
+}
+
+//Runs the operation with a retry, every attempt is traced as a child of the span of the context
+//The operation gets the context of its attempt, its queries are canceled once the context is done and it isn't
+//retried anymore
+//Returns the error of the last attempt, or the context error if the context is done before the first one
+
+func (r RetryOptions) run(ctx context.Context, fn func(ctx context.Context) error) error {
+	attempt := 0
+	return r.policy().Run(ctx, func(ctx context.Context) error {
+		attempt++
+		ctx, span := tracing.Start(ctx, "db.attempt", tracing.Internal)
+		span.SetAttribute("db.attempt", attempt)
+		err := fn(ctx)
+		span.SetError(err)
+		span.End()
+		return err
+	})
+}
+
+//Code of the conflict errors, the operation would have duplicated a unique value like a username
+
+const ConflictCode = "already_exists"
+
+//Returns the conflict error caused by the unique constraint violation of the driver
+
+func conflictError(cause error) error {
+	return apperrors.Wrap(apperrors.Conflict, ConflictCode, "Already exists", cause)
+}
+
+//Error of the members added to a full group
+
+var ErrGroupFull = apperrors.NewInvalid("group_full", "Group is full")
+
+//Outcome of a refresh token rotation
+
+type RefreshResult int
+
+const (
+	//The refresh token was replaced by the new one
+
+	RefreshRotated RefreshResult = iota
+
+	//The refresh token doesn't exist or its session expired
+
+	RefreshNotFound
+
+	//The refresh token was already rotated, so its session was revoked
+
+	RefreshReused
+)
 
 type DB interface {
 
 	//Verifies the connection to the database
 	//Returns error in case of failiure and nil in case of success
 
-	CheckConnection() error
+	CheckConnection(ctx context.Context) error
+
+	//Closes the database, the queries in progress finish first
+	//Returns error in case of failiure and nil in case of success
+
+	Close() error
 
 	//Inserts a new user into the users tables
 	//Returns error in case of failiure and the inserted user in case of success
 
-	InsertUser(user model.User) (model.User, error)
+	InsertUser(ctx context.Context, user model.User) (model.User, error)
 
 	//Gets the given user data
 	//Returns true if an user was found, false otherwise
 	//Returns error in case of failiure and the user in case of success
 
-	GetUser(username string) (model.User, bool, error)
+	GetUser(ctx context.Context, username string) (model.User, bool, error)
 
 	//Checks if the corresponding user id is registered
 	//Returns true if an user was found, false otherwise
 	//Returns error in case of failiure
 
-	CheckUserExists(userid int64) (bool, error)
+	CheckUserExists(ctx context.Context, userid int64) (bool, error)
+
+	//Replaces the password and salt of the user with the ones of the given user
+	//Returns error in case of failiure
+
+	UpdateUserPassword(ctx context.Context, user model.User) error
+
+	//Inserts the given token into the tokens table for the requested userid, starting a new session
+	//Deletes the oldest sessions of the user so there are at most maxSessions, unless maxSessions is 0 or less
+	//Returns error in case of failiure and the inserted token with its session id in case of success
+
+	InsertToken(ctx context.Context, userid int64, token model.Token, maxSessions int) (model.Token, error)
+
+	//Replaces the refresh token of the user session with the new refresh token and updates its last used time
+	//The replaced token is kept until the session expires, so using it again is detected as a reuse and the whole
+	//session is revoked
+	//Returns RefreshRotated and the updated session if the token was rotated
+	//Returns RefreshNotFound if the token doesn't exist or its session expired
+	//Returns RefreshReused if the token was already rotated
+	//Returns error in case of failiure
+
+	RotateRefreshToken(ctx context.Context, userid int64, token string, newToken string, now int64) (model.Token, RefreshResult, error)
 
-	//Inserts the given token into the tokens table for the requested userid
+	//Recovers the access tokens for the requested userid ordered by session
 	//Returns error in case of failiure and nil in case of success
 
-	InsertToken(userid int64, token model.Token) error
+	GetTokens(ctx context.Context, userid int64) ([]model.Token, error)
+
+	//Recovers the given token with its user and session
+	//Returns true if the token was found, false otherwise
+	//Returns error in case of failiure
+
+	GetToken(ctx context.Context, token string) (model.Token, bool, error)
 
-	//Recovers the access tokens for the requested userid (at most 2)
+	//Updates the last used time of the given token of the user
 	//Returns error in case of failiure and nil in case of success
 
-	GetTokens(userid int64) ([]model.Token, error)
+	TouchToken(ctx context.Context, userid int64, token string, lastUsed int64) error
+
+	//Checks if the given session of the user exists, it's deleted once the session is revoked
+	//Returns true if the session was found, false otherwise
+	//Returns error in case of failiure
+
+	CheckSession(ctx context.Context, userid int64, sessionId int64) (bool, error)
+
+	//Deletes the given session of the user, revoking its token
+	//Returns true if the session was found, false otherwise
+	//Returns error in case of failiure
+
+	DeleteSession(ctx context.Context, userid int64, sessionId int64) (bool, error)
+
+	//Deletes all the tokens of the user, revoking every session
+	//Returns the number of deleted tokens
+	//Returns error in case of failiure
+
+	DeleteTokens(ctx context.Context, userid int64) (int64, error)
 
 	//Inserts the given message into the messages table
 	//Returns error in case of failiure and nil in case of success
 
-	InsertMessage(message model.MessageDTO) (model.MessageDTO, error)
+	InsertMessage(ctx context.Context, message model.MessageDTO) (model.MessageDTO, error)
 
 	//Recovers the messages for the requested recipient id that have a messageid greater than the given messageid
 	//Returns at most the given limit of messages
 	//Returns error in case of failiure and nil in case of success
 
-	GetMessages(recipientId int64, messageId int64, limit int64) ([]model.MessageDTO, error)
+	GetMessages(ctx context.Context, recipientId int64, messageId int64, limit int64) ([]model.MessageDTO, error)
+
+	//Recovers the messages exchanged between the two users, sent by either of them
+	//If before is false returns the messages with a messageid greater than the given messageid
+	//If before is true returns the messages with a messageid lower than the given messageid
+	//Returns at most the given limit of messages ordered by messageid, oldest first
+	//Returns error in case of failiure and nil in case of success
+
+	GetConversationMessages(ctx context.Context, userId int64, otherUserId int64, messageId int64, limit int64, before bool) ([]model.MessageDTO, error)
+
+	//Marks as delivered the messages of the recipient with a messageid between fromMessageId and toMessageId
+	//The messages that were already delivered keep their delivery time
+	//Returns error in case of failiure and nil in case of success
+
+	MarkMessagesDelivered(ctx context.Context, recipientId int64, fromMessageId int64, toMessageId int64, delivered time.Time) error
+
+	//Marks as read the messages of the recipient up to the given messageid
+	//The messages that were already read keep their read time
+	//Returns the number of messages marked as read
+	//Returns error in case of failiure
+
+	MarkMessagesRead(ctx context.Context, recipientId int64, messageId int64, read time.Time) (int64, error)
+
+	//Recovers the delivery status of the messages sent by the sender that have a messageid greater than the given messageid
+	//Returns at most the given limit of statuses
+	//Returns error in case of failiure and nil in case of success
+
+	GetSentMessageStatuses(ctx context.Context, senderId int64, messageId int64, limit int64) ([]model.MessageStatus, error)
+
+	//Inserts a new group and its initial members in a single transaction
+	//Returns error in case of failiure and the inserted group in case of success
+
+	InsertGroup(ctx context.Context, group model.Group, members []int64) (model.Group, error)
+
+	//Gets the given group data
+	//Returns true if a group was found, false otherwise
+	//Returns error in case of failiure
+
+	GetGroup(ctx context.Context, groupId int64) (model.Group, bool, error)
+
+	//Checks if the user is a member of the group
+	//Returns error in case of failiure
+
+	CheckGroupMember(ctx context.Context, groupId int64, userid int64) (bool, error)
+
+	//Adds the user to the group, adding an existing member has no effect
+	//The group can have at most maxMembers members, checked in the same transaction as the insert
+	//Returns ErrGroupFull if the group already has maxMembers members
+	//Returns error in case of failiure and nil in case of success
+
+	InsertGroupMember(ctx context.Context, groupId int64, userid int64, maxMembers int) error
+
+	//Removes the user from the group
+	//Returns true if the user was a member, false otherwise
+	//Returns error in case of failiure
+
+	DeleteGroupMember(ctx context.Context, groupId int64, userid int64) (bool, error)
+
+	//Recovers the user ids of the group members
+	//Returns error in case of failiure and nil in case of success
+
+	GetGroupMembers(ctx context.Context, groupId int64) ([]int64, error)
+
+	//Inserts the given message into the group_messages table
+	//Returns error in case of failiure and the inserted message in case of success
+
+	InsertGroupMessage(ctx context.Context, message model.GroupMessageDTO) (model.GroupMessageDTO, error)
+
+	//Recovers the messages of the group that have a messageid greater than the given messageid
+	//Returns at most the given limit of messages
+	//Returns error in case of failiure and nil in case of success
+
+	GetGroupMessages(ctx context.Context, groupId int64, messageId int64, limit int64) ([]model.GroupMessageDTO, error)
+}
+
+//Manages the schema migrations of a database
+
+type Migrator interface {
+
+	//Applies all the pending migrations
+	//Returns error in case of failiure and nil in case of success
+
+	MigrateUp() error
+
+	//Reverts the given number of applied migrations, starting from the latest one
+	//Returns error in case of failiure and nil in case of success
+
+	MigrateDown(steps int) error
+
+	//Returns the status of every known migration
+	//Returns error in case of failiure
+
+	MigrationStatus() ([]MigrationStatus, error)
+}
+
+//Opens the database for the given driver and data source and applies the pending migrations
+//The data source is the file path for sqlite3 and the connection string for postgres
+//The database operations are retried with the given retry options
+//Returns error in case of failiure
+
+func OpenDatabase(driver string, dataSource string, retry RetryOptions) (DB, error) {
+	switch driver {
+	case SqliteDriver:
+		sqlite, err := OpenSqlite3Database(dataSource)
+		return sqlite.WithRetry(retry), err
+	case PostgresDriver:
+		postgres, err := OpenPostgresDatabase(dataSource)
+		return postgres.WithRetry(retry), err
+	default:
+		return nil, fmt.Errorf("Unsupported database driver %q", driver)
+	}
+}
+
+//Opens the database for the given driver and data source without applying any migration
+//Returns error in case of failiure
+
+func ConnectDatabase(driver string, dataSource string) (Migrator, error) {
+	switch driver {
+	case SqliteDriver:
+		return ConnectSqlite3Database(dataSource)
+	case PostgresDriver:
+		return ConnectPostgresDatabase(dataSource)
+	default:
+		return nil, fmt.Errorf("Unsupported database driver %q", driver)
+	}
+}
+
+//Reverses the order of the messages in place
+//Used to return the messages recovered newest first in ascending order
+
+func reverseMessages(messages []model.MessageDTO) {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
 }
@@ -0,0 +1,184 @@
+package dao
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/maidaneze/message-server/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testInsertGroupShouldSaveGroupAndMembers(t *testing.T) {
+	RefreshSchema(testDatabase)
+
+	cases := []struct {
+		name            string
+		owner           int64
+		members         []int64
+		expectedGroupId int64
+	}{
+		{"insertGroupWithOnlyOwner", 1, []int64{1}, 1},
+		{"insertGroupWithMembers", 2, []int64{2, 3, 4}, 2},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			group := model.Group{Name: c.name, OwnerId: c.owner, Created: time.Now()}
+			inserted, err := testDatabase.InsertGroup(context.Background(), group, c.members)
+			require.Nil(tt, err)
+			assert.Equal(tt, c.expectedGroupId, inserted.GroupId)
+
+			getGroup, found, err := testDatabase.GetGroup(context.Background(), inserted.GroupId)
+			assert.Nil(tt, err)
+			assert.True(tt, found)
+			assert.Equal(tt, c.name, getGroup.Name)
+			assert.Equal(tt, c.owner, getGroup.OwnerId)
+
+			members, err := testDatabase.GetGroupMembers(context.Background(), inserted.GroupId)
+			assert.Nil(tt, err)
+			assert.Equal(tt, c.members, members)
+		})
+	}
+}
+
+func testGroupMembersShouldNotExceedTheLimit(t *testing.T) {
+	RefreshSchema(testDatabase)
+
+	group, err := testDatabase.InsertGroup(context.Background(), model.Group{Name: "group", OwnerId: 1, Created: time.Now()}, []int64{1})
+	require.Nil(t, err)
+
+	//Only 2 of the concurrent inserts fit in the group
+	errs := make(chan error)
+	for member := int64(2); member < 12; member++ {
+		go func(member int64) {
+			errs <- testDatabase.InsertGroupMember(context.Background(), group.GroupId, member, 3)
+		}(member)
+	}
+
+	added := 0
+	for i := 0; i < 10; i++ {
+		err := <-errs
+		if err == nil {
+			added++
+		} else {
+			assert.Equal(t, ErrGroupFull, err)
+		}
+	}
+	assert.Equal(t, 2, added)
+
+	members, err := testDatabase.GetGroupMembers(context.Background(), group.GroupId)
+	assert.Nil(t, err)
+	assert.Len(t, members, 3)
+
+	//Adding an existing member to a full group has no effect
+	assert.Nil(t, testDatabase.InsertGroupMember(context.Background(), group.GroupId, 1, 3))
+}
+
+func testGetGroupNotStoredShouldReturnFalseAndNoError(t *testing.T) {
+	RefreshSchema(testDatabase)
+
+	_, found, err := testDatabase.GetGroup(context.Background(), 1)
+	assert.Nil(t, err)
+	assert.False(t, found)
+}
+
+func testGroupMembersShouldBeAddedAndRemoved(t *testing.T) {
+	RefreshSchema(testDatabase)
+
+	group, err := testDatabase.InsertGroup(context.Background(), model.Group{Name: "group", OwnerId: 1, Created: time.Now()}, []int64{1})
+	require.Nil(t, err)
+
+	isMember, err := testDatabase.CheckGroupMember(context.Background(), group.GroupId, 2)
+	assert.Nil(t, err)
+	assert.False(t, isMember)
+
+	//Adding a member twice should have no effect
+	assert.Nil(t, testDatabase.InsertGroupMember(context.Background(), group.GroupId, 2, 2))
+	assert.Nil(t, testDatabase.InsertGroupMember(context.Background(), group.GroupId, 2, 2))
+
+	isMember, err = testDatabase.CheckGroupMember(context.Background(), group.GroupId, 2)
+	assert.Nil(t, err)
+	assert.True(t, isMember)
+
+	members, err := testDatabase.GetGroupMembers(context.Background(), group.GroupId)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{1, 2}, members)
+
+	deleted, err := testDatabase.DeleteGroupMember(context.Background(), group.GroupId, 2)
+	assert.Nil(t, err)
+	assert.True(t, deleted)
+
+	deleted, err = testDatabase.DeleteGroupMember(context.Background(), group.GroupId, 2)
+	assert.Nil(t, err)
+	assert.False(t, deleted)
+
+	isMember, err = testDatabase.CheckGroupMember(context.Background(), group.GroupId, 2)
+	assert.Nil(t, err)
+	assert.False(t, isMember)
+}
+
+func testGroupMessagesShouldBeSavedPerGroup(t *testing.T) {
+	RefreshSchema(testDatabase)
+
+	for i := 0; i < 6; i++ {
+		message := model.GroupMessageDTO{GroupId: int64(i%2 + 1), SenderId: 1, Timestamp: time.Now(), Type: "text", Text: "text"}
+		inserted, err := testDatabase.InsertGroupMessage(context.Background(), message)
+		require.Nil(t, err)
+		assert.Equal(t, int64(i+1), inserted.MessageId)
+	}
+
+	cases := []struct {
+		name        string
+		groupId     int64
+		start       int64
+		limit       int64
+		expectedIds []int64
+	}{
+		{"getGroupMessagesFromFirstGroup", 1, 1, 10, []int64{1, 3, 5}},
+		{"getGroupMessagesFromSecondGroup", 2, 1, 10, []int64{2, 4, 6}},
+		{"getGroupMessagesStartingFromMessageId", 1, 2, 10, []int64{3, 5}},
+		{"getGroupMessagesWithLimit", 2, 1, 2, []int64{2, 4}},
+		{"getGroupMessagesFromEmptyGroup", 3, 1, 10, []int64{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			messages, err := testDatabase.GetGroupMessages(context.Background(), c.groupId, c.start, c.limit)
+			assert.Nil(tt, err)
+			ids := make([]int64, 0)
+			for _, message := range messages {
+				assert.Equal(tt, c.groupId, message.GroupId)
+				ids = append(ids, message.MessageId)
+			}
+			assert.Equal(tt, c.expectedIds, ids)
+		})
+	}
+}
+
+func testInsertGroupMessageShouldFailOnInvalidType(t *testing.T) {
+	RefreshSchema(testDatabase)
+
+	message := model.GroupMessageDTO{GroupId: 1, SenderId: 1, Timestamp: time.Now(), Type: "invalid"}
+	_, err := testDatabase.InsertGroupMessage(context.Background(), message)
+	assert.NotNil(t, err)
+}
+
+func testGroupsShouldFailWithClosedConnection(t *testing.T) {
+	_, err := testDatabase.InsertGroup(context.Background(), model.Group{Name: "group", OwnerId: 1, Created: time.Now()}, []int64{1})
+	assert.NotNil(t, err)
+	_, _, err = testDatabase.GetGroup(context.Background(), 1)
+	assert.NotNil(t, err)
+	_, err = testDatabase.CheckGroupMember(context.Background(), 1, 1)
+	assert.NotNil(t, err)
+	assert.NotNil(t, testDatabase.InsertGroupMember(context.Background(), 1, 1, 2))
+	_, err = testDatabase.DeleteGroupMember(context.Background(), 1, 1)
+	assert.NotNil(t, err)
+	_, err = testDatabase.GetGroupMembers(context.Background(), 1)
+	assert.NotNil(t, err)
+	_, err = testDatabase.InsertGroupMessage(context.Background(), model.GroupMessageDTO{GroupId: 1, Type: "text"})
+	assert.NotNil(t, err)
+	_, err = testDatabase.GetGroupMessages(context.Background(), 1, 1, 1)
+	assert.NotNil(t, err)
+}
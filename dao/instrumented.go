@@ -0,0 +1,281 @@
+package dao
+
+import (
+	"context"
+	"time"
+
+	"github.com/maidaneze/message-server/model"
+	"github.com/maidaneze/message-server/tracing"
+)
+
+//Wraps every operation of a database, named after its method
+//The interceptor runs the operation calling next, with the given context, and returns its error
+
+type Interceptor func(ctx context.Context, operation string, next func(ctx context.Context) error) error
+
+//Observes every finished operation of a database with the name of its method, its duration and its error
+
+type Observer func(operation string, duration time.Duration, err error)
+
+//Database decorator running every operation through the interceptor
+
+type interceptedDB struct {
+	db        DB
+	intercept Interceptor
+}
+
+//Returns the database running its operations through the interceptor
+
+func Intercept(db DB, interceptor Interceptor) DB {
+	return interceptedDB{db: db, intercept: interceptor}
+}
+
+//Returns the database reporting its operations to the observer
+//The duration of an operation includes its retries
+
+func Instrument(db DB, observe Observer) DB {
+	return Intercept(db, func(ctx context.Context, operation string, next func(ctx context.Context) error) error {
+		start := time.Now()
+		err := next(ctx)
+		observe(operation, time.Since(start), err)
+		return err
+	})
+}
+
+//Returns the database tracing its operations as children of the span of their context, the driver is the db.system
+//of the spans
+//The operations without a span in their context aren't traced
+
+func Trace(db DB, driver string) DB {
+	return Intercept(db, func(ctx context.Context, operation string, next func(ctx context.Context) error) error {
+		ctx, span := tracing.Start(ctx, "db."+operation, tracing.Client)
+		span.SetAttribute("db.system", driver)
+		span.SetAttribute("db.operation", operation)
+		err := next(ctx)
+		span.SetError(err)
+		span.End()
+		return err
+	})
+}
+
+func (i interceptedDB) Close() error {
+	return i.intercept(context.Background(), "Close", func(context.Context) error {
+		return i.db.Close()
+	})
+}
+
+func (i interceptedDB) CheckConnection(ctx context.Context) (err error) {
+	err = i.intercept(ctx, "CheckConnection", func(ctx context.Context) error {
+		err = i.db.CheckConnection(ctx)
+		return err
+	})
+	return err
+}
+
+func (i interceptedDB) InsertUser(ctx context.Context, user model.User) (inserted model.User, err error) {
+	err = i.intercept(ctx, "InsertUser", func(ctx context.Context) error {
+		inserted, err = i.db.InsertUser(ctx, user)
+		return err
+	})
+	return inserted, err
+}
+
+func (i interceptedDB) GetUser(ctx context.Context, username string) (user model.User, found bool, err error) {
+	err = i.intercept(ctx, "GetUser", func(ctx context.Context) error {
+		user, found, err = i.db.GetUser(ctx, username)
+		return err
+	})
+	return user, found, err
+}
+
+func (i interceptedDB) CheckUserExists(ctx context.Context, userid int64) (found bool, err error) {
+	err = i.intercept(ctx, "CheckUserExists", func(ctx context.Context) error {
+		found, err = i.db.CheckUserExists(ctx, userid)
+		return err
+	})
+	return found, err
+}
+
+func (i interceptedDB) UpdateUserPassword(ctx context.Context, user model.User) (err error) {
+	err = i.intercept(ctx, "UpdateUserPassword", func(ctx context.Context) error {
+		err = i.db.UpdateUserPassword(ctx, user)
+		return err
+	})
+	return err
+}
+
+func (i interceptedDB) InsertToken(ctx context.Context, userid int64, token model.Token, maxSessions int) (session model.Token, err error) {
+	err = i.intercept(ctx, "InsertToken", func(ctx context.Context) error {
+		session, err = i.db.InsertToken(ctx, userid, token, maxSessions)
+		return err
+	})
+	return session, err
+}
+
+func (i interceptedDB) RotateRefreshToken(ctx context.Context, userid int64, token string, newToken string, now int64) (session model.Token, result RefreshResult, err error) {
+	err = i.intercept(ctx, "RotateRefreshToken", func(ctx context.Context) error {
+		session, result, err = i.db.RotateRefreshToken(ctx, userid, token, newToken, now)
+		return err
+	})
+	return session, result, err
+}
+
+func (i interceptedDB) GetTokens(ctx context.Context, userid int64) (tokens []model.Token, err error) {
+	err = i.intercept(ctx, "GetTokens", func(ctx context.Context) error {
+		tokens, err = i.db.GetTokens(ctx, userid)
+		return err
+	})
+	return tokens, err
+}
+
+func (i interceptedDB) GetToken(ctx context.Context, token string) (session model.Token, found bool, err error) {
+	err = i.intercept(ctx, "GetToken", func(ctx context.Context) error {
+		session, found, err = i.db.GetToken(ctx, token)
+		return err
+	})
+	return session, found, err
+}
+
+func (i interceptedDB) TouchToken(ctx context.Context, userid int64, token string, lastUsed int64) (err error) {
+	err = i.intercept(ctx, "TouchToken", func(ctx context.Context) error {
+		err = i.db.TouchToken(ctx, userid, token, lastUsed)
+		return err
+	})
+	return err
+}
+
+func (i interceptedDB) CheckSession(ctx context.Context, userid int64, sessionId int64) (found bool, err error) {
+	err = i.intercept(ctx, "CheckSession", func(ctx context.Context) error {
+		found, err = i.db.CheckSession(ctx, userid, sessionId)
+		return err
+	})
+	return found, err
+}
+
+func (i interceptedDB) DeleteSession(ctx context.Context, userid int64, sessionId int64) (found bool, err error) {
+	err = i.intercept(ctx, "DeleteSession", func(ctx context.Context) error {
+		found, err = i.db.DeleteSession(ctx, userid, sessionId)
+		return err
+	})
+	return found, err
+}
+
+func (i interceptedDB) DeleteTokens(ctx context.Context, userid int64) (count int64, err error) {
+	err = i.intercept(ctx, "DeleteTokens", func(ctx context.Context) error {
+		count, err = i.db.DeleteTokens(ctx, userid)
+		return err
+	})
+	return count, err
+}
+
+func (i interceptedDB) InsertMessage(ctx context.Context, message model.MessageDTO) (inserted model.MessageDTO, err error) {
+	err = i.intercept(ctx, "InsertMessage", func(ctx context.Context) error {
+		inserted, err = i.db.InsertMessage(ctx, message)
+		return err
+	})
+	return inserted, err
+}
+
+func (i interceptedDB) GetMessages(ctx context.Context, recipientId int64, messageId int64, limit int64) (messages []model.MessageDTO, err error) {
+	err = i.intercept(ctx, "GetMessages", func(ctx context.Context) error {
+		messages, err = i.db.GetMessages(ctx, recipientId, messageId, limit)
+		return err
+	})
+	return messages, err
+}
+
+func (i interceptedDB) GetConversationMessages(ctx context.Context, userId int64, otherUserId int64, messageId int64, limit int64, before bool) (messages []model.MessageDTO, err error) {
+	err = i.intercept(ctx, "GetConversationMessages", func(ctx context.Context) error {
+		messages, err = i.db.GetConversationMessages(ctx, userId, otherUserId, messageId, limit, before)
+		return err
+	})
+	return messages, err
+}
+
+func (i interceptedDB) MarkMessagesDelivered(ctx context.Context, recipientId int64, fromMessageId int64, toMessageId int64, delivered time.Time) (err error) {
+	err = i.intercept(ctx, "MarkMessagesDelivered", func(ctx context.Context) error {
+		err = i.db.MarkMessagesDelivered(ctx, recipientId, fromMessageId, toMessageId, delivered)
+		return err
+	})
+	return err
+}
+
+func (i interceptedDB) MarkMessagesRead(ctx context.Context, recipientId int64, messageId int64, read time.Time) (count int64, err error) {
+	err = i.intercept(ctx, "MarkMessagesRead", func(ctx context.Context) error {
+		count, err = i.db.MarkMessagesRead(ctx, recipientId, messageId, read)
+		return err
+	})
+	return count, err
+}
+
+func (i interceptedDB) GetSentMessageStatuses(ctx context.Context, senderId int64, messageId int64, limit int64) (statuses []model.MessageStatus, err error) {
+	err = i.intercept(ctx, "GetSentMessageStatuses", func(ctx context.Context) error {
+		statuses, err = i.db.GetSentMessageStatuses(ctx, senderId, messageId, limit)
+		return err
+	})
+	return statuses, err
+}
+
+func (i interceptedDB) InsertGroup(ctx context.Context, group model.Group, members []int64) (inserted model.Group, err error) {
+	err = i.intercept(ctx, "InsertGroup", func(ctx context.Context) error {
+		inserted, err = i.db.InsertGroup(ctx, group, members)
+		return err
+	})
+	return inserted, err
+}
+
+func (i interceptedDB) GetGroup(ctx context.Context, groupId int64) (group model.Group, found bool, err error) {
+	err = i.intercept(ctx, "GetGroup", func(ctx context.Context) error {
+		group, found, err = i.db.GetGroup(ctx, groupId)
+		return err
+	})
+	return group, found, err
+}
+
+func (i interceptedDB) CheckGroupMember(ctx context.Context, groupId int64, userid int64) (found bool, err error) {
+	err = i.intercept(ctx, "CheckGroupMember", func(ctx context.Context) error {
+		found, err = i.db.CheckGroupMember(ctx, groupId, userid)
+		return err
+	})
+	return found, err
+}
+
+func (i interceptedDB) InsertGroupMember(ctx context.Context, groupId int64, userid int64, maxMembers int) (err error) {
+	err = i.intercept(ctx, "InsertGroupMember", func(ctx context.Context) error {
+		err = i.db.InsertGroupMember(ctx, groupId, userid, maxMembers)
+		return err
+	})
+	return err
+}
+
+func (i interceptedDB) DeleteGroupMember(ctx context.Context, groupId int64, userid int64) (found bool, err error) {
+	err = i.intercept(ctx, "DeleteGroupMember", func(ctx context.Context) error {
+		found, err = i.db.DeleteGroupMember(ctx, groupId, userid)
+		return err
+	})
+	return found, err
+}
+
+func (i interceptedDB) GetGroupMembers(ctx context.Context, groupId int64) (members []int64, err error) {
+	err = i.intercept(ctx, "GetGroupMembers", func(ctx context.Context) error {
+		members, err = i.db.GetGroupMembers(ctx, groupId)
+		return err
+	})
+	return members, err
+}
+
+func (i interceptedDB) InsertGroupMessage(ctx context.Context, message model.GroupMessageDTO) (inserted model.GroupMessageDTO, err error) {
+	err = i.intercept(ctx, "InsertGroupMessage", func(ctx context.Context) error {
+		inserted, err = i.db.InsertGroupMessage(ctx, message)
+		return err
+	})
+	return inserted, err
+}
+
+func (i interceptedDB) GetGroupMessages(ctx context.Context, groupId int64, messageId int64, limit int64) (messages []model.GroupMessageDTO, err error) {
+	err = i.intercept(ctx, "GetGroupMessages", func(ctx context.Context) error {
+		messages, err = i.db.GetGroupMessages(ctx, groupId, messageId, limit)
+		return err
+	})
+	return messages, err
+}
@@ -0,0 +1,106 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/maidaneze/message-server/model"
+	"github.com/maidaneze/message-server/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//Database failing to get users, the rest of its methods aren't used by the tests
+//Its operations are retried like the ones of the real databases
+
+type failingUsersDB struct {
+	DB
+	retry RetryOptions
+}
+
+func (f failingUsersDB) GetUser(ctx context.Context, username string) (model.User, bool, error) {
+	return model.User{}, false, f.retry.run(ctx, func(context.Context) error {
+		return errors.New("database is locked")
+	})
+}
+
+func (f failingUsersDB) CheckUserExists(ctx context.Context, userid int64) (bool, error) {
+	return true, f.retry.run(ctx, func(context.Context) error { return nil })
+}
+
+type observationForTest struct {
+	operation string
+	err       error
+}
+
+func TestInstrumentShouldObserveTheOperations(t *testing.T) {
+	var observed []observationForTest
+	db := Instrument(failingUsersDB{retry: RetryOptions{Attempts: 1}}, func(operation string, duration time.Duration, err error) {
+		assert.True(t, duration >= 0)
+		observed = append(observed, observationForTest{operation, err})
+	})
+
+	exists, err := db.CheckUserExists(context.Background(), 1)
+	assert.Nil(t, err)
+	assert.True(t, exists)
+
+	_, _, err = db.GetUser(context.Background(), "user1")
+	assert.NotNil(t, err)
+
+	assert.Equal(t, []observationForTest{{"CheckUserExists", nil}, {"GetUser", err}}, observed)
+}
+
+//Exporter keeping the exported spans
+
+type recordingExporter struct {
+	spans chan tracing.SpanData
+}
+
+func (e recordingExporter) Export(ctx context.Context, spans []tracing.SpanData) error {
+	for _, span := range spans {
+		e.spans <- span
+	}
+	return nil
+}
+
+func TestTraceShouldTraceTheOperationsAndAttempts(t *testing.T) {
+	exporter := recordingExporter{make(chan tracing.SpanData, 10)}
+	tracer := tracing.New(exporter, nil)
+	db := Trace(failingUsersDB{retry: RetryOptions{Attempts: 2, Interval: time.Millisecond}}, SqliteDriver)
+
+	ctx, request := tracer.Start(context.Background(), "request", tracing.Server)
+	_, _, err := db.GetUser(ctx, "user1")
+	assert.NotNil(t, err)
+	request.End()
+	require.Nil(t, tracer.Shutdown(context.Background()))
+	close(exporter.spans)
+
+	spans := map[string][]tracing.SpanData{}
+	for span := range exporter.spans {
+		spans[span.Name] = append(spans[span.Name], span)
+	}
+
+	require.Len(t, spans["db.GetUser"], 1)
+	operation := spans["db.GetUser"][0]
+	assert.Equal(t, request.Context().TraceID, operation.Context.TraceID)
+	assert.Equal(t, request.Context().SpanID, operation.Parent)
+	assert.Equal(t, tracing.Client, operation.Kind)
+	assert.Equal(t, "database is locked", operation.Error)
+	assert.Contains(t, operation.Attributes, tracing.Attribute{Key: "db.system", Value: SqliteDriver})
+
+	require.Len(t, spans["db.attempt"], 2)
+	for i, attempt := range spans["db.attempt"] {
+		assert.Equal(t, operation.Context.SpanID, attempt.Parent)
+		assert.Equal(t, "database is locked", attempt.Error)
+		assert.Contains(t, attempt.Attributes, tracing.Attribute{Key: "db.attempt", Value: i + 1})
+	}
+}
+
+func TestTraceShouldIgnoreUntracedOperations(t *testing.T) {
+	db := Trace(failingUsersDB{retry: RetryOptions{Attempts: 1}}, SqliteDriver)
+	exists, err := db.CheckUserExists(context.Background(), 1)
+	assert.Nil(t, err)
+	assert.True(t, exists)
+}
@@ -0,0 +1,356 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/maidaneze/message-server/utils"
+)
+
+//Returned when the database was migrated by a newer version of the server
+//The server refuses to work with a schema it doesn't know
+
+var ErrSchemaTooNew = errors.New("Database schema is newer than the latest known migration")
+
+//A single schema change
+//Versions must be consecutive starting from 1
+//The up statement applies the change and the down statement reverts it
+
+type migration struct {
+	version     int64
+	description string
+	up          string
+	down        string
+}
+
+//Status of a known migration in a database
+
+type MigrationStatus struct {
+	Version     int64
+	Description string
+	Applied     bool
+	AppliedAt   int64
+}
+
+//Ordered list of the sqlite migrations
+//New migrations must be appended at the end, applied migrations must never be edited
+
+var sqliteMigrations = []migration{
+	{
+		version:     1,
+		description: "Create users, tokens and messages tables",
+		up:          usersSchema + tokensSchema + messagesSchema,
+		down:        dropMessagesSchema + dropTokensSchema + dropUsersSchema,
+	},
+	{
+		version:     2,
+		description: "Create groups tables",
+		up:          groupsSchema,
+		down:        dropGroupsSchema,
+	},
+	{
+		version:     3,
+		description: "Create conversation index on messages",
+		up:          conversationIndexSchema,
+		down:        dropConversationIndexSchema,
+	},
+	{
+		version:     4,
+		description: "Add delivered and read status to messages",
+		up:          messageStatusSchema,
+		down:        dropMessageStatusSchema,
+	},
+	{
+		version:     5,
+		description: "Add sessions to tokens",
+		up:          sessionsSchema,
+		down:        dropSessionsSchema,
+	},
+	{
+		version:     6,
+		description: "Create rotated refresh tokens table",
+		up:          rotatedTokensSchema,
+		down:        dropRotatedTokensSchema,
+	},
+	{
+		version:     7,
+		description: "Revoke plain text tokens, tokens are stored hashed",
+		up:          hashTokensSchema,
+		down:        dropHashTokensSchema,
+	},
+	{
+		version:     8,
+		description: "Create token index on tokens",
+		up:          tokensIndexSchema,
+		down:        dropTokensIndexSchema,
+	},
+}
+
+//Ordered list of the postgres migrations
+//Every sqlite migration must have its postgres counterpart with the same version
+
+var postgresMigrations = []migration{
+	{
+		version:     1,
+		description: "Create users, tokens and messages tables",
+		up:          postgresUsersSchema + postgresTokensSchema + postgresMessagesSchema,
+		down:        dropMessagesSchema + postgresDropTokensSchema + dropUsersSchema,
+	},
+	{
+		version:     2,
+		description: "Create groups tables",
+		up:          postgresGroupsSchema,
+		down:        dropGroupsSchema,
+	},
+	{
+		version:     3,
+		description: "Create conversation index on messages",
+		up:          conversationIndexSchema,
+		down:        dropConversationIndexSchema,
+	},
+	{
+		version:     4,
+		description: "Add delivered and read status to messages",
+		up:          postgresMessageStatusSchema,
+		down:        postgresDropMessageStatusSchema,
+	},
+	{
+		version:     5,
+		description: "Add sessions to tokens",
+		up:          postgresSessionsSchema,
+		down:        postgresDropSessionsSchema,
+	},
+	{
+		version:     6,
+		description: "Create rotated refresh tokens table",
+		up:          postgresRotatedTokensSchema,
+		down:        dropRotatedTokensSchema,
+	},
+	{
+		version:     7,
+		description: "Revoke plain text tokens, tokens are stored hashed",
+		up:          hashTokensSchema,
+		down:        dropHashTokensSchema,
+	},
+	{
+		version:     8,
+		description: "Create token index on tokens",
+		up:          tokensIndexSchema,
+		down:        dropTokensIndexSchema,
+	},
+}
+
+//Dialect specific statements used to keep track of the applied migrations
+
+type migrationQueries struct {
+	checkSchemaVersionTable string
+	checkLegacyUsersTable   string
+	getSchemaVersion        string
+	getAppliedMigrations    string
+	insertSchemaVersion     string
+	deleteSchemaVersion     string
+	schemaVersionSchema     string
+}
+
+var sqliteMigrationQueries = migrationQueries{
+	checkSchemaVersionTable: checkSchemaVersionTableQuery,
+	checkLegacyUsersTable:   checkLegacyUsersTableQuery,
+	getSchemaVersion:        getSchemaVersionQuery,
+	getAppliedMigrations:    getAppliedMigrationsQuery,
+	insertSchemaVersion:     insertSchemaVersionQuery,
+	deleteSchemaVersion:     deleteSchemaVersionQuery,
+	schemaVersionSchema:     schemaVersionSchema,
+}
+
+var postgresMigrationQueries = migrationQueries{
+	checkSchemaVersionTable: postgresCheckSchemaVersionTableQuery,
+	checkLegacyUsersTable:   postgresCheckLegacyUsersTableQuery,
+	getSchemaVersion:        getSchemaVersionQuery,
+	getAppliedMigrations:    getAppliedMigrationsQuery,
+	insertSchemaVersion:     postgresInsertSchemaVersionQuery,
+	deleteSchemaVersion:     postgresDeleteSchemaVersionQuery,
+	schemaVersionSchema:     postgresSchemaVersionSchema,
+}
+
+//Applies migrations to a database keeping track of the applied versions in the schema_version table
+
+type migrator struct {
+	db         *sql.DB
+	migrations []migration
+	queries    migrationQueries
+}
+
+//Creates the schema_version table if it doesn't exist
+//Databases created before the migrations existed already have the tables of the first migration, so it is
+//registered as applied instead of being executed again
+//Returns error in case of failiure and nil in case of success
+
+func (m migrator) init() error {
+	var count int
+	if err := m.db.QueryRow(m.queries.checkSchemaVersionTable).Scan(&count); err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	if _, err := m.db.Exec(m.queries.schemaVersionSchema); err != nil {
+		return err
+	}
+
+	if err := m.db.QueryRow(m.queries.checkLegacyUsersTable).Scan(&count); err != nil {
+		return err
+	}
+
+	if count > 0 {
+		_, err := m.db.Exec(m.queries.insertSchemaVersion, m.migrations[0].version, m.migrations[0].description, utils.UTCTimeMilliseconds())
+		return err
+	}
+	return nil
+}
+
+//Returns the latest applied version, 0 if no migration was applied
+//Returns error in case of failiure
+
+func (m migrator) currentVersion() (int64, error) {
+	var version int64
+	if err := m.db.QueryRow(m.queries.getSchemaVersion).Scan(&version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+//Returns the latest known version
+
+func (m migrator) latestVersion() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].version
+}
+
+//Applies all the pending migrations in order, each one in its own transaction
+//Returns ErrSchemaTooNew if the database has a version greater than the latest known migration
+//Returns error in case of failiure and nil in case of success
+
+func (m migrator) up() error {
+	if err := m.init(); err != nil {
+		return err
+	}
+
+	current, err := m.currentVersion()
+	if err != nil {
+		return err
+	}
+
+	if current > m.latestVersion() {
+		return ErrSchemaTooNew
+	}
+
+	for _, mig := range m.migrations {
+		if mig.version <= current {
+			continue
+		}
+		if err := m.apply(mig.up, m.queries.insertSchemaVersion, mig.version, mig.description, utils.UTCTimeMilliseconds()); err != nil {
+			return fmt.Errorf("Migration %v failed: %v", mig.version, err)
+		}
+	}
+	return nil
+}
+
+//Reverts the given number of migrations starting from the latest applied one
+//Returns ErrSchemaTooNew if the database has a version greater than the latest known migration
+//Returns error in case of failiure and nil in case of success
+
+func (m migrator) down(steps int) error {
+	if err := m.init(); err != nil {
+		return err
+	}
+
+	current, err := m.currentVersion()
+	if err != nil {
+		return err
+	}
+
+	if current > m.latestVersion() {
+		return ErrSchemaTooNew
+	}
+
+	for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+		mig := m.migrations[i]
+		if mig.version > current {
+			continue
+		}
+		if err := m.apply(mig.down, m.queries.deleteSchemaVersion, mig.version); err != nil {
+			return fmt.Errorf("Migration %v rollback failed: %v", mig.version, err)
+		}
+		steps--
+	}
+	return nil
+}
+
+//Executes the schema change and the schema_version update in a single transaction
+//Returns error in case of failiure and nil in case of success
+
+func (m migrator) apply(statement string, versionQuery string, args ...interface{}) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(statement); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec(versionQuery, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//Returns the status of every known migration
+//Returns error in case of failiure
+
+func (m migrator) status() ([]MigrationStatus, error) {
+	if err := m.init(); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.Query(m.queries.getAppliedMigrations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]int64)
+	for rows.Next() {
+		var version, appliedAt int64
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		appliedAt, found := applied[mig.version]
+		statuses = append(statuses, MigrationStatus{mig.version, mig.description, found, appliedAt})
+	}
+
+	for version, appliedAt := range applied {
+		if version > m.latestVersion() {
+			statuses = append(statuses, MigrationStatus{version, "Unknown migration", true, appliedAt})
+		}
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
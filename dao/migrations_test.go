@@ -0,0 +1,186 @@
+package dao
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/maidaneze/message-server/model"
+	"github.com/maidaneze/message-server/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testMigrationsFileName = "./migrations.db"
+
+func TestSqlite3Migrations(t *testing.T) {
+	t.Run("testOpenShouldApplyAllMigrations", testOpenShouldApplyAllMigrations)
+	t.Run("testMigrateDownShouldRevertMigrations", testMigrateDownShouldRevertMigrations)
+	t.Run("testMigrateDownShouldKeepMessages", testMigrateDownShouldKeepMessages)
+	t.Run("testMigrateSessionsShouldKeepTokens", testMigrateSessionsShouldKeepTokens)
+	t.Run("testMigrateHashTokensShouldRevokeTokens", testMigrateHashTokensShouldRevokeTokens)
+	t.Run("testOpenShouldAdoptLegacyDatabase", testOpenShouldAdoptLegacyDatabase)
+	t.Run("testOpenShouldFailOnNewerSchema", testOpenShouldFailOnNewerSchema)
+
+	os.Remove(testMigrationsFileName)
+}
+
+func testOpenShouldApplyAllMigrations(t *testing.T) {
+	os.Remove(testMigrationsFileName)
+	db, err := OpenSqlite3Database(testMigrationsFileName)
+	require.Nil(t, err)
+	defer db.db.Close()
+
+	version, err := migrator{db.db, sqliteMigrations, sqliteMigrationQueries}.currentVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, sqliteMigrations[len(sqliteMigrations)-1].version, version)
+
+	statuses, err := db.MigrationStatus()
+	assert.Nil(t, err)
+	require.Equal(t, len(sqliteMigrations), len(statuses))
+	for i, status := range statuses {
+		assert.Equal(t, sqliteMigrations[i].version, status.Version)
+		assert.True(t, status.Applied)
+	}
+
+	//Applying the migrations again should be a no-op
+	assert.Nil(t, db.MigrateUp())
+	assert.Nil(t, db.CheckConnection(context.Background()))
+}
+
+func testMigrateDownShouldRevertMigrations(t *testing.T) {
+	os.Remove(testMigrationsFileName)
+	db, err := OpenSqlite3Database(testMigrationsFileName)
+	require.Nil(t, err)
+	defer db.db.Close()
+
+	assert.Nil(t, db.MigrateDown(len(sqliteMigrations)))
+
+	statuses, err := db.MigrationStatus()
+	assert.Nil(t, err)
+	for _, status := range statuses {
+		assert.False(t, status.Applied)
+	}
+
+	var count int
+	assert.Nil(t, db.db.QueryRow(checkLegacyUsersTableQuery).Scan(&count))
+	assert.Equal(t, 0, count)
+
+	assert.Nil(t, db.MigrateUp())
+	_, err = db.GetMessages(context.Background(), 1, 1, 1)
+	assert.Nil(t, err)
+}
+
+func testMigrateDownShouldKeepMessages(t *testing.T) {
+	os.Remove(testMigrationsFileName)
+	db, err := OpenSqlite3Database(testMigrationsFileName)
+	require.Nil(t, err)
+	defer db.db.Close()
+
+	message, err := db.InsertMessage(context.Background(), model.MessageDTO{SenderId: 1, RecipientId: 2, Timestamp: time.Now(), Type: "text", Text: "text"})
+	require.Nil(t, err)
+	_, err = db.MarkMessagesRead(context.Background(), 2, message.MessageId, time.Now())
+	require.Nil(t, err)
+
+	//Reverting the message status (version 4) rebuilds the messages table
+	require.Nil(t, db.MigrateDown(len(sqliteMigrations)-3))
+	assert.Nil(t, db.MigrateUp())
+
+	messages, err := db.GetMessages(context.Background(), 2, message.MessageId, 1)
+	assert.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	assert.Equal(t, "text", messages[0].Text)
+	assert.Nil(t, messages[0].Read)
+}
+
+func testMigrateSessionsShouldKeepTokens(t *testing.T) {
+	os.Remove(testMigrationsFileName)
+	db, err := OpenSqlite3Database(testMigrationsFileName)
+	require.Nil(t, err)
+	defer db.db.Close()
+
+	var yearMilliseconds int64 = 365 * 24 * 60 * 60 * 1000
+	token := model.Token{Uuid: "token", Expiration: yearMilliseconds * 2, Created: 1, LastUsed: 1, UserAgent: "agent"}
+
+	//Reverting the sessions (version 5) rebuilds the tokens table without the session columns
+	require.Nil(t, db.MigrateDown(len(sqliteMigrations)-4))
+	_, err = db.db.Exec("INSERT INTO tokens (userid, token, expiration) VALUES (1, 'token', ?)", token.Expiration)
+	require.Nil(t, err)
+
+	//The tokens without sessions are assumed to have been issued for a year
+	//Only up to version 6, the later hashed tokens migration revokes them
+	sessions := migrator{db: db.db, migrations: sqliteMigrations[:6], queries: sqliteMigrationQueries}
+	assert.Nil(t, sessions.up())
+
+	tokens, err := db.GetTokens(context.Background(), 1)
+	assert.Nil(t, err)
+	require.Equal(t, 1, len(tokens))
+	assert.Equal(t, token.Uuid, tokens[0].Uuid)
+	assert.Equal(t, token.Expiration, tokens[0].Expiration)
+	assert.Equal(t, yearMilliseconds, tokens[0].Created)
+	assert.Equal(t, yearMilliseconds, tokens[0].LastUsed)
+	assert.Equal(t, "", tokens[0].UserAgent)
+	assert.True(t, tokens[0].SessionId > 0)
+}
+
+func testMigrateHashTokensShouldRevokeTokens(t *testing.T) {
+	os.Remove(testMigrationsFileName)
+	db, err := OpenSqlite3Database(testMigrationsFileName)
+	require.Nil(t, err)
+	defer db.db.Close()
+
+	token := model.Token{Uuid: "token", Expiration: utils.UTCTimeMilliseconds() + 1000000}
+
+	//Reverting the hashed tokens (version 7) revokes the hashed tokens
+	insertTokenForTest(t, db, 1, token, 0)
+	require.Nil(t, db.MigrateDown(len(sqliteMigrations)-6))
+
+	tokens, err := db.GetTokens(context.Background(), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(tokens))
+
+	//Applying it revokes the plain text tokens
+	insertTokenForTest(t, db, 1, token, 0)
+	require.Nil(t, db.MigrateUp())
+
+	tokens, err = db.GetTokens(context.Background(), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(tokens))
+}
+
+func testOpenShouldAdoptLegacyDatabase(t *testing.T) {
+	os.Remove(testMigrationsFileName)
+	db, err := ConnectSqlite3Database(testMigrationsFileName)
+	require.Nil(t, err)
+	_, err = db.db.Exec(sqliteMigrations[0].up)
+	require.Nil(t, err)
+	db.db.Close()
+
+	db, err = OpenSqlite3Database(testMigrationsFileName)
+	require.Nil(t, err)
+	defer db.db.Close()
+
+	statuses, err := db.MigrationStatus()
+	assert.Nil(t, err)
+	for _, status := range statuses {
+		assert.True(t, status.Applied)
+	}
+}
+
+func testOpenShouldFailOnNewerSchema(t *testing.T) {
+	os.Remove(testMigrationsFileName)
+	db, err := OpenSqlite3Database(testMigrationsFileName)
+	require.Nil(t, err)
+
+	newerVersion := sqliteMigrations[len(sqliteMigrations)-1].version + 1
+	_, err = db.db.Exec(insertSchemaVersionQuery, newerVersion, "Newer migration", 0)
+	require.Nil(t, err)
+	db.db.Close()
+
+	db, err = OpenSqlite3Database(testMigrationsFileName)
+	defer db.db.Close()
+	assert.Equal(t, ErrSchemaTooNew, err)
+	assert.Equal(t, ErrSchemaTooNew, db.MigrateDown(1))
+}
@@ -0,0 +1,744 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/maidaneze/message-server/model"
+	"github.com/maidaneze/message-server/utils"
+
+	"github.com/lib/pq"
+)
+
+type PostgresDB struct {
+	db    *sql.DB
+	retry RetryOptions
+}
+
+//Opens the postgres database for the given connection string and applies the pending migrations
+//Returns ErrSchemaTooNew if the database was migrated by a newer version of the server
+//Returns error in case of failiure
+
+func OpenPostgresDatabase(dataSourceName string) (PostgresDB, error) {
+	postgres, err := ConnectPostgresDatabase(dataSourceName)
+	if err != nil {
+		return postgres, err
+	}
+	return postgres, postgres.MigrateUp()
+}
+
+//Opens the postgres database for the given connection string without applying any migration
+//Returns error in case of failiure
+
+func ConnectPostgresDatabase(dataSourceName string) (PostgresDB, error) {
+	var db *sql.DB
+	var err error
+	err = utils.Retry(func() error {
+		db, err = sql.Open("postgres", dataSourceName)
+		return err
+	}, DefaultRetryOptions.Attempts, DefaultRetryOptions.Interval)
+	return PostgresDB{db: db}.WithRetry(DefaultRetryOptions), err
+}
+
+//Returns a copy of the database whose operations are retried with the given retry options
+//Only the serialization failures, deadlocks and unavailable locks are retried, the rest fail the same way every
+//time
+
+func (postgres PostgresDB) WithRetry(retry RetryOptions) PostgresDB {
+	retry.retryable = isTransientPostgresError
+	postgres.retry = retry
+	return postgres
+}
+
+//Postgres error codes
+//The transient ones mean the transaction lost against a concurrent one
+
+const (
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
+	lockNotAvailable     = "55P03"
+	uniqueViolation      = "23505"
+)
+
+//Returns whether the error is transient, the operation can succeed once the concurrent transactions finish
+
+func isTransientPostgresError(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && (pqErr.Code == serializationFailure || pqErr.Code == deadlockDetected || pqErr.Code == lockNotAvailable)
+}
+
+//Runs the operation with the retry options of the database
+//Returns a conflict error if the operation violated a unique constraint, and the error of the operation otherwise
+
+func (postgres PostgresDB) run(ctx context.Context, fn func(ctx context.Context) error) error {
+	err := postgres.retry.run(ctx, fn)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolation {
+		return conflictError(err)
+	}
+	return err
+}
+
+//Applies all the pending migrations
+//Returns error in case of failiure and nil in case of success
+
+func (postgres PostgresDB) MigrateUp() error {
+	return migrator{postgres.db, postgresMigrations, postgresMigrationQueries}.up()
+}
+
+//Reverts the given number of applied migrations, starting from the latest one
+//Returns error in case of failiure and nil in case of success
+
+func (postgres PostgresDB) MigrateDown(steps int) error {
+	return migrator{postgres.db, postgresMigrations, postgresMigrationQueries}.down(steps)
+}
+
+//Returns the status of every known migration
+//Returns error in case of failiure
+
+func (postgres PostgresDB) MigrationStatus() ([]MigrationStatus, error) {
+	return migrator{postgres.db, postgresMigrations, postgresMigrationQueries}.status()
+}
+
+//Closes the database
+//Returns error in case of failiure and nil in case of success
+
+func (postgres PostgresDB) Close() error {
+	return postgres.db.Close()
+}
+
+//Wrapper function for "checkConnection"
+//Executes checkConnection with a retry
+
+func (postgres PostgresDB) CheckConnection(ctx context.Context) error {
+	var err error
+	err = postgres.run(ctx, func(ctx context.Context) error {
+		err = postgres.checkConnection(ctx)
+		return err
+	})
+	return err
+}
+
+//Verifies the connection to the database
+//Returns error in case of failiure and nil in case of success
+
+func (postgres PostgresDB) checkConnection(ctx context.Context) error {
+	var res int
+	if err := postgres.db.QueryRowContext(ctx, "SELECT 1").Scan(&res); err != nil {
+		return err
+	}
+
+	if res != 1 {
+		return errors.New("Unexpected query result")
+	}
+	return nil
+}
+
+//Wrapper function for insertUser
+//Executes insertUser with a retry
+
+func (postgres PostgresDB) InsertUser(ctx context.Context, user model.User) (model.User, error) {
+	var insertedUser model.User
+	var err error
+	err = postgres.run(ctx, func(ctx context.Context) error {
+		insertedUser, err = postgres.insertUser(ctx, user)
+		return err
+	})
+	return insertedUser, err
+}
+
+//Inserts a new user into the users tables
+//Returns error in case of failiure and the inserted user in case of success
+
+func (postgres PostgresDB) insertUser(ctx context.Context, user model.User) (model.User, error) {
+	var id int64
+	if err := postgres.db.QueryRowContext(ctx, postgresInsertNewUserQuery, user.Username, []byte(user.Password), []byte(user.Salt)).Scan(&id); err != nil {
+		return model.User{}, err
+	}
+
+	user.Userid = id
+	return user, nil
+}
+
+//Wrapper function for getUser
+//Executes getUser with a retry
+
+func (postgres PostgresDB) GetUser(ctx context.Context, username string) (model.User, bool, error) {
+	var getUser model.User
+	var err error
+	var found bool
+	err = postgres.run(ctx, func(ctx context.Context) error {
+		getUser, found, err = postgres.getUser(ctx, username)
+		return err
+	})
+	return getUser, found, err
+}
+
+//Gets the given user data
+//Returns true if an user was found, false otherwise
+//Returns error in case of failiure and the user in case of success
+
+func (postgres PostgresDB) getUser(ctx context.Context, username string) (model.User, bool, error) {
+	var userid int64
+	var password []byte
+	var passwordSalt []byte
+
+	if err := postgres.db.QueryRowContext(ctx, postgresGetFromUsersQuery, username).Scan(&userid, &password, &passwordSalt); err != nil {
+		if err == sql.ErrNoRows {
+			return model.User{}, false, nil
+		}
+		return model.User{}, false, err
+	}
+
+	user := model.User{Userid: userid, Username: username, Password: string(password), Salt: string(passwordSalt)}
+	return user, true, nil
+}
+
+//Wrapper function for checkUserExists
+//Executes checkUserExists with a retry
+
+func (postgres PostgresDB) CheckUserExists(ctx context.Context, userid int64) (bool, error) {
+	var err error
+	var found bool
+	err = postgres.run(ctx, func(ctx context.Context) error {
+		found, err = postgres.checkUserExists(ctx, userid)
+		return err
+	})
+	return found, err
+}
+
+//Checks if the corresponding user id is registered
+//Returns true if an user was found, false otherwise
+//Returns error in case of failure
+
+func (postgres PostgresDB) checkUserExists(ctx context.Context, userid int64) (bool, error) {
+
+	if err := postgres.db.QueryRowContext(ctx, postgresCheckUsersQuery, userid).Scan(&userid); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+//Wrapper function for updateUserPassword
+//Executes updateUserPassword with a retry
+
+func (postgres PostgresDB) UpdateUserPassword(ctx context.Context, user model.User) error {
+	var err error
+	err = postgres.run(ctx, func(ctx context.Context) error {
+		err = postgres.updateUserPassword(ctx, user)
+		return err
+	})
+	return err
+}
+
+//Replaces the password and salt of the user with the ones of the given user
+//Returns error in case of failiure and nil in case of success
+
+func (postgres PostgresDB) updateUserPassword(ctx context.Context, user model.User) error {
+	_, err := postgres.db.ExecContext(ctx, postgresUpdateUserPasswordQuery, []byte(user.Password), []byte(user.Salt), user.Userid)
+	return err
+}
+
+//Wrapper function for insertToken
+//Executes insertToken with a retry
+
+func (postgres PostgresDB) InsertToken(ctx context.Context, userid int64, token model.Token, maxSessions int) (model.Token, error) {
+	var insertedToken model.Token
+	var err error
+	err = postgres.run(ctx, func(ctx context.Context) error {
+		insertedToken, err = postgres.insertToken(ctx, userid, token, maxSessions)
+		return err
+	})
+	return insertedToken, err
+}
+
+//Inserts the given token into the tokens table for the requested userid and deletes the oldest sessions
+//exceeding maxSessions in a single transaction
+//Returns error in case of failiure and the inserted token with its session id in case of success
+
+func (postgres PostgresDB) insertToken(ctx context.Context, userid int64, token model.Token, maxSessions int) (model.Token, error) {
+	tx, err := postgres.db.BeginTx(ctx, nil)
+	if err != nil {
+		return token, err
+	}
+
+	//Insert token
+	var id int64
+	if err = tx.QueryRowContext(ctx, postgresInsertNewTokenQuery, userid, token.Uuid, token.Expiration, token.Created, token.LastUsed, token.UserAgent).Scan(&id); err != nil {
+		tx.Rollback()
+		return token, err
+	}
+
+	//Purge the oldest sessions
+	if maxSessions > 0 {
+		if _, err = tx.ExecContext(ctx, postgresPurgeTokensQuery, userid, maxSessions); err != nil {
+			tx.Rollback()
+			return token, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return token, err
+	}
+	token.SessionId = id
+	token.Userid = userid
+	return token, nil
+}
+
+//Wrapper function for getTokens
+//Executes getTokens with a retry
+
+func (postgres PostgresDB) GetTokens(ctx context.Context, userid int64) ([]model.Token, error) {
+	var getTokens []model.Token
+	var err error
+	err = postgres.run(ctx, func(ctx context.Context) error {
+		getTokens, err = postgres.getTokens(ctx, userid)
+		return err
+	})
+	return getTokens, err
+}
+
+//Recovers the access tokens for the requested userid ordered by session
+//Returns error in case of failiure and nil in case of success
+
+func (postgres PostgresDB) getTokens(ctx context.Context, userid int64) ([]model.Token, error) {
+
+	var err error
+	var rows *sql.Rows
+	if rows, err = postgres.db.QueryContext(ctx, postgresGetFromTokensQuery, userid); err != nil {
+		return nil, err
+	}
+
+	tokens := make([]model.Token, 0)
+	defer rows.Close()
+	for rows.Next() {
+		token := model.Token{Userid: userid}
+		err = rows.Scan(&token.SessionId, &token.Uuid, &token.Expiration, &token.Created, &token.LastUsed, &token.UserAgent)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+//Wrapper function for getToken
+//Executes getToken with a retry
+
+func (postgres PostgresDB) GetToken(ctx context.Context, token string) (model.Token, bool, error) {
+	var getToken model.Token
+	var found bool
+	var err error
+	err = postgres.run(ctx, func(ctx context.Context) error {
+		getToken, found, err = postgres.getToken(ctx, token)
+		return err
+	})
+	return getToken, found, err
+}
+
+//Recovers the given token with its user and session
+//Returns true if the token was found, false otherwise
+//Returns error in case of failiure
+
+func (postgres PostgresDB) getToken(ctx context.Context, token string) (model.Token, bool, error) {
+	getToken := model.Token{}
+	err := postgres.db.QueryRowContext(ctx, postgresGetTokenQuery, token).Scan(&getToken.SessionId, &getToken.Userid, &getToken.Uuid, &getToken.Expiration, &getToken.Created, &getToken.LastUsed, &getToken.UserAgent)
+	if err == sql.ErrNoRows {
+		return model.Token{}, false, nil
+	}
+	if err != nil {
+		return model.Token{}, false, err
+	}
+	return getToken, true, nil
+}
+
+//Wrapper function for deleteTokens
+//Executes deleteTokens with a retry
+
+func (postgres PostgresDB) DeleteTokens(ctx context.Context, userid int64) (int64, error) {
+	var deleted int64
+	var err error
+	err = postgres.run(ctx, func(ctx context.Context) error {
+		deleted, err = postgres.deleteTokens(ctx, userid)
+		return err
+	})
+	return deleted, err
+}
+
+//Deletes all the tokens of the user
+//Returns the number of deleted tokens
+//Returns error in case of failiure
+
+func (postgres PostgresDB) deleteTokens(ctx context.Context, userid int64) (int64, error) {
+	deleteResult, err := postgres.db.ExecContext(ctx, postgresDeleteTokensQuery, userid)
+	if err != nil {
+		return 0, err
+	}
+	return deleteResult.RowsAffected()
+}
+
+//Wrapper function for touchToken
+//Executes touchToken with a retry
+
+func (postgres PostgresDB) TouchToken(ctx context.Context, userid int64, token string, lastUsed int64) error {
+	var err error
+	err = postgres.run(ctx, func(ctx context.Context) error {
+		err = postgres.touchToken(ctx, userid, token, lastUsed)
+		return err
+	})
+	return err
+}
+
+//Updates the last used time of the given token of the user
+//Returns error in case of failiure and nil in case of success
+
+func (postgres PostgresDB) touchToken(ctx context.Context, userid int64, token string, lastUsed int64) error {
+	_, err := postgres.db.ExecContext(ctx, postgresTouchTokenQuery, lastUsed, userid, token)
+	return err
+}
+
+//Wrapper function for checkSession
+//Executes checkSession with a retry
+
+func (postgres PostgresDB) CheckSession(ctx context.Context, userid int64, sessionId int64) (bool, error) {
+	var found bool
+	var err error
+	err = postgres.run(ctx, func(ctx context.Context) error {
+		found, err = postgres.checkSession(ctx, userid, sessionId)
+		return err
+	})
+	return found, err
+}
+
+//Checks if the given session of the user exists
+//Returns true if the session was found, false otherwise
+//Returns error in case of failiure
+
+func (postgres PostgresDB) checkSession(ctx context.Context, userid int64, sessionId int64) (bool, error) {
+	if err := postgres.db.QueryRowContext(ctx, postgresCheckSessionQuery, sessionId, userid).Scan(&sessionId); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+//Wrapper function for deleteSession
+//Executes deleteSession with a retry
+
+func (postgres PostgresDB) DeleteSession(ctx context.Context, userid int64, sessionId int64) (bool, error) {
+	var deleted bool
+	var err error
+	err = postgres.run(ctx, func(ctx context.Context) error {
+		deleted, err = postgres.deleteSession(ctx, userid, sessionId)
+		return err
+	})
+	return deleted, err
+}
+
+//Deletes the given session of the user
+//Returns true if the session was found, false otherwise
+//Returns error in case of failiure
+
+func (postgres PostgresDB) deleteSession(ctx context.Context, userid int64, sessionId int64) (bool, error) {
+	deleteResult, err := postgres.db.ExecContext(ctx, postgresDeleteSessionQuery, userid, sessionId)
+	if err != nil {
+		return false, err
+	}
+	affected, err := deleteResult.RowsAffected()
+	return affected > 0, err
+}
+
+//Wrapper function for rotateRefreshToken
+//Executes rotateRefreshToken with a retry
+
+func (postgres PostgresDB) RotateRefreshToken(ctx context.Context, userid int64, token string, newToken string, now int64) (model.Token, RefreshResult, error) {
+	var session model.Token
+	var result RefreshResult
+	var err error
+	err = postgres.run(ctx, func(ctx context.Context) error {
+		session, result, err = postgres.rotateRefreshToken(ctx, userid, token, newToken, now)
+		return err
+	})
+	return session, result, err
+}
+
+//Replaces the refresh token of the user session with the new refresh token in a single transaction
+//A token that was already rotated, or is rotated concurrently, revokes its session
+//Returns the result of the rotation and the updated session in case of success
+//Returns error in case of failiure
+
+func (postgres PostgresDB) rotateRefreshToken(ctx context.Context, userid int64, token string, newToken string, now int64) (model.Token, RefreshResult, error) {
+	tx, err := postgres.db.BeginTx(ctx, nil)
+	if err != nil {
+		return model.Token{}, RefreshNotFound, err
+	}
+
+	//Purge the rotated tokens of expired sessions
+	if _, err = tx.ExecContext(ctx, postgresPurgeRotatedTokensQuery, now); err != nil {
+		tx.Rollback()
+		return model.Token{}, RefreshNotFound, err
+	}
+
+	//Get session
+	session := model.Token{}
+	err = tx.QueryRowContext(ctx, postgresGetSessionByTokenQuery, userid, token).Scan(&session.SessionId, &session.Uuid, &session.Expiration, &session.Created, &session.LastUsed, &session.UserAgent)
+	if err == sql.ErrNoRows {
+		var sessionId int64
+		err = tx.QueryRowContext(ctx, postgresGetRotatedTokenQuery, userid, token).Scan(&sessionId)
+		if err == sql.ErrNoRows {
+			return model.Token{}, RefreshNotFound, tx.Commit()
+		}
+		if err != nil {
+			tx.Rollback()
+			return model.Token{}, RefreshNotFound, err
+		}
+		return model.Token{}, RefreshReused, postgres.revokeSession(ctx, tx, userid, sessionId)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return model.Token{}, RefreshNotFound, err
+	}
+
+	if session.Expiration < now {
+		return model.Token{}, RefreshNotFound, tx.Commit()
+	}
+
+	//Rotate token
+	rotateResult, err := tx.ExecContext(ctx, postgresRotateTokenQuery, newToken, now, session.SessionId, token)
+	if err != nil {
+		tx.Rollback()
+		return model.Token{}, RefreshNotFound, err
+	}
+
+	affected, err := rotateResult.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return model.Token{}, RefreshNotFound, err
+	}
+
+	if affected == 0 {
+		return model.Token{}, RefreshReused, postgres.revokeSession(ctx, tx, userid, session.SessionId)
+	}
+
+	if _, err = tx.ExecContext(ctx, postgresInsertRotatedTokenQuery, token, userid, session.SessionId, session.Expiration); err != nil {
+		tx.Rollback()
+		return model.Token{}, RefreshNotFound, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return model.Token{}, RefreshNotFound, err
+	}
+
+	session.Userid = userid
+	session.Uuid = newToken
+	session.LastUsed = now
+	return session, RefreshRotated, nil
+}
+
+//Deletes the session and commits the transaction
+//Returns error in case of failiure and nil in case of success
+
+func (postgres PostgresDB) revokeSession(ctx context.Context, tx *sql.Tx, userid int64, sessionId int64) error {
+	if _, err := tx.ExecContext(ctx, postgresDeleteSessionQuery, userid, sessionId); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+//Wrapper function for insertMessage
+//Executes insertMessage with a retry
+
+func (postgres PostgresDB) InsertMessage(ctx context.Context, message model.MessageDTO) (model.MessageDTO, error) {
+	var insertedMessage model.MessageDTO
+	var err error
+	err = postgres.run(ctx, func(ctx context.Context) error {
+		insertedMessage, err = postgres.insertMessage(ctx, message)
+		return err
+	})
+	return insertedMessage, err
+}
+
+//Inserts the given message into the messages table
+//Returns error in case of failiure and nil in case of success
+
+func (postgres PostgresDB) insertMessage(ctx context.Context, message model.MessageDTO) (model.MessageDTO, error) {
+	var id int64
+	if err := postgres.db.QueryRowContext(ctx, postgresInsertNewMessageQuery, message.RecipientId, message.SenderId, message.Timestamp, message.Type, message.Text, message.Url, message.Height, message.Width, message.Source).Scan(&id); err != nil {
+		return message, err
+	}
+	message.MessageId = id
+	return message, nil
+}
+
+//Wrapper function for getMessages
+//Executes getMessages with a retry
+
+func (postgres PostgresDB) GetMessages(ctx context.Context, recipientId int64, messageId int64, limit int64) ([]model.MessageDTO, error) {
+	var getMessages []model.MessageDTO
+	var err error
+	err = postgres.run(ctx, func(ctx context.Context) error {
+		getMessages, err = postgres.getMessages(ctx, recipientId, messageId, limit)
+		return err
+	})
+	return getMessages, err
+}
+
+//Recovers the messages for the requested recipient id that have a messageid greater than the given messageid
+//Returns error in case of failiure and nil in case of success
+
+func (postgres PostgresDB) getMessages(ctx context.Context, recipientId int64, messageId int64, limit int64) ([]model.MessageDTO, error) {
+
+	var err error
+	var rows *sql.Rows
+	if rows, err = postgres.db.QueryContext(ctx, postgresGetFromMessagesQuery, recipientId, messageId, limit); err != nil {
+		return nil, err
+	}
+
+	messages := make([]model.MessageDTO, 0)
+	defer rows.Close()
+	for rows.Next() {
+		message := model.MessageDTO{}
+		err = rows.Scan(&message.MessageId, &message.RecipientId, &message.SenderId, &message.Timestamp, &message.Type, &message.Text, &message.Url, &message.Height, &message.Width, &message.Source, &message.Delivered, &message.Read)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, rows.Err()
+}
+
+//Wrapper function for getConversationMessages
+//Executes getConversationMessages with a retry
+
+func (postgres PostgresDB) GetConversationMessages(ctx context.Context, userId int64, otherUserId int64, messageId int64, limit int64, before bool) ([]model.MessageDTO, error) {
+	var getMessages []model.MessageDTO
+	var err error
+	err = postgres.run(ctx, func(ctx context.Context) error {
+		getMessages, err = postgres.getConversationMessages(ctx, userId, otherUserId, messageId, limit, before)
+		return err
+	})
+	return getMessages, err
+}
+
+//Recovers the messages exchanged between the two users after or before the given messageid
+//Returns the messages ordered by messageid, oldest first
+//Returns error in case of failiure and nil in case of success
+
+func (postgres PostgresDB) getConversationMessages(ctx context.Context, userId int64, otherUserId int64, messageId int64, limit int64, before bool) ([]model.MessageDTO, error) {
+	query := postgresGetConversationMessagesAfterQuery
+	if before {
+		query = postgresGetConversationMessagesBeforeQuery
+	}
+
+	rows, err := postgres.db.QueryContext(ctx, query, userId, otherUserId, otherUserId, userId, messageId, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]model.MessageDTO, 0)
+	defer rows.Close()
+	for rows.Next() {
+		message := model.MessageDTO{}
+		err = rows.Scan(&message.MessageId, &message.RecipientId, &message.SenderId, &message.Timestamp, &message.Type, &message.Text, &message.Url, &message.Height, &message.Width, &message.Source, &message.Delivered, &message.Read)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	if before {
+		reverseMessages(messages)
+	}
+	return messages, rows.Err()
+}
+
+//Wrapper function for markMessagesDelivered
+//Executes markMessagesDelivered with a retry
+
+func (postgres PostgresDB) MarkMessagesDelivered(ctx context.Context, recipientId int64, fromMessageId int64, toMessageId int64, delivered time.Time) error {
+	var err error
+	err = postgres.run(ctx, func(ctx context.Context) error {
+		err = postgres.markMessagesDelivered(ctx, recipientId, fromMessageId, toMessageId, delivered)
+		return err
+	})
+	return err
+}
+
+//Marks as delivered the messages of the recipient in the given messageid range that weren't delivered yet
+//Returns error in case of failiure and nil in case of success
+
+func (postgres PostgresDB) markMessagesDelivered(ctx context.Context, recipientId int64, fromMessageId int64, toMessageId int64, delivered time.Time) error {
+	_, err := postgres.db.ExecContext(ctx, postgresMarkMessagesDeliveredQuery, delivered, recipientId, fromMessageId, toMessageId)
+	return err
+}
+
+//Wrapper function for markMessagesRead
+//Executes markMessagesRead with a retry
+
+func (postgres PostgresDB) MarkMessagesRead(ctx context.Context, recipientId int64, messageId int64, read time.Time) (int64, error) {
+	var marked int64
+	var err error
+	err = postgres.run(ctx, func(ctx context.Context) error {
+		marked, err = postgres.markMessagesRead(ctx, recipientId, messageId, read)
+		return err
+	})
+	return marked, err
+}
+
+//Marks as read the messages of the recipient up to the given messageid that weren't read yet
+//Returns the number of messages marked as read
+//Returns error in case of failiure
+
+func (postgres PostgresDB) markMessagesRead(ctx context.Context, recipientId int64, messageId int64, read time.Time) (int64, error) {
+	updateResult, err := postgres.db.ExecContext(ctx, postgresMarkMessagesReadQuery, read, read, recipientId, messageId)
+	if err != nil {
+		return 0, err
+	}
+	return updateResult.RowsAffected()
+}
+
+//Wrapper function for getSentMessageStatuses
+//Executes getSentMessageStatuses with a retry
+
+func (postgres PostgresDB) GetSentMessageStatuses(ctx context.Context, senderId int64, messageId int64, limit int64) ([]model.MessageStatus, error) {
+	var statuses []model.MessageStatus
+	var err error
+	err = postgres.run(ctx, func(ctx context.Context) error {
+		statuses, err = postgres.getSentMessageStatuses(ctx, senderId, messageId, limit)
+		return err
+	})
+	return statuses, err
+}
+
+//Recovers the delivery status of the messages sent by the sender that have a messageid greater than the given messageid
+//Returns error in case of failiure and nil in case of success
+
+func (postgres PostgresDB) getSentMessageStatuses(ctx context.Context, senderId int64, messageId int64, limit int64) ([]model.MessageStatus, error) {
+	rows, err := postgres.db.QueryContext(ctx, postgresGetSentMessageStatusesQuery, senderId, messageId, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]model.MessageStatus, 0)
+	defer rows.Close()
+	for rows.Next() {
+		status := model.MessageStatus{}
+		if err = rows.Scan(&status.MessageId, &status.RecipientId, &status.Delivered, &status.Read); err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, rows.Err()
+}
@@ -0,0 +1,278 @@
+package dao
+
+const (
+	//Gets the userid, password and salt from the users table.
+	//The password and salt are stored as BYTEA since the legacy hashes are raw bytes which aren't valid UTF-8 TEXT
+	//The encoded hashes are stored as their bytes too, so the queries always read and write []byte
+
+	postgresGetFromUsersQuery = "SELECT userid, password, password_salt FROM users WHERE username = $1"
+
+	//Gets the userid from the users table for the given userid.
+
+	postgresCheckUsersQuery = "SELECT userid FROM users WHERE userid = $1"
+
+	//Inserts a user into the users table and returns the generated userid
+
+	postgresInsertNewUserQuery = "INSERT INTO users (username, password, password_salt) VALUES ($1, $2, $3) RETURNING userid"
+
+	//Replaces the password and salt of the user
+
+	postgresUpdateUserPasswordQuery = "UPDATE users SET password = $1, password_salt = $2 WHERE userid = $3"
+
+	//Inserts a new token into the tokens table and returns the generated sessionid
+
+	postgresInsertNewTokenQuery = "INSERT INTO tokens (userid, token, expiration, created, last_used, user_agent) VALUES ($1, $2, $3, $4, $5, $6) RETURNING sessionid"
+
+	//Deletes the oldest tokens of the user keeping only the given number of most recent sessions
+
+	postgresPurgeTokensQuery = "DELETE FROM tokens WHERE userid = $1 AND sessionid NOT IN (SELECT sessionid FROM tokens WHERE userid = $1 ORDER BY created DESC, sessionid DESC LIMIT $2)"
+
+	//Gets all the tokens corresponding to the user id ordered by session, which must then be verified
+
+	postgresGetFromTokensQuery = "SELECT sessionid, token, expiration, created, last_used, user_agent FROM tokens WHERE userid = $1 ORDER BY sessionid"
+
+	//Gets the token and its user, the query is efficient because it's performed on the INDEX "idx_tokens_token"
+
+	postgresGetTokenQuery = "SELECT sessionid, userid, token, expiration, created, last_used, user_agent FROM tokens WHERE token = $1"
+
+	//Updates the last used time of the given token of the user
+
+	postgresTouchTokenQuery = "UPDATE tokens SET last_used = $1 WHERE userid = $2 AND token = $3"
+
+	//Gets the given session of the user, the query is efficient because it's performed on the PRIMARY KEY sessionid
+
+	postgresCheckSessionQuery = "SELECT sessionid FROM tokens WHERE sessionid = $1 AND userid = $2"
+
+	//Deletes the given session of the user
+
+	postgresDeleteSessionQuery = "DELETE FROM tokens WHERE userid = $1 AND sessionid = $2"
+
+	//Gets the session of the user with the given token
+
+	postgresGetSessionByTokenQuery = "SELECT sessionid, token, expiration, created, last_used, user_agent FROM tokens WHERE userid = $1 AND token = $2"
+
+	//Replaces the token of the session, only if it wasn't replaced concurrently
+
+	postgresRotateTokenQuery = "UPDATE tokens SET token = $1, last_used = $2 WHERE sessionid = $3 AND token = $4"
+
+	//Keeps a replaced refresh token to detect its reuse
+
+	postgresInsertRotatedTokenQuery = "INSERT INTO rotated_tokens (token, userid, sessionid, expiration) VALUES ($1, $2, $3, $4)"
+
+	//Gets the session of a replaced refresh token of the user
+
+	postgresGetRotatedTokenQuery = "SELECT sessionid FROM rotated_tokens WHERE userid = $1 AND token = $2"
+
+	//Deletes the replaced refresh tokens whose session expired
+
+	postgresPurgeRotatedTokensQuery = "DELETE FROM rotated_tokens WHERE expiration < $1"
+
+	//Deletes all the tokens of the user
+
+	postgresDeleteTokensQuery = "DELETE FROM tokens WHERE userid = $1"
+
+	//Inserts a new message into the messages table and returns the generated messageid
+
+	postgresInsertNewMessageQuery = "INSERT INTO messages (recipientid, senderid, timestamp, type, text, url, height, width, source) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING messageid"
+
+	//Gets all the messages corresponding to the recipient id that have a messageid greater that the given messageid
+	//Returns at most the given limit of rows
+
+	postgresGetFromMessagesQuery = "SELECT messageid, recipientid, senderid, timestamp, type, text, url, height, width, source, delivered_at, read_at FROM messages WHERE recipientid = $1 AND messageid >= $2 ORDER BY messageid ASC LIMIT $3"
+
+	//Gets the messages exchanged between the two users that have a messageid greater than the given messageid
+	//Returns at most the given limit of rows, oldest first
+
+	postgresGetConversationMessagesAfterQuery = "SELECT messageid, recipientid, senderid, timestamp, type, text, url, height, width, source, delivered_at, read_at FROM messages WHERE ((senderid = $1 AND recipientid = $2) OR (senderid = $3 AND recipientid = $4)) AND messageid > $5 ORDER BY messageid ASC LIMIT $6"
+
+	//Gets the messages exchanged between the two users that have a messageid lower than the given messageid
+	//Returns at most the given limit of rows, newest first
+
+	postgresGetConversationMessagesBeforeQuery = "SELECT messageid, recipientid, senderid, timestamp, type, text, url, height, width, source, delivered_at, read_at FROM messages WHERE ((senderid = $1 AND recipientid = $2) OR (senderid = $3 AND recipientid = $4)) AND messageid < $5 ORDER BY messageid DESC LIMIT $6"
+
+	//Marks as delivered the messages of the recipient in the given messageid range that weren't delivered yet
+
+	postgresMarkMessagesDeliveredQuery = "UPDATE messages SET delivered_at = $1 WHERE recipientid = $2 AND messageid >= $3 AND messageid <= $4 AND delivered_at IS NULL"
+
+	//Marks as read the messages of the recipient up to the given messageid that weren't read yet
+	//The messages that weren't delivered are marked as delivered too
+
+	postgresMarkMessagesReadQuery = "UPDATE messages SET read_at = $1, delivered_at = COALESCE(delivered_at, $2) WHERE recipientid = $3 AND messageid <= $4 AND read_at IS NULL"
+
+	//Gets the delivery status of the messages sent by the sender that have a messageid greater that the given messageid
+	//Returns at most the given limit of rows
+
+	postgresGetSentMessageStatusesQuery = "SELECT messageid, recipientid, delivered_at, read_at FROM messages WHERE senderid = $1 AND messageid >= $2 ORDER BY messageid ASC LIMIT $3"
+
+	//Inserts a new group into the message_groups table and returns the generated groupid
+
+	postgresInsertNewGroupQuery = "INSERT INTO message_groups (name, ownerid, created) VALUES ($1, $2, $3) RETURNING groupid"
+
+	//Gets the group data for the given groupid
+
+	postgresGetFromGroupsQuery = "SELECT groupid, name, ownerid, created FROM message_groups WHERE groupid = $1"
+
+	//Adds a member to a group, adding an existing member has no effect
+
+	postgresInsertGroupMemberQuery = "INSERT INTO group_members (groupid, userid) VALUES ($1, $2) ON CONFLICT DO NOTHING"
+
+	//Removes a member from a group
+
+	postgresDeleteGroupMemberQuery = "DELETE FROM group_members WHERE groupid = $1 AND userid = $2"
+
+	//Checks if the user is a member of the group
+
+	postgresCheckGroupMemberQuery = "SELECT userid FROM group_members WHERE groupid = $1 AND userid = $2"
+
+	//Locks the group until the end of the transaction, so the concurrent member inserts are serialized
+
+	postgresLockGroupQuery = "SELECT groupid FROM message_groups WHERE groupid = $1 FOR UPDATE"
+
+	//Counts the members of the group
+
+	postgresCountGroupMembersQuery = "SELECT COUNT(*) FROM group_members WHERE groupid = $1"
+
+	//Gets the members of the group
+
+	postgresGetGroupMembersQuery = "SELECT userid FROM group_members WHERE groupid = $1 ORDER BY userid ASC"
+
+	//Inserts a new message into the group_messages table and returns the generated messageid
+
+	postgresInsertNewGroupMessageQuery = "INSERT INTO group_messages (groupid, senderid, timestamp, type, text, url, height, width, source) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING messageid"
+
+	//Gets all the messages of the group that have a messageid greater that the given messageid
+	//Returns at most the given limit of rows
+
+	postgresGetFromGroupMessagesQuery = "SELECT messageid, groupid, senderid, timestamp, type, text, url, height, width, source FROM group_messages WHERE groupid = $1 AND messageid >= $2 ORDER BY messageid ASC LIMIT $3"
+
+	//Checks if the schema_version table exists in the current schema
+
+	postgresCheckSchemaVersionTableQuery = "SELECT count(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'schema_version'"
+
+	//Checks if the users table exists in the current schema
+	//Used to detect databases created before the migrations existed
+
+	postgresCheckLegacyUsersTableQuery = "SELECT count(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'users'"
+
+	//Registers an applied migration
+
+	postgresInsertSchemaVersionQuery = "INSERT INTO schema_version (version, description, applied_at) VALUES ($1, $2, $3)"
+
+	//Removes a reverted migration
+
+	postgresDeleteSchemaVersionQuery = "DELETE FROM schema_version WHERE version = $1"
+
+	//Schema version table schema
+	//Keeps one row per applied migration, the current version is the greatest one
+
+	postgresSchemaVersionSchema = `CREATE TABLE schema_version (version BIGINT PRIMARY KEY, description TEXT, applied_at BIGINT);`
+
+	//Users table schema
+	//Allows efficent operations by using the index "idx_username" on the column username
+
+	postgresUsersSchema = `CREATE TABLE users (userid BIGSERIAL PRIMARY KEY, username TEXT, password BYTEA, password_salt BYTEA);
+CREATE UNIQUE INDEX idx_username ON users(username);
+`
+
+	//Tokens table schema
+	//Allows efficent operations by using the index "idx_tokens_userid" on the column userid
+	//The "purgetokens" trigger ensures there are only a maximum of 2 active sessions at the time by deleting the oldest
+	//token after each insert, the same way the sqlite trigger does
+
+	postgresTokensSchema = `CREATE TABLE tokens (userid BIGINT, token TEXT, expiration BIGINT);
+CREATE INDEX idx_tokens_userid ON tokens(userid);
+` + postgresPurgeTokensTrigger
+
+	postgresPurgeTokensTrigger = `CREATE FUNCTION purge_tokens() RETURNS trigger AS $$
+BEGIN
+IF (SELECT count(*) FROM tokens WHERE userid = NEW.userid) > 2 THEN
+DELETE FROM tokens WHERE userid = NEW.userid AND token IN (SELECT token FROM tokens WHERE userid = NEW.userid ORDER BY expiration ASC LIMIT 1);
+END IF;
+RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER purgetokens AFTER INSERT ON tokens FOR EACH ROW EXECUTE PROCEDURE purge_tokens();
+`
+
+	//MessagesSchema
+	//Allows efficent operations by using the index "idx_messages" first on the column recipientid and then on the column messageid
+
+	postgresMessagesSchema = `CREATE TABLE messages (
+ messageid BIGSERIAL PRIMARY KEY,
+ recipientid BIGINT,
+ senderid BIGINT,
+ timestamp TIMESTAMPTZ,
+ type TEXT CHECK ( type IN ('text','image','video')),
+ text TEXT,
+ url TEXT,
+ height BIGINT,
+ width BIGINT,
+ source TEXT CHECK ( source IN ('youtube','vimeo',''))
+);
+CREATE INDEX idx_messages ON messages(recipientid, messageid);
+`
+
+	//Groups schema
+	//Each group message is stored once in the group_messages table and shared by all the members
+
+	postgresGroupsSchema = `CREATE TABLE message_groups (groupid BIGSERIAL PRIMARY KEY, name TEXT, ownerid BIGINT, created TIMESTAMPTZ);
+CREATE TABLE group_members (groupid BIGINT, userid BIGINT, PRIMARY KEY (groupid, userid));
+CREATE INDEX idx_group_members_userid ON group_members(userid);
+CREATE TABLE group_messages (
+ messageid BIGSERIAL PRIMARY KEY,
+ groupid BIGINT,
+ senderid BIGINT,
+ timestamp TIMESTAMPTZ,
+ type TEXT CHECK ( type IN ('text','image','video')),
+ text TEXT,
+ url TEXT,
+ height BIGINT,
+ width BIGINT,
+ source TEXT CHECK ( source IN ('youtube','vimeo',''))
+);
+CREATE INDEX idx_group_messages ON group_messages(groupid, messageid);
+`
+
+	//Message status columns
+	//The delivered_at and read_at columns are NULL until the message is delivered or read
+
+	postgresMessageStatusSchema = `ALTER TABLE messages ADD COLUMN delivered_at TIMESTAMPTZ;
+ALTER TABLE messages ADD COLUMN read_at TIMESTAMPTZ;
+CREATE INDEX idx_messages_sender ON messages(senderid, messageid);
+`
+
+	//Sessions schema
+	//The "purgetokens" trigger is dropped, the session limit is enforced when inserting a token
+	//Existing tokens were issued for a year, so their creation time is their expiration minus a year
+
+	postgresSessionsSchema = `DROP TRIGGER purgetokens ON tokens;
+DROP FUNCTION purge_tokens();
+ALTER TABLE tokens ADD COLUMN sessionid BIGSERIAL PRIMARY KEY;
+ALTER TABLE tokens ADD COLUMN created BIGINT;
+ALTER TABLE tokens ADD COLUMN last_used BIGINT;
+ALTER TABLE tokens ADD COLUMN user_agent TEXT;
+UPDATE tokens SET created = expiration - 31536000000, last_used = expiration - 31536000000, user_agent = '';
+`
+
+	//Rotated tokens schema
+	//Keeps the replaced refresh tokens until their session expires
+
+	postgresRotatedTokensSchema = `CREATE TABLE rotated_tokens (token TEXT PRIMARY KEY, userid BIGINT, sessionid BIGINT, expiration BIGINT);
+CREATE INDEX idx_rotated_tokens_expiration ON rotated_tokens(expiration);
+`
+
+	//Statements reverting the tables schemas
+
+	postgresDropMessageStatusSchema = `DROP INDEX idx_messages_sender;
+ALTER TABLE messages DROP COLUMN read_at;
+ALTER TABLE messages DROP COLUMN delivered_at;
+`
+	postgresDropSessionsSchema = `ALTER TABLE tokens DROP COLUMN user_agent;
+ALTER TABLE tokens DROP COLUMN last_used;
+ALTER TABLE tokens DROP COLUMN created;
+ALTER TABLE tokens DROP COLUMN sessionid;
+` + postgresPurgeTokensTrigger
+	postgresDropTokensSchema = `DROP TABLE tokens;
+DROP FUNCTION purge_tokens();
+`
+)
@@ -0,0 +1,35 @@
+package dao
+
+import (
+	"os"
+	"testing"
+)
+
+//Environment variable holding the connection string of the postgres database used by the tests
+//Every table in the database is dropped by the tests
+
+const PostgresTestDataSourceEnv = "POSTGRES_TEST_DSN"
+
+//Opens the test postgres database and recreates its schema
+//Skips the test if no test database was configured
+
+func SetupPostgresDatabaseTest(t *testing.T) PostgresDB {
+	dataSourceName := os.Getenv(PostgresTestDataSourceEnv)
+	if dataSourceName == "" {
+		t.Skip(PostgresTestDataSourceEnv + " is not set")
+	}
+
+	db, err := OpenPostgresDatabase(dataSourceName)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	RefreshSchema(db)
+	return db
+}
+
+func TeardownPostgresDatabaseTest(postgres PostgresDB) {
+	postgres.MigrateDown(len(postgresMigrations))
+	postgres.db.Close()
+}
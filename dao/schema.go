@@ -1,8 +1,12 @@
 package dao
 
 const (
+	//Copies the write-ahead log, if the database uses one, into the database file and truncates it
+
+	checkpointQuery = "PRAGMA wal_checkpoint(TRUNCATE)"
+
 	//Gets the userid, password and salt from the users table.
-	//The password is encrypted using RSASHA256 using the salt
+	//The password is either an encoded hash with an empty salt or a legacy SSHA256 hash using the salt
 	//The query is eficient because its performed on the INDEX "idx_username"
 
 	getFromUsersQuery = "SELECT userid, password, password_salt FROM users WHERE username = ?"
@@ -15,14 +19,61 @@ const (
 
 	insertNewUserQuey = "INSERT INTO users (username, password, password_salt) VALUES (?, ?, ?)"
 
+	//Replaces the password and salt of the user
+
+	updateUserPasswordQuery = "UPDATE users SET password = ?, password_salt = ? WHERE userid = ?"
+
 	//Inserts a new token into the tokens table.
-	//Triggers the trigger "purgetokens" which ensures there are at most 2 tokens per user by deleting the oldest token.
 
-	insertNewTokenQuey = "INSERT INTO tokens (userid, token, expiration) VALUES (?, ?, ?)"
+	insertNewTokenQuey = "INSERT INTO tokens (userid, token, expiration, created, last_used, user_agent) VALUES (?, ?, ?, ?, ?, ?)"
+
+	//Deletes the oldest tokens of the user keeping only the given number of most recent sessions
+
+	purgeTokensQuery = "DELETE FROM tokens WHERE userid = ? AND sessionid NOT IN (SELECT sessionid FROM tokens WHERE userid = ? ORDER BY created DESC, sessionid DESC LIMIT ?)"
+
+	//Gets all the tokens corresponding to the user id ordered by session, which must then be verified
+
+	getFromTokensQuery = "SELECT sessionid, token, expiration, created, last_used, user_agent FROM tokens WHERE userid = ? ORDER BY sessionid"
+
+	//Gets the token and its user, the query is efficient because it's performed on the INDEX "idx_tokens_token"
+
+	getTokenQuery = "SELECT sessionid, userid, token, expiration, created, last_used, user_agent FROM tokens WHERE token = ?"
+
+	//Updates the last used time of the given token of the user
+
+	touchTokenQuery = "UPDATE tokens SET last_used = ? WHERE userid = ? AND token = ?"
+
+	//Gets the given session of the user, the query is efficient because it's performed on the PRIMARY KEY sessionid
+
+	checkSessionQuery = "SELECT sessionid FROM tokens WHERE sessionid = ? AND userid = ?"
+
+	//Deletes the given session of the user
+
+	deleteSessionQuery = "DELETE FROM tokens WHERE userid = ? AND sessionid = ?"
+
+	//Gets the session of the user with the given token
+
+	getSessionByTokenQuery = "SELECT sessionid, token, expiration, created, last_used, user_agent FROM tokens WHERE userid = ? AND token = ?"
+
+	//Replaces the token of the session, only if it wasn't replaced concurrently
+
+	rotateTokenQuery = "UPDATE tokens SET token = ?, last_used = ? WHERE sessionid = ? AND token = ?"
+
+	//Keeps a replaced refresh token to detect its reuse
+
+	insertRotatedTokenQuery = "INSERT INTO rotated_tokens (token, userid, sessionid, expiration) VALUES (?, ?, ?, ?)"
+
+	//Gets the session of a replaced refresh token of the user
+
+	getRotatedTokenQuery = "SELECT sessionid FROM rotated_tokens WHERE userid = ? AND token = ?"
+
+	//Deletes the replaced refresh tokens whose session expired
+
+	purgeRotatedTokensQuery = "DELETE FROM rotated_tokens WHERE expiration < ?"
 
-	//Gets all the tokens corresponding to the user id (at most 2), which must then be verified
+	//Deletes all the tokens of the user
 
-	getFromTokensQuery = "SELECT token, expiration FROM tokens WHERE userid = ?"
+	deleteTokensQuery = "DELETE FROM tokens WHERE userid = ?"
 
 	//Inserts a new message into the messages table
 
@@ -31,13 +82,108 @@ const (
 	//Gets all the messages corresponding to the recipient id that have a messageid greater that the given messageid
 	//Returns at most the given limit of rows
 
-	getFromMessagesQuery = "SELECT messageid, recipientid, senderid, timestamp, type, text, url, height, width, source FROM messages WHERE recipientid = ? AND messageid >= ? ORDER BY messageid ASC LIMIT ?"
+	getFromMessagesQuery = "SELECT messageid, recipientid, senderid, timestamp, type, text, url, height, width, source, delivered_at, read_at FROM messages WHERE recipientid = ? AND messageid >= ? ORDER BY messageid ASC LIMIT ?"
+
+	//Gets the messages exchanged between the two users that have a messageid greater than the given messageid
+	//Returns at most the given limit of rows, oldest first
+	//Uses the index "idx_messages_conversation" for each direction of the conversation
+
+	getConversationMessagesAfterQuery = "SELECT messageid, recipientid, senderid, timestamp, type, text, url, height, width, source, delivered_at, read_at FROM messages WHERE ((senderid = ? AND recipientid = ?) OR (senderid = ? AND recipientid = ?)) AND messageid > ? ORDER BY messageid ASC LIMIT ?"
+
+	//Gets the messages exchanged between the two users that have a messageid lower than the given messageid
+	//Returns at most the given limit of rows, newest first
+
+	getConversationMessagesBeforeQuery = "SELECT messageid, recipientid, senderid, timestamp, type, text, url, height, width, source, delivered_at, read_at FROM messages WHERE ((senderid = ? AND recipientid = ?) OR (senderid = ? AND recipientid = ?)) AND messageid < ? ORDER BY messageid DESC LIMIT ?"
+
+	//Marks as delivered the messages of the recipient in the given messageid range that weren't delivered yet
+
+	markMessagesDeliveredQuery = "UPDATE messages SET delivered_at = ? WHERE recipientid = ? AND messageid >= ? AND messageid <= ? AND delivered_at IS NULL"
+
+	//Marks as read the messages of the recipient up to the given messageid that weren't read yet
+	//The messages that weren't delivered are marked as delivered too
+
+	markMessagesReadQuery = "UPDATE messages SET read_at = ?, delivered_at = COALESCE(delivered_at, ?) WHERE recipientid = ? AND messageid <= ? AND read_at IS NULL"
+
+	//Gets the delivery status of the messages sent by the sender that have a messageid greater that the given messageid
+	//Returns at most the given limit of rows
+	//Uses the index "idx_messages_sender" first on the column senderid and then on the column messageid
+
+	getSentMessageStatusesQuery = "SELECT messageid, recipientid, delivered_at, read_at FROM messages WHERE senderid = ? AND messageid >= ? ORDER BY messageid ASC LIMIT ?"
+
+	//Inserts a new group into the message_groups table
+
+	insertNewGroupQuery = "INSERT INTO message_groups (name, ownerid, created) VALUES (?, ?, ?)"
+
+	//Gets the group data for the given groupid
+
+	getFromGroupsQuery = "SELECT groupid, name, ownerid, created FROM message_groups WHERE groupid = ?"
+
+	//Adds a member to a group, adding an existing member has no effect
+
+	insertGroupMemberQuery = "INSERT OR IGNORE INTO group_members (groupid, userid) VALUES (?, ?)"
+
+	//Removes a member from a group
+
+	deleteGroupMemberQuery = "DELETE FROM group_members WHERE groupid = ? AND userid = ?"
+
+	//Checks if the user is a member of the group
+	//The query is eficient because its performed on the primary key
+
+	checkGroupMemberQuery = "SELECT userid FROM group_members WHERE groupid = ? AND userid = ?"
+
+	//Counts the members of the group
+
+	countGroupMembersQuery = "SELECT COUNT(*) FROM group_members WHERE groupid = ?"
+
+	//Gets the members of the group
+
+	getGroupMembersQuery = "SELECT userid FROM group_members WHERE groupid = ? ORDER BY userid ASC"
+
+	//Inserts a new message into the group_messages table
+
+	insertNewGroupMessageQuery = "INSERT INTO group_messages (groupid, senderid, timestamp, type, text, url, height, width, source) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+	//Gets all the messages of the group that have a messageid greater that the given messageid
+	//Returns at most the given limit of rows
+
+	getFromGroupMessagesQuery = "SELECT messageid, groupid, senderid, timestamp, type, text, url, height, width, source FROM group_messages WHERE groupid = ? AND messageid >= ? ORDER BY messageid ASC LIMIT ?"
+
+	//Checks if the schema_version table exists
+
+	checkSchemaVersionTableQuery = "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'"
+
+	//Checks if the users table exists
+	//Used to detect databases created before the migrations existed
+
+	checkLegacyUsersTableQuery = "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'users'"
+
+	//Gets the latest applied migration version, 0 if there is none
+
+	getSchemaVersionQuery = "SELECT COALESCE(MAX(version), 0) FROM schema_version"
+
+	//Gets every applied migration version and the time it was applied in milliseconds
+
+	getAppliedMigrationsQuery = "SELECT version, applied_at FROM schema_version ORDER BY version ASC"
+
+	//Registers an applied migration
+
+	insertSchemaVersionQuery = "INSERT INTO schema_version (version, description, applied_at) VALUES (?, ?, ?)"
+
+	//Removes a reverted migration
+
+	deleteSchemaVersionQuery = "DELETE FROM schema_version WHERE version = ?"
+
+	//Schema version table schema
+	//Keeps one row per applied migration, the current version is the greatest one
+
+	schemaVersionSchema = `CREATE TABLE schema_version (version INTEGER PRIMARY KEY, description TEXT, applied_at INTEGER);`
 
 	//Users table schema
 	//Allows efficent operations by using the index "idx_username" on the column username
 
 	usersSchema = `CREATE TABLE users (userid INTEGER PRIMARY KEY, username TEXT, password TEXT, password_salt TEXT);
-				CREATE UNIQUE INDEX idx_username ON users(username);`
+				CREATE UNIQUE INDEX idx_username ON users(username);
+`
 
 	//Tokens table schema
 	//Allows efficent operations by using the index "idx_tokens_userid" on the column userid
@@ -51,7 +197,8 @@ CREATE TRIGGER purgetokens AFTER INSERT ON tokens
 WHEN (SELECT count(*) FROM tokens WHERE userid = NEW.userid) > 2
 BEGIN
 DELETE FROM tokens WHERE userid = NEW.userid AND token in (SELECT token FROM tokens WHERE userid = NEW.userid ORDER BY expiration ASC LIMIT 1);
-END;`
+END;
+`
 
 	//MessagesSchema
 	//Allows efficent operations by using the index "idx_messages" first on the column reciever_userid and then on the column mesageid
@@ -68,5 +215,114 @@ END;`
  width INTEGER,
  source TEXT CHECK ( source IN ('youtube','vimeo',''))
 );
-CREATE INDEX idx_messages ON messages(recipientid, messageid);`
+CREATE INDEX idx_messages ON messages(recipientid, messageid);
+`
+
+	//Groups schema
+	//Each group message is stored once in the group_messages table and shared by all the members
+	//Allows efficent operations by using the index "idx_group_messages" first on the column groupid and then on
+	//the column messageid, and the index "idx_group_members_userid" to find the groups of an user
+
+	groupsSchema = `CREATE TABLE message_groups (groupid INTEGER PRIMARY KEY, name TEXT, ownerid INTEGER, created DATE);
+CREATE TABLE group_members (groupid INTEGER, userid INTEGER, PRIMARY KEY (groupid, userid));
+CREATE INDEX idx_group_members_userid ON group_members(userid);
+CREATE TABLE group_messages (
+ messageid INTEGER PRIMARY KEY,
+ groupid INTEGER,
+ senderid INTEGER,
+ timestamp DATE,
+ type TEXT CHECK ( type IN ('text','image','video')),
+ text TEXT,
+ url TEXT,
+ height INTEGER,
+ width INTEGER,
+ source TEXT CHECK ( source IN ('youtube','vimeo',''))
+);
+CREATE INDEX idx_group_messages ON group_messages(groupid, messageid);
+`
+
+	//Conversation index
+	//Allows efficent conversation queries by using the index first on the column senderid, then on the column
+	//recipientid and then on the column messageid
+
+	conversationIndexSchema = `CREATE INDEX idx_messages_conversation ON messages(senderid, recipientid, messageid);
+`
+
+	//Message status columns
+	//The delivered_at and read_at columns are NULL until the message is delivered or read
+	//The index "idx_messages_sender" allows efficent queries of the messages sent by an user
+
+	messageStatusSchema = `ALTER TABLE messages ADD COLUMN delivered_at DATE;
+ALTER TABLE messages ADD COLUMN read_at DATE;
+CREATE INDEX idx_messages_sender ON messages(senderid, messageid);
+`
+
+	//Sessions schema
+	//Sqlite can't add a primary key to an existing table, so the tokens table is rebuilt with the session columns
+	//The "purgetokens" trigger is dropped, the session limit is enforced when inserting a token
+	//Existing tokens were issued for a year, so their creation time is their expiration minus a year
+
+	sessionsSchema = `DROP TRIGGER purgetokens;
+DROP INDEX idx_tokens_userid;
+ALTER TABLE tokens RENAME TO tokens_without_sessions;
+CREATE TABLE tokens (sessionid INTEGER PRIMARY KEY, userid INTEGER, token TEXT, expiration INTEGER, created INTEGER, last_used INTEGER, user_agent TEXT);
+CREATE INDEX idx_tokens_userid ON tokens(userid);
+INSERT INTO tokens (userid, token, expiration, created, last_used, user_agent) SELECT userid, token, expiration, expiration - 31536000000, expiration - 31536000000, '' FROM tokens_without_sessions;
+DROP TABLE tokens_without_sessions;
+`
+
+	//Rotated tokens schema
+	//Keeps the replaced refresh tokens until their session expires
+
+	rotatedTokensSchema = `CREATE TABLE rotated_tokens (token TEXT PRIMARY KEY, userid INTEGER, sessionid INTEGER, expiration INTEGER);
+CREATE INDEX idx_rotated_tokens_expiration ON rotated_tokens(expiration);
+`
+
+	//Tokens are stored as keyed hashes
+	//The plain text tokens can't be hashed without the server key, so they are revoked and the users must log in again
+
+	hashTokensSchema = `DELETE FROM rotated_tokens;
+DELETE FROM tokens;
+`
+
+	//Tokens index, the requests are authenticated by looking up their token without knowing the user
+
+	tokensIndexSchema = `CREATE UNIQUE INDEX idx_tokens_token ON tokens(token);
+`
+
+	//Statements reverting the tables schemas
+
+	dropUsersSchema             = `DROP TABLE users;`
+	dropTokensSchema            = `DROP TABLE tokens;`
+	dropMessagesSchema          = `DROP TABLE messages;`
+	dropConversationIndexSchema = `DROP INDEX idx_messages_conversation;
+`
+	dropTokensIndexSchema = `DROP INDEX idx_tokens_token;
+`
+	//Sqlite can't drop columns, so the messages table is rebuilt without the status columns
+
+	dropMessageStatusSchema = `DROP INDEX idx_messages_sender;
+DROP INDEX idx_messages_conversation;
+DROP INDEX idx_messages;
+ALTER TABLE messages RENAME TO messages_with_status;
+` + messagesSchema + conversationIndexSchema + `INSERT INTO messages SELECT messageid, recipientid, senderid, timestamp, type, text, url, height, width, source FROM messages_with_status;
+DROP TABLE messages_with_status;
+`
+	//The tokens table is rebuilt without the session columns, restoring the "purgetokens" trigger
+
+	dropSessionsSchema = `DROP INDEX idx_tokens_userid;
+ALTER TABLE tokens RENAME TO tokens_with_sessions;
+` + tokensSchema + `INSERT INTO tokens SELECT userid, token, expiration FROM tokens_with_sessions ORDER BY sessionid;
+DROP TABLE tokens_with_sessions;
+`
+	dropRotatedTokensSchema = `DROP TABLE rotated_tokens;
+`
+
+	//Older servers compare the tokens in plain text and would accept a stored hash as a token, so they are revoked too
+
+	dropHashTokensSchema = hashTokensSchema
+	dropGroupsSchema     = `DROP TABLE group_messages;
+DROP TABLE group_members;
+DROP TABLE message_groups;
+`
 )
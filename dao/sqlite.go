@@ -1,46 +1,126 @@
 package dao
 
 import (
-	"github.com/maidaneze/message-server/utils"
+	"context"
 	"database/sql"
 	"errors"
+	"github.com/maidaneze/message-server/utils"
 	"time"
 
 	"github.com/maidaneze/message-server/model"
+
+	"github.com/mattn/go-sqlite3"
 )
 
 type SqliteDB struct {
-	db *sql.DB
+	db    *sql.DB
+	retry RetryOptions
 }
 
+//Opens the sqlite database and applies the pending migrations
+//Returns ErrSchemaTooNew if the database was migrated by a newer version of the server
+//Returns error in case of failiure
+
 func OpenSqlite3Database(dbname string) (SqliteDB, error) {
+	sqlite, err := ConnectSqlite3Database(dbname)
+	if err != nil {
+		return sqlite, err
+	}
+	return sqlite, sqlite.MigrateUp()
+}
+
+//Opens the sqlite database without applying any migration
+//Returns error in case of failiure
+
+func ConnectSqlite3Database(dbname string) (SqliteDB, error) {
 	var db *sql.DB
 	var err error
 	err = utils.Retry(func() error {
 		db, err = sql.Open("sqlite3", dbname)
 		return err
-	}, 2, time.Millisecond*20)
-	return SqliteDB{db}, err
+	}, DefaultRetryOptions.Attempts, DefaultRetryOptions.Interval)
+	return SqliteDB{db: db}.WithRetry(DefaultRetryOptions), err
+}
+
+//Returns a copy of the database whose operations are retried with the given retry options
+//Only the busy and locked errors are retried, the rest fail the same way every time
+
+func (sqlite SqliteDB) WithRetry(retry RetryOptions) SqliteDB {
+	retry.retryable = isTransientSqliteError
+	sqlite.retry = retry
+	return sqlite
+}
+
+//Returns whether the error is transient, the database or one of its tables was locked by another connection
+
+func isTransientSqliteError(err error) bool {
+	sqliteErr, ok := err.(sqlite3.Error)
+	return ok && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
+
+//Runs the operation with the retry options of the database
+//Returns a conflict error if the operation violated a unique constraint, and the error of the operation otherwise
+
+func (sqlite SqliteDB) run(ctx context.Context, fn func(ctx context.Context) error) error {
+	err := sqlite.retry.run(ctx, fn)
+	if sqliteErr, ok := err.(sqlite3.Error); ok &&
+		(sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey) {
+		return conflictError(err)
+	}
+	return err
+}
+
+//Applies all the pending migrations
+//Returns error in case of failiure and nil in case of success
+
+func (sqlite SqliteDB) MigrateUp() error {
+	return migrator{sqlite.db, sqliteMigrations, sqliteMigrationQueries}.up()
+}
+
+//Reverts the given number of applied migrations, starting from the latest one
+//Returns error in case of failiure and nil in case of success
+
+func (sqlite SqliteDB) MigrateDown(steps int) error {
+	return migrator{sqlite.db, sqliteMigrations, sqliteMigrationQueries}.down(steps)
+}
+
+//Returns the status of every known migration
+//Returns error in case of failiure
+
+func (sqlite SqliteDB) MigrationStatus() ([]MigrationStatus, error) {
+	return migrator{sqlite.db, sqliteMigrations, sqliteMigrationQueries}.status()
+}
+
+//Checkpoints the write-ahead log into the database file and closes the database
+//The database is closed even if the checkpoint fails
+//Returns error in case of failiure and nil in case of success
+
+func (sqlite SqliteDB) Close() error {
+	_, err := sqlite.db.Exec(checkpointQuery)
+	if closeErr := sqlite.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
 //Wrapper function for "checkConnection"
 //Executes insertUser with a retry
 
-func (sqlite SqliteDB) CheckConnection() error {
+func (sqlite SqliteDB) CheckConnection(ctx context.Context) error {
 	var err error
-	err = utils.Retry(func() error {
-		err = sqlite.checkConnection()
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		err = sqlite.checkConnection(ctx)
 		return err
-	}, 2, time.Millisecond*20)
+	})
 	return err
 }
 
 //Verifies the connection to the database
 //Returns error in case of failiure and nil in case of success
 
-func (sqlite SqliteDB) checkConnection() error {
+func (sqlite SqliteDB) checkConnection(ctx context.Context) error {
 	var res int
-	if err := sqlite.db.QueryRow("SELECT 1").Scan(&res); err != nil {
+	if err := sqlite.db.QueryRowContext(ctx, "SELECT 1").Scan(&res); err != nil {
 		return err
 	}
 
@@ -53,24 +133,24 @@ func (sqlite SqliteDB) checkConnection() error {
 //Wrapper function for insertUser
 //Executes insertUser with a retry
 
-func (sqlite SqliteDB) InsertUser(user model.User) (model.User, error) {
+func (sqlite SqliteDB) InsertUser(ctx context.Context, user model.User) (model.User, error) {
 	var insertedUser model.User
 	var err error
-	err = utils.Retry(func() error {
-		insertedUser, err = sqlite.insertUser(user)
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		insertedUser, err = sqlite.insertUser(ctx, user)
 		return err
-	}, 2, time.Millisecond*20)
+	})
 	return insertedUser, err
 }
 
 //Inserts a new user into the users tables
 //Returns error in case of failiure and the inserted user in case of success
 
-func (sqlite SqliteDB) insertUser(user model.User) (model.User, error) {
+func (sqlite SqliteDB) insertUser(ctx context.Context, user model.User) (model.User, error) {
 	//Insert user
 	var insertResult sql.Result
 	var err error
-	if insertResult, err = sqlite.db.Exec(insertNewUserQuey, user.Username, user.Password, user.Salt); err != nil {
+	if insertResult, err = sqlite.db.ExecContext(ctx, insertNewUserQuey, user.Username, user.Password, user.Salt); err != nil {
 		return model.User{}, err
 	}
 
@@ -82,14 +162,14 @@ func (sqlite SqliteDB) insertUser(user model.User) (model.User, error) {
 //Wrapper function for getUser
 //Executes getUser with a retry
 
-func (sqlite SqliteDB) GetUser(username string) (model.User, bool, error) {
+func (sqlite SqliteDB) GetUser(ctx context.Context, username string) (model.User, bool, error) {
 	var getUser model.User
 	var err error
 	var found bool
-	err = utils.Retry(func() error {
-		getUser, found, err = sqlite.getUser(username)
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		getUser, found, err = sqlite.getUser(ctx, username)
 		return err
-	}, 2, time.Millisecond*20)
+	})
 	return getUser, found, err
 }
 
@@ -97,12 +177,12 @@ func (sqlite SqliteDB) GetUser(username string) (model.User, bool, error) {
 //Returns true if an user was found, false otherwise
 //Returns error in case of failiure and the user in case of success
 
-func (sqlite SqliteDB) getUser(username string) (model.User, bool, error) {
+func (sqlite SqliteDB) getUser(ctx context.Context, username string) (model.User, bool, error) {
 	var userid int64
 	var password string
 	var password_salt string
 
-	if err := sqlite.db.QueryRow(getFromUsersQuery, username).Scan(&userid, &password, &password_salt); err != nil {
+	if err := sqlite.db.QueryRowContext(ctx, getFromUsersQuery, username).Scan(&userid, &password, &password_salt); err != nil {
 		if err == sql.ErrNoRows {
 			return model.User{}, false, nil
 		} else {
@@ -117,13 +197,13 @@ func (sqlite SqliteDB) getUser(username string) (model.User, bool, error) {
 //Wrapper function for checkUserExists
 //Executes checkUserExists with a retry
 
-func (sqlite SqliteDB) CheckUserExists(userid int64) (bool, error) {
+func (sqlite SqliteDB) CheckUserExists(ctx context.Context, userid int64) (bool, error) {
 	var err error
 	var found bool
-	err = utils.Retry(func() error {
-		found, err = sqlite.checkUserExists(userid)
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		found, err = sqlite.checkUserExists(ctx, userid)
 		return err
-	}, 2, time.Millisecond*20)
+	})
 	return found, err
 }
 
@@ -131,9 +211,9 @@ func (sqlite SqliteDB) CheckUserExists(userid int64) (bool, error) {
 //Returns true if an user was found, false otherwise
 //Returns error in case of failure
 
-func (sqlite SqliteDB) checkUserExists(userid int64) (bool, error) {
+func (sqlite SqliteDB) checkUserExists(ctx context.Context, userid int64) (bool, error) {
 
-	if err := sqlite.db.QueryRow(checkUsersQuery, userid).Scan(&userid); err != nil {
+	if err := sqlite.db.QueryRowContext(ctx, checkUsersQuery, userid).Scan(&userid); err != nil {
 		if err == sql.ErrNoRows {
 			return false, nil
 		} else {
@@ -144,60 +224,106 @@ func (sqlite SqliteDB) checkUserExists(userid int64) (bool, error) {
 	return true, nil
 }
 
-//Wrapper function for insertToken
-//Executes insertToken with a retry
+//Wrapper function for updateUserPassword
+//Executes updateUserPassword with a retry
 
-func (sqlite SqliteDB) InsertToken(userid int64, token model.Token) error {
+func (sqlite SqliteDB) UpdateUserPassword(ctx context.Context, user model.User) error {
 	var err error
-	err = utils.Retry(func() error {
-		err = sqlite.insertToken(userid, token)
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		err = sqlite.updateUserPassword(ctx, user)
 		return err
-	}, 2, time.Millisecond*20)
+	})
 	return err
 }
 
-//Inserts the given token into the tokens table for the requested userid
+//Replaces the password and salt of the user with the ones of the given user
 //Returns error in case of failiure and nil in case of success
 
-func (sqlite SqliteDB) insertToken(userid int64, token model.Token) error {
-	//Insert token
+func (sqlite SqliteDB) updateUserPassword(ctx context.Context, user model.User) error {
+	_, err := sqlite.db.ExecContext(ctx, updateUserPasswordQuery, user.Password, user.Salt, user.Userid)
+	return err
+}
+
+//Wrapper function for insertToken
+//Executes insertToken with a retry
+
+func (sqlite SqliteDB) InsertToken(ctx context.Context, userid int64, token model.Token, maxSessions int) (model.Token, error) {
+	var insertedToken model.Token
 	var err error
-	if _, err = sqlite.db.Exec(insertNewTokenQuey, userid, token.Uuid, token.Expiration); err != nil {
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		insertedToken, err = sqlite.insertToken(ctx, userid, token, maxSessions)
 		return err
+	})
+	return insertedToken, err
+}
+
+//Inserts the given token into the tokens table for the requested userid and deletes the oldest sessions
+//exceeding maxSessions in a single transaction
+//Returns error in case of failiure and the inserted token with its session id in case of success
+
+func (sqlite SqliteDB) insertToken(ctx context.Context, userid int64, token model.Token, maxSessions int) (model.Token, error) {
+	tx, err := sqlite.db.BeginTx(ctx, nil)
+	if err != nil {
+		return token, err
 	}
 
-	return nil
+	//Insert token
+	insertResult, err := tx.ExecContext(ctx, insertNewTokenQuey, userid, token.Uuid, token.Expiration, token.Created, token.LastUsed, token.UserAgent)
+	if err != nil {
+		tx.Rollback()
+		return token, err
+	}
+	id, err := insertResult.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return token, err
+	}
+
+	//Purge the oldest sessions
+	if maxSessions > 0 {
+		if _, err = tx.ExecContext(ctx, purgeTokensQuery, userid, userid, maxSessions); err != nil {
+			tx.Rollback()
+			return token, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return token, err
+	}
+	token.SessionId = id
+	token.Userid = userid
+	return token, nil
 }
 
 //Wrapper function for getTokens
 //Executes getTokens with a retry
 
-func (sqlite SqliteDB) GetTokens(userid int64) ([]model.Token, error) {
+func (sqlite SqliteDB) GetTokens(ctx context.Context, userid int64) ([]model.Token, error) {
 	var getTokens []model.Token
 	var err error
-	err = utils.Retry(func() error {
-		getTokens, err = sqlite.getTokens(userid)
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		getTokens, err = sqlite.getTokens(ctx, userid)
 		return err
-	}, 2, time.Millisecond*20)
+	})
 	return getTokens, err
 }
 
-//Recovers the access tokens for the requested userid (at most 2)
+//Recovers the access tokens for the requested userid ordered by session
 //Returns error in case of failiure and nil in case of success
 
-func (sqlite SqliteDB) getTokens(userid int64) ([]model.Token, error) {
+func (sqlite SqliteDB) getTokens(ctx context.Context, userid int64) ([]model.Token, error) {
 
 	var err error
 	var rows *sql.Rows
-	if rows, err = sqlite.db.Query(getFromTokensQuery, userid); err != nil {
+	if rows, err = sqlite.db.QueryContext(ctx, getFromTokensQuery, userid); err != nil {
 		return nil, err
 	}
 
 	tokens := make([]model.Token, 0)
 	defer rows.Close()
 	for rows.Next() {
-		token := model.Token{}
-		err = rows.Scan(&token.Uuid, &token.Expiration)
+		token := model.Token{Userid: userid}
+		err = rows.Scan(&token.SessionId, &token.Uuid, &token.Expiration, &token.Created, &token.LastUsed, &token.UserAgent)
 		if err != nil {
 			return nil, err
 		}
@@ -207,27 +333,254 @@ func (sqlite SqliteDB) getTokens(userid int64) ([]model.Token, error) {
 	return tokens, nil
 }
 
+//Wrapper function for getToken
+//Executes getToken with a retry
+
+func (sqlite SqliteDB) GetToken(ctx context.Context, token string) (model.Token, bool, error) {
+	var getToken model.Token
+	var found bool
+	var err error
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		getToken, found, err = sqlite.getToken(ctx, token)
+		return err
+	})
+	return getToken, found, err
+}
+
+//Recovers the given token with its user and session
+//Returns true if the token was found, false otherwise
+//Returns error in case of failiure
+
+func (sqlite SqliteDB) getToken(ctx context.Context, token string) (model.Token, bool, error) {
+	getToken := model.Token{}
+	err := sqlite.db.QueryRowContext(ctx, getTokenQuery, token).Scan(&getToken.SessionId, &getToken.Userid, &getToken.Uuid, &getToken.Expiration, &getToken.Created, &getToken.LastUsed, &getToken.UserAgent)
+	if err == sql.ErrNoRows {
+		return model.Token{}, false, nil
+	}
+	if err != nil {
+		return model.Token{}, false, err
+	}
+	return getToken, true, nil
+}
+
+//Wrapper function for deleteTokens
+//Executes deleteTokens with a retry
+
+func (sqlite SqliteDB) DeleteTokens(ctx context.Context, userid int64) (int64, error) {
+	var deleted int64
+	var err error
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		deleted, err = sqlite.deleteTokens(ctx, userid)
+		return err
+	})
+	return deleted, err
+}
+
+//Deletes all the tokens of the user
+//Returns the number of deleted tokens
+//Returns error in case of failiure
+
+func (sqlite SqliteDB) deleteTokens(ctx context.Context, userid int64) (int64, error) {
+	deleteResult, err := sqlite.db.ExecContext(ctx, deleteTokensQuery, userid)
+	if err != nil {
+		return 0, err
+	}
+	return deleteResult.RowsAffected()
+}
+
+//Wrapper function for touchToken
+//Executes touchToken with a retry
+
+func (sqlite SqliteDB) TouchToken(ctx context.Context, userid int64, token string, lastUsed int64) error {
+	var err error
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		err = sqlite.touchToken(ctx, userid, token, lastUsed)
+		return err
+	})
+	return err
+}
+
+//Updates the last used time of the given token of the user
+//Returns error in case of failiure and nil in case of success
+
+func (sqlite SqliteDB) touchToken(ctx context.Context, userid int64, token string, lastUsed int64) error {
+	_, err := sqlite.db.ExecContext(ctx, touchTokenQuery, lastUsed, userid, token)
+	return err
+}
+
+//Wrapper function for checkSession
+//Executes checkSession with a retry
+
+func (sqlite SqliteDB) CheckSession(ctx context.Context, userid int64, sessionId int64) (bool, error) {
+	var found bool
+	var err error
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		found, err = sqlite.checkSession(ctx, userid, sessionId)
+		return err
+	})
+	return found, err
+}
+
+//Checks if the given session of the user exists
+//Returns true if the session was found, false otherwise
+//Returns error in case of failiure
+
+func (sqlite SqliteDB) checkSession(ctx context.Context, userid int64, sessionId int64) (bool, error) {
+	if err := sqlite.db.QueryRowContext(ctx, checkSessionQuery, sessionId, userid).Scan(&sessionId); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+//Wrapper function for deleteSession
+//Executes deleteSession with a retry
+
+func (sqlite SqliteDB) DeleteSession(ctx context.Context, userid int64, sessionId int64) (bool, error) {
+	var deleted bool
+	var err error
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		deleted, err = sqlite.deleteSession(ctx, userid, sessionId)
+		return err
+	})
+	return deleted, err
+}
+
+//Deletes the given session of the user
+//Returns true if the session was found, false otherwise
+//Returns error in case of failiure
+
+func (sqlite SqliteDB) deleteSession(ctx context.Context, userid int64, sessionId int64) (bool, error) {
+	deleteResult, err := sqlite.db.ExecContext(ctx, deleteSessionQuery, userid, sessionId)
+	if err != nil {
+		return false, err
+	}
+	affected, err := deleteResult.RowsAffected()
+	return affected > 0, err
+}
+
+//Wrapper function for rotateRefreshToken
+//Executes rotateRefreshToken with a retry
+
+func (sqlite SqliteDB) RotateRefreshToken(ctx context.Context, userid int64, token string, newToken string, now int64) (model.Token, RefreshResult, error) {
+	var session model.Token
+	var result RefreshResult
+	var err error
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		session, result, err = sqlite.rotateRefreshToken(ctx, userid, token, newToken, now)
+		return err
+	})
+	return session, result, err
+}
+
+//Replaces the refresh token of the user session with the new refresh token in a single transaction
+//A token that was already rotated, or is rotated concurrently, revokes its session
+//Returns the result of the rotation and the updated session in case of success
+//Returns error in case of failiure
+
+func (sqlite SqliteDB) rotateRefreshToken(ctx context.Context, userid int64, token string, newToken string, now int64) (model.Token, RefreshResult, error) {
+	tx, err := sqlite.db.BeginTx(ctx, nil)
+	if err != nil {
+		return model.Token{}, RefreshNotFound, err
+	}
+
+	//Purge the rotated tokens of expired sessions
+	if _, err = tx.ExecContext(ctx, purgeRotatedTokensQuery, now); err != nil {
+		tx.Rollback()
+		return model.Token{}, RefreshNotFound, err
+	}
+
+	//Get session
+	session := model.Token{}
+	err = tx.QueryRowContext(ctx, getSessionByTokenQuery, userid, token).Scan(&session.SessionId, &session.Uuid, &session.Expiration, &session.Created, &session.LastUsed, &session.UserAgent)
+	if err == sql.ErrNoRows {
+		var sessionId int64
+		err = tx.QueryRowContext(ctx, getRotatedTokenQuery, userid, token).Scan(&sessionId)
+		if err == sql.ErrNoRows {
+			return model.Token{}, RefreshNotFound, tx.Commit()
+		}
+		if err != nil {
+			tx.Rollback()
+			return model.Token{}, RefreshNotFound, err
+		}
+		return model.Token{}, RefreshReused, sqlite.revokeSession(ctx, tx, userid, sessionId)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return model.Token{}, RefreshNotFound, err
+	}
+
+	if session.Expiration < now {
+		return model.Token{}, RefreshNotFound, tx.Commit()
+	}
+
+	//Rotate token
+	rotateResult, err := tx.ExecContext(ctx, rotateTokenQuery, newToken, now, session.SessionId, token)
+	if err != nil {
+		tx.Rollback()
+		return model.Token{}, RefreshNotFound, err
+	}
+
+	affected, err := rotateResult.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return model.Token{}, RefreshNotFound, err
+	}
+
+	if affected == 0 {
+		return model.Token{}, RefreshReused, sqlite.revokeSession(ctx, tx, userid, session.SessionId)
+	}
+
+	if _, err = tx.ExecContext(ctx, insertRotatedTokenQuery, token, userid, session.SessionId, session.Expiration); err != nil {
+		tx.Rollback()
+		return model.Token{}, RefreshNotFound, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return model.Token{}, RefreshNotFound, err
+	}
+
+	session.Userid = userid
+	session.Uuid = newToken
+	session.LastUsed = now
+	return session, RefreshRotated, nil
+}
+
+//Deletes the session and commits the transaction
+//Returns error in case of failiure and nil in case of success
+
+func (sqlite SqliteDB) revokeSession(ctx context.Context, tx *sql.Tx, userid int64, sessionId int64) error {
+	if _, err := tx.ExecContext(ctx, deleteSessionQuery, userid, sessionId); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
 //Wrapper function for insertMessage
 //Executes insertMessage with a retry
 
-func (sqlite SqliteDB) InsertMessage(message model.MessageDTO) (model.MessageDTO, error) {
+func (sqlite SqliteDB) InsertMessage(ctx context.Context, message model.MessageDTO) (model.MessageDTO, error) {
 	var insertedMessage model.MessageDTO
 	var err error
-	err = utils.Retry(func() error {
-		insertedMessage, err = sqlite.insertMessage(message)
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		insertedMessage, err = sqlite.insertMessage(ctx, message)
 		return err
-	}, 2, time.Millisecond*20)
+	})
 	return insertedMessage, err
 }
 
 //Inserts the given message into the messages table
 //Returns error in case of failiure and nil in case of success
 
-func (sqlite SqliteDB) insertMessage(message model.MessageDTO) (model.MessageDTO, error) {
+func (sqlite SqliteDB) insertMessage(ctx context.Context, message model.MessageDTO) (model.MessageDTO, error) {
 	//Insert message
 	var err error
 	var insertResult sql.Result
-	if insertResult, err = sqlite.db.Exec(insertNewMessageQuey, message.RecipientId, message.SenderId, message.Timestamp, message.Type, message.Text, message.Url, message.Height, message.Width, message.Source); err != nil {
+	if insertResult, err = sqlite.db.ExecContext(ctx, insertNewMessageQuey, message.RecipientId, message.SenderId, message.Timestamp, message.Type, message.Text, message.Url, message.Height, message.Width, message.Source); err != nil {
 		return message, err
 	}
 	id, err := insertResult.LastInsertId()
@@ -238,24 +591,24 @@ func (sqlite SqliteDB) insertMessage(message model.MessageDTO) (model.MessageDTO
 //Wrapper function for getMessages
 //Executes getMessages with a retry
 
-func (sqlite SqliteDB) GetMessages(recipientId int64, messageId int64, limit int64) ([]model.MessageDTO, error) {
+func (sqlite SqliteDB) GetMessages(ctx context.Context, recipientId int64, messageId int64, limit int64) ([]model.MessageDTO, error) {
 	var getMessages []model.MessageDTO
 	var err error
-	err = utils.Retry(func() error {
-		getMessages, err = sqlite.getMessages(recipientId, messageId, limit)
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		getMessages, err = sqlite.getMessages(ctx, recipientId, messageId, limit)
 		return err
-	}, 2, time.Millisecond*20)
+	})
 	return getMessages, err
 }
 
-//Recovers the access tokens for the requested userid (at most 2)
+//Recovers the access tokens for the requested userid ordered by session
 //Returns error in case of failiure and nil in case of success
 
-func (sqlite SqliteDB) getMessages(recipientId int64, messageId int64, limit int64) ([]model.MessageDTO, error) {
+func (sqlite SqliteDB) getMessages(ctx context.Context, recipientId int64, messageId int64, limit int64) ([]model.MessageDTO, error) {
 
 	var err error
 	var rows *sql.Rows
-	if rows, err = sqlite.db.Query(getFromMessagesQuery, recipientId, messageId, limit); err != nil {
+	if rows, err = sqlite.db.QueryContext(ctx, getFromMessagesQuery, recipientId, messageId, limit); err != nil {
 		return nil, err
 	}
 
@@ -263,7 +616,7 @@ func (sqlite SqliteDB) getMessages(recipientId int64, messageId int64, limit int
 	defer rows.Close()
 	for rows.Next() {
 		message := model.MessageDTO{}
-		err = rows.Scan(&message.MessageId, &message.RecipientId, &message.SenderId, &message.Timestamp, &message.Type, &message.Text, &message.Url, &message.Height, &message.Width, &message.Source)
+		err = rows.Scan(&message.MessageId, &message.RecipientId, &message.SenderId, &message.Timestamp, &message.Type, &message.Text, &message.Url, &message.Height, &message.Width, &message.Source, &message.Delivered, &message.Read)
 		if err != nil {
 			return nil, err
 		}
@@ -272,3 +625,128 @@ func (sqlite SqliteDB) getMessages(recipientId int64, messageId int64, limit int
 
 	return messages, nil
 }
+
+//Wrapper function for getConversationMessages
+//Executes getConversationMessages with a retry
+
+func (sqlite SqliteDB) GetConversationMessages(ctx context.Context, userId int64, otherUserId int64, messageId int64, limit int64, before bool) ([]model.MessageDTO, error) {
+	var getMessages []model.MessageDTO
+	var err error
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		getMessages, err = sqlite.getConversationMessages(ctx, userId, otherUserId, messageId, limit, before)
+		return err
+	})
+	return getMessages, err
+}
+
+//Recovers the messages exchanged between the two users after or before the given messageid
+//Returns the messages ordered by messageid, oldest first
+//Returns error in case of failiure and nil in case of success
+
+func (sqlite SqliteDB) getConversationMessages(ctx context.Context, userId int64, otherUserId int64, messageId int64, limit int64, before bool) ([]model.MessageDTO, error) {
+	query := getConversationMessagesAfterQuery
+	if before {
+		query = getConversationMessagesBeforeQuery
+	}
+
+	rows, err := sqlite.db.QueryContext(ctx, query, userId, otherUserId, otherUserId, userId, messageId, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]model.MessageDTO, 0)
+	defer rows.Close()
+	for rows.Next() {
+		message := model.MessageDTO{}
+		err = rows.Scan(&message.MessageId, &message.RecipientId, &message.SenderId, &message.Timestamp, &message.Type, &message.Text, &message.Url, &message.Height, &message.Width, &message.Source, &message.Delivered, &message.Read)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	if before {
+		reverseMessages(messages)
+	}
+	return messages, rows.Err()
+}
+
+//Wrapper function for markMessagesDelivered
+//Executes markMessagesDelivered with a retry
+
+func (sqlite SqliteDB) MarkMessagesDelivered(ctx context.Context, recipientId int64, fromMessageId int64, toMessageId int64, delivered time.Time) error {
+	var err error
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		err = sqlite.markMessagesDelivered(ctx, recipientId, fromMessageId, toMessageId, delivered)
+		return err
+	})
+	return err
+}
+
+//Marks as delivered the messages of the recipient in the given messageid range that weren't delivered yet
+//Returns error in case of failiure and nil in case of success
+
+func (sqlite SqliteDB) markMessagesDelivered(ctx context.Context, recipientId int64, fromMessageId int64, toMessageId int64, delivered time.Time) error {
+	_, err := sqlite.db.ExecContext(ctx, markMessagesDeliveredQuery, delivered, recipientId, fromMessageId, toMessageId)
+	return err
+}
+
+//Wrapper function for markMessagesRead
+//Executes markMessagesRead with a retry
+
+func (sqlite SqliteDB) MarkMessagesRead(ctx context.Context, recipientId int64, messageId int64, read time.Time) (int64, error) {
+	var marked int64
+	var err error
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		marked, err = sqlite.markMessagesRead(ctx, recipientId, messageId, read)
+		return err
+	})
+	return marked, err
+}
+
+//Marks as read the messages of the recipient up to the given messageid that weren't read yet
+//Returns the number of messages marked as read
+//Returns error in case of failiure
+
+func (sqlite SqliteDB) markMessagesRead(ctx context.Context, recipientId int64, messageId int64, read time.Time) (int64, error) {
+	updateResult, err := sqlite.db.ExecContext(ctx, markMessagesReadQuery, read, read, recipientId, messageId)
+	if err != nil {
+		return 0, err
+	}
+	return updateResult.RowsAffected()
+}
+
+//Wrapper function for getSentMessageStatuses
+//Executes getSentMessageStatuses with a retry
+
+func (sqlite SqliteDB) GetSentMessageStatuses(ctx context.Context, senderId int64, messageId int64, limit int64) ([]model.MessageStatus, error) {
+	var statuses []model.MessageStatus
+	var err error
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		statuses, err = sqlite.getSentMessageStatuses(ctx, senderId, messageId, limit)
+		return err
+	})
+	return statuses, err
+}
+
+//Recovers the delivery status of the messages sent by the sender that have a messageid greater than the given messageid
+//Returns error in case of failiure and nil in case of success
+
+func (sqlite SqliteDB) getSentMessageStatuses(ctx context.Context, senderId int64, messageId int64, limit int64) ([]model.MessageStatus, error) {
+	rows, err := sqlite.db.QueryContext(ctx, getSentMessageStatusesQuery, senderId, messageId, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]model.MessageStatus, 0)
+	defer rows.Close()
+	for rows.Next() {
+		status := model.MessageStatus{}
+		if err = rows.Scan(&status.MessageId, &status.RecipientId, &status.Delivered, &status.Read); err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, rows.Err()
+}
@@ -0,0 +1,277 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/maidaneze/message-server/model"
+)
+
+//Wrapper function for insertGroup
+//Executes insertGroup with a retry
+
+func (sqlite SqliteDB) InsertGroup(ctx context.Context, group model.Group, members []int64) (model.Group, error) {
+	var insertedGroup model.Group
+	var err error
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		insertedGroup, err = sqlite.insertGroup(ctx, group, members)
+		return err
+	})
+	return insertedGroup, err
+}
+
+//Inserts the group and its initial members in a single transaction
+//Returns error in case of failiure and the inserted group in case of success
+
+func (sqlite SqliteDB) insertGroup(ctx context.Context, group model.Group, members []int64) (model.Group, error) {
+	tx, err := sqlite.db.BeginTx(ctx, nil)
+	if err != nil {
+		return group, err
+	}
+
+	insertResult, err := tx.ExecContext(ctx, insertNewGroupQuery, group.Name, group.OwnerId, group.Created)
+	if err != nil {
+		tx.Rollback()
+		return group, err
+	}
+	id, err := insertResult.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return group, err
+	}
+
+	for _, member := range members {
+		if _, err = tx.ExecContext(ctx, insertGroupMemberQuery, id, member); err != nil {
+			tx.Rollback()
+			return group, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return group, err
+	}
+	group.GroupId = id
+	return group, nil
+}
+
+//Wrapper function for getGroup
+//Executes getGroup with a retry
+
+func (sqlite SqliteDB) GetGroup(ctx context.Context, groupId int64) (model.Group, bool, error) {
+	var getGroup model.Group
+	var found bool
+	var err error
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		getGroup, found, err = sqlite.getGroup(ctx, groupId)
+		return err
+	})
+	return getGroup, found, err
+}
+
+//Gets the given group data
+//Returns true if a group was found, false otherwise
+//Returns error in case of failiure
+
+func (sqlite SqliteDB) getGroup(ctx context.Context, groupId int64) (model.Group, bool, error) {
+	group := model.Group{}
+	if err := sqlite.db.QueryRowContext(ctx, getFromGroupsQuery, groupId).Scan(&group.GroupId, &group.Name, &group.OwnerId, &group.Created); err != nil {
+		if err == sql.ErrNoRows {
+			return model.Group{}, false, nil
+		}
+		return model.Group{}, false, err
+	}
+	return group, true, nil
+}
+
+//Wrapper function for checkGroupMember
+//Executes checkGroupMember with a retry
+
+func (sqlite SqliteDB) CheckGroupMember(ctx context.Context, groupId int64, userid int64) (bool, error) {
+	var found bool
+	var err error
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		found, err = sqlite.checkGroupMember(ctx, groupId, userid)
+		return err
+	})
+	return found, err
+}
+
+//Checks if the user is a member of the group
+//Returns error in case of failiure
+
+func (sqlite SqliteDB) checkGroupMember(ctx context.Context, groupId int64, userid int64) (bool, error) {
+	if err := sqlite.db.QueryRowContext(ctx, checkGroupMemberQuery, groupId, userid).Scan(&userid); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+//Wrapper function for insertGroupMember
+//Executes insertGroupMember with a retry
+
+func (sqlite SqliteDB) InsertGroupMember(ctx context.Context, groupId int64, userid int64, maxMembers int) error {
+	var err error
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		err = sqlite.insertGroupMember(ctx, groupId, userid, maxMembers)
+		return err
+	})
+	return err
+}
+
+//Adds the user to the group, adding an existing member has no effect
+//The members are counted after the insert in the same transaction, which holds the sqlite write lock
+//Returns ErrGroupFull if the group already has maxMembers members
+//Returns error in case of failiure and nil in case of success
+
+func (sqlite SqliteDB) insertGroupMember(ctx context.Context, groupId int64, userid int64, maxMembers int) error {
+	tx, err := sqlite.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, insertGroupMemberQuery, groupId, userid); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var count int
+	if err = tx.QueryRowContext(ctx, countGroupMembersQuery, groupId).Scan(&count); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if count > maxMembers {
+		tx.Rollback()
+		return ErrGroupFull
+	}
+	return tx.Commit()
+}
+
+//Wrapper function for deleteGroupMember
+//Executes deleteGroupMember with a retry
+
+func (sqlite SqliteDB) DeleteGroupMember(ctx context.Context, groupId int64, userid int64) (bool, error) {
+	var deleted bool
+	var err error
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		deleted, err = sqlite.deleteGroupMember(ctx, groupId, userid)
+		return err
+	})
+	return deleted, err
+}
+
+//Removes the user from the group
+//Returns true if the user was a member, false otherwise
+//Returns error in case of failiure
+
+func (sqlite SqliteDB) deleteGroupMember(ctx context.Context, groupId int64, userid int64) (bool, error) {
+	deleteResult, err := sqlite.db.ExecContext(ctx, deleteGroupMemberQuery, groupId, userid)
+	if err != nil {
+		return false, err
+	}
+	affected, err := deleteResult.RowsAffected()
+	return affected > 0, err
+}
+
+//Wrapper function for getGroupMembers
+//Executes getGroupMembers with a retry
+
+func (sqlite SqliteDB) GetGroupMembers(ctx context.Context, groupId int64) ([]int64, error) {
+	var members []int64
+	var err error
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		members, err = sqlite.getGroupMembers(ctx, groupId)
+		return err
+	})
+	return members, err
+}
+
+//Recovers the user ids of the group members
+//Returns error in case of failiure and nil in case of success
+
+func (sqlite SqliteDB) getGroupMembers(ctx context.Context, groupId int64) ([]int64, error) {
+	rows, err := sqlite.db.QueryContext(ctx, getGroupMembersQuery, groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]int64, 0)
+	defer rows.Close()
+	for rows.Next() {
+		var member int64
+		if err = rows.Scan(&member); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+
+	return members, rows.Err()
+}
+
+//Wrapper function for insertGroupMessage
+//Executes insertGroupMessage with a retry
+
+func (sqlite SqliteDB) InsertGroupMessage(ctx context.Context, message model.GroupMessageDTO) (model.GroupMessageDTO, error) {
+	var insertedMessage model.GroupMessageDTO
+	var err error
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		insertedMessage, err = sqlite.insertGroupMessage(ctx, message)
+		return err
+	})
+	return insertedMessage, err
+}
+
+//Inserts the given message into the group_messages table
+//Returns error in case of failiure and the inserted message in case of success
+
+func (sqlite SqliteDB) insertGroupMessage(ctx context.Context, message model.GroupMessageDTO) (model.GroupMessageDTO, error) {
+	insertResult, err := sqlite.db.ExecContext(ctx, insertNewGroupMessageQuery, message.GroupId, message.SenderId, message.Timestamp, message.Type, message.Text, message.Url, message.Height, message.Width, message.Source)
+	if err != nil {
+		return message, err
+	}
+	id, err := insertResult.LastInsertId()
+	if err != nil {
+		return message, err
+	}
+	message.MessageId = id
+	return message, nil
+}
+
+//Wrapper function for getGroupMessages
+//Executes getGroupMessages with a retry
+
+func (sqlite SqliteDB) GetGroupMessages(ctx context.Context, groupId int64, messageId int64, limit int64) ([]model.GroupMessageDTO, error) {
+	var getMessages []model.GroupMessageDTO
+	var err error
+	err = sqlite.run(ctx, func(ctx context.Context) error {
+		getMessages, err = sqlite.getGroupMessages(ctx, groupId, messageId, limit)
+		return err
+	})
+	return getMessages, err
+}
+
+//Recovers the messages of the group that have a messageid greater than the given messageid
+//Returns error in case of failiure and nil in case of success
+
+func (sqlite SqliteDB) getGroupMessages(ctx context.Context, groupId int64, messageId int64, limit int64) ([]model.GroupMessageDTO, error) {
+	rows, err := sqlite.db.QueryContext(ctx, getFromGroupMessagesQuery, groupId, messageId, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]model.GroupMessageDTO, 0)
+	defer rows.Close()
+	for rows.Next() {
+		message := model.GroupMessageDTO{}
+		err = rows.Scan(&message.MessageId, &message.GroupId, &message.SenderId, &message.Timestamp, &message.Type, &message.Text, &message.Url, &message.Height, &message.Width, &message.Source)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, rows.Err()
+}
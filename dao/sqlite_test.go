@@ -1,85 +1,211 @@
 package dao
 
 import (
+	"context"
+	"errors"
 	"math"
 	"os"
 	"testing"
 
 	"strings"
 
+	"github.com/maidaneze/message-server/apperrors"
+	"github.com/maidaneze/message-server/services/passwords"
 	"github.com/maidaneze/message-server/services/users"
 
 	"github.com/maidaneze/message-server/model"
 	"github.com/maidaneze/message-server/services/auth"
+	"github.com/maidaneze/message-server/utils"
 
 	"time"
 
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 var (
-	testDatabase          SqliteDB
+	testDatabase          testableDB
 	testDatabaseFiletName string = "./foo.db"
+
+	//Cheap password policy, the tests don't need a secure hash
+	testPasswordPolicy = passwords.Policy{Algorithm: passwords.Bcrypt, BcryptCost: 4}
 )
 
+//Database implementation under test, the same suites run against every implementation
+
+type testableDB interface {
+	DB
+	Migrator
+}
+
 //Test suites
 
 func TestSqlite3DatabaseSuiteWithOpenConnection(t *testing.T) {
 	//Setup
 
-	testDatabase = SetupSqliteDatabaseTest(t, testDatabaseFiletName)
+	sqlite := SetupSqliteDatabaseTest(t, testDatabaseFiletName)
+	testDatabase = sqlite
+
+	//TestSuite
+
+	runDatabaseSuiteWithOpenConnection(t)
+
+	//Teardown
+
+	TeardownSqliteDatabaseTest(sqlite, testDatabaseFiletName)
+}
+
+func TestSqlite3DatabaseSuiteWithClosedConnection(t *testing.T) {
+	//Setup
+	sqlite := SetupSqliteDatabaseTest(t, testDatabaseFiletName)
+	sqlite.db.Close()
+	testDatabase = sqlite
 
 	//TestSuite
 
+	runDatabaseSuiteWithClosedConnection(t)
+
+	//TearDown
+	os.Remove(testDatabaseFiletName)
+}
+
+func TestSqlite3CloseShouldPersistTheDatabase(t *testing.T) {
+	sqlite := SetupSqliteDatabaseTest(t, testDatabaseFiletName)
+	defer os.Remove(testDatabaseFiletName)
+
+	_, err := sqlite.db.Exec("PRAGMA journal_mode=WAL")
+	require.Nil(t, err)
+
+	user, err := users.CreateUser("user", "pass", testPasswordPolicy)
+	require.Nil(t, err)
+	_, err = sqlite.InsertUser(context.Background(), user)
+	require.Nil(t, err)
+
+	require.Nil(t, sqlite.Close())
+	assert.NotNil(t, sqlite.CheckConnection(context.Background()))
+
+	//The write-ahead log was checkpointed into the database file
+	reopened, err := OpenSqlite3Database(testDatabaseFiletName)
+	require.Nil(t, err)
+	defer reopened.Close()
+
+	_, found, err := reopened.GetUser(context.Background(), "user")
+	assert.Nil(t, err)
+	assert.True(t, found)
+}
+
+func TestSqlite3ShouldFailAtOnceOnConflicts(t *testing.T) {
+	sqlite := SetupSqliteDatabaseTest(t, testDatabaseFiletName)
+	defer TeardownSqliteDatabaseTest(sqlite, testDatabaseFiletName)
+
+	var attempts []utils.RetryAttempt
+	retry := DefaultRetryOptions
+	retry.OnAttempt = func(attempt utils.RetryAttempt) { attempts = append(attempts, attempt) }
+	sqlite = sqlite.WithRetry(retry)
+
+	user, err := users.CreateUser("user", "pass", testPasswordPolicy)
+	require.Nil(t, err)
+	_, err = sqlite.InsertUser(context.Background(), user)
+	require.Nil(t, err)
+	_, err = sqlite.InsertUser(context.Background(), user)
+	assert.NotNil(t, err)
+
+	conflict, typed := apperrors.As(err)
+	require.True(t, typed)
+	assert.Equal(t, apperrors.Conflict, conflict.Kind)
+	assert.Equal(t, ConflictCode, conflict.Code)
+
+	require.Len(t, attempts, 2)
+	assert.Nil(t, attempts[0].Err)
+	assert.Equal(t, conflict.Cause, attempts[1].Err)
+	assert.False(t, attempts[1].Retryable)
+	assert.False(t, attempts[1].Retried)
+}
+
+func TestTransientErrorsShouldBeClassifiedByDriver(t *testing.T) {
+	cases := []struct {
+		name      string
+		retryable func(err error) bool
+		err       error
+		expected  bool
+	}{
+		{"testSqliteBusy", isTransientSqliteError, sqlite3.Error{Code: sqlite3.ErrBusy}, true},
+		{"testSqliteLocked", isTransientSqliteError, sqlite3.Error{Code: sqlite3.ErrLocked}, true},
+		{"testSqliteConstraint", isTransientSqliteError, sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintUnique}, false},
+		{"testSqliteOtherError", isTransientSqliteError, errors.New("database is locked"), false},
+		{"testSqliteCanceled", isTransientSqliteError, context.Canceled, false},
+		{"testPostgresSerializationFailure", isTransientPostgresError, &pq.Error{Code: "40001"}, true},
+		{"testPostgresDeadlock", isTransientPostgresError, &pq.Error{Code: "40P01"}, true},
+		{"testPostgresLockNotAvailable", isTransientPostgresError, &pq.Error{Code: "55P03"}, true},
+		{"testPostgresUniqueViolation", isTransientPostgresError, &pq.Error{Code: "23505"}, false},
+		{"testPostgresOtherError", isTransientPostgresError, errors.New("connection refused"), false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			assert.Equal(tt, c.expected, c.retryable(c.err))
+		})
+	}
+}
+
+func runDatabaseSuiteWithOpenConnection(t *testing.T) {
 	t.Run("testCheckConnectionShouldSucceedIfConnectionIsOpen", testCheckConnectionShouldSucceedIfConnectionIsOpen)
 	t.Run("testInsertUserShouldSaveUsersProperly", testInsertUserShouldSaveUsersProperly)
 	t.Run("testGetUserNotStoredShouldReturnFalseAndNoError", testGetUserNotStoredShouldReturnFalseAndNoError)
 	t.Run("testCheckUserExistsNotStoredShouldReturnFalseAndNoError", testCheckUserExistsNotStoredShouldReturnFalseAndNoError)
 	t.Run("testInsertRepeatedUserShouldFail", testInsertRepeatedUserShouldFail)
 	t.Run("testInsertRepeatedUserShouldReturnIncreasingIds", testInsertRepeatedUserShouldReturnIncreasingIds)
+	t.Run("testUpdateUserPasswordShouldReplacePassword", testUpdateUserPasswordShouldReplacePassword)
 	t.Run("testInsertTokenShouldSaveTokensProperly", testInsertTokenShouldSaveTokensProperly)
 	t.Run("testInsertdTokensShouldBePurged", testInsertdTokensShouldBePurged)
+	t.Run("testDeletedTokensShouldBeRevoked", testDeletedTokensShouldBeRevoked)
+	t.Run("testInsertTokenShouldKeepAtMostMaxSessions", testInsertTokenShouldKeepAtMostMaxSessions)
+	t.Run("testSessionsShouldBeTouchedAndDeleted", testSessionsShouldBeTouchedAndDeleted)
+	t.Run("testGetTokenShouldFindTheUserOfTheToken", testGetTokenShouldFindTheUserOfTheToken)
+	t.Run("testRefreshTokensShouldBeRotated", testRefreshTokensShouldBeRotated)
 	t.Run("testInsertMessageShouldSaveMessageProperly", testInsertMessageShouldSaveMessageProperly)
 	t.Run("testInsertMessageShouldFailOnInvalidTypeOrSource", testInsertMessageShouldFailOnInvalidTypeOrSource)
 	t.Run("testGetMessagesShouldGetAsMuchAsLimitNumberOfMessages", testGetMessagesShouldGetAsMuchAsLimitNumberOfMessages)
 	t.Run("testGetMessagesShouldGetMessagesStartingFromMessageId", testGetMessagesShouldGetMessagesStartingFromMessageId)
-
-	//Teardown
-
-	TeardownSqliteDatabaseTest(testDatabase, testDatabaseFiletName)
+	t.Run("testGetConversationMessagesShouldGetBothSidesOfTheConversation", testGetConversationMessagesShouldGetBothSidesOfTheConversation)
+	t.Run("testMessageStatusShouldBeUpdated", testMessageStatusShouldBeUpdated)
+	t.Run("testInsertGroupShouldSaveGroupAndMembers", testInsertGroupShouldSaveGroupAndMembers)
+	t.Run("testGetGroupNotStoredShouldReturnFalseAndNoError", testGetGroupNotStoredShouldReturnFalseAndNoError)
+	t.Run("testGroupMembersShouldBeAddedAndRemoved", testGroupMembersShouldBeAddedAndRemoved)
+	t.Run("testGroupMembersShouldNotExceedTheLimit", testGroupMembersShouldNotExceedTheLimit)
+	t.Run("testGroupMessagesShouldBeSavedPerGroup", testGroupMessagesShouldBeSavedPerGroup)
+	t.Run("testInsertGroupMessageShouldFailOnInvalidType", testInsertGroupMessageShouldFailOnInvalidType)
+	t.Run("testCanceledOperationsShouldFail", testCanceledOperationsShouldFail)
 }
 
-func TestSqlite3DatabaseSuiteWithClosedConnection(t *testing.T) {
-	//Setup
-	testDatabase = SetupSqliteDatabaseTest(t, testDatabaseFiletName)
-	testDatabase.db.Close()
-
-	//TestSuite
-
+func runDatabaseSuiteWithClosedConnection(t *testing.T) {
 	t.Run("testCheckConnectionShouldFailIfConectionIsNotOpen", testCheckConnectionShouldFailIfConectionIsNotOpen)
 	t.Run("testGetUserShouldFailWithClosedConnection", testGetUserShouldFailWithClosedConnection)
 	t.Run("testCheckUserExistsShouldFailWithClosedConnection", testCheckUserExistsShouldFailWithClosedConnection)
 	t.Run("testInsertUserShouldFailWithClosedConnection", testInsertUserShouldFailWithClosedConnection)
+	t.Run("testUpdateUserPasswordShouldFailWithClosedConnection", testUpdateUserPasswordShouldFailWithClosedConnection)
 	t.Run("testInsertTokenShouldFailWithClosedConnection", testInsertTokenShouldFailWithClosedConnection)
 	t.Run("testGetTokensShouldFailWithClosedConnection", testGetTokensShouldFailWithClosedConnection)
+	t.Run("testDeleteTokensShouldFailWithClosedConnection", testDeleteTokensShouldFailWithClosedConnection)
 	t.Run("testInsertMessageShouldFailWithClosedConnection", testInsertMessageShouldFailWithClosedConnection)
 	t.Run("testGetMessagesShouldFailWithClosedConnection", testGetMessagesShouldFailWithClosedConnection)
-
-	//TearDown
-	os.Remove(testDatabaseFiletName)
+	t.Run("testGetConversationMessagesShouldFailWithClosedConnection", testGetConversationMessagesShouldFailWithClosedConnection)
+	t.Run("testMessageStatusShouldFailWithClosedConnection", testMessageStatusShouldFailWithClosedConnection)
+	t.Run("testGroupsShouldFailWithClosedConnection", testGroupsShouldFailWithClosedConnection)
 }
 
 //Actual tests
 
 func testCheckConnectionShouldFailIfConectionIsNotOpen(t *testing.T) {
-	err := testDatabase.CheckConnection()
+	err := testDatabase.CheckConnection(context.Background())
 	assert.NotNil(t, err)
 }
 
 func testCheckConnectionShouldSucceedIfConnectionIsOpen(t *testing.T) {
 	RefreshSchema(testDatabase)
-	err := testDatabase.CheckConnection()
+	err := testDatabase.CheckConnection(context.Background())
 	assert.Nil(t, err)
 }
 
@@ -97,7 +223,7 @@ func testGetUserShouldFailWithClosedConnection(t *testing.T) {
 
 	for _, c := range cases {
 		t.Run(c.name, func(tt *testing.T) {
-			_, _, err := testDatabase.GetUser(c.username)
+			_, _, err := testDatabase.GetUser(context.Background(), c.username)
 			assert.NotNil(tt, err)
 		})
 	}
@@ -123,7 +249,7 @@ func testCheckUserExistsShouldFailWithClosedConnection(t *testing.T) {
 
 	for _, c := range cases {
 		t.Run(c.name, func(tt *testing.T) {
-			_, err := testDatabase.CheckUserExists(c.userid)
+			_, err := testDatabase.CheckUserExists(context.Background(), c.userid)
 			assert.NotNil(t, err)
 		})
 	}
@@ -146,8 +272,8 @@ func testInsertUserShouldFailWithClosedConnection(t *testing.T) {
 
 	for _, c := range cases {
 		t.Run(c.name, func(tt *testing.T) {
-			user, err := users.CreateUser(c.username, c.password)
-			_, err = testDatabase.InsertUser(user)
+			user, err := users.CreateUser(c.username, c.password, testPasswordPolicy)
+			_, err = testDatabase.InsertUser(context.Background(), user)
 			assert.NotNil(tt, err)
 		})
 	}
@@ -159,7 +285,7 @@ func testInsertUserShouldSaveUsersProperly(t *testing.T) {
 		name     string
 		username string
 		password string
-		setup    func(db SqliteDB)
+		setup    func(db Migrator)
 	}{
 		{"createUserShouldSucceedWithEmptyFieltds", "", "", RefreshSchema},
 		{"createUserShouldSucceedTestSampleUser", "user1", "123", RefreshSchema},
@@ -172,18 +298,18 @@ func testInsertUserShouldSaveUsersProperly(t *testing.T) {
 	for _, c := range cases {
 		t.Run(c.name, func(tt *testing.T) {
 
-			user, err := users.CreateUser(c.username, c.password)
-			createdUser, err := testDatabase.InsertUser(user)
+			user, err := users.CreateUser(c.username, c.password, testPasswordPolicy)
+			createdUser, err := testDatabase.InsertUser(context.Background(), user)
 
 			assert.Nil(tt, err)
 
 			var found bool
-			found, err = testDatabase.CheckUserExists(createdUser.Userid)
+			found, err = testDatabase.CheckUserExists(context.Background(), createdUser.Userid)
 
 			assert.True(tt, found)
 			assert.Nil(tt, err)
 
-			user, found, err = testDatabase.GetUser(c.username)
+			user, found, err = testDatabase.GetUser(context.Background(), c.username)
 
 			assert.True(tt, found)
 			assert.Nil(tt, err)
@@ -197,7 +323,7 @@ func testGetUserNotStoredShouldReturnFalseAndNoError(t *testing.T) {
 	RefreshSchema(testDatabase)
 	username := "username"
 
-	_, found, err := testDatabase.GetUser(username)
+	_, found, err := testDatabase.GetUser(context.Background(), username)
 
 	assert.False(t, found)
 	assert.Nil(t, err)
@@ -207,7 +333,7 @@ func testCheckUserExistsNotStoredShouldReturnFalseAndNoError(t *testing.T) {
 	RefreshSchema(testDatabase)
 	userid := int64(0)
 
-	found, err := testDatabase.checkUserExists(userid)
+	found, err := testDatabase.CheckUserExists(context.Background(), userid)
 
 	assert.False(t, found)
 	assert.Nil(t, err)
@@ -219,8 +345,8 @@ func testInsertRepeatedUserShouldFail(t *testing.T) {
 	username := "user1"
 	pass1 := "123"
 	pass2 := "456"
-	user, err := users.CreateUser(username, pass1)
-	_, err = testDatabase.InsertUser(user)
+	user, err := users.CreateUser(username, pass1, testPasswordPolicy)
+	_, err = testDatabase.InsertUser(context.Background(), user)
 	assert.Nil(t, err)
 
 	cases := []struct {
@@ -232,8 +358,8 @@ func testInsertRepeatedUserShouldFail(t *testing.T) {
 	}
 	for _, c := range cases {
 		t.Run(c.name, func(tt *testing.T) {
-			user, err := users.CreateUser(username, c.password)
-			_, err = testDatabase.InsertUser(user)
+			user, err := users.CreateUser(username, c.password, testPasswordPolicy)
+			_, err = testDatabase.InsertUser(context.Background(), user)
 			assert.NotNil(tt, err)
 		})
 	}
@@ -244,16 +370,16 @@ func testInsertRepeatedUserShouldReturnIncreasingIds(t *testing.T) {
 	username := "user"
 	pass1 := "123"
 
-	user, err := users.CreateUser(username+"1", pass1)
-	user1, err := testDatabase.InsertUser(user)
+	user, err := users.CreateUser(username+"1", pass1, testPasswordPolicy)
+	user1, err := testDatabase.InsertUser(context.Background(), user)
 	assert.Nil(t, err)
-	user, err = users.CreateUser(username+"2", pass1)
+	user, err = users.CreateUser(username+"2", pass1, testPasswordPolicy)
 	assert.Nil(t, err)
-	user2, err := testDatabase.InsertUser(user)
+	user2, err := testDatabase.InsertUser(context.Background(), user)
 	assert.Nil(t, err)
-	user, err = users.CreateUser(username+"3", pass1)
+	user, err = users.CreateUser(username+"3", pass1, testPasswordPolicy)
 	assert.Nil(t, err)
-	user3, err := testDatabase.InsertUser(user)
+	user3, err := testDatabase.InsertUser(context.Background(), user)
 	assert.Nil(t, err)
 
 	assert.Equal(t, int64(1), user1.Userid)
@@ -261,6 +387,44 @@ func testInsertRepeatedUserShouldReturnIncreasingIds(t *testing.T) {
 	assert.Equal(t, int64(3), user3.Userid)
 }
 
+func testUpdateUserPasswordShouldReplacePassword(t *testing.T) {
+	RefreshSchema(testDatabase)
+
+	//Legacy users have a salt which is cleared when the password is upgraded
+	password, salt, err := passwords.GenerateSecurePassword("pass")
+	require.Nil(t, err)
+	user, err := testDatabase.InsertUser(context.Background(), model.User{Username: "user", Password: password, Salt: salt})
+	require.Nil(t, err)
+
+	upgraded, err := testPasswordPolicy.HashUserPassword(user, "pass")
+	require.Nil(t, err)
+	require.Nil(t, testDatabase.UpdateUserPassword(context.Background(), upgraded))
+
+	stored, found, err := testDatabase.GetUser(context.Background(), "user")
+	require.Nil(t, err)
+	require.True(t, found)
+	assert.Equal(t, user.Userid, stored.Userid)
+	assert.Equal(t, upgraded.Password, stored.Password)
+	assert.Equal(t, "", stored.Salt)
+
+	valid, rehash := testPasswordPolicy.Verify(stored, "pass")
+	assert.True(t, valid)
+	assert.False(t, rehash)
+
+	//The password and salt are stored as bytes, whatever their encoding
+	raw := model.User{Userid: user.Userid, Password: "\\x00\xff$argon2id$", Salt: "\xfe\\"}
+	require.Nil(t, testDatabase.UpdateUserPassword(context.Background(), raw))
+	stored, _, err = testDatabase.GetUser(context.Background(), "user")
+	require.Nil(t, err)
+	assert.Equal(t, raw.Password, stored.Password)
+	assert.Equal(t, raw.Salt, stored.Salt)
+}
+
+func testUpdateUserPasswordShouldFailWithClosedConnection(t *testing.T) {
+	err := testDatabase.UpdateUserPassword(context.Background(), model.User{Userid: 1, Password: "password"})
+	assert.NotNil(t, err)
+}
+
 //Helper functions
 
 func testInsertTokenShouldFailWithClosedConnection(t *testing.T) {
@@ -278,7 +442,7 @@ func testInsertTokenShouldFailWithClosedConnection(t *testing.T) {
 
 	for _, c := range cases {
 		t.Run(c.name, func(tt *testing.T) {
-			err := testDatabase.InsertToken(c.userid, model.Token{})
+			_, err := testDatabase.InsertToken(context.Background(), c.userid, model.Token{}, 2)
 			assert.NotNil(tt, err)
 		})
 	}
@@ -298,10 +462,13 @@ func testGetTokensShouldFailWithClosedConnection(t *testing.T) {
 
 	for _, c := range cases {
 		t.Run(c.name, func(tt *testing.T) {
-			_, err := testDatabase.GetTokens(c.userid)
+			_, err := testDatabase.GetTokens(context.Background(), c.userid)
 			assert.NotNil(tt, err)
 		})
 	}
+
+	_, _, err := testDatabase.GetToken(context.Background(), "token")
+	assert.NotNil(t, err)
 }
 
 func testInsertMessageShouldFailWithClosedConnection(t *testing.T) {
@@ -321,7 +488,7 @@ func testInsertMessageShouldFailWithClosedConnection(t *testing.T) {
 		t.Run(c.name, func(tt *testing.T) {
 			message := model.MessageDTO{}
 			message.RecipientId = c.recipientid
-			_, err := testDatabase.InsertMessage(message)
+			_, err := testDatabase.InsertMessage(context.Background(), message)
 			assert.NotNil(tt, err)
 		})
 	}
@@ -341,7 +508,7 @@ func testGetMessagesShouldFailWithClosedConnection(t *testing.T) {
 
 	for _, c := range cases {
 		t.Run(c.name, func(tt *testing.T) {
-			_, err := testDatabase.GetMessages(c.recipientid, 0, 100)
+			_, err := testDatabase.GetMessages(context.Background(), c.recipientid, 0, 100)
 			assert.NotNil(tt, err)
 		})
 	}
@@ -364,15 +531,55 @@ func testInsertTokenShouldSaveTokensProperly(t *testing.T) {
 	for _, c := range cases {
 		t.Run(c.name, func(tt *testing.T) {
 			token, _ := auth.GenerateToken()
-			err := testDatabase.InsertToken(c.userid, token)
+			_, err := testDatabase.InsertToken(context.Background(), c.userid, token, 2)
 			assert.Nil(tt, err)
-			tokens, err := testDatabase.GetTokens(c.userid)
+			tokens, err := testDatabase.GetTokens(context.Background(), c.userid)
 			assert.Nil(tt, err)
 			assert.True(tt, auth.FindToken(token, tokens))
 		})
 	}
 }
 
+func testDeletedTokensShouldBeRevoked(t *testing.T) {
+	RefreshSchema(testDatabase)
+
+	var userid int64 = 123
+	var otherUserid int64 = 456
+
+	token1, _ := auth.GenerateToken()
+	token2, _ := auth.GenerateToken()
+	otherToken, _ := auth.GenerateToken()
+	session1 := insertTokenForTest(t, testDatabase, userid, token1, 2)
+	insertTokenForTest(t, testDatabase, userid, token2, 2)
+	otherSession := insertTokenForTest(t, testDatabase, otherUserid, otherToken, 2)
+
+	//Deleting another user session has no effect
+	deleted, err := testDatabase.DeleteSession(context.Background(), userid, otherSession.SessionId)
+	assert.Nil(t, err)
+	assert.False(t, deleted)
+
+	deleted, err = testDatabase.DeleteSession(context.Background(), userid, session1.SessionId)
+	assert.Nil(t, err)
+	assert.True(t, deleted)
+
+	tokens, err := testDatabase.GetTokens(context.Background(), userid)
+	assert.Nil(t, err)
+	assert.False(t, auth.ValidateAuthorizedUser(token1.Uuid, tokens))
+	assert.True(t, auth.ValidateAuthorizedUser(token2.Uuid, tokens))
+
+	count, err := testDatabase.DeleteTokens(context.Background(), userid)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+
+	tokens, err = testDatabase.GetTokens(context.Background(), userid)
+	assert.Nil(t, err)
+	assert.False(t, auth.ValidateAuthorizedUser(token2.Uuid, tokens))
+
+	tokens, err = testDatabase.GetTokens(context.Background(), otherUserid)
+	assert.Nil(t, err)
+	assert.True(t, auth.ValidateAuthorizedUser(otherToken.Uuid, tokens))
+}
+
 func testInsertMessageShouldSaveMessageProperly(t *testing.T) {
 	RefreshSchema(testDatabase)
 	cases := []struct {
@@ -403,8 +610,8 @@ func testInsertMessageShouldSaveMessageProperly(t *testing.T) {
 
 	for _, c := range cases {
 		t.Run(c.name, func(tt *testing.T) {
-			insertMessage := model.MessageDTO{0, c.recipientid, c.senderid, time.Now(), c.messageType, c.text, c.url, c.height, c.width, c.source}
-			message, err := testDatabase.InsertMessage(insertMessage)
+			insertMessage := model.MessageDTO{0, c.recipientid, c.senderid, time.Now(), c.messageType, c.text, c.url, c.height, c.width, c.source, nil, nil}
+			message, err := testDatabase.InsertMessage(context.Background(), insertMessage)
 			assert.Nil(tt, err)
 
 			assert.Equal(tt, c.expectedMessageId, message.MessageId)
@@ -417,7 +624,7 @@ func testInsertMessageShouldSaveMessageProperly(t *testing.T) {
 			assert.Equal(tt, c.width, message.Width)
 			assert.Equal(tt, c.source, message.Source)
 
-			messages, err := testDatabase.GetMessages(c.recipientid, message.MessageId, 1)
+			messages, err := testDatabase.GetMessages(context.Background(), c.recipientid, message.MessageId, 1)
 			require.True(tt, len(messages) == 1)
 			getMessage := messages[0]
 			assert.Nil(tt, err)
@@ -459,8 +666,8 @@ func testInsertMessageShouldFailOnInvalidTypeOrSource(t *testing.T) {
 
 	for _, c := range cases {
 		t.Run(c.name, func(tt *testing.T) {
-			insertMessage := model.MessageDTO{0, 0, 0, time.Now(), c.messageType, "", "", 0, 0, c.source}
-			_, err := testDatabase.InsertMessage(insertMessage)
+			insertMessage := model.MessageDTO{0, 0, 0, time.Now(), c.messageType, "", "", 0, 0, c.source, nil, nil}
+			_, err := testDatabase.InsertMessage(context.Background(), insertMessage)
 			assert.True(tt, c.success == (err == nil))
 		})
 	}
@@ -496,8 +703,8 @@ func testGetMessagesShouldGetAsMuchAsLimitNumberOfMessages(t *testing.T) {
 		t.Run(c.name, func(tt *testing.T) {
 			var id int64 = 0
 			for i := 0; i < c.saveMessages; i++ {
-				insertMessage := model.MessageDTO{0, 0, 0, time.Now(), "text", "", "", 0, 0, "youtube"}
-				message, err := testDatabase.InsertMessage(insertMessage)
+				insertMessage := model.MessageDTO{0, 0, 0, time.Now(), "text", "", "", 0, 0, "youtube", nil, nil}
+				message, err := testDatabase.InsertMessage(context.Background(), insertMessage)
 				assert.Nil(tt, err)
 				if i == 0 {
 					id = message.MessageId
@@ -505,7 +712,7 @@ func testGetMessagesShouldGetAsMuchAsLimitNumberOfMessages(t *testing.T) {
 
 			}
 
-			messages, err := testDatabase.GetMessages(0, id, c.limit)
+			messages, err := testDatabase.GetMessages(context.Background(), 0, id, c.limit)
 			assert.Nil(tt, err)
 			assert.Equal(tt, c.expectedMessages, len(messages))
 		})
@@ -516,8 +723,8 @@ func testGetMessagesShouldGetMessagesStartingFromMessageId(t *testing.T) {
 	RefreshSchema(testDatabase)
 	var id int64 = 0
 	for i := 0; i < 5; i++ {
-		insertMessage := model.MessageDTO{0, 0, 0, time.Now(), "text", "", "", 0, 0, "youtube"}
-		message, err := testDatabase.InsertMessage(insertMessage)
+		insertMessage := model.MessageDTO{0, 0, 0, time.Now(), "text", "", "", 0, 0, "youtube", nil, nil}
+		message, err := testDatabase.InsertMessage(context.Background(), insertMessage)
 		assert.Nil(t, err)
 		if i == 0 {
 			id = message.MessageId
@@ -526,7 +733,7 @@ func testGetMessagesShouldGetMessagesStartingFromMessageId(t *testing.T) {
 	}
 
 	for i := 0; i < 5; i++ {
-		messages, err := testDatabase.GetMessages(0, id, 5)
+		messages, err := testDatabase.GetMessages(context.Background(), 0, id, 5)
 		assert.Nil(t, err)
 		for j := 0; j < len(messages); j++ {
 			assert.Equal(t, id+int64(j), messages[j].MessageId)
@@ -534,6 +741,123 @@ func testGetMessagesShouldGetMessagesStartingFromMessageId(t *testing.T) {
 	}
 }
 
+func testGetConversationMessagesShouldGetBothSidesOfTheConversation(t *testing.T) {
+	RefreshSchema(testDatabase)
+
+	//Messages 1 to 8 alternate between the conversation of users 1 and 2 and other conversations
+	senders := []int64{1, 3, 2, 1, 1, 2, 3, 2}
+	recipients := []int64{2, 1, 1, 3, 2, 1, 2, 4}
+	for i := range senders {
+		insertMessage := model.MessageDTO{SenderId: senders[i], RecipientId: recipients[i], Timestamp: time.Now(), Type: "text", Source: "youtube"}
+		_, err := testDatabase.InsertMessage(context.Background(), insertMessage)
+		require.Nil(t, err)
+	}
+
+	cases := []struct {
+		name        string
+		userId      int64
+		otherUserId int64
+		messageId   int64
+		limit       int64
+		before      bool
+		expectedIds []int64
+	}{
+		{"getConversationMessagesAfter", 1, 2, 0, 10, false, []int64{1, 3, 5, 6}},
+		{"getConversationMessagesAfterFromTheOtherSide", 2, 1, 0, 10, false, []int64{1, 3, 5, 6}},
+		{"getConversationMessagesAfterMessageId", 1, 2, 3, 10, false, []int64{5, 6}},
+		{"getConversationMessagesAfterWithLimit", 1, 2, 0, 2, false, []int64{1, 3}},
+		{"getConversationMessagesBefore", 1, 2, 100, 10, true, []int64{1, 3, 5, 6}},
+		{"getConversationMessagesBeforeMessageId", 1, 2, 5, 10, true, []int64{1, 3}},
+		{"getConversationMessagesBeforeWithLimit", 1, 2, 100, 2, true, []int64{5, 6}},
+		{"getConversationMessagesWithOneMessage", 2, 4, 100, 10, true, []int64{8}},
+		{"getConversationMessagesOfUnknownConversation", 1, 4, 0, 10, false, []int64{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			messages, err := testDatabase.GetConversationMessages(context.Background(), c.userId, c.otherUserId, c.messageId, c.limit, c.before)
+			assert.Nil(tt, err)
+			ids := make([]int64, 0)
+			for _, message := range messages {
+				ids = append(ids, message.MessageId)
+			}
+			assert.Equal(tt, c.expectedIds, ids)
+		})
+	}
+}
+
+func testMessageStatusShouldBeUpdated(t *testing.T) {
+	RefreshSchema(testDatabase)
+
+	//Messages 1 to 4 are sent by user 1 to user 2, message 5 is sent by user 3 to user 2
+	senders := []int64{1, 1, 1, 1, 3}
+	for _, sender := range senders {
+		insertMessage := model.MessageDTO{SenderId: sender, RecipientId: 2, Timestamp: time.Now(), Type: "text", Source: "youtube"}
+		_, err := testDatabase.InsertMessage(context.Background(), insertMessage)
+		require.Nil(t, err)
+	}
+
+	statuses, err := testDatabase.GetSentMessageStatuses(context.Background(), 1, 1, 10)
+	require.Nil(t, err)
+	require.Equal(t, 4, len(statuses))
+	for _, status := range statuses {
+		assert.Equal(t, int64(2), status.RecipientId)
+		assert.Nil(t, status.Delivered)
+		assert.Nil(t, status.Read)
+	}
+
+	delivered := time.Now().Add(-time.Minute)
+	require.Nil(t, testDatabase.MarkMessagesDelivered(context.Background(), 2, 2, 3, delivered))
+
+	//Delivering again keeps the first delivery time
+	require.Nil(t, testDatabase.MarkMessagesDelivered(context.Background(), 2, 1, 3, time.Now()))
+
+	read := time.Now()
+	marked, err := testDatabase.MarkMessagesRead(context.Background(), 2, 2, read)
+	require.Nil(t, err)
+	assert.Equal(t, int64(2), marked)
+
+	//Reading again doesn't mark any message
+	marked, err = testDatabase.MarkMessagesRead(context.Background(), 2, 2, time.Now())
+	require.Nil(t, err)
+	assert.Equal(t, int64(0), marked)
+
+	statuses, err = testDatabase.GetSentMessageStatuses(context.Background(), 1, 1, 10)
+	require.Nil(t, err)
+	require.Equal(t, 4, len(statuses))
+
+	//Message 1 was delivered by the second call and read
+	require.NotNil(t, statuses[0].Delivered)
+	require.NotNil(t, statuses[0].Read)
+	assert.True(t, statuses[0].Delivered.After(delivered))
+
+	//Message 2 keeps the first delivery time and was read
+	require.NotNil(t, statuses[1].Delivered)
+	require.NotNil(t, statuses[1].Read)
+	assert.True(t, statuses[1].Delivered.Equal(delivered))
+	assert.True(t, statuses[1].Read.Equal(read))
+
+	//Message 3 was delivered but not read
+	require.NotNil(t, statuses[2].Delivered)
+	assert.Nil(t, statuses[2].Read)
+
+	//Message 4 wasn't delivered
+	assert.Nil(t, statuses[3].Delivered)
+	assert.Nil(t, statuses[3].Read)
+
+	//The messages carry their status
+	messages, err := testDatabase.GetMessages(context.Background(), 2, 1, 10)
+	require.Nil(t, err)
+	require.Equal(t, 5, len(messages))
+	assert.NotNil(t, messages[1].Read)
+	assert.Nil(t, messages[2].Read)
+
+	statuses, err = testDatabase.GetSentMessageStatuses(context.Background(), 1, 4, 10)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(statuses))
+	assert.Equal(t, int64(4), statuses[0].MessageId)
+}
+
 func testInsertdTokensShouldBePurged(t *testing.T) {
 	RefreshSchema(testDatabase)
 
@@ -545,25 +869,25 @@ func testInsertdTokensShouldBePurged(t *testing.T) {
 	token3, _ := auth.GenerateToken()
 	token4, _ := auth.GenerateToken()
 	token5, _ := auth.GenerateToken()
-	err := testDatabase.InsertToken(userid1, token1)
+	_, err := testDatabase.InsertToken(context.Background(), userid1, token1, 2)
 	assert.Nil(t, err)
 
-	err = testDatabase.InsertToken(userid1, token2)
+	_, err = testDatabase.InsertToken(context.Background(), userid1, token2, 2)
 	assert.Nil(t, err)
 
-	err = testDatabase.InsertToken(userid2, token3)
+	_, err = testDatabase.InsertToken(context.Background(), userid2, token3, 2)
 	assert.Nil(t, err)
 
-	err = testDatabase.InsertToken(userid2, token4)
+	_, err = testDatabase.InsertToken(context.Background(), userid2, token4, 2)
 	assert.Nil(t, err)
 
-	err = testDatabase.InsertToken(userid2, token5)
+	_, err = testDatabase.InsertToken(context.Background(), userid2, token5, 2)
 	assert.Nil(t, err)
 
-	tokens1, err := testDatabase.GetTokens(userid1)
+	tokens1, err := testDatabase.GetTokens(context.Background(), userid1)
 	assert.Nil(t, err)
 
-	tokens2, err := testDatabase.GetTokens(userid2)
+	tokens2, err := testDatabase.GetTokens(context.Background(), userid2)
 	assert.Nil(t, err)
 
 	//Ensures the query brings all elements that it finds
@@ -588,3 +912,251 @@ func testInsertdTokensShouldBePurged(t *testing.T) {
 	assert.True(t, auth.FindToken(token5, tokens2))
 
 }
+
+func testGetConversationMessagesShouldFailWithClosedConnection(t *testing.T) {
+	_, err := testDatabase.GetConversationMessages(context.Background(), 1, 2, 0, 10, false)
+	assert.NotNil(t, err)
+}
+
+func testMessageStatusShouldFailWithClosedConnection(t *testing.T) {
+	assert.NotNil(t, testDatabase.MarkMessagesDelivered(context.Background(), 1, 1, 1, time.Now()))
+	_, err := testDatabase.MarkMessagesRead(context.Background(), 1, 1, time.Now())
+	assert.NotNil(t, err)
+	_, err = testDatabase.GetSentMessageStatuses(context.Background(), 1, 1, 1)
+	assert.NotNil(t, err)
+}
+
+func testDeleteTokensShouldFailWithClosedConnection(t *testing.T) {
+	_, err := testDatabase.DeleteTokens(context.Background(), 1)
+	assert.NotNil(t, err)
+	_, err = testDatabase.DeleteSession(context.Background(), 1, 1)
+	assert.NotNil(t, err)
+	_, err = testDatabase.CheckSession(context.Background(), 1, 1)
+	assert.NotNil(t, err)
+	assert.NotNil(t, testDatabase.TouchToken(context.Background(), 1, "token", 1))
+	_, _, err = testDatabase.RotateRefreshToken(context.Background(), 1, "token", "newToken", 1)
+	assert.NotNil(t, err)
+}
+
+func insertTokenForTest(t *testing.T, db DB, userid int64, token model.Token, maxSessions int) model.Token {
+	insertedToken, err := db.InsertToken(context.Background(), userid, token, maxSessions)
+	require.Nil(t, err)
+	require.True(t, insertedToken.SessionId > 0)
+	return insertedToken
+}
+
+func testInsertTokenShouldKeepAtMostMaxSessions(t *testing.T) {
+	cases := []struct {
+		name        string
+		maxSessions int
+		inserted    int
+		expected    int
+	}{
+		{"testInsertTokenWithoutSessionLimit", 0, 5, 5},
+		{"testInsertTokenWithSingleSession", 1, 3, 1},
+		{"testInsertTokenUnderSessionLimit", 3, 2, 2},
+		{"testInsertTokenOverSessionLimit", 3, 5, 3},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			RefreshSchema(testDatabase)
+			var userid int64 = 123
+			inserted := make([]model.Token, 0)
+			for i := 0; i < c.inserted; i++ {
+				token, _ := auth.GenerateToken()
+				insertTokenForTest(tt, testDatabase, userid, token, c.maxSessions)
+				inserted = append(inserted, token)
+			}
+
+			tokens, err := testDatabase.GetTokens(context.Background(), userid)
+			assert.Nil(tt, err)
+			require.Equal(tt, c.expected, len(tokens))
+
+			//The most recent sessions are kept
+			for i, token := range inserted {
+				assert.Equal(tt, i >= c.inserted-c.expected, auth.FindToken(token, tokens))
+			}
+		})
+	}
+}
+
+func testSessionsShouldBeTouchedAndDeleted(t *testing.T) {
+	RefreshSchema(testDatabase)
+
+	var userid int64 = 123
+	var otherUserid int64 = 456
+
+	token1, _ := auth.DefaultSessionPolicy.GenerateSessionToken("agent1")
+	token2, _ := auth.DefaultSessionPolicy.GenerateSessionToken("agent2")
+	insertTokenForTest(t, testDatabase, userid, token1, 2)
+	insertTokenForTest(t, testDatabase, userid, token2, 2)
+
+	tokens, err := testDatabase.GetTokens(context.Background(), userid)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(tokens))
+	assert.True(t, tokens[0].SessionId < tokens[1].SessionId)
+	assert.Equal(t, token1.Uuid, tokens[0].Uuid)
+	assert.Equal(t, token1.Created, tokens[0].Created)
+	assert.Equal(t, token1.LastUsed, tokens[0].LastUsed)
+	assert.Equal(t, "agent1", tokens[0].UserAgent)
+	assert.Equal(t, "agent2", tokens[1].UserAgent)
+
+	//Touching updates only the given token
+	lastUsed := token1.LastUsed + 1000
+	assert.Nil(t, testDatabase.TouchToken(context.Background(), userid, token1.Uuid, lastUsed))
+	assert.Nil(t, testDatabase.TouchToken(context.Background(), otherUserid, token2.Uuid, lastUsed))
+
+	touched, err := testDatabase.GetTokens(context.Background(), userid)
+	require.Nil(t, err)
+	assert.Equal(t, lastUsed, touched[0].LastUsed)
+	assert.Equal(t, token2.LastUsed, touched[1].LastUsed)
+
+	//The session is found only for its user
+	found, err := testDatabase.CheckSession(context.Background(), userid, tokens[0].SessionId)
+	assert.Nil(t, err)
+	assert.True(t, found)
+
+	found, err = testDatabase.CheckSession(context.Background(), otherUserid, tokens[0].SessionId)
+	assert.Nil(t, err)
+	assert.False(t, found)
+
+	//Deleting another user session has no effect
+	deleted, err := testDatabase.DeleteSession(context.Background(), otherUserid, tokens[0].SessionId)
+	assert.Nil(t, err)
+	assert.False(t, deleted)
+
+	deleted, err = testDatabase.DeleteSession(context.Background(), userid, tokens[0].SessionId)
+	assert.Nil(t, err)
+	assert.True(t, deleted)
+
+	deleted, err = testDatabase.DeleteSession(context.Background(), userid, tokens[0].SessionId)
+	assert.Nil(t, err)
+	assert.False(t, deleted)
+
+	found, err = testDatabase.CheckSession(context.Background(), userid, tokens[0].SessionId)
+	assert.Nil(t, err)
+	assert.False(t, found)
+
+	tokens, err = testDatabase.GetTokens(context.Background(), userid)
+	assert.Nil(t, err)
+	assert.False(t, auth.ValidateAuthorizedUser(token1.Uuid, tokens))
+	assert.True(t, auth.ValidateAuthorizedUser(token2.Uuid, tokens))
+}
+
+func testGetTokenShouldFindTheUserOfTheToken(t *testing.T) {
+	RefreshSchema(testDatabase)
+
+	token1, _ := auth.DefaultSessionPolicy.GenerateSessionToken("agent1")
+	token2, _ := auth.DefaultSessionPolicy.GenerateSessionToken("agent2")
+	inserted1 := insertTokenForTest(t, testDatabase, 123, token1, 2)
+	inserted2 := insertTokenForTest(t, testDatabase, 456, token2, 2)
+
+	cases := []struct {
+		name     string
+		token    string
+		expected model.Token
+		found    bool
+	}{
+		{"testGetTokenOfFirstUser", token1.Uuid, inserted1, true},
+		{"testGetTokenOfSecondUser", token2.Uuid, inserted2, true},
+		{"testGetTokenNotStored", "invalid", model.Token{}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			token, found, err := testDatabase.GetToken(context.Background(), c.token)
+			assert.Nil(tt, err)
+			assert.Equal(tt, c.found, found)
+			assert.Equal(tt, c.expected, token)
+		})
+	}
+
+	//Tokens are unique
+	_, err := testDatabase.InsertToken(context.Background(), 789, token1, 2)
+	assert.NotNil(t, err)
+}
+
+func testRefreshTokensShouldBeRotated(t *testing.T) {
+	RefreshSchema(testDatabase)
+
+	var userid int64 = 123
+	var otherUserid int64 = 456
+	now := utils.UTCTimeMilliseconds()
+
+	token, _ := auth.DefaultSessionPolicy.GenerateSessionToken("agent")
+	session := insertTokenForTest(t, testDatabase, userid, token, 2)
+
+	//Rotating replaces the token of the session
+	rotated, result, err := testDatabase.RotateRefreshToken(context.Background(), userid, token.Uuid, "rotated", now)
+	assert.Nil(t, err)
+	assert.Equal(t, RefreshRotated, result)
+	assert.Equal(t, session.SessionId, rotated.SessionId)
+	assert.Equal(t, "rotated", rotated.Uuid)
+	assert.Equal(t, session.Expiration, rotated.Expiration)
+	assert.Equal(t, now, rotated.LastUsed)
+	assert.Equal(t, "agent", rotated.UserAgent)
+
+	tokens, err := testDatabase.GetTokens(context.Background(), userid)
+	assert.Nil(t, err)
+	require.Equal(t, 1, len(tokens))
+	assert.Equal(t, rotated, tokens[0])
+
+	//Unknown tokens aren't found
+	_, result, err = testDatabase.RotateRefreshToken(context.Background(), otherUserid, "rotated", "other", now)
+	assert.Nil(t, err)
+	assert.Equal(t, RefreshNotFound, result)
+
+	_, result, err = testDatabase.RotateRefreshToken(context.Background(), userid, "unknown", "other", now)
+	assert.Nil(t, err)
+	assert.Equal(t, RefreshNotFound, result)
+
+	//Reusing a rotated token revokes the session
+	_, result, err = testDatabase.RotateRefreshToken(context.Background(), userid, token.Uuid, "other", now)
+	assert.Nil(t, err)
+	assert.Equal(t, RefreshReused, result)
+
+	tokens, err = testDatabase.GetTokens(context.Background(), userid)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(tokens))
+
+	_, result, err = testDatabase.RotateRefreshToken(context.Background(), userid, "rotated", "other", now)
+	assert.Nil(t, err)
+	assert.Equal(t, RefreshNotFound, result)
+
+	//Tokens of expired sessions aren't rotated
+	expired := model.Token{Uuid: "expired", Expiration: now + 1000, Created: now, LastUsed: now}
+	insertTokenForTest(t, testDatabase, userid, expired, 2)
+
+	_, result, err = testDatabase.RotateRefreshToken(context.Background(), userid, "expired", "expiredRotated", now)
+	assert.Nil(t, err)
+	assert.Equal(t, RefreshRotated, result)
+
+	_, result, err = testDatabase.RotateRefreshToken(context.Background(), userid, "expiredRotated", "other", now+2000)
+	assert.Nil(t, err)
+	assert.Equal(t, RefreshNotFound, result)
+
+	//The rotated tokens of expired sessions are purged
+	_, result, err = testDatabase.RotateRefreshToken(context.Background(), userid, "expired", "other", now+2000)
+	assert.Nil(t, err)
+	assert.Equal(t, RefreshNotFound, result)
+}
+
+func testCanceledOperationsShouldFail(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	user, err := users.CreateUser("canceledUser", "pass", testPasswordPolicy)
+	require.Nil(t, err)
+	_, err = testDatabase.InsertUser(ctx, user)
+	assert.Equal(t, context.Canceled, err)
+
+	_, found, err := testDatabase.GetUser(context.Background(), "canceledUser")
+	assert.Nil(t, err)
+	assert.False(t, found)
+
+	expired, cancelExpired := context.WithTimeout(context.Background(), -time.Second)
+	defer cancelExpired()
+	_, err = testDatabase.GetMessages(expired, 1, 1, 10)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
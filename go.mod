@@ -4,6 +4,11 @@ go 1.12
 
 require (
 	github.com/google/uuid v1.1.1
+	github.com/gorilla/websocket v1.4.0
+	github.com/lib/pq v1.1.1
 	github.com/mattn/go-sqlite3 v1.10.0
+	github.com/prometheus/client_golang v1.0.0
 	github.com/stretchr/testify v1.3.0
+	golang.org/x/crypto v0.0.0-20190605123033-f99c8df09eb5
+	gopkg.in/yaml.v2 v2.2.2
 )
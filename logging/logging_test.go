@@ -0,0 +1,149 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loggerForTest(output *bytes.Buffer, level Level) *Logger {
+	logger := New(output, level)
+	logger.now = func() time.Time { return time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC) }
+	return logger
+}
+
+func readEntriesForTest(t *testing.T, output *bytes.Buffer) []map[string]interface{} {
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		entry := map[string]interface{}{}
+		require.Nil(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestLoggerShouldWriteJSON(t *testing.T) {
+	output := new(bytes.Buffer)
+	logger := loggerForTest(output, Info).With(Fields{"request_id": "1234"})
+
+	logger.Info("request", Fields{"status": 200, "error": errors.New("database is locked")})
+
+	entries := readEntriesForTest(t, output)
+	require.Len(t, entries, 1)
+	assert.Equal(t, map[string]interface{}{
+		"time":       "2019-06-01T12:00:00Z",
+		"level":      "info",
+		"msg":        "request",
+		"request_id": "1234",
+		"status":     float64(200),
+		"error":      "database is locked",
+	}, entries[0])
+}
+
+func TestLoggerShouldDiscardTheLowerLevels(t *testing.T) {
+	output := new(bytes.Buffer)
+	logger := loggerForTest(output, Warn)
+
+	logger.Debug("debug", nil)
+	logger.Info("info", nil)
+	logger.Warn("warn", nil)
+	logger.Error("error", nil)
+
+	entries := readEntriesForTest(t, output)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "warn", entries[0]["level"])
+	assert.Equal(t, "error", entries[1]["level"])
+}
+
+func TestLoggerShouldRedactCredentials(t *testing.T) {
+	cases := []struct {
+		name     string
+		fields   Fields
+		expected interface{}
+	}{
+		{"testRedactPassword", Fields{"value": "", "password": "pass1"}, Redacted},
+		{"testRedactToken", Fields{"value": "", "refresh_token": "abc"}, Redacted},
+		{"testRedactAuthorizationHeader", Fields{"value": "", "Authorization": "Bearer abc"}, Redacted},
+		{"testRedactBearerText", Fields{"value": "header Bearer abc.def"}, "header Bearer " + Redacted},
+		{"testRedactConnectionString", Fields{"value": "dial host=db password=pass1 dbname=messages"}, "dial host=db password=" + Redacted + " dbname=messages"},
+		{"testRedactConnectionUrl", Fields{"value": "dial postgres://user1:pass1@db/messages"}, "dial postgres://user1:" + Redacted + "@db/messages"},
+		{"testRedactErrorText", Fields{"value": errors.New("invalid token=abc")}, "invalid token=" + Redacted},
+		{"testRedactKeepsOtherFields", Fields{"value": "user1"}, "user1"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			output := new(bytes.Buffer)
+			loggerForTest(output, Info).Info("message", c.fields)
+
+			entry := readEntriesForTest(tt, output)[0]
+			for key := range c.fields {
+				if key != "value" {
+					assert.Equal(tt, Redacted, entry[key])
+				}
+			}
+			if c.expected != Redacted {
+				assert.Equal(tt, c.expected, entry["value"])
+			}
+			assert.NotContains(tt, output.String(), "pass1")
+			assert.NotContains(tt, output.String(), "abc")
+		})
+	}
+}
+
+func TestWithShouldNotModifyTheParent(t *testing.T) {
+	output := new(bytes.Buffer)
+	parent := loggerForTest(output, Info).With(Fields{"service": "messages"})
+	parent.With(Fields{"request_id": "1234"})
+
+	parent.Info("message", nil)
+	entry := readEntriesForTest(t, output)[0]
+	assert.Equal(t, "messages", entry["service"])
+	assert.NotContains(t, entry, "request_id")
+}
+
+func TestNilLoggerShouldDiscard(t *testing.T) {
+	var logger *Logger
+	assert.False(t, logger.Enabled(Error))
+	assert.Nil(t, logger.With(Fields{"request_id": "1234"}))
+	assert.NotPanics(t, func() { logger.Error("message", nil) })
+}
+
+func TestParseLevel(t *testing.T) {
+	cases := []struct {
+		name     string
+		level    string
+		expected Level
+		valid    bool
+	}{
+		{"testParseLevelDebug", "debug", Debug, true},
+		{"testParseLevelInfo", "info", Info, true},
+		{"testParseLevelWarn", "WARN", Warn, true},
+		{"testParseLevelError", "error", Error, true},
+		{"testParseLevelUnknown", "trace", Info, false},
+		{"testParseLevelEmpty", "", Info, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			level, err := ParseLevel(c.level)
+			assert.Equal(tt, c.valid, err == nil)
+			assert.Equal(tt, c.expected, level)
+		})
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Equal(t, Default, FromContext(context.Background()))
+
+	logger := New(new(bytes.Buffer), Debug)
+	assert.Equal(t, logger, FromContext(WithLogger(context.Background(), logger)))
+}
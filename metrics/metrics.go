@@ -0,0 +1,204 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/maidaneze/message-server/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+//Prefix of the metric names
+
+const namespace = "message_server"
+
+//Route of the requests that didn't match any route, so unknown paths don't create new series
+
+const UnmatchedRoute = "unmatched"
+
+//Method of the requests with a non standard method, so arbitrary methods don't create new series
+
+const OtherMethod = "other"
+
+//Methods recorded as they are, the rest are recorded as OtherMethod
+
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+	http.MethodPatch:   true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+}
+
+//Conversations of the messages
+
+const (
+	DirectConversation = "direct"
+	GroupConversation  = "group"
+)
+
+//Kinds of streaming connections
+
+const (
+	WebSocketStream = "websocket"
+	EventsStream    = "events"
+)
+
+//Metrics of the server, exposed in the Prometheus text format by Handler
+//Every metric is registered in its own registry, together with the Go runtime and process metrics
+//A nil Metrics records nothing
+
+type Metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	dbDuration      *prometheus.HistogramVec
+	dbErrors        *prometheus.CounterVec
+	dbRetries       prometheus.Counter
+	dbAttempts      *prometheus.CounterVec
+	streams         *prometheus.GaugeVec
+	messages        *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by method, route and status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of the HTTP requests by method, route and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		dbDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "db_operation_duration_seconds",
+			Help:      "Latency of the database operations by method, including their retries.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"operation"}),
+		dbErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "db_operation_errors_total",
+			Help:      "Number of failed database operations by method, after their retries.",
+		}, []string{"operation"}),
+		dbRetries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "db_retries_total",
+			Help:      "Number of database attempts retried after a failure.",
+		}),
+		dbAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "db_attempts_total",
+			Help:      "Number of database attempts by result, success or a transient or permanent error.",
+		}, []string{"result"}),
+		streams: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "active_streams",
+			Help:      "Number of open streaming connections by kind.",
+		}, []string{"kind"}),
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_total",
+			Help:      "Number of messages sent by conversation and content type.",
+		}, []string{"conversation", "type"}),
+	}
+
+	m.registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		m.requests, m.requestDuration, m.dbDuration, m.dbErrors, m.dbRetries, m.dbAttempts, m.streams, m.messages,
+	)
+	return m
+}
+
+//Returns the handler exposing the metrics
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+//Records a finished HTTP request
+//The route is the pattern of the request, or empty if it didn't match any
+//The method is recorded as OtherMethod unless it's a standard one
+
+func (m *Metrics) ObserveRequest(method string, route string, status int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	if route == "" {
+		route = UnmatchedRoute
+	}
+	if !knownMethods[method] {
+		method = OtherMethod
+	}
+	labels := prometheus.Labels{"method": method, "route": route, "status": strconv.Itoa(status)}
+	m.requests.With(labels).Inc()
+	m.requestDuration.With(labels).Observe(duration.Seconds())
+}
+
+//Records a finished database operation, err is the error the operation returned
+
+func (m *Metrics) ObserveDB(operation string, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.dbDuration.WithLabelValues(operation).Observe(duration.Seconds())
+	if err != nil {
+		m.dbErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+//Results of the database attempts
+
+const (
+	AttemptSucceeded = "success"
+	AttemptTransient = "transient"
+	AttemptPermanent = "permanent"
+)
+
+//Records a finished database attempt, and whether it's retried
+
+func (m *Metrics) ObserveAttempt(attempt utils.RetryAttempt) {
+	if m == nil {
+		return
+	}
+	result := AttemptSucceeded
+	if attempt.Err != nil && attempt.Retryable {
+		result = AttemptTransient
+	} else if attempt.Err != nil {
+		result = AttemptPermanent
+	}
+	m.dbAttempts.WithLabelValues(result).Inc()
+	if attempt.Retried {
+		m.dbRetries.Inc()
+	}
+}
+
+//Records a new streaming connection of the given kind
+//Returns the function to call once the connection is closed
+
+func (m *Metrics) OpenStream(kind string) func() {
+	if m == nil {
+		return func() {}
+	}
+	gauge := m.streams.WithLabelValues(kind)
+	gauge.Inc()
+	return gauge.Dec
+}
+
+//Records a sent message, the conversation is either DirectConversation or GroupConversation
+
+func (m *Metrics) CountMessage(conversation string, contentType string) {
+	if m == nil {
+		return
+	}
+	m.messages.WithLabelValues(conversation, contentType).Inc()
+}
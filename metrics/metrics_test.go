@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/maidaneze/message-server/utils"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveRequest(t *testing.T) {
+	m := New()
+	m.ObserveRequest("GET", "/messages", http.StatusOK, time.Millisecond)
+	m.ObserveRequest("GET", "/messages", http.StatusOK, time.Millisecond)
+	m.ObserveRequest("GET", "", http.StatusNotFound, time.Millisecond)
+	m.ObserveRequest("BREW", "", http.StatusNotFound, time.Millisecond)
+	m.ObserveRequest("get", "", http.StatusNotFound, time.Millisecond)
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.requests.WithLabelValues("GET", "/messages", "200")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.requests.WithLabelValues("GET", UnmatchedRoute, "404")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.requests.WithLabelValues(OtherMethod, UnmatchedRoute, "404")))
+
+	//Only the bounded methods are labels
+	families, err := m.registry.Gather()
+	assert.Nil(t, err)
+	series := 0
+	for _, family := range families {
+		if family.GetName() == "message_server_http_requests_total" {
+			series = len(family.GetMetric())
+		}
+	}
+	assert.Equal(t, 3, series)
+}
+
+func TestObserveDB(t *testing.T) {
+	m := New()
+	m.ObserveDB("GetUser", time.Millisecond, nil)
+	m.ObserveDB("GetUser", time.Millisecond, errors.New("database is locked"))
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.dbErrors.WithLabelValues("GetUser")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(m.dbErrors.WithLabelValues("InsertUser")))
+}
+
+func TestObserveAttempt(t *testing.T) {
+	m := New()
+	locked := errors.New("database is locked")
+	m.ObserveAttempt(utils.RetryAttempt{Number: 1, Err: locked, Retryable: true, Retried: true})
+	m.ObserveAttempt(utils.RetryAttempt{Number: 2, Err: locked, Retryable: true})
+	m.ObserveAttempt(utils.RetryAttempt{Number: 1, Err: errors.New("UNIQUE constraint failed")})
+	m.ObserveAttempt(utils.RetryAttempt{Number: 1})
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.dbRetries))
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.dbAttempts.WithLabelValues(AttemptTransient)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.dbAttempts.WithLabelValues(AttemptPermanent)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.dbAttempts.WithLabelValues(AttemptSucceeded)))
+}
+
+func TestOpenStream(t *testing.T) {
+	m := New()
+	closeFirst := m.OpenStream(WebSocketStream)
+	closeSecond := m.OpenStream(WebSocketStream)
+	m.OpenStream(EventsStream)
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.streams.WithLabelValues(WebSocketStream)))
+
+	closeFirst()
+	closeSecond()
+	assert.Equal(t, float64(0), testutil.ToFloat64(m.streams.WithLabelValues(WebSocketStream)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.streams.WithLabelValues(EventsStream)))
+}
+
+func TestCountMessage(t *testing.T) {
+	m := New()
+	m.CountMessage(DirectConversation, "text")
+	m.CountMessage(GroupConversation, "image")
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.messages.WithLabelValues(DirectConversation, "text")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.messages.WithLabelValues(GroupConversation, "image")))
+}
+
+func TestNilMetricsShouldRecordNothing(t *testing.T) {
+	var m *Metrics
+	assert.NotPanics(t, func() {
+		m.ObserveRequest("GET", "/messages", http.StatusOK, time.Millisecond)
+		m.ObserveDB("GetUser", time.Millisecond, nil)
+		m.ObserveAttempt(utils.RetryAttempt{Number: 1})
+		m.OpenStream(EventsStream)()
+		m.CountMessage(DirectConversation, "text")
+	})
+}
+
+func TestHandlerShouldExposeTheMetrics(t *testing.T) {
+	m := New()
+	m.CountMessage(DirectConversation, "video")
+
+	resp := httptest.NewRecorder()
+	m.Handler().ServeHTTP(resp, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), `message_server_messages_total{conversation="direct",type="video"} 1`)
+	assert.Contains(t, resp.Body.String(), "go_goroutines")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/maidaneze/message-server/dao"
+)
+
+const migrateUsage = `Usage: app [-config file] [-db-driver driver] [-db-source source] migrate <command>
+
+Commands:
+  up          Applies all the pending migrations
+  down [n]    Reverts the latest n applied migrations (default 1)
+  status      Prints the status of every known migration`
+
+//Executes the "migrate" command over the given database
+//Returns the exit status for the process
+
+func migrate(driver string, dataSource string, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	db, err := dao.ConnectDatabase(driver, dataSource)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to open DB:", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "up":
+		err = db.MigrateUp()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				fmt.Fprintln(os.Stderr, migrateUsage)
+				return 2
+			}
+		}
+		err = db.MigrateDown(steps)
+	case "status":
+		err = printMigrationStatus(db)
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Migration error:", err)
+		return 1
+	}
+	return 0
+}
+
+//Prints one line per known migration with its version, state and description
+//Returns error in case of failiure
+
+func printMigrationStatus(db dao.Migrator) error {
+	statuses, err := db.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		state := "pending"
+		if status.Applied {
+			state = "applied " + time.Unix(0, status.AppliedAt*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+		}
+		fmt.Printf("%4d  %-30s  %s\n", status.Version, state, status.Description)
+	}
+	return nil
+}
@@ -10,8 +10,27 @@ type UserResponseDTO struct {
 }
 
 type LoginResponseDTO struct {
-	Id    int64  `json:"id"`
-	Token string `json:"token"`
+	Id           int64  `json:"id"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+}
+
+type RefreshRequestDTO struct {
+	Id           int64  `json:"id"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type LogoutRequestDTO struct {
+	Id int64 `json:"id"`
+}
+
+type LogoutResponseDTO struct {
+	Revoked int64 `json:"revoked"`
+}
+
+type GetSessionsResponseDTO struct {
+	Sessions []SessionResponse `json:"sessions"`
 }
 
 type PostMessageRequestDTO struct {
@@ -28,3 +47,46 @@ type PostMessageResponseDTO struct {
 type GetMessageResponseDTO struct {
 	Messages []MessageResponse `json:"messages"`
 }
+
+type ReadMessagesRequestDTO struct {
+	Recipient int64 `json:"recipient"`
+	Message   int64 `json:"message"`
+}
+
+type ReadMessagesResponseDTO struct {
+	Read int64 `json:"read"`
+}
+
+type GetMessageStatusResponseDTO struct {
+	Statuses []MessageStatusResponse `json:"statuses"`
+}
+
+type CreateGroupRequestDTO struct {
+	Owner   int64   `json:"owner"`
+	Name    string  `json:"name"`
+	Members []int64 `json:"members"`
+}
+
+type CreateGroupResponseDTO struct {
+	Id int64 `json:"id"`
+}
+
+type GroupMemberRequestDTO struct {
+	User   int64 `json:"user"`
+	Group  int64 `json:"group"`
+	Member int64 `json:"member"`
+}
+
+type GetGroupMembersResponseDTO struct {
+	Members []int64 `json:"members"`
+}
+
+type PostGroupMessageRequestDTO struct {
+	Sender  int64          `json:"sender"`
+	Group   int64          `json:"group"`
+	Content MessageContent `json:"content"`
+}
+
+type GetGroupMessageResponseDTO struct {
+	Messages []GroupMessageResponse `json:"messages"`
+}
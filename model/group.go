@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+const MAX_GROUP_NAME_FIELD_SIZE = 64
+
+type Group struct {
+	GroupId int64
+	Name    string
+	OwnerId int64
+	Created time.Time
+}
+
+type GroupMessageDTO struct {
+	MessageId int64
+	GroupId   int64
+	SenderId  int64
+	Timestamp time.Time
+	Type      string
+	Text      string
+	Url       string
+	Height    int64
+	Width     int64
+	Source    string
+}
+
+type GroupMessageResponse struct {
+	Id        int64          `json:"id"`
+	Timestamp time.Time      `json:"timestamp"`
+	Sender    int64          `json:"sender"`
+	Group     int64          `json:"group"`
+	Content   MessageContent `json:"content"`
+}
@@ -3,17 +3,29 @@ package model
 import "time"
 
 const (
-	MAX_TEXT_FIELD_SIZE     = 1024
 	MAX_USERNAME_FIELD_SIZE = 32
 	MAX_PASSWORD_FIELD_SIZE = 32
 )
 
+//Maximum size of the text, url and source of the messages, set on startup from the configuration
+
+var MAX_TEXT_FIELD_SIZE = 1024
+
 type MessageResponse struct {
 	Id        int64          `json:"id"`
 	Timestamp time.Time      `json:"timestamp"`
 	Sender    int64          `json:"sender"`
 	Recipient int64          `json:"recipient"`
 	Content   MessageContent `json:"content"`
+	Delivered *time.Time     `json:"delivered,omitempty"`
+	Read      *time.Time     `json:"read,omitempty"`
+}
+
+type MessageStatusResponse struct {
+	Id        int64      `json:"id"`
+	Recipient int64      `json:"recipient"`
+	Delivered *time.Time `json:"delivered"`
+	Read      *time.Time `json:"read"`
 }
 
 type MessageContent interface{}
@@ -45,4 +57,13 @@ type MessageDTO struct {
 	Height      int64
 	Width       int64
 	Source      string
+	Delivered   *time.Time
+	Read        *time.Time
+}
+
+type MessageStatus struct {
+	MessageId   int64
+	RecipientId int64
+	Delivered   *time.Time
+	Read        *time.Time
 }
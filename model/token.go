@@ -1,6 +1,27 @@
 package model
 
+import "time"
+
+const MAX_USER_AGENT_FIELD_SIZE = 256
+
+//Access token of a session
+//The times are UTC milliseconds
+
 type Token struct {
+	SessionId  int64
+	Userid     int64
 	Uuid       string
 	Expiration int64
+	Created    int64
+	LastUsed   int64
+	UserAgent  string
+}
+
+type SessionResponse struct {
+	Id         int64     `json:"id"`
+	Created    time.Time `json:"created"`
+	Expiration time.Time `json:"expiration"`
+	LastUsed   time.Time `json:"last_used"`
+	UserAgent  string    `json:"user_agent"`
+	Current    bool      `json:"current"`
 }
@@ -1,21 +1,132 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"flag"
+	"github.com/maidaneze/message-server/config"
 	"github.com/maidaneze/message-server/controllers"
 	"github.com/maidaneze/message-server/dao"
-	"log"
+	"github.com/maidaneze/message-server/logging"
+	"github.com/maidaneze/message-server/metrics"
+	"github.com/maidaneze/message-server/services/auth"
+	"github.com/maidaneze/message-server/services/notifications"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
-	db, err := dao.OpenSqlite3Database("db/challenge.db")
+	options, err := config.Load(os.Args[0], os.Args[1:], os.LookupEnv, os.Stderr)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
 	if err != nil {
-		log.Fatal("Unable to open DB")
+		fatal(logging.Default, "Invalid configuration", err)
+	}
+	cfg := options.Config
+	logger := cfg.Logger(os.Stderr)
+
+	if options.PrintConfig {
+		if err := cfg.Write(os.Stdout); err != nil {
+			fatal(logger, "Unable to print the configuration", err)
+		}
+		os.Exit(0)
+	}
+
+	if len(options.Args) > 0 && options.Args[0] == "migrate" {
+		os.Exit(migrate(cfg.Database.Driver, cfg.Database.Source, options.Args[1:]))
+	}
+
+	cfg.ApplyLimits()
+
+	m := metrics.New()
+	retry := cfg.RetryOptions()
+	retry.OnAttempt = m.ObserveAttempt
+
+	db, err := dao.OpenDatabase(cfg.Database.Driver, cfg.Database.Source, retry)
+	if err != nil {
+		fatal(logger, "Unable to open DB", err)
+	}
+	db = dao.Instrument(db, m.ObserveDB)
+
+	tracer := cfg.Tracer(os.Stdout, logger)
+	if tracer != nil {
+		db = dao.Trace(db, cfg.Database.Driver)
+	}
+
+	h := controllers.Handler{Addr: cfg.Server.Addr, Db: db, Hub: notifications.NewHub(), Sessions: cfg.SessionPolicy(), Passwords: cfg.PasswordPolicy(), Log: logger, Metrics: m, Tracer: tracer, RequestTimeout: cfg.Server.RequestTimeout}
+
+	if cfg.Auth.TokenMode == config.JwtTokens {
+		keys, err := auth.LoadKeySet(cfg.Auth.JwtKeys)
+		if err != nil {
+			fatal(logger, "Unable to load JWT keys", err)
+		}
+		if h.Jwt, err = auth.NewJWTIssuer(keys, cfg.Auth.AccessTokenTTL); err != nil {
+			fatal(logger, "Invalid JWT keys", err)
+		}
 	}
 
+	if cfg.Auth.TokenHashKey != "" {
+		if h.Tokens, err = auth.LoadTokenHasher(cfg.Auth.TokenHashKey); err != nil {
+			fatal(logger, "Unable to load token hash key", err)
+		}
+	} else {
+		//Only the dev mode validates without a key
+		if h.Tokens, err = auth.GenerateTokenHasher(); err != nil {
+			fatal(logger, "Unable to generate token hash key", err)
+		}
+		logger.Warn("Dev mode without a token hash key, using a random key: sessions won't survive a restart", nil)
+	}
 
-	h := controllers.Handler{Db: db}
 	server := h.Setup()
-	fmt.Println("Server started!!")
-	log.Fatal(server.ListenAndServe())
+	logger.Info("Server started", logging.Fields{"addr": server.Addr})
+	os.Exit(serve(h, server, db, cfg.Server.ShutdownTimeout))
+}
+
+//Serves the requests until the process receives SIGINT or SIGTERM, then shuts down the server gracefully and
+//closes the database
+//Returns the exit status for the process, 0 if every request finished before the timeout and the database
+//was closed, 1 otherwise
+
+func serve(h controllers.Handler, server *http.Server, db dao.DB, timeout time.Duration) int {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	failed := make(chan error, 1)
+	go func() {
+		failed <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-failed:
+		h.Log.Error("Server error", logging.Fields{"error": err})
+		db.Close()
+		return 1
+	case sig := <-stop:
+		h.Log.Info("Shutting down", logging.Fields{"signal": sig.String()})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	status := 0
+	if err := h.Shutdown(ctx, server); err != nil {
+		h.Log.Error("Unable to finish the requests in progress", logging.Fields{"error": err})
+		status = 1
+	}
+
+	if err := db.Close(); err != nil {
+		h.Log.Error("Unable to close DB", logging.Fields{"error": err})
+		status = 1
+	}
+	return status
+}
+
+//Logs the error that prevents the server from starting and exits with status 1
+
+func fatal(logger *logging.Logger, message string, err error) {
+	logger.Error(message, logging.Fields{"error": err})
+	os.Exit(1)
 }
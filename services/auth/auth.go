@@ -1,27 +1,80 @@
 package auth
 
 import (
+	"crypto/subtle"
+	"errors"
 	"github.com/maidaneze/message-server/model"
 	"github.com/maidaneze/message-server/utils"
 	"time"
 
 	"net/http"
 
+	"strconv"
 	"strings"
 
 	"github.com/google/uuid"
 )
 
 var (
-	tokenDurationMilliseconds = int64(time.Hour) * 24 * 365 / int64(time.Millisecond)
-	TokenLengthInBytes        = 36
+	TokenLengthInBytes = 36
+
+	//Default session policy, at most 2 sessions per user with tokens valid for a year
+
+	DefaultSessionPolicy = SessionPolicy{MaxSessions: 2, TokenTTL: time.Hour * 24 * 365}
+
+	//Minimum time between updates of the last used time of a session, avoids a write on every request
+
+	LastUsedResolution = time.Minute
 )
 
-//Generates a Token
+//Limits on the sessions of the users
+//MaxSessions is the maximum number of concurrent sessions per user, the oldest session is closed when a new one
+//exceeds it. A MaxSessions of 0 or less doesn't limit the number of sessions
+//TokenTTL is the time an access token is valid since the login
+
+type SessionPolicy struct {
+	MaxSessions int
+	TokenTTL    time.Duration
+}
+
+//Generates a Token for a new session started from the given user agent
+//The token expires after the policy TokenTTL
+//The user agent is truncated to MAX_USER_AGENT_FIELD_SIZE bytes
+//Returns error in case of failiure
+
+func (p SessionPolicy) GenerateSessionToken(userAgent string) (model.Token, error) {
+	token, err := generateToken(p.TokenTTL)
+	if err != nil {
+		return token, err
+	}
+
+	if len(userAgent) > model.MAX_USER_AGENT_FIELD_SIZE {
+		userAgent = userAgent[:model.MAX_USER_AGENT_FIELD_SIZE]
+	}
+	token.UserAgent = userAgent
+	return token, nil
+}
+
+//Generates a random refresh token, an opaque uuid like the access tokens
+//Returns error in case of failiure
+
+func GenerateRefreshToken() (string, error) {
+	token, err := generateToken(0)
+	return token.Uuid, err
+}
+
+//Generates a Token valid for the DefaultSessionPolicy TokenTTL
 //The uuid has 122 random bits and a length of 36 bytes
 //Returns error in case of failiure
 
 func GenerateToken() (model.Token, error) {
+	return generateToken(DefaultSessionPolicy.TokenTTL)
+}
+
+//Generates a Token valid for the given duration
+//Returns error in case of failiure
+
+func generateToken(ttl time.Duration) (model.Token, error) {
 	var err error
 	var tokenUUID uuid.UUID
 	utils.Retry(func() error {
@@ -32,8 +85,13 @@ func GenerateToken() (model.Token, error) {
 	if err != nil {
 		return model.Token{}, err
 	}
-	expiration := utils.UTCTimeMilliseconds() + tokenDurationMilliseconds
-	token := model.Token{tokenUUID.String(), expiration}
+	now := utils.UTCTimeMilliseconds()
+	token := model.Token{
+		Uuid:       tokenUUID.String(),
+		Expiration: now + int64(ttl/time.Millisecond),
+		Created:    now,
+		LastUsed:   now,
+	}
 	return token, nil
 }
 
@@ -64,12 +122,85 @@ func FindToken(token model.Token, tokens []model.Token) bool {
 }
 
 //Returns true if the requestToken is one of the userTokens and it hasn't expired and returns false otherwise
+//The stored tokens are hashes, so the requestToken must be hashed with the same TokenHasher
+//The tokens are compared in constant time
 
 func ValidateAuthorizedUser(requestToken string, userTokens []model.Token) bool {
+	_, found := FindAuthorizedToken(requestToken, userTokens)
+	return found
+}
+
+//Returns the token of userTokens matching the requestToken and true if it hasn't expired
+//Returns false otherwise
+//Every token is compared in constant time, so the time doesn't leak which token or how much of it matched
+
+func FindAuthorizedToken(requestToken string, userTokens []model.Token) (model.Token, bool) {
+	now := utils.UTCTimeMilliseconds()
+	found := false
+	var token model.Token
 	for _, value := range userTokens {
-		if (value.Uuid == requestToken) && (value.Expiration >= utils.UTCTimeMilliseconds()) {
-			return true
+		if subtle.ConstantTimeCompare([]byte(value.Uuid), []byte(requestToken)) == 1 && !found && value.Expiration >= now {
+			token, found = value, true
+		}
+	}
+	return token, found
+}
+
+//Returns true if the last used time of the token is older than LastUsedResolution and must be updated
+
+func ShouldTouchToken(token model.Token, now int64) bool {
+	return now-token.LastUsed >= int64(LastUsedResolution/time.Millisecond)
+}
+
+//Parses the tokens that haven't expired into session responses
+//The current session is flagged as current
+
+func ParseSessions(tokens []model.Token, current int64) []model.SessionResponse {
+	now := utils.UTCTimeMilliseconds()
+	response := make([]model.SessionResponse, 0)
+	for _, token := range tokens {
+		if token.Expiration < now {
+			continue
 		}
+		response = append(response, model.SessionResponse{
+			Id:         token.SessionId,
+			Created:    millisecondsToTime(token.Created),
+			Expiration: millisecondsToTime(token.Expiration),
+			LastUsed:   millisecondsToTime(token.LastUsed),
+			UserAgent:  token.UserAgent,
+			Current:    token.SessionId == current,
+		})
 	}
-	return false
+	return response
+}
+
+//Parses the query params of the sessions requests
+//Returns the optional userid and session id (0 if they aren't present)
+//The userid is optional since the user is the authenticated user
+//Returns error if they aren't numbers
+
+func ParseSessionQueryParams(r *http.Request) (int64, int64, error) {
+	query := r.URL.Query()
+	user, foundUserId := query["id"]
+	session, foundSessionId := query["session"]
+
+	var userId int64
+	var err error
+	if foundUserId {
+		if userId, err = strconv.ParseInt(user[0], 10, 64); err != nil {
+			return 0, 0, errors.New("Invalid Body")
+		}
+	}
+
+	var sessionId int64
+	if foundSessionId {
+		if sessionId, err = strconv.ParseInt(session[0], 10, 64); err != nil {
+			return 0, 0, errors.New("Invalid Body")
+		}
+	}
+	return userId, sessionId, nil
+}
+
+func millisecondsToTime(milliseconds int64) time.Time {
+	return time.Unix(0, milliseconds*int64(time.Millisecond)).UTC()
 }
@@ -3,7 +3,10 @@ package auth
 import (
 	"github.com/maidaneze/message-server/model"
 	"github.com/maidaneze/message-server/utils"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"net/http"
 
@@ -53,9 +56,9 @@ func TestValidateTokenHeader(t *testing.T) {
 
 func TestFindToken(t *testing.T) {
 	emptyToken := model.Token{}
-	token1 := model.Token{"123", 1}
-	token2 := model.Token{"456", 2}
-	token3 := model.Token{"789", 3}
+	token1 := model.Token{Uuid: "123", Expiration: 1}
+	token2 := model.Token{Uuid: "456", Expiration: 2}
+	token3 := model.Token{Uuid: "789", Expiration: 3}
 	tokensSlice := []model.Token{token1, token2, token3}
 	cases := []struct {
 		name   string
@@ -70,8 +73,8 @@ func TestFindToken(t *testing.T) {
 		{"testFindTokenReturnsFalse1", token1, []model.Token{token2, token3}, false},
 		{"testFindTokenReturnsFalse2", token2, []model.Token{token1, token3}, false},
 		{"testFindTokenReturnsFalse3", token3, []model.Token{token1, token2}, false},
-		{"testFindTokenReturnsFalse4", model.Token{token1.Uuid, 0}, tokensSlice, false},
-		{"testFindTokenReturnsFalse5", model.Token{"invalid", token1.Expiration}, tokensSlice, false},
+		{"testFindTokenReturnsFalse4", model.Token{Uuid: token1.Uuid, Expiration: 0}, tokensSlice, false},
+		{"testFindTokenReturnsFalse5", model.Token{Uuid: "invalid", Expiration: token1.Expiration}, tokensSlice, false},
 	}
 	for _, c := range cases {
 		t.Run(c.name, func(tt *testing.T) {
@@ -127,9 +130,137 @@ func TestValidateAuthorizedUser(t *testing.T) {
 
 }
 
+func TestGenerateSessionToken(t *testing.T) {
+	longUserAgent := strings.Repeat("a", model.MAX_USER_AGENT_FIELD_SIZE+1)
+	cases := []struct {
+		name              string
+		policy            SessionPolicy
+		userAgent         string
+		expectedUserAgent string
+	}{
+		{"testGenerateSessionTokenWithDefaultPolicy", DefaultSessionPolicy, "curl/7.64.0", "curl/7.64.0"},
+		{"testGenerateSessionTokenWithShortTTL", SessionPolicy{MaxSessions: 1, TokenTTL: time.Hour}, "", ""},
+		{"testGenerateSessionTokenTruncatesUserAgent", DefaultSessionPolicy, longUserAgent, longUserAgent[:model.MAX_USER_AGENT_FIELD_SIZE]},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			token, err := c.policy.GenerateSessionToken(c.userAgent)
+			assert.Nil(tt, err)
+			assert.True(tt, properTokenLength(token))
+			assert.True(tt, tokenExpirationInRangeFor(token, c.policy.TokenTTL))
+			assert.Equal(tt, token.Created, token.LastUsed)
+			assert.Equal(tt, c.expectedUserAgent, token.UserAgent)
+		})
+	}
+}
+
+func TestFindAuthorizedToken(t *testing.T) {
+	token1, _ := GenerateToken()
+	token1.SessionId = 1
+	token2, _ := GenerateToken()
+	token2.SessionId = 2
+	expired := token2
+	expired.Expiration = 0
+	cases := []struct {
+		name          string
+		token         string
+		tokens        []model.Token
+		expectedToken model.Token
+		expectedFound bool
+	}{
+		{"testFindAuthorizedTokenReturnsFirstToken", token1.Uuid, []model.Token{token1, token2}, token1, true},
+		{"testFindAuthorizedTokenReturnsSecondToken", token2.Uuid, []model.Token{token1, token2}, token2, true},
+		{"testFindAuthorizedTokenIgnoresExpiredToken", token2.Uuid, []model.Token{token1, expired}, model.Token{}, false},
+		{"testFindAuthorizedTokenWithUnknownToken", "invalid", []model.Token{token1, token2}, model.Token{}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			token, found := FindAuthorizedToken(c.token, c.tokens)
+			assert.Equal(tt, c.expectedFound, found)
+			assert.Equal(tt, c.expectedToken, token)
+		})
+	}
+}
+
+func TestShouldTouchToken(t *testing.T) {
+	resolution := int64(LastUsedResolution / time.Millisecond)
+	var now int64 = 1000000
+	cases := []struct {
+		name     string
+		lastUsed int64
+		expected bool
+	}{
+		{"testShouldTouchTokenUsedNow", now, false},
+		{"testShouldTouchTokenUsedWithinResolution", now - resolution + 1, false},
+		{"testShouldTouchTokenUsedAtResolution", now - resolution, true},
+		{"testShouldTouchTokenNeverUsed", 0, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			assert.Equal(tt, c.expected, ShouldTouchToken(model.Token{LastUsed: c.lastUsed}, now))
+		})
+	}
+}
+
+func TestParseSessions(t *testing.T) {
+	current, _ := DefaultSessionPolicy.GenerateSessionToken("current")
+	current.SessionId = 1
+	other, _ := DefaultSessionPolicy.GenerateSessionToken("other")
+	other.SessionId = 2
+	expired := other
+	expired.SessionId = 3
+	expired.Expiration = 0
+
+	sessions := ParseSessions([]model.Token{current, other, expired}, current.SessionId)
+	assert.Equal(t, 2, len(sessions))
+
+	assert.Equal(t, int64(1), sessions[0].Id)
+	assert.Equal(t, "current", sessions[0].UserAgent)
+	assert.True(t, sessions[0].Current)
+	assert.Equal(t, current.Created, sessions[0].Created.UnixNano()/int64(time.Millisecond))
+	assert.Equal(t, current.Expiration, sessions[0].Expiration.UnixNano()/int64(time.Millisecond))
+	assert.Equal(t, current.LastUsed, sessions[0].LastUsed.UnixNano()/int64(time.Millisecond))
+
+	assert.Equal(t, int64(2), sessions[1].Id)
+	assert.Equal(t, "other", sessions[1].UserAgent)
+	assert.False(t, sessions[1].Current)
+
+	assert.Equal(t, 0, len(ParseSessions([]model.Token{expired}, current.SessionId)))
+}
+
+func TestParseSessionQueryParams(t *testing.T) {
+	cases := []struct {
+		name              string
+		query             string
+		expectedUserId    int64
+		expectedSessionId int64
+		expectedError     bool
+	}{
+		{"testParseSessionQueryParamsWithUser", "id=1", 1, 0, false},
+		{"testParseSessionQueryParamsWithUserAndSession", "id=1&session=2", 1, 2, false},
+		{"testParseSessionQueryParamsWithoutUser", "session=2", 0, 2, false},
+		{"testParseSessionQueryParamsWithInvalidUser", "id=a&session=2", 0, 0, true},
+		{"testParseSessionQueryParamsWithInvalidSession", "id=1&session=a", 0, 0, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			r := httptest.NewRequest("GET", "/sessions?"+c.query, nil)
+			userId, sessionId, err := ParseSessionQueryParams(r)
+			assert.Equal(tt, c.expectedError, err != nil)
+			assert.Equal(tt, c.expectedUserId, userId)
+			assert.Equal(tt, c.expectedSessionId, sessionId)
+		})
+	}
+}
+
 func tokenExpirationInRange(token model.Token) bool {
+	return tokenExpirationInRangeFor(token, DefaultSessionPolicy.TokenTTL)
+}
+
+func tokenExpirationInRangeFor(token model.Token, ttl time.Duration) bool {
 	now := utils.UTCTimeMilliseconds()
-	return token.Expiration <= now+tokenDurationMilliseconds && token.Expiration > now-1000
+	duration := int64(ttl / time.Millisecond)
+	return token.Expiration <= now+duration && token.Expiration > now+duration-1000
 }
 
 func properTokenLength(token model.Token) bool {
@@ -0,0 +1,35 @@
+package auth
+
+import "context"
+
+//Authenticated user of a request, resolved from its bearer token
+
+type Identity struct {
+	Userid    int64
+	SessionId int64
+}
+
+type contextKey int
+
+const identityKey contextKey = 0
+
+//Returns a copy of the context with the authenticated user of the request
+
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey, identity)
+}
+
+//Returns the authenticated user of the request and true if the request was authenticated
+//Returns false otherwise
+
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, found := ctx.Value(identityKey).(Identity)
+	return identity, found
+}
+
+//Returns true if the claimed user is the authenticated user or no user was claimed
+//Requests may omit their user, which is then the authenticated one, but can't claim to be another user
+
+func (i Identity) Matches(userid int64) bool {
+	return userid == 0 || userid == i.Userid
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentityContext(t *testing.T) {
+	_, found := IdentityFromContext(context.Background())
+	assert.False(t, found)
+
+	identity := Identity{Userid: 1, SessionId: 2}
+	stored, found := IdentityFromContext(WithIdentity(context.Background(), identity))
+	assert.True(t, found)
+	assert.Equal(t, identity, stored)
+}
+
+func TestIdentityMatches(t *testing.T) {
+	identity := Identity{Userid: 1, SessionId: 2}
+	cases := []struct {
+		name    string
+		userid  int64
+		matches bool
+	}{
+		{"testIdentityMatchesOmittedUser", 0, true},
+		{"testIdentityMatchesSameUser", 1, true},
+		{"testIdentityMatchesOtherUser", 2, false},
+		{"testIdentityMatchesNegativeUser", -1, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			assert.Equal(tt, c.matches, identity.Matches(c.userid))
+		})
+	}
+}
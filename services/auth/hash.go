@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	"github.com/maidaneze/message-server/model"
+)
+
+//Minimum size of the key used to hash the tokens
+
+const TokenHashKeySize = 32
+
+//Hashes the bearer tokens before storing them, so the database only has keyed hashes of the tokens
+//The hash is an HMAC-SHA256 of the token, without the key a stolen hash can't be used as a token
+
+type TokenHasher struct {
+	key []byte
+}
+
+//Creates a token hasher with the given key
+//Returns error if the key is shorter than TokenHashKeySize
+
+func NewTokenHasher(key []byte) (TokenHasher, error) {
+	if len(key) < TokenHashKeySize {
+		return TokenHasher{}, errors.New("The token hash key must be at least 32 bytes")
+	}
+	return TokenHasher{key: append([]byte(nil), key...)}, nil
+}
+
+//Creates a token hasher with a random key
+//The tokens hashed with it can't be validated after a restart, so it's only meant for tests and development
+//Returns error in case of failiure
+
+func GenerateTokenHasher() (TokenHasher, error) {
+	key := make([]byte, TokenHashKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return TokenHasher{}, err
+	}
+	return NewTokenHasher(key)
+}
+
+//Loads the token hasher key from a file with the base64 encoded key
+//Returns error if the file can't be read or the key is invalid
+
+func LoadTokenHasher(path string) (TokenHasher, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return TokenHasher{}, err
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return TokenHasher{}, err
+	}
+	return NewTokenHasher(key)
+}
+
+//Returns true if the hasher has no key
+
+func (h TokenHasher) IsZero() bool {
+	return h.key == nil
+}
+
+//Returns the hex encoded keyed hash of the token
+
+func (h TokenHasher) Hash(token string) string {
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//Returns a copy of the token with its uuid replaced by its hash, ready to be stored
+
+func (h TokenHasher) HashToken(token model.Token) model.Token {
+	token.Uuid = h.Hash(token.Uuid)
+	return token
+}
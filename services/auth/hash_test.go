@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/maidaneze/message-server/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenHasher(t *testing.T) {
+	hasher, err := NewTokenHasher(testSecret)
+	require.Nil(t, err)
+	otherHasher, err := GenerateTokenHasher()
+	require.Nil(t, err)
+
+	token, err := GenerateToken()
+	require.Nil(t, err)
+	hash := hasher.Hash(token.Uuid)
+
+	assert.Equal(t, 64, len(hash))
+	assert.NotEqual(t, token.Uuid, hash)
+	assert.Equal(t, hash, hasher.Hash(token.Uuid))
+	assert.NotEqual(t, hash, otherHasher.Hash(token.Uuid))
+
+	hashed := hasher.HashToken(token)
+	assert.Equal(t, hash, hashed.Uuid)
+	assert.Equal(t, token.Expiration, hashed.Expiration)
+
+	//The request token is validated against the stored hash
+	found, valid := FindAuthorizedToken(hasher.Hash(token.Uuid), []model.Token{hashed})
+	assert.True(t, valid)
+	assert.Equal(t, hashed, found)
+	_, valid = FindAuthorizedToken(token.Uuid, []model.Token{hashed})
+	assert.False(t, valid)
+	_, valid = FindAuthorizedToken(otherHasher.Hash(token.Uuid), []model.Token{hashed})
+	assert.False(t, valid)
+}
+
+func TestFailToCreateTokenHasher(t *testing.T) {
+	_, err := NewTokenHasher(nil)
+	assert.NotNil(t, err)
+
+	_, err = NewTokenHasher(testSecret[:TokenHashKeySize-1])
+	assert.NotNil(t, err)
+
+	assert.True(t, TokenHasher{}.IsZero())
+}
+
+func TestLoadTokenHasher(t *testing.T) {
+	file, err := ioutil.TempFile("", "token-key")
+	require.Nil(t, err)
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(base64.StdEncoding.EncodeToString(testSecret) + "\n")
+	require.Nil(t, err)
+	file.Close()
+
+	hasher, err := LoadTokenHasher(file.Name())
+	require.Nil(t, err)
+	expected, _ := NewTokenHasher(testSecret)
+	assert.Equal(t, expected.Hash("token"), hasher.Hash("token"))
+
+	_, err = LoadTokenHasher(file.Name() + "missing")
+	assert.NotNil(t, err)
+}
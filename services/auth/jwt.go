@@ -0,0 +1,274 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	HS256 = "HS256"
+	EdDSA = "EdDSA"
+)
+
+var (
+	ErrInvalidToken = errors.New("Invalid access token")
+	ErrExpiredToken = errors.New("Expired access token")
+
+	//Default lifetime of the signed access tokens
+
+	DefaultAccessTokenTTL = 15 * time.Minute
+)
+
+//Key used to sign and verify access tokens
+//HS256 keys use the Secret for both operations
+//EdDSA keys sign with the PrivateKey and verify with the PublicKey, a key without PrivateKey can only verify
+
+type SigningKey struct {
+	Id         string
+	Algorithm  string
+	Secret     []byte
+	PrivateKey ed25519.PrivateKey
+	PublicKey  ed25519.PublicKey
+}
+
+//Set of keys indexed by key id
+//New tokens are signed with the Current key, the other keys are kept to verify the tokens signed before a rotation
+
+type KeySet struct {
+	Current string
+	Keys    map[string]SigningKey
+}
+
+//Claims of the signed access tokens
+//The subject is the userid, the times are UTC seconds
+
+type AccessClaims struct {
+	Subject   string `json:"sub"`
+	SessionId int64  `json:"sid"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyId     string `json:"kid"`
+}
+
+//Key file format, the keys are base64 encoded
+//An EdDSA private key can be either the 32 bytes seed or the 64 bytes private key
+
+type keyFile struct {
+	Current string `json:"current"`
+	Keys    []struct {
+		Id         string `json:"id"`
+		Algorithm  string `json:"algorithm"`
+		Secret     string `json:"secret"`
+		PrivateKey string `json:"private_key"`
+		PublicKey  string `json:"public_key"`
+	} `json:"keys"`
+}
+
+//Loads the key set from a json key file
+//Returns error if the file can't be read or a key is invalid
+
+func LoadKeySet(path string) (KeySet, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return KeySet{}, err
+	}
+
+	var file keyFile
+	if err = json.Unmarshal(content, &file); err != nil {
+		return KeySet{}, err
+	}
+
+	keys := KeySet{Current: file.Current, Keys: make(map[string]SigningKey)}
+	for _, k := range file.Keys {
+		key := SigningKey{Id: k.Id, Algorithm: k.Algorithm}
+		if key.Secret, err = decodeKey(k.Secret); err != nil {
+			return KeySet{}, err
+		}
+
+		privateKey, err := decodeKey(k.PrivateKey)
+		if err != nil {
+			return KeySet{}, err
+		}
+		if len(privateKey) == ed25519.SeedSize {
+			privateKey = ed25519.NewKeyFromSeed(privateKey)
+		}
+		key.PrivateKey = privateKey
+
+		if key.PublicKey, err = decodeKey(k.PublicKey); err != nil {
+			return KeySet{}, err
+		}
+		keys.Keys[key.Id] = key
+	}
+	return keys, nil
+}
+
+func decodeKey(key string) ([]byte, error) {
+	if key == "" {
+		return nil, nil
+	}
+	return base64.StdEncoding.DecodeString(key)
+}
+
+//Issues and validates signed access tokens
+
+type JWTIssuer struct {
+	keys KeySet
+	ttl  time.Duration
+}
+
+//Creates an issuer signing with the current key of the key set, the tokens are valid for the given duration
+//Returns error if the current key can't sign or any key is invalid
+
+func NewJWTIssuer(keySet KeySet, ttl time.Duration) (*JWTIssuer, error) {
+	keys := KeySet{Current: keySet.Current, Keys: make(map[string]SigningKey)}
+	for id, key := range keySet.Keys {
+		if id != key.Id {
+			return nil, errors.New("Key id mismatch: " + id)
+		}
+
+		switch key.Algorithm {
+		case HS256:
+			if len(key.Secret) < sha256.Size {
+				return nil, errors.New("HS256 secret must be at least 32 bytes: " + id)
+			}
+		case EdDSA:
+			if key.PrivateKey != nil && len(key.PrivateKey) != ed25519.PrivateKeySize {
+				return nil, errors.New("Invalid EdDSA private key: " + id)
+			}
+			if key.PublicKey == nil && key.PrivateKey != nil {
+				key.PublicKey = key.PrivateKey.Public().(ed25519.PublicKey)
+			}
+			if len(key.PublicKey) != ed25519.PublicKeySize {
+				return nil, errors.New("Invalid EdDSA public key: " + id)
+			}
+		default:
+			return nil, errors.New("Unknown algorithm for key: " + id)
+		}
+		keys.Keys[id] = key
+	}
+
+	current, found := keys.Keys[keys.Current]
+	if !found || (current.Algorithm == EdDSA && current.PrivateKey == nil) {
+		return nil, errors.New("The current key must be able to sign")
+	}
+
+	if ttl <= 0 {
+		return nil, errors.New("The access token duration must be positive")
+	}
+	return &JWTIssuer{keys: keys, ttl: ttl}, nil
+}
+
+//Returns the duration of the issued access tokens
+
+func (i *JWTIssuer) TTL() time.Duration {
+	return i.ttl
+}
+
+//Issues an access token for the session of the user signed with the current key
+//Returns error in case of failiure
+
+func (i *JWTIssuer) Issue(userid int64, sessionId int64) (string, error) {
+	key := i.keys.Keys[i.keys.Current]
+	now := time.Now().Unix()
+
+	header, err := json.Marshal(jwtHeader{Algorithm: key.Algorithm, Type: "JWT", KeyId: key.Id})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(AccessClaims{
+		Subject:   strconv.FormatInt(userid, 10),
+		SessionId: sessionId,
+		IssuedAt:  now,
+		ExpiresAt: now + int64(i.ttl/time.Second),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sign(key, []byte(signingInput))), nil
+}
+
+//Validates the signature and expiration of the access token
+//The token must be signed with one of the keys of the set using the algorithm of that key
+//Returns ErrInvalidToken if the token is malformed or the signature is invalid
+//Returns ErrExpiredToken if the token expired
+//Returns the token claims otherwise
+
+func (i *JWTIssuer) Validate(token string) (AccessClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return AccessClaims{}, ErrInvalidToken
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return AccessClaims{}, ErrInvalidToken
+	}
+
+	key, found := i.keys.Keys[header.KeyId]
+	if !found || key.Algorithm != header.Algorithm {
+		return AccessClaims{}, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !verify(key, []byte(parts[0]+"."+parts[1]), signature) {
+		return AccessClaims{}, ErrInvalidToken
+	}
+
+	var claims AccessClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return AccessClaims{}, ErrInvalidToken
+	}
+
+	if claims.ExpiresAt <= time.Now().Unix() {
+		return AccessClaims{}, ErrExpiredToken
+	}
+	return claims, nil
+}
+
+//Returns the userid of the claims subject
+//Returns error if the subject isn't a userid
+
+func (c AccessClaims) Userid() (int64, error) {
+	return strconv.ParseInt(c.Subject, 10, 64)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	content, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, v)
+}
+
+func sign(key SigningKey, signingInput []byte) []byte {
+	if key.Algorithm == EdDSA {
+		return ed25519.Sign(key.PrivateKey, signingInput)
+	}
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write(signingInput)
+	return mac.Sum(nil)
+}
+
+func verify(key SigningKey, signingInput []byte, signature []byte) bool {
+	if key.Algorithm == EdDSA {
+		return ed25519.Verify(key.PublicKey, signingInput, signature)
+	}
+	return hmac.Equal(sign(key, signingInput), signature)
+}
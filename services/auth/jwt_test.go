@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+var (
+	testSecret = []byte("0123456789abcdef0123456789abcdef")
+	testSeed   = []byte("fedcba9876543210fedcba9876543210")
+)
+
+func testKeySet(current string) KeySet {
+	privateKey := ed25519.NewKeyFromSeed(testSeed)
+	return KeySet{
+		Current: current,
+		Keys: map[string]SigningKey{
+			"hmac":    {Id: "hmac", Algorithm: HS256, Secret: testSecret},
+			"ed25519": {Id: "ed25519", Algorithm: EdDSA, PrivateKey: privateKey},
+		},
+	}
+}
+
+func TestJWTIssuerShouldValidateIssuedTokens(t *testing.T) {
+	cases := []struct {
+		name    string
+		current string
+	}{
+		{"testJWTIssuerWithHS256", "hmac"},
+		{"testJWTIssuerWithEdDSA", "ed25519"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			issuer, err := NewJWTIssuer(testKeySet(c.current), time.Minute)
+			require.Nil(tt, err)
+
+			token, err := issuer.Issue(123, 456)
+			require.Nil(tt, err)
+
+			claims, err := issuer.Validate(token)
+			assert.Nil(tt, err)
+			assert.Equal(tt, "123", claims.Subject)
+			userid, err := claims.Userid()
+			assert.Nil(tt, err)
+			assert.Equal(tt, int64(123), userid)
+			assert.Equal(tt, int64(456), claims.SessionId)
+			assert.Equal(tt, claims.IssuedAt+60, claims.ExpiresAt)
+		})
+	}
+}
+
+func TestJWTIssuerShouldValidateTokensOfRotatedKeys(t *testing.T) {
+	oldIssuer, err := NewJWTIssuer(testKeySet("hmac"), time.Minute)
+	require.Nil(t, err)
+	token, err := oldIssuer.Issue(1, 1)
+	require.Nil(t, err)
+
+	//The tokens signed with the previous key are valid while the key is in the set
+	issuer, err := NewJWTIssuer(testKeySet("ed25519"), time.Minute)
+	require.Nil(t, err)
+	_, err = issuer.Validate(token)
+	assert.Nil(t, err)
+
+	//And invalid once it is removed
+	keys := testKeySet("ed25519")
+	delete(keys.Keys, "hmac")
+	issuer, err = NewJWTIssuer(keys, time.Minute)
+	require.Nil(t, err)
+	_, err = issuer.Validate(token)
+	assert.Equal(t, ErrInvalidToken, err)
+}
+
+func TestJWTIssuerShouldRejectInvalidTokens(t *testing.T) {
+	issuer, err := NewJWTIssuer(testKeySet("hmac"), time.Minute)
+	require.Nil(t, err)
+	token, err := issuer.Issue(1, 1)
+	require.Nil(t, err)
+	parts := strings.Split(token, ".")
+
+	otherKeys := testKeySet("hmac")
+	otherKeys.Keys["hmac"] = SigningKey{Id: "hmac", Algorithm: HS256, Secret: []byte(strings.Repeat("x", 32))}
+	otherIssuer, err := NewJWTIssuer(otherKeys, time.Minute)
+	require.Nil(t, err)
+	otherToken, err := otherIssuer.Issue(1, 1)
+	require.Nil(t, err)
+
+	expiredIssuer, err := NewJWTIssuer(testKeySet("hmac"), time.Nanosecond)
+	require.Nil(t, err)
+	expiredToken, err := expiredIssuer.Issue(1, 1)
+	require.Nil(t, err)
+
+	tamperedClaims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"2","sid":1,"iat":0,"exp":9999999999}`))
+	confusedHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"EdDSA","typ":"JWT","kid":"hmac"}`))
+	unknownHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT","kid":"unknown"}`))
+
+	cases := []struct {
+		name     string
+		token    string
+		expected error
+	}{
+		{"testValidateEmptyToken", "", ErrInvalidToken},
+		{"testValidateOpaqueToken", "4974e5a5-c2ef-4562-be0d-54ab937b590b", ErrInvalidToken},
+		{"testValidateTokenWithInvalidHeader", "invalid." + parts[1] + "." + parts[2], ErrInvalidToken},
+		{"testValidateTokenWithInvalidSignature", parts[0] + "." + parts[1] + ".invalid", ErrInvalidToken},
+		{"testValidateTokenWithTamperedClaims", parts[0] + "." + tamperedClaims + "." + parts[2], ErrInvalidToken},
+		{"testValidateTokenWithAnotherAlgorithm", confusedHeader + "." + parts[1] + "." + parts[2], ErrInvalidToken},
+		{"testValidateTokenWithUnknownKey", unknownHeader + "." + parts[1] + "." + parts[2], ErrInvalidToken},
+		{"testValidateTokenSignedWithAnotherSecret", otherToken, ErrInvalidToken},
+		{"testValidateExpiredToken", expiredToken, ErrExpiredToken},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			_, err := issuer.Validate(c.token)
+			assert.Equal(tt, c.expected, err)
+		})
+	}
+}
+
+func TestNewJWTIssuerShouldFailWithInvalidKeys(t *testing.T) {
+	verifyOnly := testKeySet("ed25519")
+	verifyOnly.Keys["ed25519"] = SigningKey{Id: "ed25519", Algorithm: EdDSA, PublicKey: ed25519.NewKeyFromSeed(testSeed).Public().(ed25519.PublicKey)}
+
+	cases := []struct {
+		name string
+		keys KeySet
+		ttl  time.Duration
+	}{
+		{"testNewJWTIssuerWithoutKeys", KeySet{Current: "hmac"}, time.Minute},
+		{"testNewJWTIssuerWithUnknownCurrentKey", testKeySet("unknown"), time.Minute},
+		{"testNewJWTIssuerWithVerifyOnlyCurrentKey", verifyOnly, time.Minute},
+		{"testNewJWTIssuerWithShortSecret", KeySet{Current: "hmac", Keys: map[string]SigningKey{"hmac": {Id: "hmac", Algorithm: HS256, Secret: []byte("short")}}}, time.Minute},
+		{"testNewJWTIssuerWithInvalidPrivateKey", KeySet{Current: "ed", Keys: map[string]SigningKey{"ed": {Id: "ed", Algorithm: EdDSA, PrivateKey: []byte("short")}}}, time.Minute},
+		{"testNewJWTIssuerWithUnknownAlgorithm", KeySet{Current: "rsa", Keys: map[string]SigningKey{"rsa": {Id: "rsa", Algorithm: "RS256", Secret: testSecret}}}, time.Minute},
+		{"testNewJWTIssuerWithMismatchedKeyId", KeySet{Current: "hmac", Keys: map[string]SigningKey{"hmac": {Id: "other", Algorithm: HS256, Secret: testSecret}}}, time.Minute},
+		{"testNewJWTIssuerWithoutTTL", testKeySet("hmac"), 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			_, err := NewJWTIssuer(c.keys, c.ttl)
+			assert.NotNil(tt, err)
+		})
+	}
+
+	//Verify only keys are valid when they aren't the current key
+	verifyOnly.Current = "hmac"
+	_, err := NewJWTIssuer(verifyOnly, time.Minute)
+	assert.Nil(t, err)
+}
+
+func TestLoadKeySet(t *testing.T) {
+	file, err := ioutil.TempFile("", "keys")
+	require.Nil(t, err)
+	defer os.Remove(file.Name())
+
+	content, _ := json.Marshal(map[string]interface{}{
+		"current": "ed25519",
+		"keys": []map[string]string{
+			{"id": "hmac", "algorithm": HS256, "secret": base64.StdEncoding.EncodeToString(testSecret)},
+			{"id": "ed25519", "algorithm": EdDSA, "private_key": base64.StdEncoding.EncodeToString(testSeed)},
+		},
+	})
+	_, err = file.Write(content)
+	require.Nil(t, err)
+	file.Close()
+
+	keys, err := LoadKeySet(file.Name())
+	require.Nil(t, err)
+	assert.Equal(t, "ed25519", keys.Current)
+	assert.Equal(t, testSecret, keys.Keys["hmac"].Secret)
+	assert.Equal(t, ed25519.NewKeyFromSeed(testSeed), keys.Keys["ed25519"].PrivateKey)
+
+	//The loaded keys sign the same tokens as the keys they were generated from
+	issuer, err := NewJWTIssuer(keys, time.Minute)
+	require.Nil(t, err)
+	token, err := issuer.Issue(1, 1)
+	require.Nil(t, err)
+
+	expectedIssuer, err := NewJWTIssuer(testKeySet("ed25519"), time.Minute)
+	require.Nil(t, err)
+	_, err = expectedIssuer.Validate(token)
+	assert.Nil(t, err)
+
+	_, err = LoadKeySet(file.Name() + ".missing")
+	assert.NotNil(t, err)
+}
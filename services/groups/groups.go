@@ -0,0 +1,232 @@
+package groups
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/maidaneze/message-server/apperrors"
+	"github.com/maidaneze/message-server/model"
+	"github.com/maidaneze/message-server/services/messages"
+)
+
+var MAX_GROUP_MEMBERS = 256
+
+//Validates if the CreateGroupRequestDTO is valid
+//Returns true if it is, false if it isn't
+//The owner and every member must be greater than zero
+//The name can't be empty and its maximum length is 64 characters
+//The group can't have more than MAX_GROUP_MEMBERS members counting the owner
+
+func ValidCreateGroupRequestDTO(dto model.CreateGroupRequestDTO) bool {
+	if dto.Owner <= 0 || dto.Name == "" || len(dto.Name) > model.MAX_GROUP_NAME_FIELD_SIZE {
+		return false
+	}
+
+	for _, member := range dto.Members {
+		if member <= 0 {
+			return false
+		}
+	}
+
+	return len(GroupMembers(dto)) <= MAX_GROUP_MEMBERS
+}
+
+//Returns the members of the group to be created without repetitions, starting with the owner
+
+func GroupMembers(dto model.CreateGroupRequestDTO) []int64 {
+	members := []int64{dto.Owner}
+	found := map[int64]bool{dto.Owner: true}
+	for _, member := range dto.Members {
+		if !found[member] {
+			found[member] = true
+			members = append(members, member)
+		}
+	}
+	return members
+}
+
+//Creates a new group with the given name and owner
+//The resulting group.GroupId is 0, it must be set after inserting into the database
+
+func CreateGroup(dto model.CreateGroupRequestDTO) model.Group {
+	return model.Group{Name: dto.Name, OwnerId: dto.Owner, Created: time.Now()}
+}
+
+//Validates if the GroupMemberRequestDTO is valid
+//Returns true if it is, false if it isn't
+//The user, group and member must be greater than zero
+
+func ValidGroupMemberRequestDTO(dto model.GroupMemberRequestDTO) bool {
+	return dto.User > 0 && dto.Group > 0 && dto.Member > 0
+}
+
+//Returns true if the user can add the member to the group and false otherwise
+//Only the owner can add members
+
+func CanAddMember(group model.Group, userid int64) bool {
+	return group.OwnerId == userid
+}
+
+//Returns true if the user can remove the member from the group and false otherwise
+//The owner can remove any other member and the members can remove themselves
+//The owner can't be removed
+
+func CanRemoveMember(group model.Group, userid int64, member int64) bool {
+	if member == group.OwnerId {
+		return false
+	}
+	return group.OwnerId == userid || userid == member
+}
+
+//Unmarshals the PostGroupMessageRequestDTO
+//Returns an invalid request error with the field if the dto content isn't a valid TextMessage, ImageMessage or
+//VideoMessage
+//Returns the GroupMessageDTO and nil otherwise
+
+func UnmarshallGroupMessageContent(request model.PostGroupMessageRequestDTO) (model.GroupMessageDTO, error) {
+	message, err := messages.UnmarshallMessageContent(model.PostMessageRequestDTO{Sender: request.Sender, Content: request.Content})
+	if err != nil {
+		return model.GroupMessageDTO{}, err
+	}
+
+	return model.GroupMessageDTO{
+		GroupId:   request.Group,
+		SenderId:  message.SenderId,
+		Timestamp: message.Timestamp,
+		Type:      message.Type,
+		Text:      message.Text,
+		Url:       message.Url,
+		Height:    message.Height,
+		Width:     message.Width,
+		Source:    message.Source,
+	}, nil
+}
+
+//Validates if the GroupMessageDTO is a valid TextMessage, ImageMessage or VideoMessage
+//Returns the invalid fields, none if it is valid
+//The senderId and groupId must be greater than zero
+//The content must be valid according to messages.ValidMessageContent
+
+func ValidGroupMessageDto(dto model.GroupMessageDTO) []apperrors.FieldError {
+	var fields []apperrors.FieldError
+	if dto.SenderId <= 0 {
+		fields = append(fields, apperrors.FieldError{Field: "sender", Code: apperrors.FieldOutOfRange, Message: "sender must be > 0"})
+	}
+	if dto.GroupId <= 0 {
+		fields = append(fields, apperrors.FieldError{Field: "group", Code: apperrors.FieldOutOfRange, Message: "group must be > 0"})
+	}
+	return append(fields, messages.ValidMessageContent(messageContent(dto))...)
+}
+
+//Parses the group messages from the database into responses with either textMessages, imageMessages or videoMessages
+//Discards the invalid messages
+
+func ParseGroupMessages(m []model.GroupMessageDTO) []model.GroupMessageResponse {
+	response := make([]model.GroupMessageResponse, 0)
+	for _, message := range m {
+		content, valid := messages.ParseMessageContent(messageContent(message))
+		if !valid {
+			continue
+		}
+
+		response = append(response, model.GroupMessageResponse{
+			Id:        message.MessageId,
+			Timestamp: message.Timestamp,
+			Sender:    message.SenderId,
+			Group:     message.GroupId,
+			Content:   content,
+		})
+	}
+	return response
+}
+
+func GetPostGroupMessageResponseDTO(dto model.GroupMessageDTO) model.PostMessageResponseDTO {
+	return model.PostMessageResponseDTO{Id: dto.MessageId, Timestamp: dto.Timestamp.Format("2006-01-02T15:04:05Z")}
+}
+
+//Parses the query params of the group members requests
+//The "group" param is required and the "id" and "member" params are optional
+//The id is 0 if it isn't present since the user is the authenticated user
+//Returns error if a param is missing or isn't a number
+
+func ParseGroupMemberQueryParams(r *http.Request) (model.GroupMemberRequestDTO, error) {
+	dto := model.GroupMemberRequestDTO{}
+	var found bool
+	var err error
+
+	if dto.User, _, err = parseQueryParam(r, "id"); err != nil {
+		return model.GroupMemberRequestDTO{}, errors.New("Invalid Body")
+	}
+
+	if dto.Group, found, err = parseQueryParam(r, "group"); !found || err != nil {
+		return model.GroupMemberRequestDTO{}, errors.New("Invalid Body")
+	}
+
+	if dto.Member, _, err = parseQueryParam(r, "member"); err != nil {
+		return model.GroupMemberRequestDTO{}, errors.New("Invalid Body")
+	}
+	return dto, nil
+}
+
+//Parses the query params of the get group messages request
+//Returns the userid, groupid, messageid and limit
+//The id is optional, it's 0 if it isn't present since the user is the authenticated user
+//The limit is optional and defaults to messages.DEFAULT_LIMIT
+//Returns error if a required param is missing or a param isn't a number
+
+func ParseGetGroupMessageQueryParams(r *http.Request) (int64, int64, int64, int64, error) {
+	userId, _, err := parseQueryParam(r, "id")
+	if err != nil {
+		return 0, 0, 0, 0, errors.New("Invalid Body")
+	}
+
+	groupId, found, err := parseQueryParam(r, "group")
+	if !found || err != nil {
+		return 0, 0, 0, 0, errors.New("Invalid Body")
+	}
+
+	messageId, found, err := parseQueryParam(r, "start")
+	if !found || err != nil {
+		return 0, 0, 0, 0, errors.New("Invalid Body")
+	}
+
+	limit, found, err := parseQueryParam(r, "limit")
+	if err != nil {
+		return 0, 0, 0, 0, errors.New("Invalid Body")
+	}
+	if !found {
+		limit = messages.DEFAULT_LIMIT
+	}
+	return userId, groupId, messageId, limit, nil
+}
+
+//Parses the given query param as a number
+//Returns false if the param isn't present and error if it isn't a number
+
+func parseQueryParam(r *http.Request, name string) (int64, bool, error) {
+	values, found := r.URL.Query()[name]
+	if !found {
+		return 0, false, nil
+	}
+
+	value, err := strconv.ParseInt(values[0], 10, 64)
+	return value, true, err
+}
+
+//Returns a MessageDTO with the content of the group message so the message content helpers can be reused
+
+func messageContent(dto model.GroupMessageDTO) model.MessageDTO {
+	return model.MessageDTO{
+		MessageId: dto.MessageId,
+		SenderId:  dto.SenderId,
+		Timestamp: dto.Timestamp,
+		Type:      dto.Type,
+		Text:      dto.Text,
+		Url:       dto.Url,
+		Height:    dto.Height,
+		Width:     dto.Width,
+		Source:    dto.Source,
+	}
+}
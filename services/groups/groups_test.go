@@ -0,0 +1,137 @@
+package groups
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/maidaneze/message-server/apperrors"
+	"github.com/maidaneze/message-server/model"
+	"github.com/maidaneze/message-server/services/messages"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidCreateGroupRequestDTO(t *testing.T) {
+	tooManyMembers := make([]int64, MAX_GROUP_MEMBERS)
+	for i := range tooManyMembers {
+		tooManyMembers[i] = int64(i + 2)
+	}
+
+	cases := []struct {
+		name     string
+		dto      model.CreateGroupRequestDTO
+		expected bool
+	}{
+		{"testValidCreateGroupRequestDTOEmptyBody", model.CreateGroupRequestDTO{}, false},
+		{"testValidCreateGroupRequestDTOOnlyOwner", model.CreateGroupRequestDTO{Owner: 1, Name: "group"}, true},
+		{"testValidCreateGroupRequestDTOWithMembers", model.CreateGroupRequestDTO{Owner: 1, Name: "group", Members: []int64{2, 3}}, true},
+		{"testValidCreateGroupRequestDTOInvalidOwner", model.CreateGroupRequestDTO{Owner: -1, Name: "group"}, false},
+		{"testValidCreateGroupRequestDTOEmptyName", model.CreateGroupRequestDTO{Owner: 1}, false},
+		{"testValidCreateGroupRequestDTOHugeName", model.CreateGroupRequestDTO{Owner: 1, Name: strings.Repeat("a", model.MAX_GROUP_NAME_FIELD_SIZE+1)}, false},
+		{"testValidCreateGroupRequestDTOInvalidMember", model.CreateGroupRequestDTO{Owner: 1, Name: "group", Members: []int64{2, 0}}, false},
+		{"testValidCreateGroupRequestDTOTooManyMembers", model.CreateGroupRequestDTO{Owner: 1, Name: "group", Members: tooManyMembers}, false},
+		{"testValidCreateGroupRequestDTORepeatedMembers", model.CreateGroupRequestDTO{Owner: 2, Name: "group", Members: tooManyMembers[:MAX_GROUP_MEMBERS-1]}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			assert.Equal(tt, c.expected, ValidCreateGroupRequestDTO(c.dto))
+		})
+	}
+}
+
+func TestGroupMembers(t *testing.T) {
+	cases := []struct {
+		name     string
+		dto      model.CreateGroupRequestDTO
+		expected []int64
+	}{
+		{"testGroupMembersOnlyOwner", model.CreateGroupRequestDTO{Owner: 1}, []int64{1}},
+		{"testGroupMembersOwnerFirst", model.CreateGroupRequestDTO{Owner: 3, Members: []int64{1, 2}}, []int64{3, 1, 2}},
+		{"testGroupMembersWithoutRepetitions", model.CreateGroupRequestDTO{Owner: 1, Members: []int64{2, 1, 2, 3}}, []int64{1, 2, 3}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			assert.Equal(tt, c.expected, GroupMembers(c.dto))
+		})
+	}
+}
+
+func TestCanRemoveMember(t *testing.T) {
+	group := model.Group{GroupId: 1, OwnerId: 1}
+
+	cases := []struct {
+		name     string
+		userid   int64
+		member   int64
+		expected bool
+	}{
+		{"testOwnerCanRemoveMember", 1, 2, true},
+		{"testMemberCanRemoveThemselves", 2, 2, true},
+		{"testMemberCantRemoveOtherMember", 2, 3, false},
+		{"testOwnerCantBeRemovedByThemselves", 1, 1, false},
+		{"testOwnerCantBeRemovedByMember", 2, 1, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			assert.Equal(tt, c.expected, CanRemoveMember(group, c.userid, c.member))
+		})
+	}
+}
+
+func TestValidGroupMessageDto(t *testing.T) {
+	valid := model.GroupMessageDTO{GroupId: 1, SenderId: 1, Type: "text", Text: "text"}
+
+	invalidGroup := valid
+	invalidGroup.GroupId = 0
+	invalidSender := valid
+	invalidSender.SenderId = 0
+	invalidType := valid
+	invalidType.Type = "invalid"
+
+	cases := []struct {
+		name     string
+		dto      model.GroupMessageDTO
+		expected []apperrors.FieldError
+	}{
+		{"testValidGroupMessageDto", valid, nil},
+		{"testValidGroupMessageDtoInvalidGroup", invalidGroup, []apperrors.FieldError{{Field: "group", Code: apperrors.FieldOutOfRange, Message: "group must be > 0"}}},
+		{"testValidGroupMessageDtoInvalidSender", invalidSender, []apperrors.FieldError{{Field: "sender", Code: apperrors.FieldOutOfRange, Message: "sender must be > 0"}}},
+		{"testValidGroupMessageDtoInvalidType", invalidType, []apperrors.FieldError{{Field: "content.type", Code: apperrors.FieldInvalidValue, Message: "content.type must be text, image or video"}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			assert.Equal(tt, c.expected, ValidGroupMessageDto(c.dto))
+		})
+	}
+}
+
+func TestParseGetGroupMessageQueryParams(t *testing.T) {
+	cases := []struct {
+		name          string
+		query         string
+		expectedUser  int64
+		expectedGroup int64
+		expectedStart int64
+		expectedLimit int64
+		expectedError bool
+	}{
+		{"testParseGetGroupMessageQueryParams", "id=1&group=2&start=3&limit=4", 1, 2, 3, 4, false},
+		{"testParseGetGroupMessageQueryParamsDefaultLimit", "id=1&group=2&start=3", 1, 2, 3, messages.DEFAULT_LIMIT, false},
+		{"testParseGetGroupMessageQueryParamsMissingId", "group=2&start=3", 0, 2, 3, messages.DEFAULT_LIMIT, false},
+		{"testParseGetGroupMessageQueryParamsMissingGroup", "id=1&start=3", 0, 0, 0, 0, true},
+		{"testParseGetGroupMessageQueryParamsMissingStart", "id=1&group=2", 0, 0, 0, 0, true},
+		{"testParseGetGroupMessageQueryParamsInvalidLimit", "id=1&group=2&start=3&limit=a", 0, 0, 0, 0, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			r, _ := http.NewRequest("GET", "/groups/messages?"+c.query, nil)
+			user, group, start, limit, err := ParseGetGroupMessageQueryParams(r)
+			assert.Equal(tt, c.expectedError, err != nil)
+			assert.Equal(tt, c.expectedUser, user)
+			assert.Equal(tt, c.expectedGroup, group)
+			assert.Equal(tt, c.expectedStart, start)
+			assert.Equal(tt, c.expectedLimit, limit)
+		})
+	}
+}
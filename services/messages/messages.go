@@ -1,47 +1,53 @@
 package messages
 
 import (
+	"github.com/maidaneze/message-server/apperrors"
 	"github.com/maidaneze/message-server/model"
 	"errors"
+	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 )
 
-var DEFAULT_LIMIT int64 = 100
+var (
+	DEFAULT_LIMIT    int64 = 100
+	MAX_WAIT_SECONDS int64 = 60
+)
 
 //Unmarshals the PostMessageRequestDTO
-//Returns error if the dto content isn't a valid TextMessage, ImageMessage or VideoMessage
+//Returns an invalid request error with the field if the dto content isn't a valid TextMessage, ImageMessage or
+//VideoMessage
 //Returns the MessageDto and nil otherwise
 
 func UnmarshallMessageContent(request model.PostMessageRequestDTO) (model.MessageDTO, error) {
 	message := model.MessageDTO{}
-	invalidBodyMessage := errors.New("Invalid body")
 	content, ok := request.Content.(map[string]interface{})
 
 	if !ok {
-		return message, invalidBodyMessage
+		return message, invalidContent("content", apperrors.FieldInvalidType, "content must be an object")
 	}
 
 	t, ok := content["type"].(string)
 
 	if !ok {
-		return message, invalidBodyMessage
+		return message, invalidContent("content.type", apperrors.FieldRequired, "content.type is required")
 	}
 
 	switch t {
 	case "text":
 		text, ok := content["text"].(string)
 		if !ok {
-			return message, invalidBodyMessage
+			return message, invalidContent("content.text", apperrors.FieldRequired, "content.text is required")
 		}
 		message.Text = text
 		goto finish
 	case "image":
 		url, ok := content["url"].(string)
 		if !ok {
-			return message, invalidBodyMessage
+			return message, invalidContent("content.url", apperrors.FieldRequired, "content.url is required")
 		}
 		message.Url = url
 
@@ -53,7 +59,7 @@ func UnmarshallMessageContent(request model.PostMessageRequestDTO) (model.Messag
 		case int:
 			message.Height = int64(v)
 		default:
-			return message, invalidBodyMessage
+			return message, invalidContent("content.height", apperrors.FieldInvalidType, "content.height must be a number")
 		}
 
 		switch v := content["width"].(type) {
@@ -64,19 +70,19 @@ func UnmarshallMessageContent(request model.PostMessageRequestDTO) (model.Messag
 		case int:
 			message.Width = int64(v)
 		default:
-			return message, invalidBodyMessage
+			return message, invalidContent("content.width", apperrors.FieldInvalidType, "content.width must be a number")
 		}
 		goto finish
 	case "video":
 		url, ok := content["url"].(string)
 		if !ok {
-			return message, invalidBodyMessage
+			return message, invalidContent("content.url", apperrors.FieldRequired, "content.url is required")
 		}
 		message.Url = url
 
 		source, ok := content["source"].(string)
 		if !ok {
-			return message, invalidBodyMessage
+			return message, invalidContent("content.source", apperrors.FieldRequired, "content.source is required")
 		}
 		message.Source = source
 	default:
@@ -89,64 +95,109 @@ finish:
 	return message, nil
 }
 
+//Returns the invalid body error of the content field that couldn't be unmarshalled
+
+func invalidContent(field string, code string, message string) error {
+	return apperrors.NewInvalid("invalid_body", "Invalid body", apperrors.FieldError{Field: field, Code: code, Message: message})
+}
+
 //Validates if the messageDTO is a valid TextMessage, ImageMessage or VideoMessage
-//Returns true if it is, false if it isn't
+//Returns the invalid fields, none if it is valid
 //The senderId and recipientID must be different and be greater than zero
+//The content must be valid according to ValidMessageContent
+
+func ValidMessageDto(dto model.MessageDTO) []apperrors.FieldError {
+	var fields []apperrors.FieldError
+
+	//Validate SenderId and RecipientID
+	if dto.SenderId <= 0 {
+		fields = append(fields, apperrors.FieldError{Field: "sender", Code: apperrors.FieldOutOfRange, Message: "sender must be > 0"})
+	}
+	if dto.RecipientId <= 0 {
+		fields = append(fields, apperrors.FieldError{Field: "recipient", Code: apperrors.FieldOutOfRange, Message: "recipient must be > 0"})
+	} else if dto.SenderId == dto.RecipientId {
+		fields = append(fields, apperrors.FieldError{Field: "recipient", Code: apperrors.FieldInvalidValue, Message: "recipient must be different from sender"})
+	}
+	return append(fields, ValidMessageContent(dto)...)
+}
+
+//Validates if the content of the messageDTO is a valid TextMessage, ImageMessage or VideoMessage
+//Returns the invalid fields, with their path in the request, none if it is valid
 //Maximum length for text fields is 1024 characters
 //The type field must be either "text", "image" or "video"
 //If the type field is "image" or "video", the url must be a valid uri
 //If the type field is "image" the height and width must be greater than 0
 //If the type field is "vidoe" the source must be youtube or vimeo
 
-func ValidMessageDto(dto model.MessageDTO) bool {
-	//Validate SenderId and RecipientID
-	if dto.SenderId == dto.RecipientId || dto.SenderId <= 0 || dto.RecipientId <= 0 {
-		return false
-	}
-	if len(dto.Url) > model.MAX_TEXT_FIELD_SIZE || len(dto.Source) > model.MAX_TEXT_FIELD_SIZE ||
-		len(dto.Text) > model.MAX_TEXT_FIELD_SIZE {
-		return false
+func ValidMessageContent(dto model.MessageDTO) []apperrors.FieldError {
+	var fields []apperrors.FieldError
+	for _, field := range []struct {
+		name  string
+		value string
+	}{{"content.text", dto.Text}, {"content.url", dto.Url}, {"content.source", dto.Source}} {
+		if len(field.value) > model.MAX_TEXT_FIELD_SIZE {
+			fields = append(fields, apperrors.FieldError{
+				Field:   field.name,
+				Code:    apperrors.FieldTooLong,
+				Message: fmt.Sprintf("%v must be at most %v characters", field.name, model.MAX_TEXT_FIELD_SIZE),
+			})
+		}
 	}
 
 	switch dto.Type {
 	//Validate TextMessage
 	case "text":
-		return true
 	//Validate ImageMessage
 	case "image":
-		return dto.Height > 0 && dto.Width > 0
+		if dto.Height <= 0 {
+			fields = append(fields, apperrors.FieldError{Field: "content.height", Code: apperrors.FieldOutOfRange, Message: "content.height must be > 0"})
+		}
+		if dto.Width <= 0 {
+			fields = append(fields, apperrors.FieldError{Field: "content.width", Code: apperrors.FieldOutOfRange, Message: "content.width must be > 0"})
+		}
 	//Validate VideoMessage
 	case "video":
-		return (dto.Source == "youtube" || dto.Source == "vimeo") && strings.Contains(dto.Url, dto.Source)
+		if dto.Source != "youtube" && dto.Source != "vimeo" {
+			fields = append(fields, apperrors.FieldError{Field: "content.source", Code: apperrors.FieldInvalidValue, Message: "content.source must be youtube or vimeo"})
+		} else if !strings.Contains(dto.Url, dto.Source) {
+			fields = append(fields, apperrors.FieldError{Field: "content.url", Code: apperrors.FieldInvalidValue, Message: "content.url must be a " + dto.Source + " url"})
+		}
 	default:
-		return false
+		fields = append(fields, apperrors.FieldError{Field: "content.type", Code: apperrors.FieldInvalidValue, Message: "content.type must be text, image or video"})
 	}
-	return true
+	return fields
 }
 
 //Parses the id, start and limit queryparams into int64
+//The id is optional, it's 0 if it isn't present since the recipient is the authenticated user
+//Parses the optional wait queryparam, the number of seconds to wait for new messages (Default 0, at most MAX_WAIT_SECONDS)
 //Returns error if it cant
 
-func ParseGetMessageQueryParams(r *http.Request) (int64, int64, int64, error) {
+func ParseGetMessageQueryParams(r *http.Request) (int64, int64, int64, time.Duration, error) {
 	//Get Query params
 	recipient, foundRecipientId := r.URL.Query()["id"]
 	message, foundMessageId := r.URL.Query()["start"]
 	queyLimit, foundLimit := r.URL.Query()["limit"]
+	queryWait, foundWait := r.URL.Query()["wait"]
 
-	if !(foundRecipientId && foundMessageId) {
-		return 0, 0, 0, errors.New("Invalid Body")
+	if !foundMessageId {
+		return 0, 0, 0, 0, errors.New("Invalid Body")
 	}
 
-	recipientId, err := strconv.ParseInt(recipient[0], 10, 64)
+	var recipientId int64
+	var err error
+	if foundRecipientId {
+		recipientId, err = strconv.ParseInt(recipient[0], 10, 64)
 
-	if err != nil {
-		return 0, 0, 0, errors.New("Invalid Body")
+		if err != nil {
+			return 0, 0, 0, 0, errors.New("Invalid Body")
+		}
 	}
 
 	messageId, err := strconv.ParseInt(message[0], 10, 64)
 
 	if err != nil {
-		return 0, 0, 0, errors.New("Invalid Body")
+		return 0, 0, 0, 0, errors.New("Invalid Body")
 	}
 
 	var limit int64 = DEFAULT_LIMIT
@@ -155,10 +206,104 @@ func ParseGetMessageQueryParams(r *http.Request) (int64, int64, int64, error) {
 		limit, err = strconv.ParseInt(queyLimit[0], 10, 64)
 
 		if err != nil {
-			return 0, 0, 0, errors.New("Invalid Body")
+			return 0, 0, 0, 0, errors.New("Invalid Body")
+		}
+	}
+
+	var wait int64 = 0
+
+	if foundWait {
+		wait, err = strconv.ParseInt(queryWait[0], 10, 64)
+
+		if err != nil || wait < 0 {
+			return 0, 0, 0, 0, errors.New("Invalid Body")
+		}
+
+		if wait > MAX_WAIT_SECONDS {
+			wait = MAX_WAIT_SECONDS
+		}
+	}
+	return recipientId, messageId, limit, time.Duration(wait) * time.Second, nil
+}
+
+//Parses the "Last-Event-ID" header sent by Server-Sent Events clients when they reconnect
+//Returns the message id and true if the header is present
+//Returns error if it isn't a valid message id
+
+func ParseLastEventId(r *http.Request) (int64, bool, error) {
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		return 0, false, nil
+	}
+
+	messageId, err := strconv.ParseInt(lastEventId, 10, 64)
+	if err != nil || messageId <= 0 {
+		return 0, false, errors.New("Invalid Last-Event-ID")
+	}
+	return messageId, true, nil
+}
+
+//Parses the other user id from the id path param of the conversation "/conversations/{id}/messages"
+//Returns error if the id isn't a number
+
+func ParseConversationUserId(param string) (int64, error) {
+	otherUserId, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		return 0, errors.New("Invalid path")
+	}
+	return otherUserId, nil
+}
+
+//Parses the query params of the get conversation messages request
+//Returns the userid, the cursor messageid, the limit and whether the messages before the cursor are requested
+//The id is optional, it's 0 if it isn't present since the user is the authenticated user
+//The "before" and "after" cursors are optional and exclusive, they can't be used together
+//Without a cursor the latest messages of the conversation are requested
+//The limit is optional and defaults to DEFAULT_LIMIT
+
+func ParseGetConversationQueryParams(r *http.Request) (int64, int64, int64, bool, error) {
+	query := r.URL.Query()
+	user, foundUserId := query["id"]
+	queryBefore, foundBefore := query["before"]
+	queryAfter, foundAfter := query["after"]
+	queryLimit, foundLimit := query["limit"]
+
+	if foundBefore && foundAfter {
+		return 0, 0, 0, false, errors.New("Invalid Body")
+	}
+
+	var userId int64
+	var err error
+	if foundUserId {
+		if userId, err = strconv.ParseInt(user[0], 10, 64); err != nil {
+			return 0, 0, 0, false, errors.New("Invalid Body")
+		}
+	}
+
+	var messageId int64 = math.MaxInt64
+	before := true
+
+	if foundBefore {
+		if messageId, err = strconv.ParseInt(queryBefore[0], 10, 64); err != nil {
+			return 0, 0, 0, false, errors.New("Invalid Body")
+		}
+	}
+
+	if foundAfter {
+		before = false
+		if messageId, err = strconv.ParseInt(queryAfter[0], 10, 64); err != nil {
+			return 0, 0, 0, false, errors.New("Invalid Body")
 		}
 	}
-	return recipientId, messageId, limit, nil
+
+	var limit int64 = DEFAULT_LIMIT
+
+	if foundLimit {
+		if limit, err = strconv.ParseInt(queryLimit[0], 10, 64); err != nil {
+			return 0, 0, 0, false, errors.New("Invalid Body")
+		}
+	}
+	return userId, messageId, limit, before, nil
 }
 
 //Parses the messages from the database into either textMessages, imageMessages or videoMessages
@@ -173,24 +318,75 @@ func ParseMessages(m []model.MessageDTO) []model.MessageResponse {
 		messageResponse.Timestamp = message.Timestamp
 		messageResponse.Sender = message.SenderId
 		messageResponse.Recipient = message.RecipientId
-		switch message.Type {
-		case "text":
-			textContent := model.TextContent{message.Type, message.Text}
-			messageResponse.Content = textContent
-		case "image":
-			imageContent := model.ImageContent{message.Type, message.Url, message.Height, message.Width}
-			messageResponse.Content = imageContent
-		case "video":
-			videoContent := model.VideoContent{message.Type, message.Url, message.Source}
-			messageResponse.Content = videoContent
-		default:
+		messageResponse.Delivered = message.Delivered
+		messageResponse.Read = message.Read
+
+		content, valid := ParseMessageContent(message)
+		if !valid {
 			continue
 		}
+		messageResponse.Content = content
 		response = append(response, messageResponse)
 	}
 	return response
 }
 
+//Parses the content of a message from the database into either a TextContent, ImageContent or VideoContent
+//Returns false if the message type is invalid
+
+func ParseMessageContent(message model.MessageDTO) (model.MessageContent, bool) {
+	switch message.Type {
+	case "text":
+		return model.TextContent{Type: message.Type, Text: message.Text}, true
+	case "image":
+		return model.ImageContent{Type: message.Type, Url: message.Url, Height: message.Height, Width: message.Width}, true
+	case "video":
+		return model.VideoContent{Type: message.Type, Url: message.Url, Source: message.Source}, true
+	default:
+		return nil, false
+	}
+}
+
+//Sets the delivery time of the messages that weren't delivered before
+
+func SetDelivered(m []model.MessageDTO, delivered time.Time) {
+	for i := range m {
+		if m[i].Delivered == nil {
+			m[i].Delivered = &delivered
+		}
+	}
+}
+
+//Validates if the ReadMessagesRequestDTO is valid
+//Returns the invalid fields, none if it is valid
+//The recipient and message must be greater than zero
+
+func ValidReadMessagesRequestDTO(dto model.ReadMessagesRequestDTO) []apperrors.FieldError {
+	var fields []apperrors.FieldError
+	if dto.Recipient <= 0 {
+		fields = append(fields, apperrors.FieldError{Field: "recipient", Code: apperrors.FieldOutOfRange, Message: "recipient must be > 0"})
+	}
+	if dto.Message <= 0 {
+		fields = append(fields, apperrors.FieldError{Field: "message", Code: apperrors.FieldOutOfRange, Message: "message must be > 0"})
+	}
+	return fields
+}
+
+//Parses the message statuses from the database into responses
+
+func ParseMessageStatuses(statuses []model.MessageStatus) []model.MessageStatusResponse {
+	response := make([]model.MessageStatusResponse, 0)
+	for _, status := range statuses {
+		response = append(response, model.MessageStatusResponse{
+			Id:        status.MessageId,
+			Recipient: status.RecipientId,
+			Delivered: status.Delivered,
+			Read:      status.Read,
+		})
+	}
+	return response
+}
+
 func GetPostMessageResponseDTO(dto model.MessageDTO) model.PostMessageResponseDTO {
 	return model.PostMessageResponseDTO{dto.MessageId, dto.Timestamp.Format("2006-01-02T15:04:05Z")}
 }
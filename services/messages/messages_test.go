@@ -1,11 +1,13 @@
 package messages
 
 import (
+	"github.com/maidaneze/message-server/apperrors"
 	"github.com/maidaneze/message-server/model"
 	"testing"
 	"time"
 
 	"fmt"
+	"math"
 	"net/http"
 	"net/url"
 
@@ -200,12 +202,66 @@ func TestValidMessageDto(t *testing.T) {
 	for _, c := range cases {
 		t.Run(c.name, func(tt *testing.T) {
 			actual := ValidMessageDto(c.message)
-			assert.Equal(tt, c.expected, actual)
+			assert.Equal(tt, c.expected, len(actual) == 0)
 
 		})
 	}
 }
 
+func TestValidMessageDtoShouldReturnTheInvalidFields(t *testing.T) {
+	cases := []struct {
+		name     string
+		message  model.MessageDTO
+		expected []apperrors.FieldError
+	}{
+		{"testInvalidFieldsSameSenderAndRecipient", model.MessageDTO{SenderId: 1, RecipientId: 1, Type: "text"},
+			[]apperrors.FieldError{{Field: "recipient", Code: apperrors.FieldInvalidValue, Message: "recipient must be different from sender"}}},
+		{"testInvalidFieldsImageSize", model.MessageDTO{SenderId: 1, RecipientId: 2, Type: "image", Url: "url", Height: 10},
+			[]apperrors.FieldError{{Field: "content.width", Code: apperrors.FieldOutOfRange, Message: "content.width must be > 0"}}},
+		{"testInvalidFieldsVideoSource", model.MessageDTO{SenderId: 1, RecipientId: 2, Type: "video", Url: "url", Source: "dailymotion"},
+			[]apperrors.FieldError{{Field: "content.source", Code: apperrors.FieldInvalidValue, Message: "content.source must be youtube or vimeo"}}},
+		{"testInvalidFieldsVideoUrl", model.MessageDTO{SenderId: 1, RecipientId: 2, Type: "video", Url: "url", Source: "vimeo"},
+			[]apperrors.FieldError{{Field: "content.url", Code: apperrors.FieldInvalidValue, Message: "content.url must be a vimeo url"}}},
+		{"testInvalidFieldsType", model.MessageDTO{SenderId: 1, RecipientId: 2, Type: "audio"},
+			[]apperrors.FieldError{{Field: "content.type", Code: apperrors.FieldInvalidValue, Message: "content.type must be text, image or video"}}},
+		{"testInvalidFieldsEveryField", model.MessageDTO{Type: "image", Text: string(make([]byte, model.MAX_TEXT_FIELD_SIZE+1))},
+			[]apperrors.FieldError{
+				{Field: "sender", Code: apperrors.FieldOutOfRange, Message: "sender must be > 0"},
+				{Field: "recipient", Code: apperrors.FieldOutOfRange, Message: "recipient must be > 0"},
+				{Field: "content.text", Code: apperrors.FieldTooLong, Message: "content.text must be at most 1024 characters"},
+				{Field: "content.height", Code: apperrors.FieldOutOfRange, Message: "content.height must be > 0"},
+				{Field: "content.width", Code: apperrors.FieldOutOfRange, Message: "content.width must be > 0"},
+			}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			assert.Equal(tt, c.expected, ValidMessageDto(c.message))
+		})
+	}
+}
+
+func TestUnmarshallMessageContentShouldReturnTheInvalidField(t *testing.T) {
+	cases := []struct {
+		name     string
+		content  interface{}
+		expected apperrors.FieldError
+	}{
+		{"testInvalidFieldContent", "text", apperrors.FieldError{Field: "content", Code: apperrors.FieldInvalidType, Message: "content must be an object"}},
+		{"testInvalidFieldType", map[string]interface{}{}, apperrors.FieldError{Field: "content.type", Code: apperrors.FieldRequired, Message: "content.type is required"}},
+		{"testInvalidFieldWidth", map[string]interface{}{"type": "image", "url": "url", "height": 150}, apperrors.FieldError{Field: "content.width", Code: apperrors.FieldInvalidType, Message: "content.width must be a number"}},
+		{"testInvalidFieldSource", map[string]interface{}{"type": "video", "url": "url"}, apperrors.FieldError{Field: "content.source", Code: apperrors.FieldRequired, Message: "content.source is required"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			_, err := UnmarshallMessageContent(model.PostMessageRequestDTO{Sender: 1, Recipient: 2, Content: c.content})
+			typed, ok := apperrors.As(err)
+			assert.True(tt, ok)
+			assert.Equal(tt, apperrors.Invalid, typed.Kind)
+			assert.Equal(tt, []apperrors.FieldError{c.expected}, typed.Fields)
+		})
+	}
+}
+
 func TestParseGetMessageQueryParams(t *testing.T) {
 	cases := []struct {
 		name          string
@@ -233,20 +289,140 @@ func TestParseGetMessageQueryParams(t *testing.T) {
 			var err error
 			req.URL, err = url.Parse(fmt.Sprintf("www.example.com?id=%s&start=%s&limit=%s", c.id, c.start, c.limit))
 			assert.Nil(tt, err)
-			id, start, limit, err := ParseGetMessageQueryParams(req)
+			id, start, limit, wait, err := ParseGetMessageQueryParams(req)
 			assert.Equal(tt, c.expectedId, id)
 			assert.Equal(tt, c.expectedStart, start)
 			assert.Equal(tt, c.expectedLimit, limit)
+			assert.Equal(tt, time.Duration(0), wait)
+			assert.True(tt, c.success == (err == nil))
+		})
+	}
+}
+
+func TestParseGetMessageQueryParamsWithoutId(t *testing.T) {
+	req := &http.Request{}
+	var err error
+	req.URL, err = url.Parse("www.example.com?start=2&limit=3")
+	assert.Nil(t, err)
+
+	id, start, limit, _, err := ParseGetMessageQueryParams(req)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), id)
+	assert.Equal(t, int64(2), start)
+	assert.Equal(t, int64(3), limit)
+}
+
+func TestParseGetMessageQueryParamsWait(t *testing.T) {
+	cases := []struct {
+		name         string
+		wait         string
+		success      bool
+		expectedWait time.Duration
+	}{
+		{"testParseGetMessageQueryParamsWaitEmpty", "", false, 0},
+		{"testParseGetMessageQueryParamsWaitInvalid", "invalid", false, 0},
+		{"testParseGetMessageQueryParamsWaitNegative", "-1", false, 0},
+		{"testParseGetMessageQueryParamsWaitZero", "0", true, 0},
+		{"testParseGetMessageQueryParamsWaitValid", "30", true, 30 * time.Second},
+		{"testParseGetMessageQueryParamsWaitTooLong", "3600", true, time.Duration(MAX_WAIT_SECONDS) * time.Second},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			req := &http.Request{}
+			var err error
+			req.URL, err = url.Parse(fmt.Sprintf("www.example.com?id=1&start=1&wait=%s", c.wait))
+			assert.Nil(tt, err)
+			_, _, _, wait, err := ParseGetMessageQueryParams(req)
+			assert.Equal(tt, c.expectedWait, wait)
+			assert.True(tt, c.success == (err == nil))
+		})
+	}
+}
+
+func TestParseLastEventId(t *testing.T) {
+	cases := []struct {
+		name        string
+		header      string
+		success     bool
+		expectedId  int64
+		expectFound bool
+	}{
+		{"testParseLastEventIdMissingHeader", "", true, 0, false},
+		{"testParseLastEventIdValidHeader", "15", true, 15, true},
+		{"testParseLastEventIdInvalidHeader", "invalid", false, 0, false},
+		{"testParseLastEventIdZeroHeader", "0", false, 0, false},
+		{"testParseLastEventIdNegativeHeader", "-1", false, 0, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			req := &http.Request{Header: http.Header{}}
+			if c.header != "" {
+				req.Header.Set("Last-Event-ID", c.header)
+			}
+			id, found, err := ParseLastEventId(req)
+			assert.Equal(tt, c.expectedId, id)
+			assert.Equal(tt, c.expectFound, found)
+			assert.True(tt, c.success == (err == nil))
+		})
+	}
+}
+
+func TestParseConversationUserId(t *testing.T) {
+	cases := []struct {
+		name       string
+		param      string
+		success    bool
+		expectedId int64
+	}{
+		{"testParseConversationUserIdValid", "2", true, 2},
+		{"testParseConversationUserIdInvalidId", "a", false, 0},
+		{"testParseConversationUserIdMissingId", "", false, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			id, err := ParseConversationUserId(c.param)
+			assert.Equal(tt, c.expectedId, id)
 			assert.True(tt, c.success == (err == nil))
 		})
 	}
 }
 
+func TestParseGetConversationQueryParams(t *testing.T) {
+	cases := []struct {
+		name              string
+		query             string
+		success           bool
+		expectedUserId    int64
+		expectedMessageId int64
+		expectedLimit     int64
+		expectedBefore    bool
+	}{
+		{"testParseGetConversationQueryParamsLatest", "id=1", true, 1, math.MaxInt64, DEFAULT_LIMIT, true},
+		{"testParseGetConversationQueryParamsBefore", "id=1&before=10&limit=5", true, 1, 10, 5, true},
+		{"testParseGetConversationQueryParamsAfter", "id=1&after=10", true, 1, 10, DEFAULT_LIMIT, false},
+		{"testParseGetConversationQueryParamsMissingId", "after=10", true, 0, 10, DEFAULT_LIMIT, false},
+		{"testParseGetConversationQueryParamsBeforeAndAfter", "id=1&before=10&after=5", false, 0, 0, 0, false},
+		{"testParseGetConversationQueryParamsInvalidCursor", "id=1&after=a", false, 0, 0, 0, false},
+		{"testParseGetConversationQueryParamsInvalidLimit", "id=1&limit=a", false, 0, 0, 0, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			req, _ := http.NewRequest("GET", "/conversations/2/messages?"+c.query, nil)
+			userId, messageId, limit, before, err := ParseGetConversationQueryParams(req)
+			assert.True(tt, c.success == (err == nil))
+			assert.Equal(tt, c.expectedUserId, userId)
+			assert.Equal(tt, c.expectedMessageId, messageId)
+			assert.Equal(tt, c.expectedLimit, limit)
+			assert.Equal(tt, c.expectedBefore, before)
+		})
+	}
+}
+
 func TestParseMessages(t *testing.T) {
-	textMessage := model.MessageDTO{1, 2, 3, time.Now(), "text", "text", "", 0, 0, ""}
-	imageMessage := model.MessageDTO{2, 2, 4, time.Now(), "image", "", "www.image.com", 150, 120, ""}
-	videoMessage := model.MessageDTO{3, 2, 5, time.Now(), "video", "", "www.youtube.com", 0, 0, "youtube"}
-	invalidMessage := model.MessageDTO{1, 2, 3, time.Now(), "invalid", "", "", 0, 0, ""}
+	textMessage := model.MessageDTO{1, 2, 3, time.Now(), "text", "text", "", 0, 0, "", nil, nil}
+	imageMessage := model.MessageDTO{2, 2, 4, time.Now(), "image", "", "www.image.com", 150, 120, "", nil, nil}
+	videoMessage := model.MessageDTO{3, 2, 5, time.Now(), "video", "", "www.youtube.com", 0, 0, "youtube", nil, nil}
+	invalidMessage := model.MessageDTO{1, 2, 3, time.Now(), "invalid", "", "", 0, 0, "", nil, nil}
 	messages1 := []model.MessageDTO{textMessage, imageMessage, videoMessage, invalidMessage}
 	responseMessages := ParseMessages(messages1)
 	assert.Equal(t, 3, len(responseMessages))
@@ -287,3 +463,23 @@ func TestParseMessages(t *testing.T) {
 	assert.Equal(t, videoMessage.Url, videoContent.Url)
 	assert.Equal(t, videoMessage.Source, videoContent.Source)
 }
+
+func TestSetDelivered(t *testing.T) {
+	delivered := time.Now().Add(-time.Minute)
+	m := []model.MessageDTO{{MessageId: 1, Delivered: &delivered}, {MessageId: 2}}
+
+	now := time.Now()
+	SetDelivered(m, now)
+
+	assert.True(t, m[0].Delivered.Equal(delivered))
+	assert.True(t, m[1].Delivered.Equal(now))
+}
+
+func TestParseMessageStatuses(t *testing.T) {
+	read := time.Now()
+	statuses := []model.MessageStatus{{MessageId: 1, RecipientId: 2}, {MessageId: 3, RecipientId: 4, Delivered: &read, Read: &read}}
+
+	response := ParseMessageStatuses(statuses)
+
+	assert.Equal(t, []model.MessageStatusResponse{{Id: 1, Recipient: 2}, {Id: 3, Recipient: 4, Delivered: &read, Read: &read}}, response)
+}
@@ -0,0 +1,143 @@
+package notifications
+
+import (
+	"context"
+	"sync"
+)
+
+//In-process hub that notifies the subscribers of a recipient each time a message is inserted for it
+//Notifications don't carry the message, subscribers are expected to recover the new messages from the database
+//starting from the last message they delivered, so a missed or coalesced notification never causes a gap
+
+type Hub struct {
+	mutex       sync.Mutex
+	subscribers map[int64]map[*Subscription]struct{}
+	closed      bool
+	active      sync.WaitGroup
+}
+
+//Subscription to the notifications of a single recipient
+//C receives a value when there are new messages, pending notifications are coalesced into one
+//C is closed when the hub is closed
+
+type Subscription struct {
+	C           <-chan struct{}
+	notify      chan struct{}
+	recipientId int64
+	hub         *Hub
+	done        bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[int64]map[*Subscription]struct{})}
+}
+
+//Subscribes to the notifications for the given recipient
+//The subscription must be closed once it isn't needed
+
+func (h *Hub) Subscribe(recipientId int64) *Subscription {
+	notify := make(chan struct{}, 1)
+	s := &Subscription{C: notify, notify: notify, recipientId: recipientId, hub: h}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.closed {
+		close(notify)
+		s.done = true
+		return s
+	}
+	h.active.Add(1)
+
+	if h.subscribers[recipientId] == nil {
+		h.subscribers[recipientId] = make(map[*Subscription]struct{})
+	}
+	h.subscribers[recipientId][s] = struct{}{}
+	return s
+}
+
+//Notifies every subscriber of the recipient that there are new messages
+//Never blocks, if a subscriber already has a pending notification it isn't notified again
+
+func (h *Hub) Publish(recipientId int64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for s := range h.subscribers[recipientId] {
+		select {
+		case s.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+//Returns the number of open subscriptions
+
+func (h *Hub) Subscribers() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	count := 0
+	for _, subscriptions := range h.subscribers {
+		count += len(subscriptions)
+	}
+	return count
+}
+
+//Closes every subscription and rejects the new ones
+
+func (h *Hub) Close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.closed {
+		return
+	}
+	h.closed = true
+
+	for _, subscriptions := range h.subscribers {
+		for s := range subscriptions {
+			close(s.notify)
+		}
+	}
+	h.subscribers = make(map[int64]map[*Subscription]struct{})
+}
+
+//Waits until the subscribers close every subscription, which they are expected to do once the hub is closed
+//Must be called after closing the hub
+//Returns the context error if there are still open subscriptions when the context is done
+
+func (h *Hub) Drain(ctx context.Context) error {
+	drained := make(chan struct{})
+	go func() {
+		h.active.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//Stops receiving notifications
+//Closing a subscription more than once has no effect
+
+func (s *Subscription) Close() {
+	s.hub.mutex.Lock()
+	defer s.hub.mutex.Unlock()
+
+	if s.done {
+		return
+	}
+	s.done = true
+	s.hub.active.Done()
+
+	subscriptions := s.hub.subscribers[s.recipientId]
+	delete(subscriptions, s)
+	if len(subscriptions) == 0 {
+		delete(s.hub.subscribers, s.recipientId)
+	}
+}
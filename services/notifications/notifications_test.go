@@ -0,0 +1,85 @@
+package notifications
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishShouldNotifyOnlyTheRecipientSubscribers(t *testing.T) {
+	hub := NewHub()
+	subscription1 := hub.Subscribe(1)
+	subscription2 := hub.Subscribe(2)
+	defer subscription1.Close()
+	defer subscription2.Close()
+
+	hub.Publish(1)
+
+	assert.True(t, notified(subscription1))
+	assert.False(t, notified(subscription2))
+}
+
+func TestPublishShouldCoalescePendingNotifications(t *testing.T) {
+	hub := NewHub()
+	subscription := hub.Subscribe(1)
+	defer subscription.Close()
+
+	hub.Publish(1)
+	hub.Publish(1)
+	hub.Publish(1)
+
+	assert.True(t, notified(subscription))
+	assert.False(t, notified(subscription))
+}
+
+func TestClosedSubscriptionShouldNotBeNotified(t *testing.T) {
+	hub := NewHub()
+	subscription := hub.Subscribe(1)
+	assert.Equal(t, 1, hub.Subscribers())
+
+	subscription.Close()
+	subscription.Close()
+	hub.Publish(1)
+
+	assert.False(t, notified(subscription))
+	assert.Equal(t, 0, hub.Subscribers())
+}
+
+func TestCloseHubShouldCloseSubscriptions(t *testing.T) {
+	hub := NewHub()
+	subscription := hub.Subscribe(1)
+
+	hub.Close()
+	_, open := <-subscription.C
+	assert.False(t, open)
+	subscription.Close()
+
+	subscription = hub.Subscribe(1)
+	_, open = <-subscription.C
+	assert.False(t, open)
+	assert.Equal(t, 0, hub.Subscribers())
+}
+
+func TestDrainShouldWaitForTheSubscriptions(t *testing.T) {
+	hub := NewHub()
+	subscription := hub.Subscribe(1)
+	hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, hub.Drain(ctx))
+
+	go subscription.Close()
+	assert.Nil(t, hub.Drain(context.Background()))
+}
+
+func notified(subscription *Subscription) bool {
+	select {
+	case <-subscription.C:
+		return true
+	default:
+		return false
+	}
+}
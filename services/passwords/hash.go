@@ -0,0 +1,264 @@
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/maidaneze/message-server/model"
+	"github.com/maidaneze/message-server/utils"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+	Scrypt   = "scrypt"
+
+	//Legacy single salted SHA256, only verified, new passwords are never hashed with it
+
+	SSHA256 = "ssha256"
+
+	saltLength = 16
+	keyLength  = 32
+)
+
+var (
+	ErrInvalidHash = errors.New("Invalid password hash")
+
+	//Default policy, argon2id with the RFC 9106 second recommended option
+
+	DefaultPolicy = Policy{
+		Algorithm:  Argon2id,
+		Argon2:     Argon2Params{Time: 3, Memory: 64 * 1024, Threads: 4},
+		BcryptCost: 12,
+		Scrypt:     ScryptParams{LogN: 15, R: 8, P: 1},
+	}
+)
+
+//Cost of the argon2id hashes, the memory is in KiB
+
+type Argon2Params struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+//Cost of the scrypt hashes, N is 2^LogN
+
+type ScryptParams struct {
+	LogN uint8
+	R    int
+	P    int
+}
+
+//Algorithm and cost used to hash the new passwords
+//Only the parameters of the selected algorithm are used to hash, but a stored hash with another algorithm or cost
+//must be upgraded
+
+type Policy struct {
+	Algorithm  string
+	Argon2     Argon2Params
+	BcryptCost int
+	Scrypt     ScryptParams
+}
+
+//Returns error if the algorithm is unknown or its cost is invalid
+
+func (p Policy) Validate() error {
+	switch p.Algorithm {
+	case Argon2id:
+		if p.Argon2.Time == 0 || p.Argon2.Threads == 0 || p.Argon2.Memory < 8*uint32(p.Argon2.Threads) {
+			return errors.New("Invalid argon2id parameters")
+		}
+	case Bcrypt:
+		if p.BcryptCost < bcrypt.MinCost || p.BcryptCost > bcrypt.MaxCost {
+			return errors.New("Invalid bcrypt cost")
+		}
+	case Scrypt:
+		if p.Scrypt.LogN <= 1 || p.Scrypt.LogN >= 63 || p.Scrypt.R <= 0 || p.Scrypt.P <= 0 {
+			return errors.New("Invalid scrypt parameters")
+		}
+	default:
+		return errors.New("Unknown password hash algorithm: " + p.Algorithm)
+	}
+	return nil
+}
+
+//Hashes the password with the policy algorithm and cost using a random salt
+//The result is self describing, it records the algorithm, the cost and the salt:
+//	$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+//	$scrypt$ln=15,r=8,p=1$<salt>$<hash>
+//	$2a$12$<bcrypt salt and hash>
+//Returns error in case of failiure
+
+func (p Policy) Hash(password string) (string, error) {
+	if err := p.Validate(); err != nil {
+		return "", err
+	}
+
+	if p.Algorithm == Bcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), p.BcryptCost)
+		return string(hash), err
+	}
+
+	salt, err := randomSalt(saltLength)
+	if err != nil {
+		return "", err
+	}
+
+	if p.Algorithm == Scrypt {
+		key, err := scrypt.Key([]byte(password), salt, 1<<p.Scrypt.LogN, p.Scrypt.R, p.Scrypt.P, keyLength)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", p.Scrypt.LogN, p.Scrypt.R, p.Scrypt.P, encode(salt), encode(key)), nil
+	}
+
+	key := argon2.IDKey([]byte(password), salt, p.Argon2.Time, p.Argon2.Memory, p.Argon2.Threads, keyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Argon2.Memory, p.Argon2.Time, p.Argon2.Threads, encode(salt), encode(key)), nil
+}
+
+//Returns a user with the password hashed with the policy, the userid and username are kept
+//Returns error in case of failiure
+
+func (p Policy) HashUserPassword(user model.User, password string) (model.User, error) {
+	hash, err := p.Hash(password)
+	if err != nil {
+		return user, err
+	}
+	user.Password = hash
+	user.Salt = ""
+	return user, nil
+}
+
+//Verifies the password against the user stored hash, either a legacy SSHA256 hash with its salt or an encoded hash
+//Returns true if the password matches
+//Returns true in the second value if the hash must be upgraded because it doesn't use the policy algorithm and cost
+
+func (p Policy) Verify(user model.User, password string) (bool, bool) {
+	params, err := decodeHash(user)
+	if err != nil {
+		return false, false
+	}
+
+	var valid bool
+	switch params.Algorithm {
+	case SSHA256:
+		valid = subtle.ConstantTimeCompare([]byte(GetEncryptedPassword(password, user.Salt)), []byte(user.Password)) == 1
+	case Bcrypt:
+		valid = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil
+	case Scrypt:
+		key, err := scrypt.Key([]byte(password), params.salt, 1<<params.Scrypt.LogN, params.Scrypt.R, params.Scrypt.P, len(params.key))
+		valid = err == nil && subtle.ConstantTimeCompare(key, params.key) == 1
+	case Argon2id:
+		key := argon2.IDKey([]byte(password), params.salt, params.Argon2.Time, params.Argon2.Memory, params.Argon2.Threads, uint32(len(params.key)))
+		valid = subtle.ConstantTimeCompare(key, params.key) == 1
+	}
+	return valid, valid && !p.matches(params.Policy)
+}
+
+//Returns true if the stored hash parameters are the ones the policy would use
+
+func (p Policy) matches(stored Policy) bool {
+	if stored.Algorithm != p.Algorithm {
+		return false
+	}
+
+	switch p.Algorithm {
+	case Argon2id:
+		return stored.Argon2 == p.Argon2
+	case Bcrypt:
+		return stored.BcryptCost == p.BcryptCost
+	case Scrypt:
+		return stored.Scrypt == p.Scrypt
+	}
+	return false
+}
+
+//Parameters decoded from a stored hash
+
+type hashParams struct {
+	Policy
+	salt []byte
+	key  []byte
+}
+
+//Decodes the algorithm, cost, salt and key of the user stored hash
+//Users with a salt have a legacy SSHA256 hash
+//Returns ErrInvalidHash if the hash can't be decoded
+
+func decodeHash(user model.User) (hashParams, error) {
+	var params hashParams
+	if user.Salt != "" {
+		params.Algorithm = SSHA256
+		return params, nil
+	}
+
+	if strings.HasPrefix(user.Password, "$2") {
+		cost, err := bcrypt.Cost([]byte(user.Password))
+		if err != nil {
+			return params, ErrInvalidHash
+		}
+		params.Algorithm = Bcrypt
+		params.BcryptCost = cost
+		return params, nil
+	}
+
+	parts := strings.Split(user.Password, "$")
+	var salt, key string
+	var err error
+	switch {
+	case len(parts) == 6 && parts[1] == Argon2id:
+		var version int
+		params.Algorithm = Argon2id
+		if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+			return params, ErrInvalidHash
+		}
+		_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Argon2.Memory, &params.Argon2.Time, &params.Argon2.Threads)
+		salt, key = parts[4], parts[5]
+	case len(parts) == 5 && parts[1] == Scrypt:
+		params.Algorithm = Scrypt
+		_, err = fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &params.Scrypt.LogN, &params.Scrypt.R, &params.Scrypt.P)
+		salt, key = parts[3], parts[4]
+	default:
+		return params, ErrInvalidHash
+	}
+
+	if err != nil || params.Validate() != nil {
+		return params, ErrInvalidHash
+	}
+
+	if params.salt, err = decode(salt); err != nil {
+		return params, ErrInvalidHash
+	}
+	if params.key, err = decode(key); err != nil || len(params.key) == 0 {
+		return params, ErrInvalidHash
+	}
+	return params, nil
+}
+
+func randomSalt(length int) ([]byte, error) {
+	salt := make([]byte, length)
+	var err error
+	utils.Retry(func() error {
+		_, err = rand.Read(salt)
+		return err
+	}, 2, 20*time.Millisecond)
+	return salt, err
+}
+
+func encode(value []byte) string {
+	return base64.RawStdEncoding.EncodeToString(value)
+}
+
+func decode(value string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(value)
+}
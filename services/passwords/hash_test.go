@@ -0,0 +1,163 @@
+package passwords
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/maidaneze/message-server/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testArgon2Policy = Policy{Algorithm: Argon2id, Argon2: Argon2Params{Time: 1, Memory: 64, Threads: 1}}
+	testBcryptPolicy = Policy{Algorithm: Bcrypt, BcryptCost: 4}
+	testScryptPolicy = Policy{Algorithm: Scrypt, Scrypt: ScryptParams{LogN: 4, R: 8, P: 1}}
+)
+
+func TestPolicyHashShouldBeVerified(t *testing.T) {
+	cases := []struct {
+		name   string
+		policy Policy
+		prefix string
+	}{
+		{"testArgon2idHash", testArgon2Policy, "$argon2id$v=19$m=64,t=1,p=1$"},
+		{"testBcryptHash", testBcryptPolicy, "$2a$04$"},
+		{"testScryptHash", testScryptPolicy, "$scrypt$ln=4,r=8,p=1$"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			hash1, err := c.policy.Hash("pass")
+			require.Nil(tt, err)
+			hash2, err := c.policy.Hash("pass")
+			require.Nil(tt, err)
+
+			assert.True(tt, strings.HasPrefix(hash1, c.prefix))
+			assert.NotEqual(tt, hash1, hash2)
+
+			valid, rehash := c.policy.Verify(model.User{Password: hash1}, "pass")
+			assert.True(tt, valid)
+			assert.False(tt, rehash)
+
+			valid, rehash = c.policy.Verify(model.User{Password: hash1}, "invalid")
+			assert.False(tt, valid)
+			assert.False(tt, rehash)
+
+			assert.True(tt, ValidUserPassword(model.User{Password: hash2}, "pass"))
+		})
+	}
+}
+
+func TestPolicyVerifyShouldRequestRehash(t *testing.T) {
+	legacyPassword, salt, err := GenerateSecurePassword("pass")
+	require.Nil(t, err)
+	legacy := model.User{Password: legacyPassword, Salt: salt}
+
+	cheaperArgon2 := testArgon2Policy
+	cheaperArgon2.Argon2.Memory = 32
+	cheaperScrypt := testScryptPolicy
+	cheaperScrypt.Scrypt.LogN = 3
+
+	cases := []struct {
+		name   string
+		policy Policy
+		stored Policy
+	}{
+		{"testRehashArgon2idWithOtherCost", testArgon2Policy, cheaperArgon2},
+		{"testRehashBcryptWithOtherCost", Policy{Algorithm: Bcrypt, BcryptCost: 5}, testBcryptPolicy},
+		{"testRehashScryptWithOtherCost", testScryptPolicy, cheaperScrypt},
+		{"testRehashBcryptToArgon2id", testArgon2Policy, testBcryptPolicy},
+		{"testRehashScryptToBcrypt", testBcryptPolicy, testScryptPolicy},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			hash, err := c.stored.Hash("pass")
+			require.Nil(tt, err)
+
+			valid, rehash := c.policy.Verify(model.User{Password: hash}, "pass")
+			assert.True(tt, valid)
+			assert.True(tt, rehash)
+
+			valid, rehash = c.policy.Verify(legacy, "pass")
+			assert.True(tt, valid)
+			assert.True(tt, rehash)
+
+			valid, rehash = c.policy.Verify(legacy, "invalid")
+			assert.False(tt, valid)
+			assert.False(tt, rehash)
+		})
+	}
+}
+
+func TestHashUserPasswordShouldClearLegacySalt(t *testing.T) {
+	legacyPassword, salt, err := GenerateSecurePassword("pass")
+	require.Nil(t, err)
+	legacy := model.User{Userid: 1, Username: "user", Password: legacyPassword, Salt: salt}
+
+	user, err := testArgon2Policy.HashUserPassword(legacy, "pass")
+	require.Nil(t, err)
+	assert.Equal(t, legacy.Userid, user.Userid)
+	assert.Equal(t, legacy.Username, user.Username)
+	assert.Equal(t, "", user.Salt)
+
+	valid, rehash := testArgon2Policy.Verify(user, "pass")
+	assert.True(t, valid)
+	assert.False(t, rehash)
+}
+
+func TestPolicyVerifyShouldRejectInvalidHashes(t *testing.T) {
+	cases := []struct {
+		name     string
+		password string
+	}{
+		{"testEmptyHash", ""},
+		{"testUnknownAlgorithm", "$md5$salt$hash"},
+		{"testArgon2idWrongVersion", "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$aGFzaA"},
+		{"testArgon2idInvalidParams", "$argon2id$v=19$m=64,t=0,p=1$c2FsdA$aGFzaA"},
+		{"testArgon2idInvalidSalt", "$argon2id$v=19$m=64,t=1,p=1$!!!$aGFzaA"},
+		{"testArgon2idEmptyKey", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA$"},
+		{"testScryptMissingKey", "$scrypt$ln=4,r=8,p=1$c2FsdA"},
+		{"testScryptInvalidParams", "$scrypt$ln=4,r=0,p=1$c2FsdA$aGFzaA"},
+		{"testBcryptInvalidHash", "$2a$04$short"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			valid, rehash := testArgon2Policy.Verify(model.User{Password: c.password}, "pass")
+			assert.False(tt, valid)
+			assert.False(tt, rehash)
+		})
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		policy Policy
+		valid  bool
+	}{
+		{"testValidateDefaultPolicy", DefaultPolicy, true},
+		{"testValidateArgon2idPolicy", testArgon2Policy, true},
+		{"testValidateBcryptPolicy", testBcryptPolicy, true},
+		{"testValidateScryptPolicy", testScryptPolicy, true},
+		{"testValidateEmptyPolicy", Policy{}, false},
+		{"testValidateUnknownAlgorithm", Policy{Algorithm: SSHA256}, false},
+		{"testValidateArgon2idWithoutTime", Policy{Algorithm: Argon2id, Argon2: Argon2Params{Memory: 64, Threads: 1}}, false},
+		{"testValidateArgon2idWithoutMemory", Policy{Algorithm: Argon2id, Argon2: Argon2Params{Time: 1, Threads: 1}}, false},
+		{"testValidateBcryptLowCost", Policy{Algorithm: Bcrypt, BcryptCost: 3}, false},
+		{"testValidateBcryptHighCost", Policy{Algorithm: Bcrypt, BcryptCost: 32}, false},
+		{"testValidateScryptWithoutR", Policy{Algorithm: Scrypt, Scrypt: ScryptParams{LogN: 4, P: 1}}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			assert.Equal(tt, c.valid, c.policy.Validate() == nil)
+
+			_, err := c.policy.Hash("pass")
+			assert.Equal(tt, c.valid, err == nil)
+		})
+	}
+}
@@ -10,7 +10,8 @@ import (
 	"github.com/mattn/go-sqlite3"
 )
 
-//Generates a secure password to safely store it on a database using a 32 bit random salt
+//Generates a legacy SSHA256 password using a 32 bit random salt
+//New passwords are hashed with a Policy, legacy passwords are only verified and upgraded on login
 //Returns the encrypted password and the random salt used on success
 //Returns an error if it fails
 
@@ -41,8 +42,10 @@ func GetEncryptedPassword(password, salt string) string {
 	return encryptedPassword
 }
 
-//Returns true if the given password matches the encrypted password and false otherwise
+//Returns true if the given password matches the user password, either a legacy or an encoded hash
+//Returns false otherwise
 
 func ValidUserPassword(user model.User, password string) bool {
-	return GetEncryptedPassword(password, user.Salt) == user.Password
+	valid, _ := DefaultPolicy.Verify(user, password)
+	return valid
 }
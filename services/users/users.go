@@ -1,31 +1,44 @@
 package users
 
 import (
+	"fmt"
+
+	"github.com/maidaneze/message-server/apperrors"
 	"github.com/maidaneze/message-server/model"
 	"github.com/maidaneze/message-server/services/passwords"
 )
 
 //Validates if the usersRequestDTO is valid
-//Returns true if it is, false if it isn't
+//Returns the invalid fields, none if it is valid
 //Maximum length for username and passwords fields is 32 characters
 //Username and Password can't be empty
 
-func ValidateUsersRequestDTO(dto model.UserRequestDTO) bool {
-	if dto.Username == "" || dto.Password == "" || len(dto.Username) > model.MAX_USERNAME_FIELD_SIZE ||
-		len(dto.Password) > model.MAX_PASSWORD_FIELD_SIZE {
-		return false
+func ValidateUsersRequestDTO(dto model.UserRequestDTO) []apperrors.FieldError {
+	var fields []apperrors.FieldError
+	fields = append(fields, validateCredential("username", dto.Username, model.MAX_USERNAME_FIELD_SIZE)...)
+	fields = append(fields, validateCredential("password", dto.Password, model.MAX_PASSWORD_FIELD_SIZE)...)
+	return fields
+}
+
+//Validates the username or password field, it can't be empty nor longer than max
+
+func validateCredential(field string, value string, max int) []apperrors.FieldError {
+	switch {
+	case value == "":
+		return []apperrors.FieldError{{Field: field, Code: apperrors.FieldRequired, Message: field + " is required"}}
+	case len(value) > max:
+		return []apperrors.FieldError{{Field: field, Code: apperrors.FieldTooLong, Message: fmt.Sprintf("%v must be at most %v characters", field, max)}}
+	default:
+		return nil
 	}
-	return true
 }
 
 //Creates a new user with the given username and password
 //The resulting user.UserId is 0, it must be set after inserting into the database
 //The resulting user.Username is the given username
-//The resulting user.Salt is a randomly insertd 32 byte string
-//The resulting user.password is the given password encoded with SHA256 using the insertd salt
+//The resulting user.Password is the given password hashed with the policy, encoding its algorithm, cost and salt
+//The resulting user.Salt is empty, it's only used by legacy passwords
 
-func CreateUser(username string, password string) (model.User, error) {
-	//Encode password and insert salt
-	encryptedPassword, salt, err := passwords.GenerateSecurePassword(password)
-	return model.User{0, username, encryptedPassword, salt}, err
+func CreateUser(username string, password string, policy passwords.Policy) (model.User, error) {
+	return policy.HashUserPassword(model.User{Username: username}, password)
 }
@@ -1,6 +1,7 @@
 package users
 
 import (
+	"github.com/maidaneze/message-server/apperrors"
 	"github.com/maidaneze/message-server/model"
 	"testing"
 
@@ -49,12 +50,33 @@ func TestValidateUsersRequestDTO(t *testing.T) {
 	for _, c := range cases {
 		t.Run(c.name, func(tt *testing.T) {
 			actual := ValidateUsersRequestDTO(c.message)
-			assert.Equal(tt, c.expected, actual)
+			assert.Equal(tt, c.expected, len(actual) == 0)
 
 		})
 	}
 }
 
+func TestValidateUsersRequestDTOShouldReturnTheInvalidFields(t *testing.T) {
+	cases := []struct {
+		name     string
+		message  model.UserRequestDTO
+		expected []apperrors.FieldError
+	}{
+		{"testInvalidFieldsEmptyBody", model.UserRequestDTO{}, []apperrors.FieldError{
+			{Field: "username", Code: apperrors.FieldRequired, Message: "username is required"},
+			{Field: "password", Code: apperrors.FieldRequired, Message: "password is required"},
+		}},
+		{"testInvalidFieldsLongPassword", model.UserRequestDTO{Username: "user1", Password: string(make([]byte, model.MAX_PASSWORD_FIELD_SIZE+1))}, []apperrors.FieldError{
+			{Field: "password", Code: apperrors.FieldTooLong, Message: "password must be at most 32 characters"},
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			assert.Equal(tt, c.expected, ValidateUsersRequestDTO(c.message))
+		})
+	}
+}
+
 func TestCreateUser(t *testing.T) {
 	cases := []struct {
 		name     string
@@ -66,14 +88,19 @@ func TestCreateUser(t *testing.T) {
 		{"testCreateUserNonEmptyUserAndEmptyPassword", "user", ""},
 		{"testCreateUserNonEmptyUserAndNonEmptyPassword", "user", "pass"},
 	}
+	policy := passwords.Policy{Algorithm: passwords.Bcrypt, BcryptCost: 4}
 	for _, c := range cases {
 		t.Run(c.name, func(tt *testing.T) {
-			user, err := CreateUser(c.username, c.password)
+			user, err := CreateUser(c.username, c.password, policy)
 			assert.Nil(tt, err)
 			assert.Equal(tt, int64(0), user.Userid)
 			assert.Equal(tt, c.username, user.Username)
-			assert.Equal(tt, passwords.GetEncryptedPassword(c.password, user.Salt), user.Password)
-			assert.True(tt, len(user.Salt) > 0)
+			assert.NotEqual(tt, c.password, user.Password)
+			assert.Equal(tt, "", user.Salt)
+
+			valid, rehash := policy.Verify(user, c.password)
+			assert.True(tt, valid)
+			assert.False(tt, rehash)
 		})
 	}
 }
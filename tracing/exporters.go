@@ -0,0 +1,222 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//Exporter writing every span as a JSON line, like to the standard output
+
+type WriterExporter struct {
+	mutex *sync.Mutex
+	out   io.Writer
+}
+
+func NewWriterExporter(out io.Writer) WriterExporter {
+	return WriterExporter{mutex: &sync.Mutex{}, out: out}
+}
+
+//Span as written by the WriterExporter
+
+type writtenSpan struct {
+	TraceId      string                 `json:"trace_id"`
+	SpanId       string                 `json:"span_id"`
+	ParentSpanId string                 `json:"parent_span_id,omitempty"`
+	Name         string                 `json:"name"`
+	Kind         string                 `json:"kind"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	DurationMs   float64                `json:"duration_ms"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Error        string                 `json:"error,omitempty"`
+}
+
+func (e WriterExporter) Export(ctx context.Context, spans []SpanData) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	encoder := json.NewEncoder(e.out)
+	for _, span := range spans {
+		written := writtenSpan{
+			TraceId:    span.Context.TraceID.String(),
+			SpanId:     span.Context.SpanID.String(),
+			Name:       span.Name,
+			Kind:       span.Kind.String(),
+			Start:      span.Start.UTC(),
+			End:        span.End.UTC(),
+			DurationMs: float64(span.End.Sub(span.Start)) / float64(time.Millisecond),
+			Error:      span.Error,
+		}
+		if span.Parent.IsValid() {
+			written.ParentSpanId = span.Parent.String()
+		}
+		if len(span.Attributes) > 0 {
+			written.Attributes = make(map[string]interface{}, len(span.Attributes))
+			for _, attribute := range span.Attributes {
+				written.Attributes[attribute.Key] = attribute.Value
+			}
+		}
+		if err := encoder.Encode(written); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Exporter sending the spans to an OpenTelemetry collector with the OTLP/HTTP protocol, JSON encoded
+//The endpoint is the full url of the traces, like http://localhost:4318/v1/traces
+//The service is the service.name of the spans
+
+type OTLPExporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+}
+
+func NewOTLPExporter(endpoint string, service string) OTLPExporter {
+	return OTLPExporter{endpoint: endpoint, service: service, client: &http.Client{}}
+}
+
+//OTLP ExportTraceServiceRequest, see https://github.com/open-telemetry/opentelemetry-proto
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+//Status codes of the spans
+
+const (
+	otlpStatusUnset = 0
+	otlpStatusError = 2
+)
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+//Instrumentation scope of the spans
+
+const scopeName = "github.com/maidaneze/message-server/tracing"
+
+//Returns error if the collector can't be reached or doesn't accept the spans
+
+func (e OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: scopeName}, Spans: make([]otlpSpan, 0, len(spans))}
+	for _, span := range spans {
+		scope.Spans = append(scope.Spans, otlpSpanOf(span))
+	}
+	request := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttribute{otlpAttributeOf(Attribute{"service.name", e.service})}},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Unexpected OTLP response status %v", resp.Status)
+	}
+	return nil
+}
+
+func otlpSpanOf(span SpanData) otlpSpan {
+	converted := otlpSpan{
+		TraceId:           span.Context.TraceID.String(),
+		SpanId:            span.Context.SpanID.String(),
+		Name:              span.Name,
+		Kind:              span.Kind,
+		StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		Status:            otlpStatus{Code: otlpStatusUnset},
+	}
+	if span.Parent.IsValid() {
+		converted.ParentSpanId = span.Parent.String()
+	}
+	for _, attribute := range span.Attributes {
+		converted.Attributes = append(converted.Attributes, otlpAttributeOf(attribute))
+	}
+	if span.Error != "" {
+		converted.Status = otlpStatus{Code: otlpStatusError, Message: span.Error}
+	}
+	return converted
+}
+
+//Returns the attribute with its OTLP AnyValue, the integers are encoded as strings like in the OTLP JSON mapping
+
+func otlpAttributeOf(attribute Attribute) otlpAttribute {
+	var value map[string]interface{}
+	switch v := attribute.Value.(type) {
+	case string:
+		value = map[string]interface{}{"stringValue": v}
+	case bool:
+		value = map[string]interface{}{"boolValue": v}
+	case int:
+		value = map[string]interface{}{"intValue": strconv.FormatInt(int64(v), 10)}
+	case int64:
+		value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		value = map[string]interface{}{"doubleValue": v}
+	default:
+		value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+	}
+	return otlpAttribute{Key: attribute.Key, Value: value}
+}
@@ -0,0 +1,174 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/maidaneze/message-server/logging"
+)
+
+//Exports the finished spans to a tracing backend
+
+type Exporter interface {
+
+	//Exports a batch of spans
+	//Returns error in case of failiure
+
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+//Batching of the exports
+
+const (
+	//Spans waiting for the export, the spans ended while the queue is full are dropped
+	queueSize = 2048
+
+	//Maximum number of spans of an export
+	batchSize = 512
+
+	//Maximum time a span waits for the export
+	batchInterval = time.Second
+
+	//Maximum time of an export
+	exportTimeout = time.Second * 10
+)
+
+//Starts the spans and exports them once ended, in batches and in the background so the traced operations never
+//wait for the exporter
+//A nil tracer doesn't trace anything
+
+type Tracer struct {
+	exporter Exporter
+	log      *logging.Logger
+	now      func() time.Time
+
+	mutex  *sync.Mutex
+	closed bool
+	spans  chan SpanData
+	done   chan struct{}
+}
+
+//Returns a tracer exporting its spans with the exporter, the export failures are logged to log
+
+func New(exporter Exporter, log *logging.Logger) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		log:      log,
+		now:      time.Now,
+		mutex:    &sync.Mutex{},
+		spans:    make(chan SpanData, queueSize),
+		done:     make(chan struct{}),
+	}
+	go t.export()
+	return t
+}
+
+//Starts a span, child of the span of the context or of its remote parent
+//The span starts a new trace if the context has neither, and the new traces are always sampled
+//Returns the context with the new span and the span
+//Returns the context and a nil span if the tracer is nil
+
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	var parent SpanContext
+	if span := SpanFromContext(ctx); span != nil {
+		parent = span.Context()
+	} else if remote, found := ctx.Value(remoteParentKey{}).(SpanContext); found {
+		parent = remote
+	}
+
+	span := &Span{tracer: t, data: SpanData{Name: name, Kind: kind, Start: t.now()}}
+	if parent.IsValid() {
+		span.data.Context.TraceID = parent.TraceID
+		span.data.Context.Sampled = parent.Sampled
+		span.data.Parent = parent.SpanID
+	} else {
+		randomId(span.data.Context.TraceID[:])
+		span.data.Context.Sampled = true
+	}
+	randomId(span.data.Context.SpanID[:])
+
+	return ContextWithSpan(ctx, span), span
+}
+
+//Stops the tracer and exports the pending spans, the spans ended afterwards are dropped
+//Returns the context error if the spans couldn't be exported before the context is done
+
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	t.mutex.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.spans)
+	}
+	t.mutex.Unlock()
+
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//Queues the ended span for the export, dropping it if the queue is full or the tracer stopped
+
+func (t *Tracer) queue(span SpanData) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed {
+		return
+	}
+
+	select {
+	case t.spans <- span:
+	default:
+		t.log.Warn("Dropped a span, the tracing queue is full", logging.Fields{"span": span.Name})
+	}
+}
+
+//Exports the queued spans in batches, once the batch is full or after batchInterval
+//Returns once the tracer is stopped and the remaining spans are exported
+
+func (t *Tracer) export() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		defer cancel()
+		if err := t.exporter.Export(ctx, batch); err != nil {
+			t.log.Error("Unable to export the spans", logging.Fields{"error": err, "spans": len(batch)})
+		}
+		batch = make([]SpanData, 0, batchSize)
+	}
+
+	for {
+		select {
+		case span, open := <-t.spans:
+			if !open {
+				flush()
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) == batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
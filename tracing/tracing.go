@@ -0,0 +1,260 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Identifier of a trace, shared by all its spans
+
+type TraceID [16]byte
+
+//Identifier of a span within its trace
+
+type SpanID [8]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+//Identity of a span, propagated to the child spans and between services with the traceparent header
+//Sampled is false if the trace isn't recorded, its spans are created for the propagation but never exported
+
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (c SpanContext) IsValid() bool {
+	return c.TraceID.IsValid() && c.SpanID.IsValid()
+}
+
+//Header propagating the span context, see https://www.w3.org/TR/trace-context/
+
+const TraceparentHeader = "traceparent"
+
+//Returns the traceparent header value of the span context, version 00
+
+func (c SpanContext) Traceparent() string {
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+	return "00-" + c.TraceID.String() + "-" + c.SpanID.String() + "-" + flags
+}
+
+//Parses a traceparent header value
+//Future versions are accepted as long as they start with the version 00 fields
+//Returns the span context and true if the value is valid
+
+func ParseTraceparent(value string) (SpanContext, bool) {
+	value = strings.TrimSpace(value)
+	parts := strings.Split(value, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+
+	var c SpanContext
+	version, versionErr := hex.DecodeString(parts[0])
+	traceId, traceErr := hex.DecodeString(parts[1])
+	spanId, spanErr := hex.DecodeString(parts[2])
+	flags, flagsErr := hex.DecodeString(parts[3])
+	if versionErr != nil || traceErr != nil || spanErr != nil || flagsErr != nil ||
+		len(version) != 1 || len(traceId) != len(c.TraceID) || len(spanId) != len(c.SpanID) || len(flags) != 1 ||
+		strings.ToLower(value) != value {
+		return SpanContext{}, false
+	}
+
+	copy(c.TraceID[:], traceId)
+	copy(c.SpanID[:], spanId)
+	c.Sampled = flags[0]&1 == 1
+	return c, c.IsValid()
+}
+
+//Role of the span in the trace, with the values of OpenTelemetry
+
+type SpanKind int
+
+const (
+	Internal SpanKind = 1
+	Server   SpanKind = 2
+	Client   SpanKind = 3
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case Server:
+		return "server"
+	case Client:
+		return "client"
+	default:
+		return "internal"
+	}
+}
+
+//Attribute of a span, the value is a string, a bool, an integer or a float
+
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+//Finished span, as exported
+//Parent is invalid for the root spans
+//Error describes why the operation failed, empty if it didn't
+
+type SpanData struct {
+	Name       string
+	Kind       SpanKind
+	Context    SpanContext
+	Parent     SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	Error      string
+}
+
+//Operation in progress of a trace
+//A nil span records nothing, so code can be traced without checking if tracing is enabled
+
+type Span struct {
+	tracer *Tracer
+	mutex  sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+//Returns the context of the span, or an invalid context if the span is nil
+
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+//Replaces the name of the span, like once the route of a request is known
+
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data.Name = name
+}
+
+//Sets the attribute of the span, replacing its previous value
+
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := range s.data.Attributes {
+		if s.data.Attributes[i].Key == key {
+			s.data.Attributes[i].Value = value
+			return
+		}
+	}
+	s.data.Attributes = append(s.data.Attributes, Attribute{key, value})
+}
+
+//Marks the operation of the span as failed by the error, a nil error has no effect
+//The first error is kept, it's the cause of the later ones
+
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.data.Error == "" {
+		s.data.Error = err.Error()
+	}
+}
+
+//Finishes the span and queues it for the export if it's sampled, ending it again has no effect
+
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = s.tracer.now()
+	data := s.data
+	s.mutex.Unlock()
+
+	if data.Context.Sampled {
+		s.tracer.queue(data)
+	}
+}
+
+type spanKey struct{}
+
+type remoteParentKey struct{}
+
+//Returns a copy of the context with the span, the parent of the spans started from the context
+
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+//Returns the span of the context, or nil if the context doesn't have one
+
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+//Returns a copy of the context with the span context received from another service, like in a traceparent header
+//The next span started from the context continues its trace
+
+func ContextWithRemoteParent(ctx context.Context, parent SpanContext) context.Context {
+	return context.WithValue(ctx, remoteParentKey{}, parent)
+}
+
+//Starts a span as child of the span of the context, using the tracer of that span
+//Returns the context with the new span and the span
+//Returns the context and a nil span if the context doesn't have a span, so the operations of untraced requests
+//aren't traced either
+
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, kind)
+}
+
+//Generates the ids of the new traces and spans
+
+func randomId(id []byte) {
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Sprintf("tracing: unable to generate an id: %v", err))
+	}
+}
@@ -0,0 +1,237 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//Exporter keeping the exported spans
+
+type recordingExporter struct {
+	spans []SpanData
+}
+
+func (e *recordingExporter) Export(ctx context.Context, spans []SpanData) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	cases := []struct {
+		name            string
+		value           string
+		expectedValid   bool
+		expectedTrace   string
+		expectedSpan    string
+		expectedSampled bool
+	}{
+		{"testParseTraceparentSampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true},
+		{"testParseTraceparentNotSampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", false},
+		{"testParseTraceparentFutureVersion", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true},
+		{"testParseTraceparentEmpty", "", false, "", "", false},
+		{"testParseTraceparentInvalidVersion", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, "", "", false},
+		{"testParseTraceparentExtraFields", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, "", "", false},
+		{"testParseTraceparentShortTraceId", "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", false, "", "", false},
+		{"testParseTraceparentUppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01", false, "", "", false},
+		{"testParseTraceparentZeroTraceId", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, "", "", false},
+		{"testParseTraceparentZeroSpanId", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, "", "", false},
+		{"testParseTraceparentNotHex", "00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01", false, "", "", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			parsed, valid := ParseTraceparent(c.value)
+			assert.Equal(tt, c.expectedValid, valid)
+			if c.expectedValid {
+				assert.Equal(tt, c.expectedTrace, parsed.TraceID.String())
+				assert.Equal(tt, c.expectedSpan, parsed.SpanID.String())
+				assert.Equal(tt, c.expectedSampled, parsed.Sampled)
+			}
+		})
+	}
+}
+
+func TestTraceparentShouldRoundTrip(t *testing.T) {
+	value := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	parsed, valid := ParseTraceparent(value)
+	require.True(t, valid)
+	assert.Equal(t, value, parsed.Traceparent())
+}
+
+func TestTracerShouldExportTheChildSpans(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := New(exporter, nil)
+
+	ctx, root := tracer.Start(context.Background(), "request", Server)
+	_, child := Start(ctx, "db.GetUser", Client)
+	child.SetAttribute("db.system", "sqlite3")
+	child.SetAttribute("db.system", "postgres")
+	child.SetError(errors.New("database is locked"))
+	child.SetError(errors.New("retries exhausted"))
+	child.End()
+	child.End()
+	root.SetName("GET /users")
+	root.End()
+	require.Nil(t, tracer.Shutdown(context.Background()))
+
+	require.Len(t, exporter.spans, 2)
+	exportedChild, exportedRoot := exporter.spans[0], exporter.spans[1]
+
+	assert.Equal(t, "GET /users", exportedRoot.Name)
+	assert.Equal(t, Server, exportedRoot.Kind)
+	assert.False(t, exportedRoot.Parent.IsValid())
+	assert.True(t, exportedRoot.Context.Sampled)
+
+	assert.Equal(t, "db.GetUser", exportedChild.Name)
+	assert.Equal(t, exportedRoot.Context.TraceID, exportedChild.Context.TraceID)
+	assert.Equal(t, exportedRoot.Context.SpanID, exportedChild.Parent)
+	assert.NotEqual(t, exportedRoot.Context.SpanID, exportedChild.Context.SpanID)
+	assert.Equal(t, []Attribute{{"db.system", "postgres"}}, exportedChild.Attributes)
+	assert.Equal(t, "database is locked", exportedChild.Error)
+	assert.False(t, exportedChild.End.Before(exportedChild.Start))
+}
+
+func TestTracerShouldContinueTheRemoteTrace(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := New(exporter, nil)
+
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, span := tracer.Start(ContextWithRemoteParent(context.Background(), parent), "request", Server)
+	span.End()
+
+	unsampled, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, ignored := tracer.Start(ContextWithRemoteParent(context.Background(), unsampled), "request", Server)
+	ignored.End()
+	require.Nil(t, tracer.Shutdown(context.Background()))
+
+	require.Len(t, exporter.spans, 1)
+	assert.Equal(t, parent.TraceID, exporter.spans[0].Context.TraceID)
+	assert.Equal(t, parent.SpanID, exporter.spans[0].Parent)
+	assert.Equal(t, parent.TraceID, ignored.Context().TraceID)
+}
+
+func TestTracerShouldDropTheSpansEndedAfterShutdown(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := New(exporter, nil)
+
+	_, span := tracer.Start(context.Background(), "request", Server)
+	require.Nil(t, tracer.Shutdown(context.Background()))
+	require.Nil(t, tracer.Shutdown(context.Background()))
+	span.End()
+
+	assert.Empty(t, exporter.spans)
+}
+
+func TestNilTracerShouldTraceNothing(t *testing.T) {
+	var tracer *Tracer
+	ctx, span := tracer.Start(context.Background(), "request", Server)
+	assert.Nil(t, span)
+	assert.Nil(t, SpanFromContext(ctx))
+
+	_, child := Start(ctx, "db.GetUser", Client)
+	assert.Nil(t, child)
+	child.SetName("db.GetUsers")
+	child.SetAttribute("db.system", "sqlite3")
+	child.SetError(errors.New("database is locked"))
+	child.End()
+	assert.False(t, child.Context().IsValid())
+	assert.Nil(t, tracer.Shutdown(context.Background()))
+}
+
+func spanForTest() SpanData {
+	start := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	var span SpanData
+	span.Name = "db.GetUser"
+	span.Kind = Client
+	span.Context.TraceID = TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36}
+	span.Context.SpanID = SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7}
+	span.Context.Sampled = true
+	span.Parent = SpanID{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}
+	span.Start = start
+	span.End = start.Add(time.Millisecond * 3)
+	span.Attributes = []Attribute{{"db.system", "sqlite3"}, {"db.attempt", 2}}
+	span.Error = "database is locked"
+	return span
+}
+
+func TestWriterExporterShouldWriteJSONLines(t *testing.T) {
+	output := new(bytes.Buffer)
+	require.Nil(t, NewWriterExporter(output).Export(context.Background(), []SpanData{spanForTest(), spanForTest()}))
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	require.Len(t, lines, 2)
+
+	written := map[string]interface{}{}
+	require.Nil(t, json.Unmarshal([]byte(lines[0]), &written))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", written["trace_id"])
+	assert.Equal(t, "00f067aa0ba902b7", written["span_id"])
+	assert.Equal(t, "0000000000000001", written["parent_span_id"])
+	assert.Equal(t, "db.GetUser", written["name"])
+	assert.Equal(t, "client", written["kind"])
+	assert.Equal(t, float64(3), written["duration_ms"])
+	assert.Equal(t, map[string]interface{}{"db.system": "sqlite3", "db.attempt": float64(2)}, written["attributes"])
+	assert.Equal(t, "database is locked", written["error"])
+}
+
+func TestOTLPExporterShouldPostTheSpans(t *testing.T) {
+	var received map[string]interface{}
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		body, err := ioutil.ReadAll(r.Body)
+		require.Nil(t, err)
+		require.Nil(t, json.Unmarshal(body, &received))
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLPExporter(collector.URL+"/v1/traces", "message-server")
+	require.Nil(t, exporter.Export(context.Background(), []SpanData{spanForTest()}))
+
+	expected := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": []interface{}{
+				map[string]interface{}{"key": "service.name", "value": map[string]interface{}{"stringValue": "message-server"}},
+			}},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": scopeName},
+				"spans": []interface{}{map[string]interface{}{
+					"traceId":           "4bf92f3577b34da6a3ce929d0e0e4736",
+					"spanId":            "00f067aa0ba902b7",
+					"parentSpanId":      "0000000000000001",
+					"name":              "db.GetUser",
+					"kind":              float64(Client),
+					"startTimeUnixNano": "1559390400000000000",
+					"endTimeUnixNano":   "1559390400003000000",
+					"attributes": []interface{}{
+						map[string]interface{}{"key": "db.system", "value": map[string]interface{}{"stringValue": "sqlite3"}},
+						map[string]interface{}{"key": "db.attempt", "value": map[string]interface{}{"intValue": "2"}},
+					},
+					"status": map[string]interface{}{"code": float64(otlpStatusError), "message": "database is locked"},
+				}},
+			}},
+		}},
+	}
+	assert.Equal(t, expected, received)
+}
+
+func TestOTLPExporterShouldFailOnRejectedSpans(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	err := NewOTLPExporter(collector.URL, "message-server").Export(context.Background(), []SpanData{spanForTest()})
+	assert.NotNil(t, err)
+}
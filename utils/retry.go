@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Executes a function that returns an error the given number of times until it succeeds
+// Returns error in case the function call failed all the times
+// The function calls are executed after the intervals
+
+func Retry(fn func() error, count int, interval time.Duration) error {
+	return RetryContext(context.Background(), func(context.Context) error { return fn() }, count, interval)
+}
+
+// Executes a function that returns an error the given number of times until it succeeds, like Retry, passing it
+// the context
+// Stops retrying once the context is done, without waiting for the rest of the interval
+// Returns the error of the last call, or the context error if the context is done before the first call
+
+func RetryContext(ctx context.Context, fn func(ctx context.Context) error, count int, interval time.Duration) error {
+	return RetryPolicy{Attempts: count, Interval: interval}.Run(ctx, fn)
+}
+
+//Outcome of an attempt of a retry policy
+//Number is the number of the attempt, starting at 1, and Err its error, nil if it succeeded
+//Retryable is whether the error is transient according to the policy
+//Retried is whether the attempt is retried, after Delay
+
+type RetryAttempt struct {
+	Number    int
+	Err       error
+	Retryable bool
+	Retried   bool
+	Delay     time.Duration
+}
+
+//Policy retrying a function with exponential backoff
+//Attempts is the maximum number of calls, including the first one
+//Interval is the delay before the first retry, multiplied by Multiplier after every retry up to MaxInterval
+//A Multiplier below 1 keeps the interval fixed, and a MaxInterval of 0 doesn't limit it
+//Jitter randomizes every delay by up to that fraction of it, so concurrent callers don't retry in lockstep
+//MaxElapsed is the time after which no retry is started, 0 doesn't limit it
+//Retryable tells the transient errors, only those are retried, nil retries every error
+//OnAttempt is called after every attempt, nil observes nothing
+
+type RetryPolicy struct {
+	Attempts    int
+	Interval    time.Duration
+	Multiplier  float64
+	MaxInterval time.Duration
+	Jitter      float64
+	MaxElapsed  time.Duration
+	Retryable   func(err error) bool
+	OnAttempt   func(attempt RetryAttempt)
+}
+
+//Returns the delay before the given retry, starting at 1, without the jitter
+
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	delay := float64(p.Interval)
+	multiplier := math.Max(p.Multiplier, 1)
+	for i := 1; i < retry; i++ {
+		delay *= multiplier
+		if p.MaxInterval > 0 && delay >= float64(p.MaxInterval) {
+			break
+		}
+	}
+	if p.MaxInterval > 0 && delay > float64(p.MaxInterval) {
+		return p.MaxInterval
+	}
+	return time.Duration(delay)
+}
+
+//Returns the delay randomized by up to the jitter fraction of it, in both directions
+
+func (p RetryPolicy) jitter(delay time.Duration) time.Duration {
+	if p.Jitter <= 0 || delay <= 0 {
+		return delay
+	}
+	jitter := math.Min(p.Jitter, 1)
+	return time.Duration(float64(delay) * (1 + jitter*(2*rand.Float64()-1)))
+}
+
+//Calls the function, passing it the context, until it succeeds
+//Stops once an error isn't retryable, the attempts are exhausted, the next retry would start after MaxElapsed
+//or the context is done, without waiting for the rest of the delay
+//Returns the error of the last call, or the context error if the context is done before the first call
+
+func (p RetryPolicy) Run(ctx context.Context, fn func(ctx context.Context) error) error {
+	start := time.Now()
+	var err error
+	for number := 1; number <= p.Attempts; number++ {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			if err == nil {
+				err = ctxErr
+			}
+			return err
+		}
+
+		err = fn(ctx)
+		attempt := RetryAttempt{Number: number, Err: err}
+		if err != nil {
+			attempt.Retryable = p.Retryable == nil || p.Retryable(err)
+			delay := p.jitter(p.Backoff(number))
+			attempt.Retried = attempt.Retryable && number < p.Attempts && ctx.Err() == nil &&
+				(p.MaxElapsed <= 0 || time.Since(start)+delay <= p.MaxElapsed)
+			if attempt.Retried {
+				attempt.Delay = delay
+			}
+		}
+		if p.OnAttempt != nil {
+			p.OnAttempt(attempt)
+		}
+		if !attempt.Retried {
+			return err
+		}
+
+		timer := time.NewTimer(attempt.Delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+	return err
+}
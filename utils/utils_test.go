@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -79,3 +80,139 @@ func TestRetryShouldReturnAfterItSucceeds(t *testing.T) {
 		})
 	}
 }
+
+func TestRetryPolicyShouldOnlyRetryTheRetryableErrors(t *testing.T) {
+	transient := errors.New("database is locked")
+	permanent := errors.New("UNIQUE constraint failed")
+	cases := []struct {
+		name             string
+		errs             []error
+		attempts         int
+		expectedCalls    int
+		expectedErr      error
+		expectedAttempts []RetryAttempt
+	}{
+		{"testRetryPolicySucceeds", []error{nil}, 3, 1, nil, []RetryAttempt{{Number: 1}}},
+		{"testRetryPolicySucceedsAfterATransientError", []error{transient, nil}, 3, 2, nil,
+			[]RetryAttempt{{Number: 1, Err: transient, Retryable: true, Retried: true, Delay: time.Millisecond}, {Number: 2}}},
+		{"testRetryPolicyPermanentError", []error{permanent, nil}, 3, 1, permanent,
+			[]RetryAttempt{{Number: 1, Err: permanent}}},
+		{"testRetryPolicyPermanentErrorAfterATransientOne", []error{transient, permanent, nil}, 3, 2, permanent,
+			[]RetryAttempt{{Number: 1, Err: transient, Retryable: true, Retried: true, Delay: time.Millisecond}, {Number: 2, Err: permanent}}},
+		{"testRetryPolicyAttemptsExhausted", []error{transient, transient, transient}, 2, 2, transient,
+			[]RetryAttempt{{Number: 1, Err: transient, Retryable: true, Retried: true, Delay: time.Millisecond}, {Number: 2, Err: transient, Retryable: true}}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			var attempts []RetryAttempt
+			policy := RetryPolicy{
+				Attempts:  c.attempts,
+				Interval:  time.Millisecond,
+				Retryable: func(err error) bool { return err == transient },
+				OnAttempt: func(attempt RetryAttempt) { attempts = append(attempts, attempt) },
+			}
+
+			var timesCalled = 0
+			err := policy.Run(context.Background(), func(ctx context.Context) error {
+				timesCalled++
+				return c.errs[timesCalled-1]
+			})
+			assert.Equal(tt, c.expectedErr, err)
+			assert.Equal(tt, c.expectedCalls, timesCalled)
+			assert.Equal(tt, c.expectedAttempts, attempts)
+		})
+	}
+}
+
+func TestRetryPolicyBackoffShouldGrowUpToTheMaxInterval(t *testing.T) {
+	cases := []struct {
+		name     string
+		policy   RetryPolicy
+		expected []time.Duration
+	}{
+		{"testBackoffFixed", RetryPolicy{Interval: time.Millisecond * 20}, []time.Duration{time.Millisecond * 20, time.Millisecond * 20, time.Millisecond * 20}},
+		{"testBackoffExponential", RetryPolicy{Interval: time.Millisecond * 10, Multiplier: 2}, []time.Duration{time.Millisecond * 10, time.Millisecond * 20, time.Millisecond * 40}},
+		{"testBackoffMaxInterval", RetryPolicy{Interval: time.Millisecond * 10, Multiplier: 2, MaxInterval: time.Millisecond * 25}, []time.Duration{time.Millisecond * 10, time.Millisecond * 20, time.Millisecond * 25}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			for i, expected := range c.expected {
+				assert.Equal(tt, expected, c.policy.Backoff(i+1))
+			}
+		})
+	}
+}
+
+func TestRetryPolicyJitterShouldStayWithinItsFraction(t *testing.T) {
+	policy := RetryPolicy{Interval: time.Millisecond * 100, Jitter: 0.2}
+	for i := 0; i < 100; i++ {
+		delay := policy.jitter(policy.Backoff(1))
+		assert.True(t, delay >= time.Millisecond*80 && delay <= time.Millisecond*120, "delay %v", delay)
+	}
+}
+
+func TestRetryPolicyShouldNotRetryAfterTheMaxElapsedTime(t *testing.T) {
+	policy := RetryPolicy{Attempts: 10, Interval: time.Millisecond * 20, MaxElapsed: time.Millisecond * 50}
+
+	var timesCalled = 0
+	start := time.Now()
+	err := policy.Run(context.Background(), func(ctx context.Context) error {
+		timesCalled++
+		return errors.New("database is locked")
+	})
+	assert.NotNil(t, err)
+	assert.True(t, timesCalled >= 2 && timesCalled < 10, "called %v times", timesCalled)
+	assert.True(t, time.Since(start) < time.Millisecond*150)
+}
+
+func TestRetryContextShouldStopOnceTheContextIsDone(t *testing.T) {
+	cases := []struct {
+		name          string
+		cancelAfter   int
+		count         int
+		expectedCalls int
+	}{
+		{"testRetryContextCanceledBeforeTheFirstCall", 0, 3, 0},
+		{"testRetryContextCanceledByTheFirstCall", 1, 3, 1},
+		{"testRetryContextCanceledBySecondCall", 2, 3, 2},
+		{"testRetryContextNotCanceled", 5, 3, 3},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if c.cancelAfter == 0 {
+				cancel()
+			}
+
+			var timesCalled = 0
+			err := RetryContext(ctx, func(ctx context.Context) error {
+				timesCalled++
+				if timesCalled == c.cancelAfter {
+					cancel()
+				}
+				return errors.New("error")
+			}, c.count, time.Millisecond)
+			assert.NotNil(tt, err)
+			assert.Equal(tt, c.expectedCalls, timesCalled)
+			if c.cancelAfter == 0 {
+				assert.Equal(tt, context.Canceled, err)
+			}
+		})
+	}
+}
+
+func TestRetryContextShouldNotWaitTheIntervalOnceTheContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+
+	start := time.Now()
+	err := RetryContext(ctx, func(ctx context.Context) error {
+		return errors.New("database is locked")
+	}, 3, time.Minute)
+	assert.Equal(t, "database is locked", err.Error())
+	assert.True(t, time.Since(start) < time.Second)
+}